@@ -0,0 +1,100 @@
+package main
+
+import (
+    "fmt"
+    "math"
+    "time"
+
+    "cloud.google.com/go/bigquery"
+)
+
+// MonthlyWeather is one month of daily rows aggregated together.
+type MonthlyWeather struct {
+    Latitude        float64              `bigquery:"latitude" json:"latitude"`
+    Longitude       float64              `bigquery:"longitude" json:"longitude"`
+    Month           string               `bigquery:"month" json:"month"`
+    Days            int                  `bigquery:"days" json:"days"`
+    Complete        bool                 `bigquery:"complete" json:"complete"`
+    MeanTemperature bigquery.NullFloat64 `bigquery:"mean_temperature" json:"mean_temperature"`
+    MinTemperature  bigquery.NullFloat64 `bigquery:"min_temperature" json:"min_temperature"`
+    MaxTemperature  bigquery.NullFloat64 `bigquery:"max_temperature" json:"max_temperature"`
+    RainSum         bigquery.NullFloat64 `bigquery:"rain_sum" json:"rain_sum"`
+    SnowfallSum     bigquery.NullFloat64 `bigquery:"snowfall_sum" json:"snowfall_sum"`
+    InsertedAt      time.Time            `bigquery:"inserted_at" json:"inserted_at"`
+    BatchID         string               `bigquery:"batch_id" json:"batch_id"`
+}
+
+// aggregateMonthly groups date-sorted daily rows by coordinate and calendar month: the mean
+// of the daily means, the min of the mins, the max of the maxes, and the sums of rain and
+// snow. Months cut off by the edges of the range are kept, with Days counting only the
+// days present and Complete false, so partial sums are never mistaken for full months.
+// NULL daily values are skipped; a value is NULL for the month only if it is NULL every day.
+func aggregateMonthly(rows []*WeatherData) []*MonthlyWeather {
+    var out []*MonthlyWeather
+    var cur *MonthlyWeather
+    var meanSum float64
+    var meanDays int
+    flush := func() {
+        if cur == nil {
+            return
+        }
+        if meanDays > 0 {
+            cur.MeanTemperature = bigquery.NullFloat64{Float64: meanSum / float64(meanDays), Valid: true}
+        }
+        if month, err := time.Parse("2006-01", cur.Month); err == nil {
+            cur.Complete = cur.Days == month.AddDate(0, 1, -1).Day()
+        }
+        out = append(out, cur)
+    }
+
+    for _, row := range rows {
+        if len(row.Date) < 7 {
+            continue
+        }
+        month := row.Date[:7]
+        if cur == nil || cur.Month != month || cur.Latitude != row.Latitude || cur.Longitude != row.Longitude {
+            flush()
+            cur = &MonthlyWeather{
+                Latitude:   row.Latitude,
+                Longitude:  row.Longitude,
+                Month:      month,
+                InsertedAt: row.InsertedAt,
+                BatchID:    row.BatchID,
+            }
+            meanSum, meanDays = 0, 0
+        }
+        cur.Days++
+        if row.MeanTemperature.Valid {
+            meanSum += row.MeanTemperature.Float64
+            meanDays++
+        }
+        accumulate(&cur.MinTemperature, row.MinTemperature, math.Min)
+        accumulate(&cur.MaxTemperature, row.MaxTemperature, math.Max)
+        accumulate(&cur.RainSum, row.RainSum, sum)
+        accumulate(&cur.SnowfallSum, row.SnowfallSum, sum)
+    }
+    flush()
+    return out
+}
+
+// accumulate folds a daily value into a monthly one with f, ignoring NULL days.
+func accumulate(acc *bigquery.NullFloat64, v bigquery.NullFloat64, f func(a, b float64) float64) {
+    switch {
+    case !v.Valid:
+    case !acc.Valid:
+        *acc = v
+    default:
+        acc.Float64 = f(acc.Float64, v.Float64)
+    }
+}
+
+func sum(a, b float64) float64 { return a + b }
+
+// monthlyTableMetadata builds the schema for a new monthly aggregates table.
+func monthlyTableMetadata() (*bigquery.TableMetadata, error) {
+    schema, err := bigquery.InferSchema(MonthlyWeather{})
+    if err != nil {
+        return nil, fmt.Errorf("failed to infer schema: %w", err)
+    }
+    return &bigquery.TableMetadata{Schema: schema}, nil
+}
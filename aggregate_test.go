@@ -0,0 +1,97 @@
+package main
+
+import (
+    "testing"
+    "time"
+
+    "cloud.google.com/go/bigquery"
+)
+
+// days returns n consecutive daily rows starting at start, with the given rain each day.
+func days(lat float64, start string, n int, rain bigquery.NullFloat64) []*WeatherData {
+    first, _ := time.Parse(dateLayout, start)
+    var rows []*WeatherData
+    for i := 0; i < n; i++ {
+        rows = append(rows, &WeatherData{
+            Latitude:        lat,
+            Longitude:       13.4,
+            Date:            first.AddDate(0, 0, i).Format(dateLayout),
+            MeanTemperature: nf(float64(i)),
+            MinTemperature:  nf(float64(-i)),
+            MaxTemperature:  nf(float64(2 * i)),
+            RainSum:         rain,
+        })
+    }
+    return rows
+}
+
+func TestAggregateMonthly(t *testing.T) {
+    tests := []struct {
+        name string
+        rows []*WeatherData
+        want []MonthlyWeather
+    }{
+        {
+            name: "full month",
+            rows: days(52.5, "2024-02-01", 29, nf(1)),
+            want: []MonthlyWeather{{Latitude: 52.5, Month: "2024-02", Days: 29, Complete: true, MeanTemperature: nf(14), MinTemperature: nf(-28), MaxTemperature: nf(56), RainSum: nf(29)}},
+        },
+        {
+            name: "range cut at both edges",
+            rows: days(52.5, "2024-01-30", 4, nf(0.5)),
+            want: []MonthlyWeather{
+                {Latitude: 52.5, Month: "2024-01", Days: 2, MeanTemperature: nf(0.5), MinTemperature: nf(-1), MaxTemperature: nf(2), RainSum: nf(1)},
+                {Latitude: 52.5, Month: "2024-02", Days: 2, MeanTemperature: nf(2.5), MinTemperature: nf(-3), MaxTemperature: nf(6), RainSum: nf(1)},
+            },
+        },
+        {
+            name: "null every day stays null",
+            rows: days(52.5, "2024-03-01", 2, bigquery.NullFloat64{}),
+            want: []MonthlyWeather{{Latitude: 52.5, Month: "2024-03", Days: 2, MeanTemperature: nf(0.5), MinTemperature: nf(-1), MaxTemperature: nf(2)}},
+        },
+        {
+            name: "coordinates are kept apart",
+            rows: append(days(52.5, "2024-04-01", 1, nf(1)), days(48.8, "2024-04-01", 1, nf(2))...),
+            want: []MonthlyWeather{
+                {Latitude: 52.5, Month: "2024-04", Days: 1, MeanTemperature: nf(0), MinTemperature: nf(0), MaxTemperature: nf(0), RainSum: nf(1)},
+                {Latitude: 48.8, Month: "2024-04", Days: 1, MeanTemperature: nf(0), MinTemperature: nf(0), MaxTemperature: nf(0), RainSum: nf(2)},
+            },
+        },
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            got := aggregateMonthly(tt.rows)
+            if len(got) != len(tt.want) {
+                t.Fatalf("got %d months, want %d", len(got), len(tt.want))
+            }
+            for i, m := range got {
+                w := tt.want[i]
+                if m.Latitude != w.Latitude || m.Month != w.Month || m.Days != w.Days || m.Complete != w.Complete ||
+                    m.MeanTemperature != w.MeanTemperature || m.MinTemperature != w.MinTemperature ||
+                    m.MaxTemperature != w.MaxTemperature || m.RainSum != w.RainSum || m.SnowfallSum != w.SnowfallSum {
+                    t.Errorf("month %d = %+v, want %+v", i, *m, w)
+                }
+            }
+        })
+    }
+}
+
+func TestAccumulate(t *testing.T) {
+    tests := []struct {
+        name string
+        acc  bigquery.NullFloat64
+        v    bigquery.NullFloat64
+        want bigquery.NullFloat64
+    }{
+        {"null day is skipped", nf(3), bigquery.NullFloat64{}, nf(3)},
+        {"first value", bigquery.NullFloat64{}, nf(2), nf(2)},
+        {"folded", nf(3), nf(2), nf(5)},
+    }
+    for _, tt := range tests {
+        acc := tt.acc
+        accumulate(&acc, tt.v, sum)
+        if acc != tt.want {
+            t.Errorf("%s: accumulate() = %+v, want %+v", tt.name, acc, tt.want)
+        }
+    }
+}
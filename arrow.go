@@ -0,0 +1,157 @@
+package main
+
+import (
+    "fmt"
+    "log/slog"
+    "net/http"
+    "reflect"
+    "time"
+
+    "cloud.google.com/go/bigquery"
+    "github.com/apache/arrow/go/v15/arrow"
+    "github.com/apache/arrow/go/v15/arrow/array"
+    "github.com/apache/arrow/go/v15/arrow/ipc"
+    "github.com/apache/arrow/go/v15/arrow/memory"
+)
+
+// arrowColumn maps one WeatherData field to an Arrow field and appends its values.
+type arrowColumn struct {
+    field  arrow.Field
+    index  int
+    append func(b array.Builder, v reflect.Value)
+}
+
+// arrowTimestamp is the Arrow type of timestamps: microseconds in UTC, as BigQuery stores them.
+var arrowTimestamp = &arrow.TimestampType{Unit: arrow.Microsecond, TimeZone: "UTC"}
+
+// arrowColumns derives the Arrow columns from the WeatherData fields stored in BigQuery,
+// under their column names. NULL-able BigQuery types become nullable fields, and the date
+// becomes a date32.
+var arrowColumns = newArrowColumns()
+
+// newArrowColumns builds arrowColumns. A field type without a mapping panics at startup
+// rather than being silently dropped from the output.
+func newArrowColumns() []arrowColumn {
+    t := reflect.TypeOf(WeatherData{})
+    var columns []arrowColumn
+    for i := 0; i < t.NumField(); i++ {
+        sf := t.Field(i)
+        name := sf.Tag.Get("bigquery")
+        if name == "" || name == "-" {
+            continue
+        }
+        col := arrowColumn{index: i}
+        switch sf.Type {
+        case reflect.TypeOf(float64(0)):
+            col.field = arrow.Field{Name: name, Type: arrow.PrimitiveTypes.Float64}
+            col.append = func(b array.Builder, v reflect.Value) { b.(*array.Float64Builder).Append(v.Float()) }
+        case reflect.TypeOf(""):
+            if name == "date" {
+                col.field = arrow.Field{Name: name, Type: arrow.FixedWidthTypes.Date32}
+                col.append = func(b array.Builder, v reflect.Value) {
+                    date, err := time.Parse(dateLayout, v.String())
+                    if err != nil {
+                        b.AppendNull()
+                        return
+                    }
+                    b.(*array.Date32Builder).Append(arrow.Date32FromTime(date))
+                }
+                break
+            }
+            col.field = arrow.Field{Name: name, Type: arrow.BinaryTypes.String}
+            col.append = func(b array.Builder, v reflect.Value) { b.(*array.StringBuilder).Append(v.String()) }
+        case reflect.TypeOf(time.Time{}):
+            col.field = arrow.Field{Name: name, Type: arrowTimestamp}
+            col.append = func(b array.Builder, v reflect.Value) {
+                b.(*array.TimestampBuilder).Append(arrow.Timestamp(v.Interface().(time.Time).UnixMicro()))
+            }
+        case reflect.TypeOf(bigquery.NullFloat64{}):
+            col.field = arrow.Field{Name: name, Type: arrow.PrimitiveTypes.Float64, Nullable: true}
+            col.append = func(b array.Builder, v reflect.Value) {
+                if n := v.Interface().(bigquery.NullFloat64); n.Valid {
+                    b.(*array.Float64Builder).Append(n.Float64)
+                } else {
+                    b.AppendNull()
+                }
+            }
+        case reflect.TypeOf(bigquery.NullInt64{}):
+            col.field = arrow.Field{Name: name, Type: arrow.PrimitiveTypes.Int64, Nullable: true}
+            col.append = func(b array.Builder, v reflect.Value) {
+                if n := v.Interface().(bigquery.NullInt64); n.Valid {
+                    b.(*array.Int64Builder).Append(n.Int64)
+                } else {
+                    b.AppendNull()
+                }
+            }
+        case reflect.TypeOf(bigquery.NullString{}):
+            col.field = arrow.Field{Name: name, Type: arrow.BinaryTypes.String, Nullable: true}
+            col.append = func(b array.Builder, v reflect.Value) {
+                if n := v.Interface().(bigquery.NullString); n.Valid {
+                    b.(*array.StringBuilder).Append(n.StringVal)
+                } else {
+                    b.AppendNull()
+                }
+            }
+        case reflect.TypeOf(bigquery.NullTimestamp{}):
+            col.field = arrow.Field{Name: name, Type: arrowTimestamp, Nullable: true}
+            col.append = func(b array.Builder, v reflect.Value) {
+                if n := v.Interface().(bigquery.NullTimestamp); n.Valid {
+                    b.(*array.TimestampBuilder).Append(arrow.Timestamp(n.Timestamp.UnixMicro()))
+                } else {
+                    b.AppendNull()
+                }
+            }
+        default:
+            panic(fmt.Sprintf("no Arrow mapping for WeatherData.%s of type %s", sf.Name, sf.Type))
+        }
+        columns = append(columns, col)
+    }
+    return columns
+}
+
+// arrowSchema returns the Arrow schema of the WeatherData rows.
+func arrowSchema() *arrow.Schema {
+    fields := make([]arrow.Field, len(arrowColumns))
+    for i, col := range arrowColumns {
+        fields[i] = col.field
+    }
+    return arrow.NewSchema(fields, nil)
+}
+
+// streamArrow writes rows as an Arrow IPC stream, one record batch per flushEvery rows, so
+// Arrow clients can read large exports incrementally. As with streamJSON, a failure after
+// the first batch is only logged and the stream is left truncated.
+func streamArrow(w http.ResponseWriter, rows []*WeatherData) {
+    w.Header().Set("Content-Type", "application/vnd.apache.arrow.stream")
+    w.WriteHeader(http.StatusOK)
+    flusher, _ := w.(http.Flusher)
+
+    schema := arrowSchema()
+    writer := ipc.NewWriter(w, ipc.WithSchema(schema))
+    defer func() {
+        if err := writer.Close(); err != nil {
+            slog.Error("Failed to finish Arrow stream", "error", err)
+        }
+    }()
+
+    builder := array.NewRecordBuilder(memory.DefaultAllocator, schema)
+    defer builder.Release()
+    for start := 0; start < len(rows); start += flushEvery {
+        for _, row := range rows[start:min(start+flushEvery, len(rows))] {
+            v := reflect.ValueOf(row).Elem()
+            for i, col := range arrowColumns {
+                col.append(builder.Field(i), v.Field(col.index))
+            }
+        }
+        record := builder.NewRecord()
+        err := writer.Write(record)
+        record.Release()
+        if err != nil {
+            slog.Error("Failed to write Arrow record batch; client likely disconnected", "row", start, "error", err)
+            return
+        }
+        if flusher != nil {
+            flusher.Flush()
+        }
+    }
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+    "net/http"
+    "net/http/httptest"
+    "strings"
+    "testing"
+    "time"
+
+    "cloud.google.com/go/bigquery"
+    "github.com/apache/arrow/go/v15/arrow"
+    "github.com/apache/arrow/go/v15/arrow/array"
+    "github.com/apache/arrow/go/v15/arrow/ipc"
+)
+
+func TestArrowSchema(t *testing.T) {
+    schema := arrowSchema()
+    tests := []struct {
+        name     string
+        typ      arrow.DataType
+        nullable bool
+    }{
+        {"latitude", arrow.PrimitiveTypes.Float64, false},
+        {"date", arrow.FixedWidthTypes.Date32, false},
+        {"batch_id", arrow.BinaryTypes.String, false},
+        {"inserted_at", arrowTimestamp, false},
+        {"rain_sum", arrow.PrimitiveTypes.Float64, true},
+        {"weather_code", arrow.PrimitiveTypes.Int64, true},
+        {"weather_description", arrow.BinaryTypes.String, true},
+        {"date_ts", arrowTimestamp, true},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            fields, ok := schema.FieldsByName(tt.name)
+            if !ok {
+                t.Fatalf("schema lacks %s", tt.name)
+            }
+            if f := fields[0]; !arrow.TypeEqual(f.Type, tt.typ) || f.Nullable != tt.nullable {
+                t.Errorf("%s is %s (nullable %v), want %s (nullable %v)", tt.name, f.Type, f.Nullable, tt.typ, tt.nullable)
+            }
+        })
+    }
+    if schema.NumFields() != len(arrowColumns) {
+        t.Errorf("schema has %d fields, want %d", schema.NumFields(), len(arrowColumns))
+    }
+}
+
+func TestStreamArrow(t *testing.T) {
+    tests := []struct {
+        name        string
+        rows        []*WeatherData
+        wantBatches int
+    }{
+        {"no rows", nil, 0},
+        {"one batch", manyRows(3), 1},
+        {"a batch per flush", manyRows(flushEvery + 1), 2},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            rec := httptest.NewRecorder()
+            streamArrow(rec, tt.rows)
+            if got := rec.Header().Get("Content-Type"); got != "application/vnd.apache.arrow.stream" {
+                t.Errorf("Content-Type = %q", got)
+            }
+            reader, err := ipc.NewReader(rec.Body)
+            if err != nil {
+                t.Fatal(err)
+            }
+            defer reader.Release()
+            if !reader.Schema().Equal(arrowSchema()) {
+                t.Errorf("stream schema %s, want %s", reader.Schema(), arrowSchema())
+            }
+            batches, n := 0, 0
+            for reader.Next() {
+                batches++
+                n += int(reader.Record().NumRows())
+            }
+            if err := reader.Err(); err != nil {
+                t.Fatal(err)
+            }
+            if batches != tt.wantBatches || n != len(tt.rows) {
+                t.Errorf("decoded %d rows in %d batches, want %d in %d", n, batches, len(tt.rows), tt.wantBatches)
+            }
+        })
+    }
+}
+
+func TestStreamArrowValues(t *testing.T) {
+    inserted := time.Date(2024, 1, 2, 3, 4, 5, 6000, time.UTC)
+    rows := []*WeatherData{
+        {Latitude: 52.5, Date: "2024-01-02", RainSum: nf(0.4), WeatherCode: bigquery.NullInt64{Int64: 63, Valid: true}, BatchID: "b", InsertedAt: inserted},
+        {Latitude: 52.5, Date: "2024-01-03"},
+    }
+    rec := httptest.NewRecorder()
+    streamArrow(rec, rows)
+    reader, err := ipc.NewReader(rec.Body)
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer reader.Release()
+    if !reader.Next() {
+        t.Fatalf("no record batch: %v", reader.Err())
+    }
+    record := reader.Record()
+    column := func(name string) arrow.Array {
+        return record.Column(record.Schema().FieldIndices(name)[0])
+    }
+    tests := []struct {
+        name string
+        got  interface{}
+        want interface{}
+    }{
+        {"date", column("date").(*array.Date32).Value(0).ToTime().Format(dateLayout), "2024-01-02"},
+        {"latitude", column("latitude").(*array.Float64).Value(1), 52.5},
+        {"rain_sum", column("rain_sum").(*array.Float64).Value(0), 0.4},
+        {"NULL rain_sum", column("rain_sum").IsNull(1), true},
+        {"weather_code", column("weather_code").(*array.Int64).Value(0), int64(63)},
+        {"NULL weather_code", column("weather_code").IsNull(1), true},
+        {"batch_id", column("batch_id").(*array.String).Value(0), "b"},
+        {"inserted_at", column("inserted_at").(*array.Timestamp).Value(0), arrow.Timestamp(inserted.UnixMicro())},
+        {"NULL date_ts", column("date_ts").IsNull(0), true},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            if tt.got != tt.want {
+                t.Errorf("got %v, want %v", tt.got, tt.want)
+            }
+        })
+    }
+}
+
+func TestFormatArrow(t *testing.T) {
+    noBigQuery(t)
+    stubOpenMeteo(t, serveBody(threeDays))
+    rec := httptest.NewRecorder()
+    fetchWeatherData(rec, httptest.NewRequest(http.MethodGet, "/?latitude=52.52&longitude=13.41&start_date=2024-01-01&end_date=2024-01-03&sink=none&format=arrow", nil))
+    if rec.Code != http.StatusOK {
+        t.Fatalf("status = %d: %s", rec.Code, rec.Body)
+    }
+    reader, err := ipc.NewReader(strings.NewReader(rec.Body.String()))
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer reader.Release()
+    n := 0
+    for reader.Next() {
+        n += int(reader.Record().NumRows())
+    }
+    if n != 3 {
+        t.Errorf("decoded %d rows, want 3", n)
+    }
+}
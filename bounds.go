@@ -0,0 +1,103 @@
+package main
+
+import (
+    "encoding/json"
+    "fmt"
+    "log/slog"
+
+    "cloud.google.com/go/bigquery"
+)
+
+// defaultPlausibilityBounds are the ranges outside which a value is treated as corrupt.
+// They are loose enough to keep records: the hottest and coldest observed temperatures,
+// and precipitation totals that hold in either snowfall unit.
+var defaultPlausibilityBounds = map[string][2]float64{
+    "temperature_2m_min":         {-90, 60},
+    "temperature_2m_max":         {-90, 60},
+    "temperature_2m_mean":        {-90, 60},
+    "rain_sum":                   {0, 2000},
+    "snowfall_sum":               {0, 2000},
+    "surface_pressure_mean":      {300, 1100},
+    "cloud_cover_mean":           {0, 100},
+    "et0_fao_evapotranspiration": {0, 30},
+}
+
+// parsePlausibilityBounds merges a JSON object of variable to [min, max] onto the defaults.
+// Invalid overrides return the defaults and the reason.
+func parsePlausibilityBounds(s string) (map[string][2]float64, error) {
+    bounds := make(map[string][2]float64, len(defaultPlausibilityBounds))
+    for name, b := range defaultPlausibilityBounds {
+        bounds[name] = b
+    }
+    if s == "" {
+        return bounds, nil
+    }
+    var overrides map[string][2]float64
+    if err := json.Unmarshal([]byte(s), &overrides); err != nil {
+        return bounds, err
+    }
+    for name, b := range overrides {
+        if _, ok := defaultPlausibilityBounds[name]; !ok || b[0] > b[1] {
+            return bounds, fmt.Errorf("invalid bounds for %q", name)
+        }
+    }
+    for name, b := range overrides {
+        bounds[name] = b
+    }
+    return bounds, nil
+}
+
+// applyPlausibility checks the requested variables against the plausibility bounds. Under
+// the "log" policy offending values are only logged; "null" also clears them and "reject"
+// drops their rows.
+func applyPlausibility(rows []*WeatherData, variables []string, policy string) []*WeatherData {
+    kept := rows[:0]
+    for _, row := range rows {
+        implausible := false
+        for _, name := range variables {
+            v, b := row.floatField(name), cfg.PlausibilityBounds[name]
+            if v == nil || !v.Valid || (v.Float64 >= b[0] && v.Float64 <= b[1]) {
+                continue
+            }
+            slog.Warn("Implausible value", "variable", name, "date", row.Date, "value", v.Float64, "min", b[0], "max", b[1], "policy", policy)
+            implausible = true
+            if policy == "null" {
+                *v = bigquery.NullFloat64{}
+            }
+        }
+        if !implausible || policy != "reject" {
+            kept = append(kept, row)
+        }
+    }
+    return kept
+}
+
+// scoreRows sets each row's quality score: the fraction of the requested variables whose
+// value is present and within its plausibility bounds, from 0 when none are to 1 when all
+// are. The weather code has no bounds, so it only has to be present. It runs after the
+// plausibility policy and the zero-precipitation check, so values they cleared count as
+// missing, and before nodata_sentinel fills NULLs in.
+func scoreRows(rows []*WeatherData, variables []string) {
+    if len(variables) == 0 {
+        return
+    }
+    for _, row := range rows {
+        good := 0
+        for _, name := range variables {
+            if name == "weather_code" {
+                if row.WeatherCode.Valid {
+                    good++
+                }
+                continue
+            }
+            v := row.floatField(name)
+            if v == nil || !v.Valid {
+                continue
+            }
+            if b, ok := cfg.PlausibilityBounds[name]; !ok || (v.Float64 >= b[0] && v.Float64 <= b[1]) {
+                good++
+            }
+        }
+        row.QualityScore = bigquery.NullFloat64{Float64: float64(good) / float64(len(variables)), Valid: true}
+    }
+}
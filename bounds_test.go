@@ -0,0 +1,202 @@
+package main
+
+import (
+    "fmt"
+    "log/slog"
+    "net/http"
+    "net/http/httptest"
+    "strings"
+    "testing"
+
+    "cloud.google.com/go/bigquery"
+)
+
+func TestParsePlausibilityBounds(t *testing.T) {
+    tests := []struct {
+        name    string
+        value   string
+        want    map[string][2]float64
+        wantErr bool
+    }{
+        {"unset", "", nil, false},
+        {"override", `{"rain_sum":[0,500],"temperature_2m_max":[-50,55]}`, map[string][2]float64{"rain_sum": {0, 500}, "temperature_2m_max": {-50, 55}}, false},
+        {"invalid JSON", `{"rain_sum":[0]`, nil, true},
+        {"unknown variable", `{"rain_sum":[0,500],"wind_speed":[0,100]}`, nil, true},
+        {"inverted bounds", `{"rain_sum":[500,0]}`, nil, true},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            got, err := parsePlausibilityBounds(tt.value)
+            if (err != nil) != tt.wantErr {
+                t.Fatalf("err = %v, wantErr %v", err, tt.wantErr)
+            }
+            for name, b := range defaultPlausibilityBounds {
+                want, ok := tt.want[name]
+                if !ok {
+                    want = b
+                }
+                if got[name] != want {
+                    t.Errorf("bounds[%s] = %v, want %v", name, got[name], want)
+                }
+            }
+            if len(got) != len(defaultPlausibilityBounds) {
+                t.Errorf("got bounds for %d variables, want %d", len(got), len(defaultPlausibilityBounds))
+            }
+        })
+    }
+    if defaultPlausibilityBounds["rain_sum"] != [2]float64{0, 2000} {
+        t.Error("an override changed the defaults")
+    }
+}
+
+func TestLoadConfigPlausibilityBounds(t *testing.T) {
+    t.Setenv("PLAUSIBILITY_BOUNDS_JSON", `{"rain_sum":[0,500]}`)
+    if got := loadConfig().PlausibilityBounds["rain_sum"]; got != [2]float64{0, 500} {
+        t.Errorf("rain_sum bounds = %v, want [0 500]", got)
+    }
+    t.Setenv("PLAUSIBILITY_BOUNDS_JSON", `{"rain_sum":[500,0]}`)
+    if got := loadConfig().PlausibilityBounds["rain_sum"]; got != [2]float64{0, 2000} {
+        t.Errorf("invalid override loaded rain_sum bounds %v, want the default", got)
+    }
+}
+
+func TestApplyPlausibility(t *testing.T) {
+    variables := []string{"temperature_2m_max", "rain_sum", "weather_code"}
+    tests := []struct {
+        policy    string
+        wantDates []string
+        wantTemp  bigquery.NullFloat64
+    }{
+        {"log", []string{"2024-01-01", "2024-01-02", "2024-01-03"}, nf(500)},
+        {"null", []string{"2024-01-01", "2024-01-02", "2024-01-03"}, bigquery.NullFloat64{}},
+        {"reject", []string{"2024-01-01", "2024-01-03"}, nf(60)},
+    }
+    for _, tt := range tests {
+        t.Run(tt.policy, func(t *testing.T) {
+            logs := captureLogs(t, slog.LevelWarn)
+            rows := []*WeatherData{
+                {Date: "2024-01-01", MaxTemperature: nf(21.5), RainSum: nf(0)},
+                {Date: "2024-01-02", MaxTemperature: nf(500), RainSum: nf(1.2)},
+                // Values on the bounds and unrequested columns are left alone.
+                {Date: "2024-01-03", MaxTemperature: nf(60), RainSum: nf(2000), SnowfallSum: nf(-5)},
+            }
+            got := applyPlausibility(rows, variables, tt.policy)
+            var dates []string
+            for _, row := range got {
+                dates = append(dates, row.Date)
+            }
+            if fmt.Sprint(dates) != fmt.Sprint(tt.wantDates) {
+                t.Fatalf("kept %q, want %q", dates, tt.wantDates)
+            }
+            if got[1].MaxTemperature != tt.wantTemp {
+                t.Errorf("second row temperature = %+v, want %+v", got[1].MaxTemperature, tt.wantTemp)
+            }
+            if got[len(got)-1].SnowfallSum != nf(-5) {
+                t.Errorf("unrequested snowfall_sum changed to %+v", got[len(got)-1].SnowfallSum)
+            }
+            if n := strings.Count(logs.String(), "Implausible value"); n != 1 {
+                t.Errorf("logged %d implausible values, want 1: %s", n, logs)
+            }
+        })
+    }
+}
+
+func TestParseOutOfBounds(t *testing.T) {
+    tests := []struct {
+        value   string
+        want    string
+        wantErr bool
+    }{
+        {"", "log", false},
+        {"log", "log", false},
+        {"null", "null", false},
+        {"reject", "reject", false},
+        {"drop", "", true},
+        {"NULL", "", true},
+    }
+    for _, tt := range tests {
+        t.Run(tt.value, func(t *testing.T) {
+            query := "latitude=52.52&longitude=13.41&out_of_bounds=" + tt.value
+            if tt.wantErr {
+                if err := parseOptionsError(t, query); err == nil {
+                    t.Error("parseQueryOptions() succeeded, want an error")
+                }
+                return
+            }
+            if got := mustParseOptions(t, query).OutOfBounds; got != tt.want {
+                t.Errorf("OutOfBounds = %q, want %q", got, tt.want)
+            }
+        })
+    }
+}
+
+func TestScoreRows(t *testing.T) {
+    variables := []string{"temperature_2m_max", "rain_sum", "snowfall_sum", "weather_code"}
+    code := bigquery.NullInt64{Int64: 63, Valid: true}
+    tests := []struct {
+        name      string
+        row       *WeatherData
+        variables []string
+        want      bigquery.NullFloat64
+    }{
+        {"complete", &WeatherData{MaxTemperature: nf(21.5), RainSum: nf(0), SnowfallSum: nf(0), WeatherCode: code}, variables, nf(1)},
+        {"one missing", &WeatherData{MaxTemperature: nf(21.5), RainSum: nf(1.2), WeatherCode: code}, variables, nf(0.75)},
+        {"missing weather code", &WeatherData{MaxTemperature: nf(21.5), RainSum: nf(1.2), SnowfallSum: nf(0)}, variables, nf(0.75)},
+        {"implausible value", &WeatherData{MaxTemperature: nf(500), RainSum: nf(1.2), SnowfallSum: nf(0), WeatherCode: code}, variables, nf(0.75)},
+        {"half missing", &WeatherData{MaxTemperature: nf(21.5), WeatherCode: code}, variables, nf(0.5)},
+        {"all missing", &WeatherData{}, variables, nf(0)},
+        {"unrequested columns do not count", &WeatherData{RainSum: nf(1), MaxTemperature: nf(500)}, []string{"rain_sum"}, nf(1)},
+        {"no variables", &WeatherData{RainSum: nf(1)}, nil, bigquery.NullFloat64{}},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            scoreRows([]*WeatherData{tt.row}, tt.variables)
+            if tt.row.QualityScore != tt.want {
+                t.Errorf("QualityScore = %+v, want %+v", tt.row.QualityScore, tt.want)
+            }
+        })
+    }
+}
+
+func TestScoreRowsCustomBounds(t *testing.T) {
+    withConfig(t, func(c *Config) {
+        c.PlausibilityBounds = map[string][2]float64{"rain_sum": {0, 1}}
+    })
+    rows := []*WeatherData{{RainSum: nf(0.5)}, {RainSum: nf(1.5)}}
+    scoreRows(rows, []string{"rain_sum"})
+    if rows[0].QualityScore != nf(1) || rows[1].QualityScore != nf(0) {
+        t.Errorf("scores = %+v, %+v; want 1 and 0", rows[0].QualityScore, rows[1].QualityScore)
+    }
+}
+
+func TestQualityScoreStored(t *testing.T) {
+    tests := []struct {
+        name  string
+        query string
+        want  []interface{}
+    }{
+        {"not requested", "", []interface{}{nil, nil, nil}},
+        {"complete, missing, and implausible", "&quality_score=true", []interface{}{1.0, 0.8, 0.8}},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            fake, _ := newFakeBigQuery(t)
+            stubOpenMeteo(t, serveBody(`{"latitude":52.5,"longitude":13.4,"daily":{"time":["2024-01-01","2024-01-02","2024-01-03"],`+
+                `"temperature_2m_min":[-1,0,1],"temperature_2m_max":[4,null,70],"temperature_2m_mean":[1.5,2.5,3.5],"rain_sum":[0,0.4,1.2],"snowfall_sum":[0,0,0]}}`))
+            rec := httptest.NewRecorder()
+            fetchWeatherData(rec, httptest.NewRequest(http.MethodGet, "/?latitude=52.52&longitude=13.41&start_date=2024-01-01&end_date=2024-01-03"+tt.query, nil))
+            if rec.Code != http.StatusOK {
+                t.Fatalf("status = %d: %s", rec.Code, rec.Body)
+            }
+            rows := fake.rows("daily_weather")
+            if len(rows) != len(tt.want) {
+                t.Fatalf("stored %d rows, want %d", len(rows), len(tt.want))
+            }
+            for i, row := range rows {
+                if got := row["quality_score"]; got != tt.want[i] {
+                    t.Errorf("row %d quality_score = %v, want %v", i, got, tt.want[i])
+                }
+            }
+        })
+    }
+}
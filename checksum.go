@@ -0,0 +1,143 @@
+package main
+
+import (
+    "context"
+    "crypto/sha256"
+    "encoding/hex"
+    "fmt"
+    "hash"
+    "strconv"
+    "strings"
+    "time"
+
+    "cloud.google.com/go/bigquery"
+    "google.golang.org/api/iterator"
+)
+
+// RangeChecksum is the hash of the values fetched for a coordinate, range, and variable set,
+// recorded by checksum=true runs in the checksums table.
+type RangeChecksum struct {
+    Latitude   float64   `bigquery:"latitude"`
+    Longitude  float64   `bigquery:"longitude"`
+    StartDate  string    `bigquery:"start_date"`
+    EndDate    string    `bigquery:"end_date"`
+    Variables  string    `bigquery:"variables"`
+    Checksum   string    `bigquery:"checksum"`
+    BatchID    string    `bigquery:"batch_id"`
+    ComputedAt time.Time `bigquery:"computed_at"`
+}
+
+// rowsChecksum hashes the date and requested values of each row, in row order, so the same
+// upstream data always gives the same checksum and any revised value changes it.
+func rowsChecksum(rows []*WeatherData, variables []string) string {
+    h := sha256.New()
+    for _, row := range rows {
+        h.Write([]byte(row.Date))
+        for _, name := range variables {
+            writeChecksumValue(h, row, name)
+        }
+        h.Write([]byte{'\n'})
+    }
+    return hex.EncodeToString(h.Sum(nil))
+}
+
+// writeChecksumValue writes one value of the row to the hash, with NULL distinct from zero.
+func writeChecksumValue(h hash.Hash, row *WeatherData, name string) {
+    h.Write([]byte{'|'})
+    if name == "weather_code" {
+        if row.WeatherCode.Valid {
+            h.Write([]byte(strconv.FormatInt(row.WeatherCode.Int64, 10)))
+            return
+        }
+    } else if v := row.floatField(name); v != nil && v.Valid {
+        h.Write([]byte(strconv.FormatFloat(v.Float64, 'g', -1, 64)))
+        return
+    }
+    h.Write([]byte("null"))
+}
+
+// checksumFetched records the checksum of the fetched rows on the result when checksum=true.
+// It runs before finishRows, so options that only shape the output, such as round and
+// snowfall_unit, give the same checksum for the same upstream data.
+func checksumFetched(result *ingestResult, rows []*WeatherData, opts *requestOptions) {
+    if opts.Checksum {
+        result.Checksum = rowsChecksum(rows, opts.Variables)
+    }
+}
+
+// compareChecksum records the checksum of the fetched values and reports how it compares with the
+// last one recorded for the same coordinate, range, and variables: "new" when there was
+// none, otherwise "unchanged" or "changed", the latter meaning the upstream data was revised.
+func compareChecksum(ctx context.Context, client *bigquery.Client, opts *requestOptions, batchID, checksum string) (string, error) {
+    created, err := ensureTable(ctx, client, cfg.ChecksumsTable, checksumsMetadata)
+    if err != nil {
+        return "", err
+    }
+    current := &RangeChecksum{
+        Latitude:   opts.Latitude,
+        Longitude:  opts.Longitude,
+        StartDate:  opts.StartDate,
+        EndDate:    opts.EndDate,
+        Variables:  strings.Join(opts.Variables, ","),
+        Checksum:   checksum,
+        BatchID:    batchID,
+        ComputedAt: now(),
+    }
+
+    status := "new"
+    if !created {
+        previous, ok, err := latestChecksum(ctx, client, current)
+        if err != nil {
+            return "", err
+        }
+        switch {
+        case ok && previous == current.Checksum:
+            status = "unchanged"
+        case ok:
+            status = "changed"
+        }
+    }
+    if err := putRows(ctx, client.Dataset(cfg.DatasetID).Table(cfg.ChecksumsTable), current); err != nil {
+        return "", fmt.Errorf("failed to insert checksum: %w", err)
+    }
+    return status, nil
+}
+
+// latestChecksum returns the most recently recorded checksum matching the coordinate,
+// range, and variables of c, or false if there is none.
+func latestChecksum(ctx context.Context, client *bigquery.Client, c *RangeChecksum) (string, bool, error) {
+    query := client.Query(fmt.Sprintf(
+        "SELECT checksum FROM `%s.%s.%s` WHERE latitude = @latitude AND longitude = @longitude AND start_date = @start_date AND end_date = @end_date AND variables = @variables ORDER BY computed_at DESC LIMIT 1",
+        cfg.ProjectID, cfg.DatasetID, cfg.ChecksumsTable,
+    ))
+    query.Parameters = []bigquery.QueryParameter{
+        {Name: "latitude", Value: c.Latitude},
+        {Name: "longitude", Value: c.Longitude},
+        {Name: "start_date", Value: c.StartDate},
+        {Name: "end_date", Value: c.EndDate},
+        {Name: "variables", Value: c.Variables},
+    }
+    it, err := query.Read(ctx)
+    if err != nil {
+        return "", false, err
+    }
+    var row struct {
+        Checksum string `bigquery:"checksum"`
+    }
+    if err := it.Next(&row); err != nil {
+        if err == iterator.Done {
+            return "", false, nil
+        }
+        return "", false, err
+    }
+    return row.Checksum, true, nil
+}
+
+// checksumsMetadata builds the schema for a new checksums table.
+func checksumsMetadata() (*bigquery.TableMetadata, error) {
+    schema, err := bigquery.InferSchema(RangeChecksum{})
+    if err != nil {
+        return nil, fmt.Errorf("failed to infer schema: %w", err)
+    }
+    return &bigquery.TableMetadata{Schema: schema}, nil
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+    "net/http"
+    "net/http/httptest"
+    "strings"
+    "testing"
+
+    "cloud.google.com/go/bigquery"
+)
+
+func TestRowsChecksum(t *testing.T) {
+    variables := []string{"rain_sum", "weather_code"}
+    base := func() []*WeatherData {
+        return []*WeatherData{
+            {Date: "2024-01-01", RainSum: nf(0), WeatherCode: bigquery.NullInt64{Int64: 3, Valid: true}},
+            {Date: "2024-01-02", RainSum: nf(1.2), WeatherCode: bigquery.NullInt64{Int64: 61, Valid: true}},
+        }
+    }
+    tests := []struct {
+        name      string
+        change    func(rows []*WeatherData)
+        variables []string
+        wantSame  bool
+    }{
+        {"same data", func(rows []*WeatherData) {}, variables, true},
+        {"unrequested column revised", func(rows []*WeatherData) { rows[0].SnowfallSum = nf(2) }, variables, true},
+        {"value revised", func(rows []*WeatherData) { rows[1].RainSum = nf(1.3) }, variables, false},
+        {"zero became NULL", func(rows []*WeatherData) { rows[0].RainSum = bigquery.NullFloat64{} }, variables, false},
+        {"code revised", func(rows []*WeatherData) { rows[0].WeatherCode.Int64 = 2 }, variables, false},
+        {"date shifted", func(rows []*WeatherData) { rows[1].Date = "2024-01-03" }, variables, false},
+        {"other variables", func(rows []*WeatherData) {}, []string{"rain_sum"}, false},
+    }
+    want := rowsChecksum(base(), variables)
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            rows := base()
+            tt.change(rows)
+            if got := rowsChecksum(rows, tt.variables); (got == want) != tt.wantSame {
+                t.Errorf("checksum %s vs %s, want same %v", got, want, tt.wantSame)
+            }
+        })
+    }
+}
+
+func TestChecksumBetweenRuns(t *testing.T) {
+    revised := strings.Replace(threeDays, `"rain_sum":[0,0.4,1.2]`, `"rain_sum":[0,0.5,1.2]`, 1)
+    fake, _ := newFakeBigQuery(t)
+    fake.answer = func(q *fakeQuery) *fakeResult {
+        if !strings.Contains(q.SQL, cfg.ChecksumsTable) {
+            return nil
+        }
+        // Answer with the checksum recorded last for the range, as the query orders by time.
+        stored := fake.rows(cfg.ChecksumsTable)
+        for i := len(stored) - 1; i >= 0; i-- {
+            if stored[i]["start_date"] == q.param("start_date") && stored[i]["end_date"] == q.param("end_date") {
+                return &fakeResult{Fields: fields("checksum", "STRING"), Rows: [][]interface{}{{stored[i]["checksum"]}}}
+            }
+        }
+        return nil
+    }
+    runs := []struct {
+        name  string
+        body  string
+        end   string
+        query string
+        want  string
+    }{
+        {"first run", threeDays, "2024-01-03", "", "new"},
+        {"same upstream data", threeDays, "2024-01-03", "", "unchanged"},
+        {"output options", threeDays, "2024-01-03", "&round=0&snowfall_unit=mm&nodata_sentinel=-9999&months=1", "unchanged"},
+        {"upstream revised", revised, "2024-01-03", "", "changed"},
+        {"revision is the new baseline", revised, "2024-01-03", "&round=0", "unchanged"},
+        {"other range", threeDays, "2024-01-04", "", "new"},
+    }
+    for _, run := range runs {
+        stubOpenMeteo(t, serveBody(run.body))
+        rec := httptest.NewRecorder()
+        fetchWeatherData(rec, httptest.NewRequest(http.MethodGet, "/?latitude=52.52&longitude=13.41&start_date=2024-01-01&end_date="+run.end+"&checksum=true"+run.query, nil))
+        if rec.Code != http.StatusOK {
+            t.Fatalf("%s: status = %d: %s", run.name, rec.Code, rec.Body)
+        }
+        if !strings.Contains(rec.Body.String(), "; checksum "+run.want) {
+            t.Errorf("%s: body %q, want checksum %s", run.name, rec.Body, run.want)
+        }
+    }
+    if n := len(fake.rows(cfg.ChecksumsTable)); n != len(runs) {
+        t.Errorf("stored %d checksums, want one per run (%d)", n, len(runs))
+    }
+}
+
+func TestParseChecksum(t *testing.T) {
+    if !mustParseOptions(t, "latitude=52.52&longitude=13.41&checksum=true").Checksum {
+        t.Error("checksum=true not parsed")
+    }
+    if err := parseOptionsError(t, "latitude=52.52&longitude=13.41&checksum=true&sink=none"); err == nil {
+        t.Error("checksum with sink=none accepted, want an error")
+    }
+}
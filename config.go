@@ -0,0 +1,294 @@
+package main
+
+import (
+    "log/slog"
+    "math"
+    "os"
+    "strconv"
+    "strings"
+    "time"
+)
+
+// Config holds the deployment settings read from environment variables.
+type Config struct {
+    ProjectID        string
+    DatasetID        string
+    TableID          string
+    PartitionField   string
+    PartitionType    string
+    ClusteringFields []string
+    AuthSecret       string
+    OpenMeteoAPIKey  string
+    CredentialsJSON  string
+    StrictDecode     bool
+    RecordIngestRuns bool
+    RecordCallerInfo bool
+    IngestRunsTable  string
+    ChecksumsTable   string
+    MonthlyTableID   string
+    ModelsTableID    string
+    LongTableID      string
+    DefaultRound     int
+    GeohashPrecision uint
+    MaxCoordinates   int
+    WorkerPoolSize   int
+    MaxResponseRows  int
+    MetricsEnabled   bool
+    Environment      string
+    FreshnessWindow  time.Duration
+    MaxRetries       int
+    FunctionTimeout  time.Duration
+    MaxInFlight      int
+    RequestTimeout   time.Duration
+    GzipMinBytes     int
+    LogLevel         slog.Level
+    // MaxIdleConns, MaxIdleConnsPerHost, and IdleConnTimeout tune the connection pool of
+    // the shared Open-Meteo HTTP client.
+    MaxIdleConns        int
+    MaxIdleConnsPerHost int
+    IdleConnTimeout     time.Duration
+    // ResponseRowsPolicy is "reject" or "truncate" for responses over MaxResponseRows.
+    ResponseRowsPolicy string
+    // DeadLetterURI is a gs://bucket/prefix where batches that fail to insert are saved
+    // for replay; empty disables the dead letter.
+    DeadLetterURI string
+    // ManifestURI is a gs://bucket/prefix where a JSON manifest describing each successful
+    // ingestion is written; empty disables the manifests.
+    ManifestURI string
+    // MaxRedirects is how many redirects from Open-Meteo are followed before failing.
+    MaxRedirects int
+    // NonFiniteSentinel replaces NaN and infinite values in JSON responses; nil writes null.
+    NonFiniteSentinel *float64
+    // GeocodingTimeout, GeocodingMaxBytes, and GeocodingCacheTTL bound the place name
+    // lookup: how long it may take, how large its response may be, and how long results
+    // are cached. A TTL of 0 disables the cache.
+    GeocodingTimeout  time.Duration
+    GeocodingMaxBytes int64
+    GeocodingCacheTTL time.Duration
+    // RejectNullIsland rejects ingestion requests for exactly latitude 0, longitude 0.
+    RejectNullIsland bool
+    // RecordVariablesMeta keeps VariablesMetaTable listing the unit and description of
+    // each ingested variable's column.
+    RecordVariablesMeta bool
+    VariablesMetaTable  string
+    // IngestPaused is the kill switch refusing every write with a 503 while reads and
+    // health checks keep working.
+    IngestPaused bool
+    // CounterHeaders adds the instance's cumulative request, row, and error counts to
+    // every response.
+    CounterHeaders bool
+    // Mirrors are alternate Open-Meteo base URLs, tried in order when the primary endpoint fails.
+    Mirrors []string
+    // AllowedRegion limits the coordinates this deployment serves, from ALLOWED_BBOX; nil
+    // when unrestricted.
+    AllowedRegion *boundingBox
+    // RetryRules classify which failed Open-Meteo and BigQuery calls are retried, from
+    // RETRY_RULES_JSON or the defaults.
+    RetryRules retryRuleSet
+    // PlausibilityBounds are the [min, max] per variable outside which a value is treated as
+    // corrupt, from PLAUSIBILITY_BOUNDS_JSON merged onto the defaults.
+    PlausibilityBounds map[string][2]float64
+    // DefaultCoordinates is used when a request omits latitude and longitude; nil if unset.
+    DefaultCoordinates *Location
+    // CreateRetryWindow bounds how long inserts into a just-created table are retried
+    // while BigQuery still reports it as not found.
+    CreateRetryWindow time.Duration
+    // NormalsBaselineStart and NormalsBaselineEnd are the default baseline years of /normals,
+    // and NormalsMinYears how many of them must have data.
+    NormalsBaselineStart int
+    NormalsBaselineEnd   int
+    NormalsMinYears      int
+    // VerifyWindow bounds how long verify=true polls for inserted rows to become visible.
+    VerifyWindow time.Duration
+    // IncrementalTolerance is how far, in degrees, a stored grid point may be from the
+    // requested coordinate and still count as the same location in incremental mode.
+    IncrementalTolerance float64
+}
+
+// cfg is the configuration loaded once per instance.
+var cfg = loadConfig()
+
+// loadConfig reads the configuration from the environment, falling back to defaults.
+func loadConfig() *Config {
+    return &Config{
+        ProjectID:        getEnv("BIGQUERY_PROJECT_ID", "dataform-intro-469416"),
+        DatasetID:        getEnv("BIGQUERY_DATASET_ID", "weather_dataset"),
+        TableID:          getEnv("BIGQUERY_TABLE_ID", "daily_weather"),
+        PartitionField:   getEnvOrNone("TABLE_PARTITION_FIELD", "date"),
+        PartitionType:    strings.ToUpper(getEnv("TABLE_PARTITION_TYPE", "DAY")),
+        ClusteringFields: splitList(getEnvOrNone("TABLE_CLUSTERING_FIELDS", "latitude,longitude")),
+        AuthSecret:       os.Getenv("AUTH_SHARED_SECRET"),
+        OpenMeteoAPIKey:  os.Getenv("OPENMETEO_API_KEY"),
+        CredentialsJSON:  os.Getenv("GOOGLE_APPLICATION_CREDENTIALS_JSON"),
+        StrictDecode:     getEnvBool("STRICT_DECODE", false),
+        RecordIngestRuns: getEnvBool("RECORD_INGEST_RUNS", false),
+        RecordCallerInfo: getEnvBool("RECORD_CALLER_INFO", false),
+        IngestRunsTable:  getEnv("INGEST_RUNS_TABLE_ID", "ingest_runs"),
+        ChecksumsTable:   getEnv("CHECKSUMS_TABLE_ID", "range_checksums"),
+        MonthlyTableID:   getEnv("MONTHLY_TABLE_ID", "monthly_weather"),
+        ModelsTableID:    getEnv("MODELS_TABLE_ID", "model_comparison"),
+        LongTableID:      getEnv("LONG_TABLE_ID", "daily_weather_long"),
+        DefaultRound:     getEnvInt("ROUND_DECIMALS", -1),
+        GeohashPrecision: uint(min(max(getEnvInt("GEOHASH_PRECISION", 7), 1), 12)),
+        MaxCoordinates:   getEnvInt("MAX_COORDINATES", 1000),
+        WorkerPoolSize:   max(getEnvInt("WORKER_POOL_SIZE", 4), 1),
+        MaxResponseRows:  getEnvInt("MAX_RESPONSE_ROWS", 100000),
+        MetricsEnabled:   getEnvBool("METRICS_ENABLED", false),
+        Environment:      os.Getenv("ENVIRONMENT"),
+        FreshnessWindow:  getEnvDuration("FRESHNESS_WINDOW", time.Hour),
+        MaxRetries:       max(getEnvInt("MAX_RETRIES", 3), 0),
+        FunctionTimeout:  getEnvDuration("FUNCTION_TIMEOUT", 60*time.Second),
+        MaxInFlight:      getEnvInt("MAX_IN_FLIGHT_REQUESTS", 0),
+        RequestTimeout:   getEnvDuration("REQUEST_TIMEOUT", 0),
+        GzipMinBytes:     getEnvInt("GZIP_MIN_BYTES", 1024),
+        LogLevel:         parseLogLevel(os.Getenv("LOG_LEVEL")),
+
+        MaxIdleConns:        getEnvInt("HTTP_MAX_IDLE_CONNS", 100),
+        MaxIdleConnsPerHost: getEnvInt("HTTP_MAX_IDLE_CONNS_PER_HOST", 16),
+        IdleConnTimeout:     getEnvDuration("HTTP_IDLE_CONN_TIMEOUT", 90*time.Second),
+
+        ResponseRowsPolicy: strings.ToLower(getEnv("MAX_RESPONSE_ROWS_POLICY", "reject")),
+
+        DeadLetterURI: os.Getenv("DEAD_LETTER_GCS_URI"),
+
+        ManifestURI: os.Getenv("RUN_MANIFEST_GCS_URI"),
+
+        NonFiniteSentinel: nonFiniteSentinel(),
+
+        GeocodingTimeout:  getEnvDuration("GEOCODING_TIMEOUT", 5*time.Second),
+        GeocodingMaxBytes: int64(max(getEnvInt("GEOCODING_MAX_BYTES", 64<<10), 1)),
+        GeocodingCacheTTL: getEnvDuration("GEOCODING_CACHE_TTL", 24*time.Hour),
+
+        RejectNullIsland: getEnvBool("REJECT_NULL_ISLAND", false),
+
+        RecordVariablesMeta: getEnvBool("RECORD_VARIABLES_META", false),
+        VariablesMetaTable:  getEnv("VARIABLES_META_TABLE_ID", "weather_variables_meta"),
+
+        IngestPaused: getEnvBool("INGEST_PAUSED", false),
+
+        CounterHeaders: getEnvBool("COUNTER_HEADERS", false),
+
+        MaxRedirects: max(getEnvInt("HTTP_MAX_REDIRECTS", 3), 0),
+        Mirrors:      splitList(os.Getenv("OPENMETEO_MIRRORS")),
+
+        DefaultCoordinates: defaultCoordinates(),
+        AllowedRegion:      allowedRegion(),
+
+        RetryRules:         retryRules(),
+        PlausibilityBounds: plausibilityBounds(),
+
+        CreateRetryWindow: getEnvDuration("TABLE_CREATE_RETRY_WINDOW", 30*time.Second),
+
+        NormalsBaselineStart: getEnvInt("NORMALS_BASELINE_START", 1991),
+        NormalsBaselineEnd:   getEnvInt("NORMALS_BASELINE_END", 2020),
+        NormalsMinYears:      getEnvInt("NORMALS_MIN_YEARS", 25),
+
+        VerifyWindow: getEnvDuration("VERIFY_WINDOW", 30*time.Second),
+
+        IncrementalTolerance: getEnvFloat("INCREMENTAL_COORD_TOLERANCE", 0.05),
+    }
+}
+
+// defaultCoordinates reads DEFAULT_LATITUDE and DEFAULT_LONGITUDE, returning nil unless both are valid.
+func defaultCoordinates() *Location {
+    lat, latErr := strconv.ParseFloat(os.Getenv("DEFAULT_LATITUDE"), 64)
+    lon, lonErr := strconv.ParseFloat(os.Getenv("DEFAULT_LONGITUDE"), 64)
+    if latErr != nil || lonErr != nil || validateCoordinates(lat, lon) != nil {
+        return nil
+    }
+    return &Location{Latitude: lat, Longitude: lon}
+}
+
+// allowedRegion reads ALLOWED_BBOX. An invalid box rejects every coordinate; init logs why
+// once logging is set up.
+func allowedRegion() *boundingBox {
+    box, _ := parseAllowedRegion(os.Getenv("ALLOWED_BBOX"))
+    return box
+}
+
+// retryRules reads RETRY_RULES_JSON. Invalid rules fall back to the defaults; init logs why
+// once logging is set up.
+func retryRules() retryRuleSet {
+    rules, _ := parseRetryRules(os.Getenv("RETRY_RULES_JSON"))
+    return rules
+}
+
+// plausibilityBounds reads PLAUSIBILITY_BOUNDS_JSON. Invalid overrides fall back to the
+// defaults; init logs why once logging is set up.
+func plausibilityBounds() map[string][2]float64 {
+    bounds, _ := parsePlausibilityBounds(os.Getenv("PLAUSIBILITY_BOUNDS_JSON"))
+    return bounds
+}
+
+// nonFiniteSentinel reads NONFINITE_JSON_SENTINEL, returning nil unless it is a finite number.
+func nonFiniteSentinel() *float64 {
+    f, err := strconv.ParseFloat(os.Getenv("NONFINITE_JSON_SENTINEL"), 64)
+    if err != nil || math.IsNaN(f) || math.IsInf(f, 0) {
+        return nil
+    }
+    return &f
+}
+
+// getEnv returns the value of the environment variable or the fallback when unset.
+func getEnv(key, fallback string) string {
+    if v, ok := os.LookupEnv(key); ok && v != "" {
+        return v
+    }
+    return fallback
+}
+
+// getEnvBool parses a boolean environment variable, returning the fallback when unset or invalid.
+func getEnvBool(key string, fallback bool) bool {
+    b, err := strconv.ParseBool(os.Getenv(key))
+    if err != nil {
+        return fallback
+    }
+    return b
+}
+
+// getEnvInt parses an integer environment variable, returning the fallback when unset or invalid.
+func getEnvInt(key string, fallback int) int {
+    n, err := strconv.Atoi(os.Getenv(key))
+    if err != nil {
+        return fallback
+    }
+    return n
+}
+
+// getEnvFloat parses a float environment variable, returning the fallback when unset or invalid.
+func getEnvFloat(key string, fallback float64) float64 {
+    f, err := strconv.ParseFloat(os.Getenv(key), 64)
+    if err != nil {
+        return fallback
+    }
+    return f
+}
+
+// getEnvDuration parses a duration environment variable, returning the fallback when unset or invalid.
+func getEnvDuration(key string, fallback time.Duration) time.Duration {
+    d, err := time.ParseDuration(os.Getenv(key))
+    if err != nil {
+        return fallback
+    }
+    return d
+}
+
+// getEnvOrNone is like getEnv but treats the value "none" as an explicit empty setting.
+func getEnvOrNone(key, fallback string) string {
+    v := getEnv(key, fallback)
+    if strings.EqualFold(v, "none") {
+        return ""
+    }
+    return v
+}
+
+// splitList splits a comma-separated list, dropping empty entries.
+func splitList(s string) []string {
+    var out []string
+    for _, part := range strings.Split(s, ",") {
+        if part = strings.TrimSpace(part); part != "" {
+            out = append(out, part)
+        }
+    }
+    return out
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+    "net/http"
+    "reflect"
+    "time"
+)
+
+// secretConfigFields are the Config fields that are never returned by /config. Any new
+// credential added to Config must be listed here.
+var secretConfigFields = map[string]bool{
+    "AuthSecret":      true,
+    "OpenMeteoAPIKey": true,
+    "CredentialsJSON": true,
+}
+
+// serveConfig returns the effective configuration as JSON. Secrets are replaced by
+// "REDACTED" when set, so operators can still see whether they are configured.
+func serveConfig(w http.ResponseWriter, r *http.Request) {
+    if r.Method != http.MethodGet {
+        http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+        return
+    }
+    writeJSON(w, http.StatusOK, redactedConfig(cfg))
+}
+
+// redactedConfig returns the fields of c keyed by name, with secrets redacted and
+// durations written as strings such as "30s".
+func redactedConfig(c *Config) map[string]interface{} {
+    v := reflect.ValueOf(c).Elem()
+    out := make(map[string]interface{}, v.NumField())
+    for i := 0; i < v.NumField(); i++ {
+        name := v.Type().Field(i).Name
+        field := v.Field(i).Interface()
+        switch {
+        case secretConfigFields[name]:
+            if !v.Field(i).IsZero() {
+                field = "REDACTED"
+            }
+        case v.Field(i).Type() == reflect.TypeOf(time.Duration(0)):
+            field = field.(time.Duration).String()
+        }
+        out[name] = field
+    }
+    return out
+}
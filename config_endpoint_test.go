@@ -0,0 +1,81 @@
+package main
+
+import (
+    "encoding/json"
+    "net/http"
+    "net/http/httptest"
+    "reflect"
+    "testing"
+    "time"
+)
+
+func TestRedactedConfig(t *testing.T) {
+    tests := []struct {
+        name  string
+        set   func(*Config)
+        field string
+        want  interface{}
+    }{
+        {"set secret is redacted", func(c *Config) { c.AuthSecret = "s3cret" }, "AuthSecret", "REDACTED"},
+        {"empty secret shows as unset", func(c *Config) { c.OpenMeteoAPIKey = "" }, "OpenMeteoAPIKey", ""},
+        {"credentials are redacted", func(c *Config) { c.CredentialsJSON = `{"private_key":"x"}` }, "CredentialsJSON", "REDACTED"},
+        {"duration as string", func(c *Config) { c.VerifyWindow = 90 * time.Second }, "VerifyWindow", "1m30s"},
+        {"plain value", func(c *Config) { c.TableID = "daily_weather" }, "TableID", "daily_weather"},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            withConfig(t, tt.set)
+            got := redactedConfig(cfg)
+            if !reflect.DeepEqual(got[tt.field], tt.want) {
+                t.Errorf("%s = %#v, want %#v", tt.field, got[tt.field], tt.want)
+            }
+            if n := reflect.TypeOf(Config{}).NumField(); len(got) != n {
+                t.Errorf("returned %d fields, want all %d", len(got), n)
+            }
+        })
+    }
+}
+
+func TestSecretConfigFieldsExist(t *testing.T) {
+    typ := reflect.TypeOf(Config{})
+    for name := range secretConfigFields {
+        if _, ok := typ.FieldByName(name); !ok {
+            t.Errorf("secret field %s is not in Config", name)
+        }
+    }
+}
+
+func TestServeConfig(t *testing.T) {
+    tests := []struct {
+        name   string
+        secret string
+        method string
+        want   int
+    }{
+        {"admin", "s3cret", http.MethodGet, http.StatusOK},
+        {"without a shared secret", "", http.MethodGet, http.StatusForbidden},
+        {"wrong method", "s3cret", http.MethodPost, http.StatusMethodNotAllowed},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            withConfig(t, func(c *Config) { c.AuthSecret = tt.secret })
+            req := httptest.NewRequest(tt.method, "/config", nil)
+            req.Header.Set("Authorization", "Bearer "+tt.secret)
+            rec := httptest.NewRecorder()
+            newRouter().ServeHTTP(rec, req)
+            if rec.Code != tt.want {
+                t.Fatalf("status = %d, want %d", rec.Code, tt.want)
+            }
+            if tt.want != http.StatusOK {
+                return
+            }
+            var body map[string]interface{}
+            if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+                t.Fatal(err)
+            }
+            if body["AuthSecret"] != "REDACTED" {
+                t.Errorf("AuthSecret = %v, want REDACTED", body["AuthSecret"])
+            }
+        })
+    }
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+    "reflect"
+    "testing"
+    "time"
+)
+
+// withConfig applies set to the configuration for the rest of the test and restores the
+// previous configuration when it ends.
+func withConfig(t *testing.T, set func(*Config)) {
+    t.Helper()
+    saved := *cfg
+    t.Cleanup(func() { *cfg = saved })
+    set(cfg)
+}
+
+func TestGetEnvOrNone(t *testing.T) {
+    tests := []struct {
+        name  string
+        value string
+        set   bool
+        want  string
+    }{
+        {"unset uses fallback", "", false, "date"},
+        {"empty uses fallback", "", true, "date"},
+        {"explicit value", "inserted_at", true, "inserted_at"},
+        {"none disables", "none", true, ""},
+        {"none is case-insensitive", "NONE", true, ""},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            if tt.set {
+                t.Setenv("TEST_PARTITION_FIELD", tt.value)
+            }
+            if got := getEnvOrNone("TEST_PARTITION_FIELD", "date"); got != tt.want {
+                t.Errorf("getEnvOrNone() = %q, want %q", got, tt.want)
+            }
+        })
+    }
+}
+
+func TestLoadConfigPartitioning(t *testing.T) {
+    tests := []struct {
+        name           string
+        env            map[string]string
+        wantField      string
+        wantType       string
+        wantClustering []string
+    }{
+        {"defaults", nil, "date", "DAY", []string{"latitude", "longitude"}},
+        {"monthly", map[string]string{"TABLE_PARTITION_TYPE": "month"}, "date", "MONTH", []string{"latitude", "longitude"}},
+        {"disabled", map[string]string{"TABLE_PARTITION_FIELD": "none", "TABLE_CLUSTERING_FIELDS": "none"}, "", "DAY", nil},
+        {"custom clustering", map[string]string{"TABLE_CLUSTERING_FIELDS": "grid_cell_id, date"}, "date", "DAY", []string{"grid_cell_id", "date"}},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            for k, v := range tt.env {
+                t.Setenv(k, v)
+            }
+            c := loadConfig()
+            if c.PartitionField != tt.wantField || c.PartitionType != tt.wantType {
+                t.Errorf("partitioning = %q/%q, want %q/%q", c.PartitionField, c.PartitionType, tt.wantField, tt.wantType)
+            }
+            if !reflect.DeepEqual(c.ClusteringFields, tt.wantClustering) {
+                t.Errorf("ClusteringFields = %q, want %q", c.ClusteringFields, tt.wantClustering)
+            }
+        })
+    }
+}
+
+func TestDefaultCoordinates(t *testing.T) {
+    tests := []struct {
+        name string
+        lat  string
+        lon  string
+        want *Location
+    }{
+        {"both set", "52.52", "13.41", &Location{Latitude: 52.52, Longitude: 13.41}},
+        {"unset", "", "", nil},
+        {"latitude only", "52.52", "", nil},
+        {"not a number", "north", "13.41", nil},
+        {"out of range", "95", "13.41", nil},
+        {"not a coordinate", "NaN", "13.41", nil},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            t.Setenv("DEFAULT_LATITUDE", tt.lat)
+            t.Setenv("DEFAULT_LONGITUDE", tt.lon)
+            if got := defaultCoordinates(); !reflect.DeepEqual(got, tt.want) {
+                t.Errorf("defaultCoordinates() = %+v, want %+v", got, tt.want)
+            }
+        })
+    }
+}
+
+func TestLoadConfigConnectionPool(t *testing.T) {
+    tests := []struct {
+        name        string
+        env         map[string]string
+        wantIdle    int
+        wantPerHost int
+        wantTimeout time.Duration
+    }{
+        {"defaults", nil, 100, 16, 90 * time.Second},
+        {"tuned", map[string]string{"HTTP_MAX_IDLE_CONNS": "20", "HTTP_MAX_IDLE_CONNS_PER_HOST": "8", "HTTP_IDLE_CONN_TIMEOUT": "30s"}, 20, 8, 30 * time.Second},
+        {"invalid values use defaults", map[string]string{"HTTP_MAX_IDLE_CONNS": "many", "HTTP_IDLE_CONN_TIMEOUT": "30"}, 100, 16, 90 * time.Second},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            for k, v := range tt.env {
+                t.Setenv(k, v)
+            }
+            c := loadConfig()
+            if c.MaxIdleConns != tt.wantIdle || c.MaxIdleConnsPerHost != tt.wantPerHost || c.IdleConnTimeout != tt.wantTimeout {
+                t.Errorf("pool = %d/%d/%v, want %d/%d/%v", c.MaxIdleConns, c.MaxIdleConnsPerHost, c.IdleConnTimeout, tt.wantIdle, tt.wantPerHost, tt.wantTimeout)
+            }
+        })
+    }
+}
+
+func TestLoadConfigResponseRows(t *testing.T) {
+    tests := []struct {
+        name       string
+        env        map[string]string
+        wantMax    int
+        wantPolicy string
+    }{
+        {"defaults", nil, 100000, "reject"},
+        {"truncate", map[string]string{"MAX_RESPONSE_ROWS": "500", "MAX_RESPONSE_ROWS_POLICY": "Truncate"}, 500, "truncate"},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            for k, v := range tt.env {
+                t.Setenv(k, v)
+            }
+            c := loadConfig()
+            if c.MaxResponseRows != tt.wantMax || c.ResponseRowsPolicy != tt.wantPolicy {
+                t.Errorf("cap = %d/%q, want %d/%q", c.MaxResponseRows, c.ResponseRowsPolicy, tt.wantMax, tt.wantPolicy)
+            }
+        })
+    }
+}
+
+func TestLoadConfigMaxRedirects(t *testing.T) {
+    tests := []struct {
+        value string
+        want  int
+    }{
+        {"", 3},
+        {"5", 5},
+        {"0", 0},
+        {"-1", 0},
+        {"many", 3},
+    }
+    for _, tt := range tests {
+        t.Run(tt.value, func(t *testing.T) {
+            t.Setenv("HTTP_MAX_REDIRECTS", tt.value)
+            if got := loadConfig().MaxRedirects; got != tt.want {
+                t.Errorf("MaxRedirects = %d, want %d", got, tt.want)
+            }
+        })
+    }
+}
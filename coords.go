@@ -0,0 +1,338 @@
+package main
+
+import (
+    "bufio"
+    "context"
+    "encoding/csv"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "io"
+    "log/slog"
+    "math"
+    "net/http"
+    "path"
+    "strconv"
+    "strings"
+    "sync"
+    "time"
+
+    "cloud.google.com/go/bigquery"
+    "cloud.google.com/go/storage"
+)
+
+// Location is a single coordinate to ingest.
+type Location struct {
+    Latitude  float64 `json:"latitude"`
+    Longitude float64 `json:"longitude"`
+    // ID optionally names the location, for table routing rules.
+    ID string `json:"id,omitempty"`
+}
+
+// LocationResult reports the outcome of ingesting one location in a multi-location request.
+type LocationResult struct {
+    Latitude  float64 `json:"latitude"`
+    Longitude float64 `json:"longitude"`
+    Status    string  `json:"status"`
+    Rows      int     `json:"rows"`
+    Error     string  `json:"error,omitempty"`
+    // Verified reports the verify=true read-back; omitted when verification was not requested.
+    Verified *bool `json:"verified,omitempty"`
+    // UpstreamRemaining is the Open-Meteo budget left after this location, when reported.
+    UpstreamRemaining string `json:"upstream_rate_limit_remaining,omitempty"`
+    // Upstream is the Open-Meteo endpoint that served this location.
+    Upstream string `json:"upstream,omitempty"`
+}
+
+// ingestFromGCS ingests every coordinate listed in the GCS file named by opts.CoordsGCSURI
+// and writes a per-coordinate summary.
+func ingestFromGCS(ctx context.Context, w http.ResponseWriter, client *bigquery.Client, opts *requestOptions) {
+    locations, err := loadGCSCoordinates(ctx, opts.CoordsGCSURI, opts.CoordsFormat)
+    if err != nil {
+        writeError(w, err)
+        return
+    }
+
+    writeMultiStatus(w, ingestLocations(ctx, client, opts, locations))
+}
+
+// writeMultiStatus writes the per-location outcomes of a multi-location request. Successful
+// locations are kept even when others fail, so the overall status is 200 if any succeeded.
+// When every location hit a BigQuery quota, the status is 429 so callers back off. The
+// lowest upstream budget any location reported is repeated at the top level, as locations
+// finish out of order and the lowest is the closest to what is left.
+func writeMultiStatus(w http.ResponseWriter, results []LocationResult) {
+    succeeded, quota, total := 0, 0, 0
+    remaining, lowest := "", 0
+    for i := range results {
+        if n, err := strconv.Atoi(results[i].UpstreamRemaining); err == nil && (remaining == "" || n < lowest) {
+            remaining, lowest = results[i].UpstreamRemaining, n
+        }
+        if results[i].Error == "" {
+            results[i].Status = "ok"
+            succeeded++
+            total += results[i].Rows
+        } else {
+            results[i].Status = "error"
+            if results[i].Error == quotaMessage {
+                quota++
+            }
+        }
+    }
+
+    status := http.StatusOK
+    switch {
+    case succeeded > 0:
+    case quota > 0 && quota == len(results):
+        status = http.StatusTooManyRequests
+        w.Header().Set("Retry-After", strconv.Itoa(int(quotaRetryAfter.Seconds())))
+    default:
+        status = http.StatusBadGateway
+    }
+    body := map[string]interface{}{
+        "locations":  results,
+        "succeeded":  succeeded,
+        "failed":     len(results) - succeeded,
+        "total_rows": total,
+    }
+    if remaining != "" {
+        body["upstream_rate_limit_remaining"] = remaining
+    }
+    writeJSON(w, status, body)
+}
+
+// ingestLocations ingests each location with a bounded pool of workers, returning the
+// results in the same order as locations.
+func ingestLocations(ctx context.Context, client *bigquery.Client, opts *requestOptions, locations []Location) []LocationResult {
+    results := make([]LocationResult, len(locations))
+    jobs := make(chan int)
+    var wg sync.WaitGroup
+    for n := 0; n < cfg.WorkerPoolSize; n++ {
+        wg.Add(1)
+        go func() {
+            defer wg.Done()
+            for i := range jobs {
+                results[i] = ingestLocation(ctx, client, opts, locations[i])
+            }
+        }()
+    }
+    for i := range locations {
+        jobs <- i
+    }
+    close(jobs)
+    wg.Wait()
+    return results
+}
+
+// ingestLocation ingests a single location using a copy of the shared options.
+func ingestLocation(ctx context.Context, client *bigquery.Client, opts *requestOptions, loc Location) LocationResult {
+    locOpts := *opts
+    locOpts.Latitude, locOpts.Longitude, _ = normalizeCoordinates(loc.Latitude, loc.Longitude)
+    if table := routeTable(loc); table != "" {
+        slog.Debug("Routing location", "latitude", loc.Latitude, "longitude", loc.Longitude, "table", table)
+        locOpts.Table = table
+    }
+
+    res := LocationResult{Latitude: loc.Latitude, Longitude: loc.Longitude}
+    // Coordinates read from GCS are only known here; all others were checked while parsing.
+    if err := checkAllowedRegion(locOpts.Latitude, locOpts.Longitude); err != nil {
+        res.Error = errorMessage(err)
+        return res
+    }
+    result, err := ingest(ctx, client, &locOpts, time.Now())
+    if err != nil {
+        slog.Error("Failed to ingest location", "latitude", loc.Latitude, "longitude", loc.Longitude, "error", err)
+        res.Error = errorMessage(err)
+        return res
+    }
+    res.Rows = result.Rows
+    if result.RateLimit != nil {
+        res.UpstreamRemaining = result.RateLimit.Remaining
+    }
+    res.Upstream = result.Endpoint
+    if opts.Verify {
+        res.Verified = &result.Verified
+    }
+    return res
+}
+
+// loadGCSCoordinates downloads and parses a coordinates file from GCS.
+func loadGCSCoordinates(ctx context.Context, uri, format string) ([]Location, error) {
+    bucket, object, ok := parseGCSURI(uri)
+    if !ok {
+        return nil, &requestError{http.StatusBadRequest, "Invalid coords_gcs_uri", fmt.Errorf("invalid GCS URI %q", uri)}
+    }
+    if format == "" {
+        format = formatFromExtension(object)
+    }
+    if format != "csv" && format != "ndjson" {
+        return nil, &requestError{http.StatusBadRequest, "Unsupported coordinates file format", fmt.Errorf("unsupported coordinates format %q", format)}
+    }
+
+    gcs, err := storage.NewClient(ctx, clientOptions()...)
+    if err != nil {
+        return nil, &requestError{http.StatusInternalServerError, "Storage error", fmt.Errorf("failed to create storage client: %w", err)}
+    }
+    defer gcs.Close()
+
+    reader, err := gcs.Bucket(bucket).Object(object).NewReader(ctx)
+    if err != nil {
+        if errors.Is(err, storage.ErrObjectNotExist) {
+            return nil, &requestError{http.StatusBadRequest, "Coordinates file not found", err}
+        }
+        return nil, &requestError{http.StatusInternalServerError, "Storage error", fmt.Errorf("failed to open %s: %w", uri, err)}
+    }
+    defer reader.Close()
+
+    locations, err := parseCoordinatesFile(reader, format)
+    if err != nil {
+        return nil, &requestError{http.StatusBadRequest, fmt.Sprintf("Invalid coordinates file: %v", err), err}
+    }
+    return locations, nil
+}
+
+// parseGCSURI splits a gs://bucket/object URI.
+func parseGCSURI(uri string) (string, string, bool) {
+    rest, ok := strings.CutPrefix(uri, "gs://")
+    if !ok {
+        return "", "", false
+    }
+    bucket, object, ok := strings.Cut(rest, "/")
+    if !ok || bucket == "" || object == "" {
+        return "", "", false
+    }
+    return bucket, object, true
+}
+
+// formatFromExtension guesses the coordinates file format from the object name.
+func formatFromExtension(object string) string {
+    switch strings.ToLower(path.Ext(object)) {
+    case ".csv":
+        return "csv"
+    case ".ndjson", ".jsonl", ".json":
+        return "ndjson"
+    }
+    return ""
+}
+
+// parseCoordinatesFile parses a CSV (latitude,longitude[,id] with an optional header row) or
+// NDJSON ({"latitude":..,"longitude":..,"id":..} per line) coordinates file, capped at
+// cfg.MaxCoordinates. The id is optional and only used for table routing.
+func parseCoordinatesFile(r io.Reader, format string) ([]Location, error) {
+    var locations []Location
+    add := func(loc Location) error {
+        if err := validateCoordinates(loc.Latitude, loc.Longitude); err != nil {
+            return err
+        }
+        if err := checkNullIsland(loc.Latitude, loc.Longitude); err != nil {
+            return err
+        }
+        if len(locations) >= cfg.MaxCoordinates {
+            return fmt.Errorf("more than %d coordinates", cfg.MaxCoordinates)
+        }
+        locations = append(locations, loc)
+        return nil
+    }
+
+    switch format {
+    case "csv":
+        reader := csv.NewReader(r)
+        reader.FieldsPerRecord = -1
+        for line := 1; ; line++ {
+            record, err := reader.Read()
+            if err == io.EOF {
+                break
+            }
+            if err != nil {
+                return nil, err
+            }
+            if len(record) < 2 {
+                return nil, fmt.Errorf("line %d: expected latitude,longitude", line)
+            }
+            lat, latErr := strconv.ParseFloat(strings.TrimSpace(record[0]), 64)
+            lon, lonErr := strconv.ParseFloat(strings.TrimSpace(record[1]), 64)
+            if latErr != nil || lonErr != nil {
+                if line == 1 {
+                    continue // Header row.
+                }
+                return nil, fmt.Errorf("line %d: invalid coordinates", line)
+            }
+            loc := Location{Latitude: lat, Longitude: lon}
+            if len(record) > 2 {
+                loc.ID = strings.TrimSpace(record[2])
+            }
+            if err := add(loc); err != nil {
+                return nil, fmt.Errorf("line %d: %w", line, err)
+            }
+        }
+    case "ndjson":
+        scanner := bufio.NewScanner(r)
+        for line := 1; scanner.Scan(); line++ {
+            text := strings.TrimSpace(scanner.Text())
+            if text == "" {
+                continue
+            }
+            var raw struct {
+                Latitude  *float64 `json:"latitude"`
+                Longitude *float64 `json:"longitude"`
+                ID        string   `json:"id"`
+            }
+            if err := json.Unmarshal([]byte(text), &raw); err != nil || raw.Latitude == nil || raw.Longitude == nil {
+                return nil, fmt.Errorf("line %d: expected {\"latitude\":..,\"longitude\":..}", line)
+            }
+            if err := add(Location{Latitude: *raw.Latitude, Longitude: *raw.Longitude, ID: raw.ID}); err != nil {
+                return nil, fmt.Errorf("line %d: %w", line, err)
+            }
+        }
+        if err := scanner.Err(); err != nil {
+            return nil, err
+        }
+    }
+
+    if len(locations) == 0 {
+        return nil, fmt.Errorf("no coordinates found")
+    }
+    return locations, nil
+}
+
+// validateCoordinates checks that a coordinate is within the valid latitude/longitude range.
+func validateCoordinates(latitude, longitude float64) error {
+    if math.IsNaN(latitude) || latitude < -90 || latitude > 90 {
+        return fmt.Errorf("latitude %v out of range", latitude)
+    }
+    if math.IsNaN(longitude) || longitude < -180 || longitude > 180 {
+        return fmt.Errorf("longitude %v out of range", longitude)
+    }
+    return nil
+}
+
+// checkNullIsland rejects exactly (0, 0) when REJECT_NULL_ISLAND is set. That coordinate,
+// Null Island in the Gulf of Guinea, is what clients send when their coordinates failed to
+// populate far more often than anyone asks for it on purpose.
+func checkNullIsland(latitude, longitude float64) error {
+    if !cfg.RejectNullIsland || latitude != 0 || longitude != 0 {
+        return nil
+    }
+    slog.Warn("Rejected Null Island coordinate", "latitude", latitude, "longitude", longitude)
+    return fmt.Errorf("latitude 0 and longitude 0 (Null Island) is likely a missing coordinate")
+}
+
+// normalizeCoordinates maps edge coordinates to a canonical form before they are sent to
+// Open-Meteo, reporting whether anything changed:
+//   - longitude 180 is the same meridian as -180 and is normalized to -180;
+//   - at the poles (latitude ±90) every longitude is the same point, so longitude is set
+//     to 0 to make all requests for a pole snap to the same grid cell.
+func normalizeCoordinates(latitude, longitude float64) (float64, float64, bool) {
+    lat, lon := latitude, longitude
+    if lon == 180 {
+        lon = -180
+    }
+    if lat == 90 || lat == -90 {
+        lon = 0
+    }
+    if lat != latitude || lon != longitude {
+        slog.Info("Normalized edge coordinate", "latitude", latitude, "longitude", longitude, "normalized_latitude", lat, "normalized_longitude", lon)
+        return lat, lon, true
+    }
+    return lat, lon, false
+}
@@ -0,0 +1,433 @@
+package main
+
+import (
+    "context"
+    "encoding/json"
+    "errors"
+    "io"
+    "log/slog"
+    "math"
+    "mime"
+    "mime/multipart"
+    "net/http"
+    "net/http/httptest"
+    "net/url"
+    "strconv"
+    "strings"
+    "sync"
+    "testing"
+)
+
+// fakeGCS serves objects to the storage client through STORAGE_EMULATOR_HOST and stores
+// the objects it uploads.
+type fakeGCS struct {
+    mu           sync.Mutex
+    objects      map[string]string // "bucket/object" to content
+    contentTypes map[string]string // "bucket/object" to the content type it was uploaded with
+    // uploadStatus, when set, fails every upload with that HTTP status.
+    uploadStatus int
+}
+
+// newFakeGCS points the storage client at a fake holding the given objects.
+func newFakeGCS(t *testing.T, objects map[string]string) *fakeGCS {
+    t.Helper()
+    if objects == nil {
+        objects = make(map[string]string)
+    }
+    fake := &fakeGCS{objects: objects, contentTypes: make(map[string]string)}
+    srv := httptest.NewServer(fake)
+    t.Cleanup(srv.Close)
+    t.Setenv("STORAGE_EMULATOR_HOST", srv.URL)
+    return fake
+}
+
+func (f *fakeGCS) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+    if bucket, ok := strings.CutPrefix(r.URL.Path, "/upload/storage/v1/b/"); ok && r.Method == http.MethodPost {
+        f.upload(w, r, strings.TrimSuffix(bucket, "/o"))
+        return
+    }
+    path, _ := url.PathUnescape(r.URL.EscapedPath())
+    path = strings.TrimPrefix(path, "/download")
+    if rest, ok := strings.CutPrefix(path, "/storage/v1/b/"); ok {
+        path = "/" + strings.Replace(rest, "/o/", "/", 1)
+    }
+    f.mu.Lock()
+    content, ok := f.objects[strings.TrimPrefix(path, "/")]
+    f.mu.Unlock()
+    if r.Method != http.MethodGet || !ok {
+        http.Error(w, `{"error":{"code":404,"message":"Not Found"}}`, http.StatusNotFound)
+        return
+    }
+    w.Header().Set("Content-Type", "application/octet-stream")
+    w.Write([]byte(content))
+}
+
+// upload stores a multipart upload, whose first part is the object metadata and whose
+// second is its content.
+func (f *fakeGCS) upload(w http.ResponseWriter, r *http.Request, bucket string) {
+    if f.uploadStatus != 0 {
+        http.Error(w, `{"error":{"code":503,"message":"Unavailable"}}`, f.uploadStatus)
+        return
+    }
+    _, params, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+    if err != nil {
+        http.Error(w, err.Error(), http.StatusBadRequest)
+        return
+    }
+    parts := multipart.NewReader(r.Body, params["boundary"])
+    var meta struct {
+        Name        string `json:"name"`
+        ContentType string `json:"contentType"`
+    }
+    part, err := parts.NextPart()
+    if err == nil {
+        err = json.NewDecoder(part).Decode(&meta)
+    }
+    if err == nil {
+        part, err = parts.NextPart()
+    }
+    var content []byte
+    if err == nil {
+        content, err = io.ReadAll(part)
+    }
+    if err != nil {
+        http.Error(w, err.Error(), http.StatusBadRequest)
+        return
+    }
+    f.mu.Lock()
+    f.objects[bucket+"/"+meta.Name] = string(content)
+    f.contentTypes[bucket+"/"+meta.Name] = meta.ContentType
+    f.mu.Unlock()
+    w.Header().Set("Content-Type", "application/json")
+    json.NewEncoder(w).Encode(map[string]interface{}{"bucket": bucket, "name": meta.Name, "contentType": meta.ContentType, "size": strconv.Itoa(len(content))})
+}
+
+// object returns the content of bucket/name and whether it exists.
+func (f *fakeGCS) object(name string) (string, bool) {
+    f.mu.Lock()
+    defer f.mu.Unlock()
+    content, ok := f.objects[name]
+    return content, ok
+}
+
+func TestParseGCSURI(t *testing.T) {
+    tests := []struct {
+        uri        string
+        wantBucket string
+        wantObject string
+        wantOK     bool
+    }{
+        {"gs://coords/daily.csv", "coords", "daily.csv", true},
+        {"gs://coords/nested/dir/daily.ndjson", "coords", "nested/dir/daily.ndjson", true},
+        {"gs://coords", "", "", false},
+        {"gs://coords/", "", "", false},
+        {"gs:///daily.csv", "", "", false},
+        {"https://storage.googleapis.com/coords/daily.csv", "", "", false},
+    }
+    for _, tt := range tests {
+        bucket, object, ok := parseGCSURI(tt.uri)
+        if bucket != tt.wantBucket || object != tt.wantObject || ok != tt.wantOK {
+            t.Errorf("parseGCSURI(%q) = %q, %q, %v; want %q, %q, %v", tt.uri, bucket, object, ok, tt.wantBucket, tt.wantObject, tt.wantOK)
+        }
+    }
+}
+
+func TestFormatFromExtension(t *testing.T) {
+    tests := map[string]string{
+        "daily.csv":    "csv",
+        "DAILY.CSV":    "csv",
+        "daily.ndjson": "ndjson",
+        "daily.jsonl":  "ndjson",
+        "daily.json":   "ndjson",
+        "daily.txt":    "",
+        "daily":        "",
+    }
+    for object, want := range tests {
+        if got := formatFromExtension(object); got != want {
+            t.Errorf("formatFromExtension(%q) = %q, want %q", object, got, want)
+        }
+    }
+}
+
+func TestParseCoordinatesFile(t *testing.T) {
+    tests := []struct {
+        name    string
+        format  string
+        content string
+        want    []Location
+        wantErr string
+    }{
+        {"csv with header", "csv", "latitude,longitude\n52.52,13.41\n48.85,2.35\n", []Location{{52.52, 13.41, ""}, {48.85, 2.35, ""}}, ""},
+        {"csv without header", "csv", "52.52,13.41\n", []Location{{52.52, 13.41, ""}}, ""},
+        {"csv with ids", "csv", "lat,lon,id\n52.52, 13.41, berlin\n", []Location{{52.52, 13.41, "berlin"}}, ""},
+        {"csv short line", "csv", "52.52\n", nil, "line 1: expected latitude,longitude"},
+        {"csv invalid line", "csv", "52.52,13.41\nnorth,east\n", nil, "line 2: invalid coordinates"},
+        {"csv out of range", "csv", "91,13.41\n", nil, "line 1: latitude 91 out of range"},
+        {"ndjson", "ndjson", "{\"latitude\":52.52,\"longitude\":13.41,\"id\":\"berlin\"}\n\n{\"latitude\":-33.87,\"longitude\":151.21}\n", []Location{{52.52, 13.41, "berlin"}, {-33.87, 151.21, ""}}, ""},
+        {"ndjson missing longitude", "ndjson", "{\"latitude\":52.52}\n", nil, "line 1: expected"},
+        {"ndjson out of range", "ndjson", "{\"latitude\":0,\"longitude\":181}\n", nil, "line 1: longitude 181 out of range"},
+        {"empty", "csv", "latitude,longitude\n", nil, "no coordinates found"},
+        {"too many", "csv", "1,1\n2,2\n3,3\n", nil, "line 3: more than 2 coordinates"},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            withConfig(t, func(c *Config) { c.MaxCoordinates = 2 })
+            got, err := parseCoordinatesFile(strings.NewReader(tt.content), tt.format)
+            if tt.wantErr != "" {
+                if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+                    t.Fatalf("err = %v, want it to contain %q", err, tt.wantErr)
+                }
+                return
+            }
+            if err != nil {
+                t.Fatal(err)
+            }
+            if len(got) != len(tt.want) {
+                t.Fatalf("got %d locations, want %d", len(got), len(tt.want))
+            }
+            for i := range got {
+                if got[i] != tt.want[i] {
+                    t.Errorf("location %d = %+v, want %+v", i, got[i], tt.want[i])
+                }
+            }
+        })
+    }
+}
+
+func TestLoadGCSCoordinates(t *testing.T) {
+    newFakeGCS(t, map[string]string{
+        "coords/daily.csv":   "latitude,longitude\n52.52,13.41\n",
+        "coords/daily.jsonl": "{\"latitude\":48.85,\"longitude\":2.35}\n",
+        "coords/daily.txt":   "52.52,13.41\n",
+        "coords/broken.csv":  "52.52\n",
+    })
+    tests := []struct {
+        name       string
+        uri        string
+        format     string
+        want       int
+        wantStatus int
+    }{
+        {"csv by extension", "gs://coords/daily.csv", "", 1, 0},
+        {"ndjson by extension", "gs://coords/daily.jsonl", "", 1, 0},
+        {"explicit format", "gs://coords/daily.txt", "csv", 1, 0},
+        {"unknown format", "gs://coords/daily.txt", "", 0, http.StatusBadRequest},
+        {"invalid uri", "coords/daily.csv", "", 0, http.StatusBadRequest},
+        {"missing object", "gs://coords/missing.csv", "", 0, http.StatusBadRequest},
+        {"invalid file", "gs://coords/broken.csv", "", 0, http.StatusBadRequest},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            got, err := loadGCSCoordinates(context.Background(), tt.uri, tt.format)
+            if tt.wantStatus != 0 {
+                var reqErr *requestError
+                if !errors.As(err, &reqErr) || reqErr.Status != tt.wantStatus {
+                    t.Fatalf("err = %v, want a %d request error", err, tt.wantStatus)
+                }
+                return
+            }
+            if err != nil {
+                t.Fatal(err)
+            }
+            if len(got) != tt.want {
+                t.Errorf("got %d locations, want %d", len(got), tt.want)
+            }
+        })
+    }
+}
+
+func TestWriteMultiStatus(t *testing.T) {
+    tests := []struct {
+        name           string
+        results        []LocationResult
+        wantStatus     int
+        wantSucceeded  int
+        wantRows       int
+        wantRetryAfter bool
+    }{
+        {"all succeed", []LocationResult{{Rows: 3}, {Rows: 4}}, http.StatusOK, 2, 7, false},
+        {"partial failure is still 200", []LocationResult{{Rows: 3}, {Error: "Failed to store data"}}, http.StatusOK, 1, 3, false},
+        {"all fail", []LocationResult{{Error: "Failed to fetch"}, {Error: quotaMessage}}, http.StatusBadGateway, 0, 0, false},
+        {"all hit the quota", []LocationResult{{Error: quotaMessage}, {Error: quotaMessage}}, http.StatusTooManyRequests, 0, 0, true},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            rec := httptest.NewRecorder()
+            writeMultiStatus(rec, tt.results)
+            if rec.Code != tt.wantStatus {
+                t.Errorf("status = %d, want %d", rec.Code, tt.wantStatus)
+            }
+            if got := rec.Header().Get("Retry-After") != ""; got != tt.wantRetryAfter {
+                t.Errorf("Retry-After set = %v, want %v", got, tt.wantRetryAfter)
+            }
+            var body struct {
+                Locations []LocationResult `json:"locations"`
+                Succeeded int              `json:"succeeded"`
+                Failed    int              `json:"failed"`
+                TotalRows int              `json:"total_rows"`
+            }
+            if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+                t.Fatal(err)
+            }
+            if body.Succeeded != tt.wantSucceeded || body.Failed != len(tt.results)-tt.wantSucceeded || body.TotalRows != tt.wantRows {
+                t.Errorf("summary = %d ok, %d failed, %d rows; want %d ok, %d rows", body.Succeeded, body.Failed, body.TotalRows, tt.wantSucceeded, tt.wantRows)
+            }
+            for i, loc := range body.Locations {
+                want := "ok"
+                if tt.results[i].Error != "" {
+                    want = "error"
+                }
+                if loc.Status != want {
+                    t.Errorf("location %d status = %q, want %q", i, loc.Status, want)
+                }
+            }
+        })
+    }
+}
+
+func TestNormalizeCoordinates(t *testing.T) {
+    tests := []struct {
+        name        string
+        lat, lon    float64
+        wantLat     float64
+        wantLon     float64
+        wantChanged bool
+    }{
+        {"ordinary coordinate", 52.52, 13.41, 52.52, 13.41, false},
+        {"antimeridian east", 10, 180, 10, -180, true},
+        {"antimeridian west is canonical", 10, -180, 10, -180, false},
+        {"north pole", 90, 45, 90, 0, true},
+        {"south pole", -90, -120, -90, 0, true},
+        {"pole at the antimeridian", 90, 180, 90, 0, true},
+        {"pole already canonical", 90, 0, 90, 0, false},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            lat, lon, changed := normalizeCoordinates(tt.lat, tt.lon)
+            if lat != tt.wantLat || lon != tt.wantLon || changed != tt.wantChanged {
+                t.Errorf("normalizeCoordinates(%v, %v) = %v, %v, %v; want %v, %v, %v", tt.lat, tt.lon, lat, lon, changed, tt.wantLat, tt.wantLon, tt.wantChanged)
+            }
+        })
+    }
+}
+
+func TestValidateCoordinates(t *testing.T) {
+    tests := []struct {
+        lat, lon float64
+        wantErr  bool
+    }{
+        {0, 0, false},
+        {90, 180, false},
+        {-90, -180, false},
+        {90.1, 0, true},
+        {0, -180.5, true},
+        {math.NaN(), 0, true},
+        {0, math.NaN(), true},
+    }
+    for _, tt := range tests {
+        if err := validateCoordinates(tt.lat, tt.lon); (err != nil) != tt.wantErr {
+            t.Errorf("validateCoordinates(%v, %v) = %v, wantErr %v", tt.lat, tt.lon, err, tt.wantErr)
+        }
+    }
+}
+
+func TestWriteMultiStatusRateLimit(t *testing.T) {
+    tests := []struct {
+        name    string
+        results []LocationResult
+        want    string
+    }{
+        {"lowest budget", []LocationResult{{Rows: 1, UpstreamRemaining: "40"}, {Rows: 1, UpstreamRemaining: "38"}, {Rows: 1, UpstreamRemaining: "39"}}, "38"},
+        {"only some reported", []LocationResult{{Rows: 1}, {Error: "Failed to fetch", UpstreamRemaining: "0"}}, "0"},
+        {"non-numeric is ignored", []LocationResult{{Rows: 1, UpstreamRemaining: "lots"}}, ""},
+        {"none reported", []LocationResult{{Rows: 1}}, ""},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            rec := httptest.NewRecorder()
+            writeMultiStatus(rec, tt.results)
+            var body map[string]interface{}
+            if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+                t.Fatal(err)
+            }
+            got, ok := body["upstream_rate_limit_remaining"]
+            if ok != (tt.want != "") || (ok && got != tt.want) {
+                t.Errorf("upstream_rate_limit_remaining = %v (present %v), want %q", got, ok, tt.want)
+            }
+        })
+    }
+}
+
+func TestRejectNullIsland(t *testing.T) {
+    const dates = "&start_date=2024-01-01&end_date=2024-01-03"
+    tests := []struct {
+        name   string
+        reject bool
+        method string
+        query  string
+        body   string
+        want   int
+    }{
+        {"allowed by default", false, http.MethodGet, "latitude=0&longitude=0" + dates, "", http.StatusOK},
+        {"rejected", true, http.MethodGet, "latitude=0&longitude=0" + dates, "", http.StatusBadRequest},
+        {"negative zero", true, http.MethodGet, "latitude=-0&longitude=0.0" + dates, "", http.StatusBadRequest},
+        {"on the equator", true, http.MethodGet, "latitude=0&longitude=13.41" + dates, "", http.StatusOK},
+        {"on the prime meridian", true, http.MethodGet, "latitude=52.52&longitude=0" + dates, "", http.StatusOK},
+        {"next to it", true, http.MethodGet, "latitude=0.0001&longitude=0" + dates, "", http.StatusOK},
+        {"job location", true, http.MethodPost, "", `{"locations":[{"latitude":52.52,"longitude":13.41},{"latitude":0,"longitude":0}],"start_date":"2024-01-01","end_date":"2024-01-03"}`, http.StatusBadRequest},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            withConfig(t, func(c *Config) { c.RejectNullIsland = tt.reject })
+            logs := captureLogs(t, slog.LevelWarn)
+            fake, _ := newFakeBigQuery(t)
+            fetches := 0
+            var mu sync.Mutex
+            stubOpenMeteo(t, func(w http.ResponseWriter, r *http.Request) {
+                mu.Lock()
+                fetches++
+                mu.Unlock()
+                serveBody(threeDays)(w, r)
+            })
+
+            rec := httptest.NewRecorder()
+            fetchWeatherData(rec, httptest.NewRequest(tt.method, "/?"+tt.query, strings.NewReader(tt.body)))
+            if rec.Code != tt.want {
+                t.Fatalf("status = %d, want %d: %s", rec.Code, tt.want, rec.Body)
+            }
+            logged := strings.Contains(logs.String(), "Rejected Null Island coordinate")
+            if tt.want == http.StatusOK {
+                if logged {
+                    t.Errorf("logged a rejection for an accepted request: %s", logs)
+                }
+                return
+            }
+            if !strings.Contains(rec.Body.String(), "Null Island") || !logged {
+                t.Errorf("body %q, logs %q; want the rejection explained and logged", rec.Body, logs)
+            }
+            if n := len(fake.received()); n != 0 || fetches != 0 {
+                t.Errorf("ran %d queries and %d fetches for a rejected request", n, fetches)
+            }
+        })
+    }
+}
+
+func TestParseCoordinatesFileNullIsland(t *testing.T) {
+    tests := []struct {
+        reject  bool
+        wantErr bool
+    }{
+        {false, false},
+        {true, true},
+    }
+    for _, tt := range tests {
+        t.Run(strconv.FormatBool(tt.reject), func(t *testing.T) {
+            withConfig(t, func(c *Config) { c.RejectNullIsland = tt.reject })
+            captureLogs(t, slog.LevelWarn)
+            _, err := parseCoordinatesFile(strings.NewReader("latitude,longitude\n52.52,13.41\n0,0\n"), "csv")
+            if (err != nil) != tt.wantErr {
+                t.Errorf("err = %v, wantErr %v", err, tt.wantErr)
+            }
+        })
+    }
+}
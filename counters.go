@@ -0,0 +1,65 @@
+package main
+
+import (
+    "net/http"
+    "strconv"
+    "sync/atomic"
+)
+
+// Cumulative counters since the instance started, reported in response headers when
+// cfg.CounterHeaders is set.
+var (
+    totalRequests     atomic.Uint64
+    totalRowsInserted atomic.Uint64
+    totalErrors       atomic.Uint64
+)
+
+// withCounterHeaders counts every request and adds the instance's running totals to its
+// response as X-Total-Requests, X-Total-Rows-Inserted, and X-Total-Errors. A response with
+// a 4xx or 5xx status counts as an error, including in its own headers.
+func withCounterHeaders(next http.Handler) http.Handler {
+    if !cfg.CounterHeaders {
+        return next
+    }
+    return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        totalRequests.Add(1)
+        next.ServeHTTP(&counterWriter{ResponseWriter: w}, r)
+    })
+}
+
+// counterWriter sets the counter headers just before the status line is written.
+type counterWriter struct {
+    http.ResponseWriter
+    wroteHeader bool
+}
+
+func (cw *counterWriter) WriteHeader(status int) {
+    if !cw.wroteHeader {
+        cw.wroteHeader = true
+        if status >= http.StatusBadRequest {
+            totalErrors.Add(1)
+        }
+        h := cw.Header()
+        h.Set("X-Total-Requests", strconv.FormatUint(totalRequests.Load(), 10))
+        h.Set("X-Total-Rows-Inserted", strconv.FormatUint(totalRowsInserted.Load(), 10))
+        h.Set("X-Total-Errors", strconv.FormatUint(totalErrors.Load(), 10))
+    }
+    cw.ResponseWriter.WriteHeader(status)
+}
+
+func (cw *counterWriter) Write(b []byte) (int, error) {
+    if !cw.wroteHeader {
+        cw.WriteHeader(http.StatusOK)
+    }
+    return cw.ResponseWriter.Write(b)
+}
+
+func (cw *counterWriter) Flush() {
+    // Flushing commits the headers, so they are set first.
+    if !cw.wroteHeader {
+        cw.WriteHeader(http.StatusOK)
+    }
+    if f, ok := cw.ResponseWriter.(http.Flusher); ok {
+        f.Flush()
+    }
+}
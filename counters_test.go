@@ -0,0 +1,84 @@
+package main
+
+import (
+    "net/http"
+    "net/http/httptest"
+    "strconv"
+    "testing"
+)
+
+// counterHeaders reads the three counter headers of a response.
+func counterHeaders(t *testing.T, rec *httptest.ResponseRecorder) (requests, rows, errors uint64) {
+    t.Helper()
+    var values [3]uint64
+    for i, name := range []string{"X-Total-Requests", "X-Total-Rows-Inserted", "X-Total-Errors"} {
+        v, err := strconv.ParseUint(rec.Header().Get(name), 10, 64)
+        if err != nil {
+            t.Fatalf("%s = %q: %v", name, rec.Header().Get(name), err)
+        }
+        values[i] = v
+    }
+    return values[0], values[1], values[2]
+}
+
+func TestCounterHeaders(t *testing.T) {
+    withConfig(t, func(c *Config) { c.CounterHeaders = true })
+    handlers := []struct {
+        name       string
+        handler    http.HandlerFunc
+        wantErrors uint64
+    }{
+        {"write", func(w http.ResponseWriter, r *http.Request) { w.Write([]byte("ok")) }, 0},
+        {"explicit status", func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusAccepted) }, 0},
+        {"flush first", func(w http.ResponseWriter, r *http.Request) {
+            w.(http.Flusher).Flush()
+            w.Write([]byte("ok"))
+        }, 0},
+        {"client error", func(w http.ResponseWriter, r *http.Request) { http.Error(w, "bad", http.StatusBadRequest) }, 1},
+        {"server error", func(w http.ResponseWriter, r *http.Request) { http.Error(w, "down", http.StatusBadGateway) }, 1},
+    }
+    var lastRequests, lastErrors uint64
+    for i, tt := range handlers {
+        rec := httptest.NewRecorder()
+        withCounterHeaders(tt.handler).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+        requests, _, errors := counterHeaders(t, rec)
+        if i > 0 {
+            if requests != lastRequests+1 {
+                t.Errorf("%s: X-Total-Requests = %d, want %d", tt.name, requests, lastRequests+1)
+            }
+            if errors != lastErrors+tt.wantErrors {
+                t.Errorf("%s: X-Total-Errors = %d, want %d", tt.name, errors, lastErrors+tt.wantErrors)
+            }
+        }
+        lastRequests, lastErrors = requests, errors
+    }
+}
+
+func TestCounterHeadersDisabled(t *testing.T) {
+    withConfig(t, func(c *Config) { c.CounterHeaders = false })
+    rec := httptest.NewRecorder()
+    withCounterHeaders(okHandler).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+    if got := rec.Header().Get("X-Total-Requests"); got != "" {
+        t.Errorf("X-Total-Requests = %q, want none when disabled", got)
+    }
+}
+
+func TestCounterHeadersCountInsertedRows(t *testing.T) {
+    withConfig(t, func(c *Config) { c.CounterHeaders = true })
+    newFakeBigQuery(t)
+    stubOpenMeteo(t, serveBody(threeDays))
+    router := newRouter()
+    var lastRows uint64
+    for i := 0; i < 2; i++ {
+        rec := httptest.NewRecorder()
+        router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/?latitude=52.52&longitude=13.41&start_date=2024-01-01&end_date=2024-01-03", nil))
+        if rec.Code != http.StatusOK {
+            t.Fatalf("status = %d: %s", rec.Code, rec.Body)
+        }
+        _, rows, _ := counterHeaders(t, rec)
+        if i > 0 && rows != lastRows+3 {
+            t.Errorf("X-Total-Rows-Inserted = %d after another 3 rows, want %d", rows, lastRows+3)
+        }
+        lastRows = rows
+    }
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+    "bytes"
+    "context"
+    "encoding/json"
+    "fmt"
+    "log/slog"
+    "reflect"
+    "strings"
+    "time"
+
+    "cloud.google.com/go/bigquery"
+    "cloud.google.com/go/storage"
+)
+
+// deadLetterTimeout bounds writing a dead letter, which runs after the request context
+// may already have expired.
+const deadLetterTimeout = 30 * time.Second
+
+// deadLetterMeta describes a dead-lettered batch so it can be replayed.
+type deadLetterMeta struct {
+    BatchID   string    `json:"batch_id"`
+    Latitude  float64   `json:"latitude"`
+    Longitude float64   `json:"longitude"`
+    StartDate string    `json:"start_date"`
+    EndDate   string    `json:"end_date"`
+    Table     string    `json:"table"`
+    Rows      int       `json:"rows"`
+    Error     string    `json:"error"`
+    FailedAt  time.Time `json:"failed_at"`
+}
+
+// writeDeadLetter stores the rows that could not be inserted into tableID as <batch_id>.ndjson
+// under cfg.DeadLetterURI, with a <batch_id>.meta.json file describing the failure. rows is the
+// payload that was inserted, such as monthly or long rows; days are the fetched daily rows it
+// was built from, which date the batch.
+func writeDeadLetter(ctx context.Context, opts *requestOptions, tableID, batchID string, days []*WeatherData, rows interface{}, insertErr error) error {
+    bucket, prefix, ok := splitGCSPrefix(cfg.DeadLetterURI)
+    if !ok {
+        return fmt.Errorf("invalid DEAD_LETTER_GCS_URI %q", cfg.DeadLetterURI)
+    }
+    ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), deadLetterTimeout)
+    defer cancel()
+
+    data, count, err := encodeDeadLetterRows(rows)
+    if err != nil {
+        return err
+    }
+    meta, err := json.Marshal(deadLetterMeta{
+        BatchID:   batchID,
+        Latitude:  opts.Latitude,
+        Longitude: opts.Longitude,
+        StartDate: days[0].Date,
+        EndDate:   days[len(days)-1].Date,
+        Table:     tableID,
+        Rows:      count,
+        Error:     insertErr.Error(),
+        FailedAt:  now(),
+    })
+    if err != nil {
+        return fmt.Errorf("failed to encode dead-letter metadata: %w", err)
+    }
+
+    gcs, err := storage.NewClient(ctx, clientOptions()...)
+    if err != nil {
+        return fmt.Errorf("failed to create storage client: %w", err)
+    }
+    defer gcs.Close()
+
+    // Write the rows first, so a metadata file always has its data next to it.
+    for _, obj := range []struct {
+        name        string
+        contentType string
+        body        []byte
+    }{
+        {prefix + batchID + ".ndjson", "application/x-ndjson", data.Bytes()},
+        {prefix + batchID + ".meta.json", "application/json", meta},
+    } {
+        if err := writeGCSObject(ctx, gcs, bucket, obj.name, obj.contentType, obj.body); err != nil {
+            return err
+        }
+    }
+    slog.Info("Wrote failed batch to dead letter", "batch_id", batchID, "uri", fmt.Sprintf("gs://%s/%s%s.ndjson", bucket, prefix, batchID))
+    return nil
+}
+
+// encodeDeadLetterRows encodes a slice of rows of any layout as NDJSON, saving the value
+// savers of rolling and versioned rows so their lines hold the columns that were inserted.
+func encodeDeadLetterRows(rows interface{}) (*bytes.Buffer, int, error) {
+    var buf bytes.Buffer
+    enc := json.NewEncoder(&buf)
+    v := reflect.ValueOf(rows)
+    if v.Kind() != reflect.Slice {
+        return nil, 0, fmt.Errorf("unexpected dead-letter rows %T", rows)
+    }
+    for i := 0; i < v.Len(); i++ {
+        row := v.Index(i).Interface()
+        if saver, ok := row.(bigquery.ValueSaver); ok {
+            values, _, err := saver.Save()
+            if err != nil {
+                return nil, 0, fmt.Errorf("failed to save row: %w", err)
+            }
+            row = values
+        }
+        if err := enc.Encode(row); err != nil {
+            return nil, 0, fmt.Errorf("failed to encode row: %w", err)
+        }
+    }
+    return &buf, v.Len(), nil
+}
+
+// splitGCSPrefix splits a gs://bucket/prefix URI into its bucket and its prefix, which is
+// empty or ends in a slash so object names can be appended to it.
+func splitGCSPrefix(uri string) (bucket, prefix string, ok bool) {
+    rest, ok := strings.CutPrefix(uri, "gs://")
+    bucket, prefix, _ = strings.Cut(rest, "/")
+    if !ok || bucket == "" {
+        return "", "", false
+    }
+    if prefix = strings.Trim(prefix, "/"); prefix != "" {
+        prefix += "/"
+    }
+    return bucket, prefix, true
+}
+
+// writeGCSObject writes body to gs://bucket/name with the given content type.
+func writeGCSObject(ctx context.Context, gcs *storage.Client, bucket, name, contentType string, body []byte) error {
+    w := gcs.Bucket(bucket).Object(name).NewWriter(ctx)
+    w.ContentType = contentType
+    if _, err := w.Write(body); err != nil {
+        w.Close()
+        return fmt.Errorf("failed to write gs://%s/%s: %w", bucket, name, err)
+    }
+    if err := w.Close(); err != nil {
+        return fmt.Errorf("failed to write gs://%s/%s: %w", bucket, name, err)
+    }
+    return nil
+}
@@ -0,0 +1,195 @@
+package main
+
+import (
+    "bufio"
+    "encoding/json"
+    "net/http"
+    "net/http/httptest"
+    "strings"
+    "testing"
+    "time"
+)
+
+func TestSplitGCSPrefix(t *testing.T) {
+    tests := []struct {
+        uri        string
+        wantBucket string
+        wantPrefix string
+        wantOK     bool
+    }{
+        {"gs://dlq", "dlq", "", true},
+        {"gs://dlq/", "dlq", "", true},
+        {"gs://dlq/weather", "dlq", "weather/", true},
+        {"gs://dlq/weather/daily/", "dlq", "weather/daily/", true},
+        {"gs://", "", "", false},
+        {"s3://dlq/weather", "", "", false},
+        {"dlq/weather", "", "", false},
+    }
+    for _, tt := range tests {
+        t.Run(tt.uri, func(t *testing.T) {
+            bucket, prefix, ok := splitGCSPrefix(tt.uri)
+            if bucket != tt.wantBucket || prefix != tt.wantPrefix || ok != tt.wantOK {
+                t.Errorf("splitGCSPrefix() = %q, %q, %v; want %q, %q, %v", bucket, prefix, ok, tt.wantBucket, tt.wantPrefix, tt.wantOK)
+            }
+        })
+    }
+}
+
+func TestIngestDeadLetter(t *testing.T) {
+    tests := []struct {
+        name         string
+        uri          string
+        uploadStatus int
+        wantPrefix   string
+        wantSaved    bool
+    }{
+        {"saved under the prefix", "gs://dlq/weather", 0, "dlq/weather/", true},
+        {"saved at the bucket root", "gs://dlq", 0, "dlq/", true},
+        {"dead letter not configured", "", 0, "", false},
+        {"dead letter write fails", "gs://dlq/weather", http.StatusServiceUnavailable, "", false},
+        {"invalid dead letter URI", "dlq/weather", 0, "", false},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            fixClock(t, time.Date(2024, 6, 30, 12, 0, 0, 0, time.UTC))
+            fake, _ := newFakeBigQuery(t)
+            // A 400 is not transient, so the insert fails without retries.
+            fake.insertStatus = func(table string, n int) int { return http.StatusBadRequest }
+            gcs := newFakeGCS(t, nil)
+            gcs.uploadStatus = tt.uploadStatus
+            withConfig(t, func(c *Config) { c.DeadLetterURI = tt.uri })
+            stubOpenMeteo(t, serveBody(threeDays))
+
+            rec := httptest.NewRecorder()
+            fetchWeatherData(rec, httptest.NewRequest(http.MethodGet, "/?latitude=52.52&longitude=13.41&start_date=2024-01-01&end_date=2024-01-03", nil))
+            if rec.Code != http.StatusInternalServerError {
+                t.Fatalf("status = %d, want 500: %s", rec.Code, rec.Body)
+            }
+            if got := strings.Contains(rec.Body.String(), "saved to the dead letter"); got != tt.wantSaved {
+                t.Errorf("body %q reports the dead letter: %v, want %v", rec.Body, got, tt.wantSaved)
+            }
+            if !tt.wantSaved {
+                if n := len(gcs.objects); n != 0 {
+                    t.Errorf("stored %d objects, want none", n)
+                }
+                return
+            }
+
+            var batchID string
+            for name := range gcs.objects {
+                if id, ok := strings.CutSuffix(strings.TrimPrefix(name, tt.wantPrefix), ".meta.json"); ok {
+                    batchID = id
+                }
+            }
+            if batchID == "" || !strings.Contains(rec.Body.String(), batchID) {
+                t.Fatalf("no metadata for the batch in the response %q among %d objects", rec.Body, len(gcs.objects))
+            }
+
+            data, ok := gcs.object(tt.wantPrefix + batchID + ".ndjson")
+            if !ok {
+                t.Fatal("rows were not saved")
+            }
+            if got := gcs.contentTypes[tt.wantPrefix+batchID+".ndjson"]; got != "application/x-ndjson" {
+                t.Errorf("rows content type = %q, want application/x-ndjson", got)
+            }
+            var dates []string
+            scanner := bufio.NewScanner(strings.NewReader(data))
+            for scanner.Scan() {
+                var row WeatherData
+                if err := json.Unmarshal(scanner.Bytes(), &row); err != nil {
+                    t.Fatalf("row %q: %v", scanner.Text(), err)
+                }
+                if row.BatchID != batchID {
+                    t.Errorf("row batch_id = %q, want %q", row.BatchID, batchID)
+                }
+                dates = append(dates, row.Date)
+            }
+            if strings.Join(dates, ",") != "2024-01-01,2024-01-02,2024-01-03" {
+                t.Errorf("saved dates %q, want the three fetched days", dates)
+            }
+
+            raw, _ := gcs.object(tt.wantPrefix + batchID + ".meta.json")
+            var meta deadLetterMeta
+            if err := json.Unmarshal([]byte(raw), &meta); err != nil {
+                t.Fatal(err)
+            }
+            want := deadLetterMeta{
+                BatchID:   batchID,
+                Latitude:  52.52,
+                Longitude: 13.41,
+                StartDate: "2024-01-01",
+                EndDate:   "2024-01-03",
+                Table:     cfg.TableID,
+                Rows:      3,
+                Error:     meta.Error,
+                FailedAt:  now(),
+            }
+            if meta != want {
+                t.Errorf("metadata = %+v, want %+v", meta, want)
+            }
+            if !strings.Contains(meta.Error, "failed") {
+                t.Errorf("metadata error %q does not describe the insert failure", meta.Error)
+            }
+        })
+    }
+}
+
+func TestIngestDeadLetterLayouts(t *testing.T) {
+    tests := []struct {
+        name      string
+        query     string
+        table     func() string
+        wantRows  int
+        wantField string
+    }{
+        {"daily", "", func() string { return cfg.TableID }, 3, "date"},
+        {"monthly", "&aggregate=monthly", func() string { return cfg.MonthlyTableID }, 1, "month"},
+        {"long", "&layout=long", func() string { return cfg.LongTableID }, 15, "variable"},
+        {"rolling", "&rolling=2", func() string { return cfg.TableID }, 3, "rain_sum_2d"},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            fake, _ := newFakeBigQuery(t)
+            fake.insertStatus = func(table string, n int) int { return http.StatusBadRequest }
+            gcs := newFakeGCS(t, nil)
+            withConfig(t, func(c *Config) { c.DeadLetterURI = "gs://dlq" })
+            stubOpenMeteo(t, serveBody(threeDays))
+
+            rec := httptest.NewRecorder()
+            fetchWeatherData(rec, httptest.NewRequest(http.MethodGet, "/?latitude=52.52&longitude=13.41&start_date=2024-01-01&end_date=2024-01-03"+tt.query, nil))
+            if !strings.Contains(rec.Body.String(), "saved to the dead letter") {
+                t.Fatalf("status = %d, body %q does not report the dead letter", rec.Code, rec.Body)
+            }
+
+            var meta deadLetterMeta
+            var data string
+            for name, body := range gcs.objects {
+                if id, ok := strings.CutSuffix(strings.TrimPrefix(name, "dlq/"), ".meta.json"); ok {
+                    if err := json.Unmarshal([]byte(body), &meta); err != nil {
+                        t.Fatal(err)
+                    }
+                    data, _ = gcs.object("dlq/" + id + ".ndjson")
+                }
+            }
+            if meta.Table != tt.table() || meta.Rows != tt.wantRows {
+                t.Errorf("metadata table %q with %d rows, want %q with %d", meta.Table, meta.Rows, tt.table(), tt.wantRows)
+            }
+            if meta.StartDate != "2024-01-01" || meta.EndDate != "2024-01-03" {
+                t.Errorf("metadata dates %s to %s, want the fetched days", meta.StartDate, meta.EndDate)
+            }
+            lines := strings.Split(strings.TrimSpace(data), "\n")
+            if len(lines) != tt.wantRows {
+                t.Fatalf("saved %d rows, want %d: %s", len(lines), tt.wantRows, data)
+            }
+            for _, line := range lines {
+                var row map[string]interface{}
+                if err := json.Unmarshal([]byte(line), &row); err != nil {
+                    t.Fatalf("row %q: %v", line, err)
+                }
+                if _, ok := row[tt.wantField]; !ok {
+                    t.Errorf("saved row %s has no %s", line, tt.wantField)
+                }
+            }
+        })
+    }
+}
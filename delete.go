@@ -0,0 +1,69 @@
+package main
+
+import (
+    "fmt"
+    "log/slog"
+    "net/http"
+    "strconv"
+
+    "cloud.google.com/go/bigquery"
+)
+
+// deleteWeather removes the stored rows of a coordinate, matched within the incremental
+// tolerance and optionally limited to a date range, and reports how many rows were deleted.
+// It requires confirm=true so a stray request cannot wipe data. Rows still in the streaming
+// buffer cannot be deleted yet; BigQuery rejects the statement with an error, which is
+// reported as a failure.
+func deleteWeather(w http.ResponseWriter, r *http.Request) {
+    if r.Method != http.MethodDelete {
+        http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+        return
+    }
+    ctx := r.Context()
+    q := r.URL.Query()
+
+    if confirm, _ := strconv.ParseBool(q.Get("confirm")); !confirm {
+        http.Error(w, "Deleting data requires confirm=true", http.StatusBadRequest)
+        return
+    }
+    latitude, longitude, err := parseCoordinates(q)
+    if err != nil {
+        http.Error(w, err.Error(), http.StatusBadRequest)
+        return
+    }
+
+    startDate, endDate, err := parseDateBounds(q)
+    if err != nil {
+        http.Error(w, err.Error(), http.StatusBadRequest)
+        return
+    }
+
+    // Match the coordinate within the incremental tolerance, as /query does, since stored
+    // coordinates are the grid point Open-Meteo snapped the request to.
+    sql := fmt.Sprintf("DELETE FROM `%s.%s.%s` WHERE ABS(latitude - @latitude) <= @tolerance AND ABS(longitude - @longitude) <= @tolerance", cfg.ProjectID, cfg.DatasetID, cfg.TableID)
+    params := []bigquery.QueryParameter{
+        {Name: "latitude", Value: latitude},
+        {Name: "longitude", Value: longitude},
+        {Name: "tolerance", Value: cfg.IncrementalTolerance},
+    }
+    sql += dateBoundsFilter(startDate, endDate, &params)
+
+    client, err := newBigQueryClient(ctx)
+    if err != nil {
+        slog.Error("Failed to create BigQuery client", "error", err)
+        http.Error(w, "BigQuery error", http.StatusInternalServerError)
+        return
+    }
+    defer client.Close()
+
+    query := client.Query(sql)
+    query.Parameters = params
+    deleted, err := runDML(ctx, query)
+    if err != nil {
+        slog.Error("Failed to delete rows", "latitude", latitude, "longitude", longitude, "error", err)
+        http.Error(w, "Failed to delete data", http.StatusInternalServerError)
+        return
+    }
+    slog.Info("Deleted rows", "latitude", latitude, "longitude", longitude, "rows", deleted)
+    writeJSON(w, http.StatusOK, map[string]interface{}{"deleted": deleted})
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+    "net/http"
+    "net/http/httptest"
+    "strings"
+    "testing"
+)
+
+func TestDeleteWeather(t *testing.T) {
+    tests := []struct {
+        name       string
+        method     string
+        query      string
+        wantStatus int
+        wantSQL    []string
+        wantTypes  map[string]string
+    }{
+        {
+            name:       "coordinate within tolerance",
+            method:     http.MethodDelete,
+            query:      "latitude=52.52&longitude=13.41&confirm=true",
+            wantStatus: http.StatusOK,
+            wantSQL:    []string{"ABS(latitude - @latitude) <= @tolerance", "ABS(longitude - @longitude) <= @tolerance"},
+            wantTypes:  map[string]string{"start_date": "", "end_date": ""},
+        },
+        {
+            name:       "date range uses DATE parameters",
+            method:     http.MethodDelete,
+            query:      "latitude=52.52&longitude=13.41&confirm=true&start_date=2024-01-01&end_date=2024-01-31",
+            wantStatus: http.StatusOK,
+            wantSQL:    []string{"date >= @start_date", "date <= @end_date"},
+            wantTypes:  map[string]string{"start_date": "DATE", "end_date": "DATE"},
+        },
+        {name: "not confirmed", method: http.MethodDelete, query: "latitude=52.52&longitude=13.41", wantStatus: http.StatusBadRequest},
+        {name: "invalid date", method: http.MethodDelete, query: "latitude=52.52&longitude=13.41&confirm=true&end_date=2024-02-30", wantStatus: http.StatusBadRequest},
+        {name: "inverted range", method: http.MethodDelete, query: "latitude=52.52&longitude=13.41&confirm=true&start_date=2024-02-01&end_date=2024-01-01", wantStatus: http.StatusBadRequest},
+        {name: "missing coordinates", method: http.MethodDelete, query: "confirm=true", wantStatus: http.StatusBadRequest},
+        {name: "wrong method", method: http.MethodGet, query: "latitude=52.52&longitude=13.41&confirm=true", wantStatus: http.StatusMethodNotAllowed},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            fake, _ := newFakeBigQuery(t)
+            fake.answer = func(q *fakeQuery) *fakeResult { return &fakeResult{Affected: 7} }
+            rec := httptest.NewRecorder()
+            deleteWeather(rec, httptest.NewRequest(tt.method, "/data?"+tt.query, nil))
+            if rec.Code != tt.wantStatus {
+                t.Fatalf("status = %d, want %d: %s", rec.Code, tt.wantStatus, rec.Body)
+            }
+            if tt.wantStatus != http.StatusOK {
+                if n := len(fake.received()); n != 0 {
+                    t.Errorf("ran %d statements for an invalid request", n)
+                }
+                return
+            }
+            if body := strings.TrimSpace(rec.Body.String()); body != `{"deleted":7}` {
+                t.Errorf("body = %s, want 7 deleted", body)
+            }
+            q := fake.received()[0]
+            for _, want := range tt.wantSQL {
+                if !strings.Contains(q.SQL, want) {
+                    t.Errorf("SQL %q lacks %q", q.SQL, want)
+                }
+            }
+            if strings.Contains(q.SQL, "CAST(date AS STRING)") || strings.Contains(q.SQL, "latitude = @latitude") {
+                t.Errorf("SQL %q casts the date or matches the coordinate exactly", q.SQL)
+            }
+            for name, typ := range tt.wantTypes {
+                if got := q.paramType(name); got != typ {
+                    t.Errorf("parameter %s has type %q, want %q", name, got, typ)
+                }
+            }
+        })
+    }
+}
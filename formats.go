@@ -0,0 +1,209 @@
+package main
+
+import (
+    "encoding/json"
+    "fmt"
+    "io"
+    "log/slog"
+    "math"
+    "net/http"
+    "strconv"
+    "strings"
+    "time"
+
+    "cloud.google.com/go/bigquery"
+)
+
+// flushEvery is how many rows are written between flushes when streaming a response.
+const flushEvery = 500
+
+// writeRows serializes rows to the response in the requested format.
+func writeRows(w http.ResponseWriter, format string, rows []*WeatherData) {
+    switch format {
+    case "influx":
+        writeInflux(w, rows)
+    case "geojson":
+        streamGeoJSON(w, rows)
+    case "arrow":
+        streamArrow(w, rows)
+    default:
+        streamJSON(w, rows)
+    }
+}
+
+// streamJSON writes rows as a JSON array one element at a time, flushing every flushEvery
+// rows so large exports go out with chunked encoding instead of being buffered whole.
+// Once the first byte is sent the status can no longer change, so a failure part way
+// through is only logged and the response is left truncated.
+func streamJSON[T any](w http.ResponseWriter, rows []T) {
+    w.Header().Set("Content-Type", "application/json")
+    w.WriteHeader(http.StatusOK)
+    flusher, _ := w.(http.Flusher)
+
+    if _, err := io.WriteString(w, "["); err != nil {
+        slog.Error("Failed to write response", "error", err)
+        return
+    }
+    for i := range rows {
+        sanitizeNonFinite(&rows[i])
+        b, err := json.Marshal(rows[i])
+        if err != nil {
+            slog.Error("Failed to encode row; response truncated", "row", i, "error", err)
+            return
+        }
+        if i > 0 {
+            b = append([]byte(",\n"), b...)
+        }
+        if _, err := w.Write(b); err != nil {
+            slog.Error("Failed to write response; client likely disconnected", "row", i, "error", err)
+            return
+        }
+        if flusher != nil && (i+1)%flushEvery == 0 {
+            flusher.Flush()
+        }
+    }
+    if _, err := io.WriteString(w, "]\n"); err != nil {
+        slog.Error("Failed to write response", "error", err)
+    }
+}
+
+// streamGeoJSON writes rows as a GeoJSON FeatureCollection with one Point feature per row,
+// carrying the row's values as properties. It streams and flushes like streamJSON.
+func streamGeoJSON(w http.ResponseWriter, rows []*WeatherData) {
+    w.Header().Set("Content-Type", "application/geo+json")
+    w.WriteHeader(http.StatusOK)
+    flusher, _ := w.(http.Flusher)
+
+    if _, err := io.WriteString(w, `{"type":"FeatureCollection","features":[`); err != nil {
+        slog.Error("Failed to write response", "error", err)
+        return
+    }
+    for i, row := range rows {
+        sanitizeNonFinite(row)
+        properties, err := json.Marshal(row)
+        if err != nil {
+            slog.Error("Failed to encode row; response truncated", "row", i, "error", err)
+            return
+        }
+        // GeoJSON positions are longitude first.
+        b := fmt.Appendf(nil, `{"type":"Feature","geometry":{"type":"Point","coordinates":[%s,%s]},"properties":%s}`,
+            strconv.FormatFloat(row.Longitude, 'f', -1, 64), strconv.FormatFloat(row.Latitude, 'f', -1, 64), properties)
+        if i > 0 {
+            b = append([]byte(",\n"), b...)
+        }
+        if _, err := w.Write(b); err != nil {
+            slog.Error("Failed to write response; client likely disconnected", "row", i, "error", err)
+            return
+        }
+        if flusher != nil && (i+1)%flushEvery == 0 {
+            flusher.Flush()
+        }
+    }
+    if _, err := io.WriteString(w, "]}\n"); err != nil {
+        slog.Error("Failed to write response", "error", err)
+    }
+}
+
+// writeInflux writes rows as InfluxDB line protocol: measurement "weather", tagged with the
+// coordinates and model, with the numeric values as fields and the date (midnight UTC) as
+// timestamp. Rows that cannot be converted are logged and skipped; as the body is streamed,
+// their number is sent in the X-Skipped-Rows trailer.
+func writeInflux(w http.ResponseWriter, rows []*WeatherData) {
+    w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+    w.Header().Set("Trailer", "X-Skipped-Rows")
+    flusher, _ := w.(http.Flusher)
+    skipped := 0
+    defer func() {
+        w.Header().Set("X-Skipped-Rows", strconv.Itoa(skipped))
+        if skipped > 0 {
+            slog.Warn("Skipped rows that could not be written as line protocol", "skipped", skipped, "rows", len(rows))
+        }
+    }()
+    var b strings.Builder
+    for i, row := range rows {
+        b.Reset()
+        if err := appendInfluxLine(&b, row); err != nil {
+            slog.Warn("Skipping row in line protocol output", "row", i, "date", row.Date, "error", err)
+            skipped++
+            continue
+        }
+        if _, err := fmt.Fprintln(w, b.String()); err != nil {
+            slog.Error("Failed to write response; client likely disconnected", "row", i, "error", err)
+            return
+        }
+        if flusher != nil && (i+1)%flushEvery == 0 {
+            flusher.Flush()
+        }
+    }
+}
+
+// appendInfluxLine appends the line protocol for one row.
+func appendInfluxLine(b *strings.Builder, row *WeatherData) error {
+    date, err := time.Parse(dateLayout, row.Date)
+    if err != nil {
+        return err
+    }
+    if row.DateTS.Valid {
+        date = row.DateTS.Timestamp
+    }
+
+    b.WriteString("weather")
+    b.WriteString(",latitude=" + escapeInfluxTag(strconv.FormatFloat(row.Latitude, 'f', -1, 64)))
+    b.WriteString(",longitude=" + escapeInfluxTag(strconv.FormatFloat(row.Longitude, 'f', -1, 64)))
+    if row.SourceModel.Valid {
+        b.WriteString(",model=" + escapeInfluxTag(row.SourceModel.StringVal))
+    }
+
+    var fields []string
+    fields = appendInfluxNullFloat(fields, "mean_temperature", row.MeanTemperature)
+    fields = appendInfluxNullFloat(fields, "min_temperature", row.MinTemperature)
+    fields = appendInfluxNullFloat(fields, "max_temperature", row.MaxTemperature)
+    fields = appendInfluxNullFloat(fields, "rain_sum", row.RainSum)
+    fields = appendInfluxNullFloat(fields, "snowfall_sum", row.SnowfallSum)
+    fields = appendInfluxNullFloat(fields, "surface_pressure_mean", row.SurfacePressureMean)
+    fields = appendInfluxNullFloat(fields, "cloud_cover_mean", row.CloudCoverMean)
+    fields = appendInfluxNullFloat(fields, "et0_fao_evapotranspiration", row.ET0)
+    if row.WeatherCode.Valid {
+        fields = append(fields, "weather_code="+strconv.FormatInt(row.WeatherCode.Int64, 10)+"i")
+    }
+    if row.WeatherDescription.Valid {
+        fields = append(fields, "weather_description="+quoteInfluxString(row.WeatherDescription.StringVal))
+    }
+    if len(fields) == 0 {
+        return fmt.Errorf("no values for %s", row.Date)
+    }
+
+    b.WriteByte(' ')
+    b.WriteString(strings.Join(fields, ","))
+    b.WriteByte(' ')
+    b.WriteString(strconv.FormatInt(date.UnixNano(), 10))
+    return nil
+}
+
+// influxFloat formats a float field.
+func influxFloat(key string, v float64) string {
+    return escapeInfluxTag(key) + "=" + strconv.FormatFloat(v, 'f', -1, 64)
+}
+
+// appendInfluxNullFloat appends a float field when the value is not NULL. NaN and infinite
+// values are skipped too, since line protocol has no way to write them.
+func appendInfluxNullFloat(fields []string, key string, v bigquery.NullFloat64) []string {
+    if !v.Valid || math.IsNaN(v.Float64) || math.IsInf(v.Float64, 0) {
+        return fields
+    }
+    return append(fields, influxFloat(key, v.Float64))
+}
+
+// influxTagEscaper escapes commas, equals signs, and spaces in tag keys, tag values, and field keys.
+var influxTagEscaper = strings.NewReplacer(",", `\,`, "=", `\=`, " ", `\ `)
+
+func escapeInfluxTag(s string) string {
+    return influxTagEscaper.Replace(s)
+}
+
+// influxStringEscaper escapes backslashes and double quotes in string field values.
+var influxStringEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`)
+
+func quoteInfluxString(s string) string {
+    return `"` + influxStringEscaper.Replace(s) + `"`
+}
@@ -0,0 +1,267 @@
+package main
+
+import (
+    "encoding/json"
+    "errors"
+    "fmt"
+    "log/slog"
+    "math"
+    "net/http"
+    "net/http/httptest"
+    "strings"
+    "testing"
+    "time"
+
+    "cloud.google.com/go/bigquery"
+    "cloud.google.com/go/civil"
+)
+
+func TestAppendInfluxLine(t *testing.T) {
+    tests := []struct {
+        name    string
+        row     *WeatherData
+        want    string
+        wantErr bool
+    }{
+        {
+            name: "values as fields",
+            row:  &WeatherData{Latitude: 52.5, Longitude: 13.4, Date: "2024-01-02", MeanTemperature: nf(1.5), RainSum: nf(0.4)},
+            want: "weather,latitude=52.5,longitude=13.4 mean_temperature=1.5,rain_sum=0.4 1704153600000000000",
+        },
+        {
+            name: "model tag is escaped",
+            row:  &WeatherData{Latitude: 1, Longitude: 2, Date: "2024-01-01", SourceModel: bigquery.NullString{StringVal: "icon seamless,eu", Valid: true}, RainSum: nf(0)},
+            want: `weather,latitude=1,longitude=2,model=icon\ seamless\,eu rain_sum=0 1704067200000000000`,
+        },
+        {
+            name: "code and quoted description",
+            row:  &WeatherData{Latitude: 1, Longitude: 2, Date: "2024-01-01", WeatherCode: bigquery.NullInt64{Int64: 63, Valid: true}, WeatherDescription: bigquery.NullString{StringVal: `Rain "moderate"`, Valid: true}},
+            want: `weather,latitude=1,longitude=2 weather_code=63i,weather_description="Rain \"moderate\"" 1704067200000000000`,
+        },
+        {
+            name: "local midnight from date_ts",
+            row:  &WeatherData{Latitude: 1, Longitude: 2, Date: "2024-01-01", DateTS: bigquery.NullTimestamp{Timestamp: time.Date(2024, 1, 1, 0, 0, 0, 0, time.FixedZone("", 3600)), Valid: true}, RainSum: nf(0)},
+            want: "weather,latitude=1,longitude=2 rain_sum=0 1704063600000000000",
+        },
+        {
+            name: "non-finite values are skipped",
+            row:  &WeatherData{Latitude: 1, Longitude: 2, Date: "2024-01-01", MeanTemperature: nf(math.NaN()), MaxTemperature: nf(math.Inf(1)), MinTemperature: nf(math.Inf(-1)), RainSum: nf(0)},
+            want: "weather,latitude=1,longitude=2 rain_sum=0 1704067200000000000",
+        },
+        {name: "only non-finite values", row: &WeatherData{Latitude: 1, Longitude: 2, Date: "2024-01-01", RainSum: nf(math.NaN())}, wantErr: true},
+        {name: "no values", row: &WeatherData{Latitude: 1, Longitude: 2, Date: "2024-01-01"}, wantErr: true},
+        {name: "invalid date", row: &WeatherData{Latitude: 1, Longitude: 2, Date: "01/01/2024", RainSum: nf(1)}, wantErr: true},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            var b strings.Builder
+            err := appendInfluxLine(&b, tt.row)
+            if (err != nil) != tt.wantErr {
+                t.Fatalf("err = %v, wantErr %v", err, tt.wantErr)
+            }
+            if !tt.wantErr && b.String() != tt.want {
+                t.Errorf("line = %q, want %q", b.String(), tt.want)
+            }
+        })
+    }
+}
+
+func TestWriteInfluxReportsSkippedRows(t *testing.T) {
+    tests := []struct {
+        name        string
+        rows        []*WeatherData
+        wantLines   int
+        wantSkipped string
+    }{
+        {"all converted", []*WeatherData{{Date: "2024-01-01", RainSum: nf(1)}, {Date: "2024-01-02", RainSum: nf(2)}}, 2, "0"},
+        {"rows without values are skipped", []*WeatherData{{Date: "2024-01-01", RainSum: nf(1)}, {Date: "2024-01-02"}, {Date: "bad", RainSum: nf(3)}}, 1, "2"},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            logs := captureLogs(t, slog.LevelWarn)
+            rec := httptest.NewRecorder()
+            writeInflux(rec, tt.rows)
+            body := strings.TrimSpace(rec.Body.String())
+            if lines := len(strings.Split(body, "\n")); lines != tt.wantLines {
+                t.Errorf("wrote %d lines, want %d:\n%s", lines, tt.wantLines, body)
+            }
+            if got := rec.Result().Trailer.Get("X-Skipped-Rows"); got != tt.wantSkipped {
+                t.Errorf("X-Skipped-Rows trailer = %q, want %q", got, tt.wantSkipped)
+            }
+            warned := strings.Count(logs.String(), "Skipping row in line protocol output")
+            if want := len(tt.rows) - tt.wantLines; warned != want {
+                t.Errorf("logged %d skipped rows at Warn, want %d", warned, want)
+            }
+        })
+    }
+}
+
+func TestWriteInfluxTrailerUnderRequestTimeout(t *testing.T) {
+    tests := []struct {
+        name        string
+        timeout     time.Duration
+        body        string
+        wantLines   int
+        wantSkipped string
+    }{
+        {"without a timeout", 0, threeDays, 3, "0"},
+        {"with a timeout", time.Minute, threeDays, 3, "0"},
+        {"skipped rows with a timeout", time.Minute, `{"latitude":52.5,"longitude":13.4,"daily":{"time":["2024-01-01","2024-01-02"],"rain_sum":[1,null]}}`, 1, "1"},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            withConfig(t, func(c *Config) { c.RequestTimeout = tt.timeout })
+            captureLogs(t, slog.LevelError)
+            noBigQuery(t)
+            stubOpenMeteo(t, serveBody(tt.body))
+            rec := httptest.NewRecorder()
+            newRouter().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/?latitude=52.52&longitude=13.41&start_date=2024-01-01&end_date=2024-01-03&sink=none&format=influx", nil))
+            if rec.Code != http.StatusOK {
+                t.Fatalf("status = %d: %s", rec.Code, rec.Body)
+            }
+            if lines := len(strings.Split(strings.TrimSpace(rec.Body.String()), "\n")); lines != tt.wantLines {
+                t.Errorf("wrote %d lines, want %d: %s", lines, tt.wantLines, rec.Body)
+            }
+            if got := rec.Result().Trailer.Get("X-Skipped-Rows"); got != tt.wantSkipped {
+                t.Errorf("X-Skipped-Rows trailer = %q, want %q", got, tt.wantSkipped)
+            }
+        })
+    }
+}
+
+// flushRecorder records how much of the body had been written at each Flush, and fails
+// writes once failAfter bytes were written when failAfter is set.
+type flushRecorder struct {
+    *httptest.ResponseRecorder
+    flushedAt []int
+    failAfter int
+}
+
+func (r *flushRecorder) Write(b []byte) (int, error) {
+    if r.failAfter > 0 && r.Body.Len()+len(b) > r.failAfter {
+        return 0, errors.New("connection reset")
+    }
+    return r.ResponseRecorder.Write(b)
+}
+
+func (r *flushRecorder) Flush() {
+    r.flushedAt = append(r.flushedAt, r.Body.Len())
+}
+
+// manyRows returns n rows of one coordinate on consecutive days.
+func manyRows(n int) []*WeatherData {
+    rows := make([]*WeatherData, n)
+    start := civil.Date{Year: 2000, Month: 1, Day: 1}
+    for i := range rows {
+        rows[i] = &WeatherData{Latitude: 52.5, Longitude: 13.4, Date: start.AddDays(i).String(), RainSum: nf(float64(i % 7))}
+    }
+    return rows
+}
+
+func TestWriteRowsFlushesIncrementally(t *testing.T) {
+    tests := []struct {
+        format      string
+        rows        int
+        wantFlushes int
+    }{
+        {"json", 10 * flushEvery, 10},
+        {"json", flushEvery - 1, 0},
+        {"geojson", 3*flushEvery + 1, 3},
+        {"influx", 4 * flushEvery, 4},
+    }
+    for _, tt := range tests {
+        t.Run(fmt.Sprintf("%s/%d", tt.format, tt.rows), func(t *testing.T) {
+            rec := &flushRecorder{ResponseRecorder: httptest.NewRecorder()}
+            writeRows(rec, tt.format, manyRows(tt.rows))
+            if len(rec.flushedAt) != tt.wantFlushes {
+                t.Fatalf("flushed %d times, want %d", len(rec.flushedAt), tt.wantFlushes)
+            }
+            for i := 1; i < len(rec.flushedAt); i++ {
+                if rec.flushedAt[i] <= rec.flushedAt[i-1] {
+                    t.Errorf("flush %d at %d bytes, not after flush %d at %d", i, rec.flushedAt[i], i-1, rec.flushedAt[i-1])
+                }
+            }
+            if n := len(rec.flushedAt); n > 0 && rec.flushedAt[0] >= rec.Body.Len() {
+                t.Errorf("first flush at %d of %d bytes, want part of the body", rec.flushedAt[0], rec.Body.Len())
+            }
+        })
+    }
+}
+
+func TestWriteRowsMidStreamError(t *testing.T) {
+    for _, format := range []string{"json", "geojson", "influx"} {
+        t.Run(format, func(t *testing.T) {
+            logs := captureLogs(t, slog.LevelError)
+            rec := &flushRecorder{ResponseRecorder: httptest.NewRecorder(), failAfter: 4096}
+            writeRows(rec, format, manyRows(2*flushEvery))
+            if rec.Code != http.StatusOK {
+                t.Errorf("status = %d, want the 200 already sent", rec.Code)
+            }
+            if !strings.Contains(logs.String(), "client likely disconnected") {
+                t.Errorf("write failure not logged: %s", logs)
+            }
+            if rec.Body.Len() > 4096 {
+                t.Errorf("wrote %d bytes after the failure", rec.Body.Len())
+            }
+        })
+    }
+}
+
+func TestStreamGeoJSON(t *testing.T) {
+    tests := []struct {
+        name string
+        rows []*WeatherData
+    }{
+        {"no rows", nil},
+        {"one row", []*WeatherData{{Latitude: 52.52, Longitude: 13.41, Date: "2024-01-01", RainSum: nf(0.4)}}},
+        {"southern and western hemispheres", []*WeatherData{
+            {Latitude: -33.87, Longitude: -70.65, Date: "2024-01-01", MeanTemperature: nf(21.5)},
+            {Latitude: -33.87, Longitude: -70.65, Date: "2024-01-02"},
+        }},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            rec := httptest.NewRecorder()
+            writeRows(rec, "geojson", tt.rows)
+            if got := rec.Header().Get("Content-Type"); got != "application/geo+json" {
+                t.Errorf("Content-Type = %q, want application/geo+json", got)
+            }
+            var collection struct {
+                Type     string `json:"type"`
+                Features []struct {
+                    Type     string `json:"type"`
+                    Geometry struct {
+                        Type        string    `json:"type"`
+                        Coordinates []float64 `json:"coordinates"`
+                    } `json:"geometry"`
+                    Properties map[string]interface{} `json:"properties"`
+                } `json:"features"`
+            }
+            if err := json.Unmarshal(rec.Body.Bytes(), &collection); err != nil {
+                t.Fatalf("%v: %s", err, rec.Body)
+            }
+            if collection.Type != "FeatureCollection" || collection.Features == nil || len(collection.Features) != len(tt.rows) {
+                t.Fatalf("got %s with %d features, want a FeatureCollection of %d", collection.Type, len(collection.Features), len(tt.rows))
+            }
+            for i, f := range collection.Features {
+                row := tt.rows[i]
+                if f.Type != "Feature" || f.Geometry.Type != "Point" {
+                    t.Errorf("feature %d is a %s with a %s geometry, want a Point Feature", i, f.Type, f.Geometry.Type)
+                }
+                // GeoJSON positions are longitude first.
+                if want := []float64{row.Longitude, row.Latitude}; fmt.Sprint(f.Geometry.Coordinates) != fmt.Sprint(want) {
+                    t.Errorf("feature %d coordinates = %v, want %v", i, f.Geometry.Coordinates, want)
+                }
+                if f.Properties["date"] != row.Date {
+                    t.Errorf("feature %d date = %v, want %s", i, f.Properties["date"], row.Date)
+                }
+                if want := row.MeanTemperature; want.Valid && f.Properties["mean_temperature"] != want.Float64 {
+                    t.Errorf("feature %d mean_temperature = %v, want %v", i, f.Properties["mean_temperature"], want.Float64)
+                }
+                if v, ok := f.Properties["rain_sum"]; !ok || (v == nil) == row.RainSum.Valid {
+                    t.Errorf("feature %d rain_sum = %v, want %+v", i, v, row.RainSum)
+                }
+            }
+        })
+    }
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+    "context"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "io"
+    "log/slog"
+    "net/http"
+    "net/url"
+    "strings"
+    "sync"
+    "time"
+)
+
+// geocodingBaseURL is the Open-Meteo place search, and customerGeocodingBaseURL its
+// commercial counterpart used with an API key.
+var (
+    geocodingBaseURL         = "https://geocoding-api.open-meteo.com/v1/search"
+    customerGeocodingBaseURL = "https://customer-geocoding-api.open-meteo.com/v1/search"
+)
+
+// maxPlaceNameLength caps the name parameter.
+const maxPlaceNameLength = 200
+
+// errGeocodeTimeout is returned when the lookup exceeds cfg.GeocodingTimeout. It does not
+// wrap context.DeadlineExceeded, so it is reported as a geocoding timeout rather than as
+// the request's own deadline.
+var errGeocodeTimeout = errors.New("geocoding lookup timed out")
+
+// geocodeEntry is a cached lookup result.
+type geocodeEntry struct {
+    Location Location
+    Expires  time.Time
+}
+
+var (
+    geocodeMu    sync.Mutex
+    geocodeCache = make(map[string]geocodeEntry)
+)
+
+// geocode resolves a place name to the coordinates of Open-Meteo's best match. Results
+// are cached per instance for cfg.GeocodingCacheTTL. The lookup has its own timeout and
+// response size limit, independent of the weather fetch.
+func geocode(ctx context.Context, name string) (Location, error) {
+    key := strings.ToLower(strings.TrimSpace(name))
+    if key == "" || len(key) > maxPlaceNameLength {
+        return Location{}, &requestError{http.StatusBadRequest, fmt.Sprintf("name must be 1 to %d characters", maxPlaceNameLength), fmt.Errorf("invalid place name %q", name)}
+    }
+    if loc, ok := cachedGeocode(key); ok {
+        slog.Debug("Geocoding cache hit", "name", key)
+        return loc, nil
+    }
+
+    lookupCtx, cancel := context.WithTimeout(ctx, cfg.GeocodingTimeout)
+    defer cancel()
+    loc, err := lookupPlace(lookupCtx, key)
+    if err != nil {
+        // Only the lookup's own timeout is reported as such; the request's deadline is not.
+        if lookupCtx.Err() == context.DeadlineExceeded && ctx.Err() == nil {
+            return Location{}, &requestError{http.StatusGatewayTimeout, "Geocoding lookup timed out", fmt.Errorf("%w after %s", errGeocodeTimeout, cfg.GeocodingTimeout)}
+        }
+        return Location{}, err
+    }
+    slog.Info("Geocoded place name", "name", key, "latitude", loc.Latitude, "longitude", loc.Longitude)
+
+    if cfg.GeocodingCacheTTL > 0 {
+        geocodeMu.Lock()
+        geocodeCache[key] = geocodeEntry{Location: loc, Expires: now().Add(cfg.GeocodingCacheTTL)}
+        geocodeMu.Unlock()
+    }
+    return loc, nil
+}
+
+// cachedGeocode returns the cached coordinates of the name unless they have expired.
+func cachedGeocode(key string) (Location, bool) {
+    geocodeMu.Lock()
+    defer geocodeMu.Unlock()
+    entry, ok := geocodeCache[key]
+    if !ok {
+        return Location{}, false
+    }
+    if !now().Before(entry.Expires) {
+        delete(geocodeCache, key)
+        return Location{}, false
+    }
+    return entry.Location, true
+}
+
+// lookupPlace asks the geocoding API for the best match of the name.
+func lookupPlace(ctx context.Context, name string) (Location, error) {
+    base := geocodingBaseURL
+    query := url.Values{"name": {name}, "count": {"1"}, "format": {"json"}}
+    if cfg.OpenMeteoAPIKey != "" {
+        base = customerGeocodingBaseURL
+        query.Set("apikey", cfg.OpenMeteoAPIKey)
+    }
+    req, err := http.NewRequestWithContext(ctx, http.MethodGet, base+"?"+query.Encode(), nil)
+    if err != nil {
+        return Location{}, &requestError{http.StatusInternalServerError, "Geocoding error", fmt.Errorf("failed to build geocoding request: %s", redactAPIKey(err.Error()))}
+    }
+    resp, err := httpClient.Do(req)
+    if err != nil {
+        if ctxErr := ctx.Err(); ctxErr != nil {
+            return Location{}, ctxErr
+        }
+        return Location{}, &requestError{http.StatusBadGateway, "Geocoding lookup failed", errors.New(redactAPIKey(err.Error()))}
+    }
+    defer resp.Body.Close()
+
+    // Read one byte past the limit to tell a body of exactly the limit from a larger one.
+    body, err := io.ReadAll(io.LimitReader(resp.Body, cfg.GeocodingMaxBytes+1))
+    if err != nil {
+        return Location{}, &requestError{http.StatusBadGateway, "Geocoding lookup failed", fmt.Errorf("failed to read geocoding response: %w", err)}
+    }
+    if int64(len(body)) > cfg.GeocodingMaxBytes {
+        return Location{}, &requestError{http.StatusBadGateway, "Geocoding response too large", fmt.Errorf("geocoding response exceeds %d bytes", cfg.GeocodingMaxBytes)}
+    }
+    if resp.StatusCode != http.StatusOK {
+        return Location{}, &requestError{http.StatusBadGateway, "Geocoding lookup failed", fmt.Errorf("geocoding API returned status %d: %s", resp.StatusCode, truncate(string(body), 200))}
+    }
+
+    var result struct {
+        Results []struct {
+            Latitude  float64 `json:"latitude"`
+            Longitude float64 `json:"longitude"`
+        } `json:"results"`
+    }
+    if err := json.Unmarshal(body, &result); err != nil {
+        return Location{}, &requestError{http.StatusBadGateway, "Geocoding lookup failed", fmt.Errorf("failed to parse geocoding response: %w", err)}
+    }
+    if len(result.Results) == 0 {
+        return Location{}, &requestError{http.StatusNotFound, fmt.Sprintf("No place found for name %q", name), fmt.Errorf("no geocoding result for %q", name)}
+    }
+    match := result.Results[0]
+    if err := validateCoordinates(match.Latitude, match.Longitude); err != nil {
+        return Location{}, &requestError{http.StatusBadGateway, "Geocoding lookup failed", fmt.Errorf("geocoding result: %w", err)}
+    }
+    return Location{Latitude: match.Latitude, Longitude: match.Longitude}, nil
+}
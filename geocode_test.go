@@ -0,0 +1,172 @@
+package main
+
+import (
+    "context"
+    "errors"
+    "net/http"
+    "net/http/httptest"
+    "strings"
+    "sync"
+    "testing"
+    "time"
+)
+
+// stubGeocoding points both geocoding endpoints at a test server running handler and
+// empties the geocoding cache for the test.
+func stubGeocoding(t *testing.T, handler http.HandlerFunc) {
+    t.Helper()
+    srv := httptest.NewServer(handler)
+    t.Cleanup(srv.Close)
+    savedFree, savedCustomer := geocodingBaseURL, customerGeocodingBaseURL
+    geocodingBaseURL, customerGeocodingBaseURL = srv.URL+"/v1/search", srv.URL+"/customer/v1/search"
+    geocodeMu.Lock()
+    geocodeCache = make(map[string]geocodeEntry)
+    geocodeMu.Unlock()
+    t.Cleanup(func() {
+        geocodingBaseURL, customerGeocodingBaseURL = savedFree, savedCustomer
+        geocodeMu.Lock()
+        geocodeCache = make(map[string]geocodeEntry)
+        geocodeMu.Unlock()
+    })
+}
+
+// berlin is a geocoding response whose best match is Berlin.
+const berlin = `{"results":[{"name":"Berlin","latitude":52.52437,"longitude":13.41053},{"name":"Berlin","latitude":39.9,"longitude":-74.9}]}`
+
+func TestGeocode(t *testing.T) {
+    tests := []struct {
+        name       string
+        place      string
+        body       string
+        status     int
+        delay      time.Duration
+        maxBytes   int64
+        want       Location
+        wantStatus int
+    }{
+        {"best match", "Berlin", berlin, http.StatusOK, 0, 64 << 10, Location{Latitude: 52.52437, Longitude: 13.41053}, 0},
+        {"no match", "Nowhere", `{}`, http.StatusOK, 0, 64 << 10, Location{}, http.StatusNotFound},
+        {"empty name", "  ", berlin, http.StatusOK, 0, 64 << 10, Location{}, http.StatusBadRequest},
+        {"name too long", strings.Repeat("a", maxPlaceNameLength+1), berlin, http.StatusOK, 0, 64 << 10, Location{}, http.StatusBadRequest},
+        {"upstream error", "Berlin", `{"error":true}`, http.StatusInternalServerError, 0, 64 << 10, Location{}, http.StatusBadGateway},
+        {"invalid JSON", "Berlin", `<html>`, http.StatusOK, 0, 64 << 10, Location{}, http.StatusBadGateway},
+        {"invalid coordinates", "Berlin", `{"results":[{"latitude":152.5,"longitude":13.4}]}`, http.StatusOK, 0, 64 << 10, Location{}, http.StatusBadGateway},
+        {"response at the size cap", "Berlin", berlin, http.StatusOK, 0, int64(len(berlin)), Location{Latitude: 52.52437, Longitude: 13.41053}, 0},
+        {"response over the size cap", "Berlin", berlin, http.StatusOK, 0, int64(len(berlin)) - 1, Location{}, http.StatusBadGateway},
+        {"timeout", "Berlin", berlin, http.StatusOK, 200 * time.Millisecond, 64 << 10, Location{}, http.StatusGatewayTimeout},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            withConfig(t, func(c *Config) {
+                c.GeocodingTimeout, c.GeocodingMaxBytes, c.GeocodingCacheTTL = 50*time.Millisecond, tt.maxBytes, time.Hour
+            })
+            stubGeocoding(t, func(w http.ResponseWriter, r *http.Request) {
+                if tt.delay > 0 {
+                    select {
+                    case <-time.After(tt.delay):
+                    case <-r.Context().Done():
+                        return
+                    }
+                }
+                w.WriteHeader(tt.status)
+                w.Write([]byte(tt.body))
+            })
+            got, err := geocode(context.Background(), tt.place)
+            if tt.wantStatus == 0 {
+                if err != nil {
+                    t.Fatal(err)
+                }
+                if got != tt.want {
+                    t.Errorf("geocode() = %+v, want %+v", got, tt.want)
+                }
+                return
+            }
+            var reqErr *requestError
+            if !errors.As(err, &reqErr) || reqErr.Status != tt.wantStatus {
+                t.Fatalf("err = %v, want a %d", err, tt.wantStatus)
+            }
+            if tt.wantStatus == http.StatusGatewayTimeout && (!errors.Is(err, errGeocodeTimeout) || isDeadlineError(err)) {
+                t.Errorf("err = %v, want a geocoding timeout distinct from the request deadline", err)
+            }
+        })
+    }
+}
+
+func TestGeocodeCache(t *testing.T) {
+    tests := []struct {
+        name        string
+        ttl         time.Duration
+        second      string
+        after       time.Duration
+        wantLookups int
+    }{
+        {"cache hit", time.Hour, "Berlin", time.Minute, 1},
+        {"names are matched case-insensitively", time.Hour, " berlin ", time.Minute, 1},
+        {"expired", time.Hour, "Berlin", time.Hour, 2},
+        {"cache disabled", 0, "Berlin", time.Minute, 2},
+        {"other name", time.Hour, "Paris", time.Minute, 2},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            withConfig(t, func(c *Config) {
+                c.GeocodingTimeout, c.GeocodingMaxBytes, c.GeocodingCacheTTL = time.Second, 64<<10, tt.ttl
+            })
+            var mu sync.Mutex
+            lookups := 0
+            stubGeocoding(t, func(w http.ResponseWriter, r *http.Request) {
+                mu.Lock()
+                lookups++
+                mu.Unlock()
+                w.Write([]byte(berlin))
+            })
+            start := time.Date(2024, 6, 30, 12, 0, 0, 0, time.UTC)
+            fixClock(t, start)
+            if _, err := geocode(context.Background(), "Berlin"); err != nil {
+                t.Fatal(err)
+            }
+            fixClock(t, start.Add(tt.after))
+            got, err := geocode(context.Background(), tt.second)
+            if err != nil {
+                t.Fatal(err)
+            }
+            if got.Latitude != 52.52437 {
+                t.Errorf("second lookup = %+v", got)
+            }
+            if lookups != tt.wantLookups {
+                t.Errorf("made %d lookups, want %d", lookups, tt.wantLookups)
+            }
+        })
+    }
+}
+
+func TestFetchByPlaceName(t *testing.T) {
+    noBigQuery(t)
+    withConfig(t, func(c *Config) {
+        c.GeocodingTimeout, c.GeocodingMaxBytes, c.GeocodingCacheTTL = time.Second, 64<<10, time.Hour
+    })
+    stubGeocoding(t, func(w http.ResponseWriter, r *http.Request) {
+        if r.URL.Query().Get("name") != "berlin" || r.URL.Query().Get("count") != "1" {
+            t.Errorf("unexpected geocoding query %s", r.URL.RawQuery)
+        }
+        w.Write([]byte(berlin))
+    })
+    var latitude string
+    stubOpenMeteo(t, func(w http.ResponseWriter, r *http.Request) {
+        latitude = r.URL.Query().Get("latitude")
+        serveBody(threeDays)(w, r)
+    })
+    rec := httptest.NewRecorder()
+    fetchWeatherData(rec, httptest.NewRequest(http.MethodGet, "/?name=Berlin&start_date=2024-01-01&end_date=2024-01-03&sink=none", nil))
+    if rec.Code != http.StatusOK {
+        t.Fatalf("status = %d: %s", rec.Code, rec.Body)
+    }
+    if latitude != "52.524370" {
+        t.Errorf("fetched latitude %q, want the geocoded 52.52437", latitude)
+    }
+
+    rec = httptest.NewRecorder()
+    fetchWeatherData(rec, httptest.NewRequest(http.MethodGet, "/?name=Berlin&latitude=52.52&longitude=13.41&sink=none", nil))
+    if rec.Code != http.StatusBadRequest {
+        t.Errorf("name with coordinates: status = %d, want 400", rec.Code)
+    }
+}
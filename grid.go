@@ -0,0 +1,102 @@
+package main
+
+import (
+    "fmt"
+    "math"
+    "net/url"
+    "strconv"
+)
+
+// minGridStep is the finest grid spacing accepted, in degrees; Open-Meteo's own grids are
+// coarser than this.
+const minGridStep = 0.01
+
+// gridSpec is a regular grid of points over an inclusive bounding box.
+type gridSpec struct {
+    Box  boundingBox
+    Step float64
+}
+
+// parseGrid reads the min_lat, min_lon, max_lat, max_lon, and step parameters, returning
+// nil when no bounding box is given. The grid may hold at most cfg.MaxCoordinates points.
+func parseGrid(q url.Values) (*gridSpec, error) {
+    if q.Get("min_lat") == "" && q.Get("min_lon") == "" && q.Get("max_lat") == "" && q.Get("max_lon") == "" {
+        return nil, nil
+    }
+    var errs validationErrors
+    value := func(name string) float64 {
+        f, err := strconv.ParseFloat(q.Get(name), 64)
+        if err != nil || math.IsNaN(f) || math.IsInf(f, 0) {
+            errs.add(name, "must be a number")
+        }
+        return f
+    }
+    grid := &gridSpec{
+        Box: boundingBox{
+            MinLatitude:  value("min_lat"),
+            MinLongitude: value("min_lon"),
+            MaxLatitude:  value("max_lat"),
+            MaxLongitude: value("max_lon"),
+        },
+        Step: value("step"),
+    }
+    if len(errs) > 0 {
+        return nil, errs
+    }
+    b := grid.Box
+    if err := validateCoordinates(b.MinLatitude, b.MinLongitude); err != nil {
+        errs.add("min_lat", "%v", err)
+    }
+    if err := validateCoordinates(b.MaxLatitude, b.MaxLongitude); err != nil {
+        errs.add("max_lat", "%v", err)
+    }
+    if b.MinLatitude > b.MaxLatitude {
+        errs.add("min_lat", "must not exceed max_lat")
+    }
+    if b.MinLongitude > b.MaxLongitude {
+        errs.add("min_lon", "must not exceed max_lon")
+    }
+    if grid.Step < minGridStep {
+        errs.add("step", "must be at least %v degrees", minGridStep)
+    }
+    if len(errs) > 0 {
+        return nil, errs
+    }
+    if n := grid.rows() * grid.columns(); n > cfg.MaxCoordinates {
+        return nil, fmt.Errorf("grid has %d points, more than the limit of %d; use a larger step or a smaller box", n, cfg.MaxCoordinates)
+    }
+    return grid, nil
+}
+
+// rows returns the number of grid latitudes.
+func (g *gridSpec) rows() int {
+    return gridCount(g.Box.MaxLatitude-g.Box.MinLatitude, g.Step)
+}
+
+// columns returns the number of grid longitudes.
+func (g *gridSpec) columns() int {
+    return gridCount(g.Box.MaxLongitude-g.Box.MinLongitude, g.Step)
+}
+
+// gridCount returns how many points spaced step apart fit in span, including both ends. A
+// small tolerance keeps a span that is a whole number of steps from losing its last point
+// to floating-point error.
+func gridCount(span, step float64) int {
+    return int(math.Floor(span/step+1e-9)) + 1
+}
+
+// locations lists the grid points row by row from the south-west corner. Coordinates are
+// computed from their index rather than accumulated, and rounded to 6 decimals, so they
+// come out as the values a caller would type.
+func (g *gridSpec) locations() []Location {
+    rows, columns := g.rows(), g.columns()
+    locations := make([]Location, 0, rows*columns)
+    for i := 0; i < rows; i++ {
+        lat := roundTo(g.Box.MinLatitude+float64(i)*g.Step, 6)
+        for j := 0; j < columns; j++ {
+            lon := roundTo(g.Box.MinLongitude+float64(j)*g.Step, 6)
+            locations = append(locations, Location{Latitude: lat, Longitude: lon})
+        }
+    }
+    return locations
+}
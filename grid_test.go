@@ -0,0 +1,135 @@
+package main
+
+import (
+    "encoding/json"
+    "net/http"
+    "net/http/httptest"
+    "net/url"
+    "reflect"
+    "sort"
+    "strings"
+    "sync"
+    "testing"
+)
+
+func TestParseGrid(t *testing.T) {
+    tests := []struct {
+        name    string
+        query   string
+        want    *gridSpec
+        wantErr string
+    }{
+        {"no box", "latitude=52.52&longitude=13.41", nil, ""},
+        {"valid", "min_lat=52&min_lon=13&max_lat=53&max_lon=14&step=0.5", &gridSpec{boundingBox{52, 13, 53, 14}, 0.5}, ""},
+        {"single point", "min_lat=52&min_lon=13&max_lat=52&max_lon=13&step=1", &gridSpec{boundingBox{52, 13, 52, 13}, 1}, ""},
+        {"missing step", "min_lat=52&min_lon=13&max_lat=53&max_lon=14", nil, "step"},
+        {"missing corner", "min_lat=52&min_lon=13&max_lat=53&step=1", nil, "max_lon"},
+        {"not a number", "min_lat=52&min_lon=13&max_lat=NaN&max_lon=14&step=1", nil, "max_lat"},
+        {"inverted latitudes", "min_lat=53&min_lon=13&max_lat=52&max_lon=14&step=0.5", nil, "must not exceed max_lat"},
+        {"out of range", "min_lat=52&min_lon=13&max_lat=95&max_lon=14&step=1", nil, "max_lat"},
+        {"step too fine", "min_lat=52&min_lon=13&max_lat=53&max_lon=14&step=0.001", nil, "step"},
+        {"over the point cap", "min_lat=0&min_lon=0&max_lat=10&max_lon=10&step=0.5", nil, "more than the limit of 100"},
+        {"at the point cap", "min_lat=0&min_lon=0&max_lat=9&max_lon=9&step=1", &gridSpec{boundingBox{0, 0, 9, 9}, 1}, ""},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            withConfig(t, func(c *Config) { c.MaxCoordinates = 100 })
+            q, _ := url.ParseQuery(tt.query)
+            got, err := parseGrid(q)
+            if tt.wantErr != "" {
+                if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+                    t.Errorf("err = %v, want one mentioning %q", err, tt.wantErr)
+                }
+                return
+            }
+            if err != nil {
+                t.Fatal(err)
+            }
+            if !reflect.DeepEqual(got, tt.want) {
+                t.Errorf("parseGrid() = %+v, want %+v", got, tt.want)
+            }
+        })
+    }
+}
+
+func TestGridLocations(t *testing.T) {
+    tests := []struct {
+        name string
+        grid gridSpec
+        want []Location
+    }{
+        {"single point", gridSpec{boundingBox{52, 13, 52, 13}, 1}, []Location{{Latitude: 52, Longitude: 13}}},
+        {"row by row from the south-west", gridSpec{boundingBox{52, 13, 53, 14}, 1}, []Location{
+            {Latitude: 52, Longitude: 13}, {Latitude: 52, Longitude: 14}, {Latitude: 53, Longitude: 13}, {Latitude: 53, Longitude: 14},
+        }},
+        {"partial step at the edge is dropped", gridSpec{boundingBox{52, 13, 52.7, 13}, 0.5}, []Location{{Latitude: 52, Longitude: 13}, {Latitude: 52.5, Longitude: 13}}},
+        {"decimal step keeps its last point", gridSpec{boundingBox{0, 0, 0.3, 0}, 0.1}, []Location{
+            {Latitude: 0, Longitude: 0}, {Latitude: 0.1, Longitude: 0}, {Latitude: 0.2, Longitude: 0}, {Latitude: 0.3, Longitude: 0},
+        }},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            got := tt.grid.locations()
+            if !reflect.DeepEqual(got, tt.want) {
+                t.Errorf("locations() = %v, want %v", got, tt.want)
+            }
+            if n := tt.grid.rows() * tt.grid.columns(); n != len(got) {
+                t.Errorf("rows*columns = %d, want %d", n, len(got))
+            }
+        })
+    }
+}
+
+func TestGridIngest(t *testing.T) {
+    fake, _ := newFakeBigQuery(t)
+    withConfig(t, func(c *Config) { c.TableID = "daily_weather" })
+    var mu sync.Mutex
+    var fetched []string
+    stubOpenMeteo(t, func(w http.ResponseWriter, r *http.Request) {
+        mu.Lock()
+        fetched = append(fetched, r.URL.Query().Get("latitude")+","+r.URL.Query().Get("longitude"))
+        mu.Unlock()
+        serveBody(threeDays)(w, r)
+    })
+
+    rec := httptest.NewRecorder()
+    fetchWeatherData(rec, httptest.NewRequest(http.MethodGet, "/?min_lat=52&min_lon=13&max_lat=52.5&max_lon=13.5&step=0.5&start_date=2024-01-01&end_date=2024-01-03", nil))
+    if rec.Code != http.StatusOK {
+        t.Fatalf("status = %d: %s", rec.Code, rec.Body)
+    }
+    var body struct {
+        Succeeded int `json:"succeeded"`
+        TotalRows int `json:"total_rows"`
+    }
+    if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+        t.Fatalf("%v: %s", err, rec.Body)
+    }
+    if body.Succeeded != 4 || body.TotalRows != 12 {
+        t.Errorf("summary = %+v, want 4 points of 3 rows", body)
+    }
+    sort.Strings(fetched)
+    if want := []string{"52.000000,13.000000", "52.000000,13.500000", "52.500000,13.000000", "52.500000,13.500000"}; !reflect.DeepEqual(fetched, want) {
+        t.Errorf("fetched %v, want %v", fetched, want)
+    }
+    // Open-Meteo snaps every point to 52.5,13.4 here, so the grid point tells them apart.
+    points := make(map[[2]float64]int)
+    for _, row := range fake.rows("daily_weather") {
+        lat, _ := row["grid_latitude"].(float64)
+        lon, _ := row["grid_longitude"].(float64)
+        points[[2]float64{lat, lon}]++
+    }
+    if want := map[[2]float64]int{{52, 13}: 3, {52, 13.5}: 3, {52.5, 13}: 3, {52.5, 13.5}: 3}; !reflect.DeepEqual(points, want) {
+        t.Errorf("stored grid points %v, want %v", points, want)
+    }
+}
+
+func TestParseRequestOptionsGridConflicts(t *testing.T) {
+    for _, query := range []string{
+        "min_lat=52&min_lon=13&max_lat=53&max_lon=14&step=1&latitude=52.52&longitude=13.41",
+        "min_lat=52&min_lon=13&max_lat=53&max_lon=14&step=1&coords_gcs_uri=gs://coords/daily.csv",
+    } {
+        if _, err := parseRequestOptions(httptest.NewRequest(http.MethodGet, "/?"+query, nil)); err == nil || !strings.Contains(err.Error(), "cannot be combined") {
+            t.Errorf("%s: err = %v, want a conflict error", query, err)
+        }
+    }
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+    "compress/gzip"
+    "log/slog"
+    "net/http"
+    "strings"
+)
+
+// withGzip compresses responses for clients that accept gzip once the body reaches minBytes.
+// Smaller bodies are sent as is, since compressing them costs more than it saves. A minBytes
+// of 0 disables compression.
+func withGzip(minBytes int, next http.Handler) http.Handler {
+    if minBytes <= 0 {
+        return next
+    }
+    return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        if !acceptsGzip(r.Header.Get("Accept-Encoding")) {
+            next.ServeHTTP(w, r)
+            return
+        }
+        w.Header().Add("Vary", "Accept-Encoding")
+        gw := &gzipWriter{ResponseWriter: w, minBytes: minBytes, status: http.StatusOK}
+        defer gw.close()
+        next.ServeHTTP(gw, r)
+    })
+}
+
+// acceptsGzip reports whether an Accept-Encoding header allows gzip.
+func acceptsGzip(header string) bool {
+    for _, part := range strings.Split(header, ",") {
+        coding, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+        if strings.EqualFold(strings.TrimSpace(coding), "gzip") {
+            return strings.ReplaceAll(params, " ", "") != "q=0"
+        }
+    }
+    return false
+}
+
+// gzipWriter holds back the start of the body until it knows whether the response is big
+// enough to compress, then either gzips everything or writes it through unchanged.
+type gzipWriter struct {
+    http.ResponseWriter
+    minBytes int
+    status   int
+    buf      []byte
+    decided  bool
+    gz       *gzip.Writer
+}
+
+func (gw *gzipWriter) WriteHeader(status int) {
+    if !gw.decided {
+        gw.status = status
+    }
+}
+
+func (gw *gzipWriter) Write(b []byte) (int, error) {
+    switch {
+    case gw.gz != nil:
+        return gw.gz.Write(b)
+    case gw.decided:
+        return gw.ResponseWriter.Write(b)
+    }
+    gw.buf = append(gw.buf, b...)
+    if len(gw.buf) >= gw.minBytes {
+        if err := gw.start(true); err != nil {
+            return 0, err
+        }
+    }
+    return len(b), nil
+}
+
+// Flush commits to compressing: only streamed responses flush, and those are the large ones.
+func (gw *gzipWriter) Flush() {
+    if !gw.decided {
+        if err := gw.start(true); err != nil {
+            return
+        }
+    }
+    if gw.gz != nil {
+        gw.gz.Flush()
+    }
+    if f, ok := gw.ResponseWriter.(http.Flusher); ok {
+        f.Flush()
+    }
+}
+
+// start sends the header and the held-back body, compressed when compress is set and the
+// response can carry a body that is not already encoded.
+func (gw *gzipWriter) start(compress bool) error {
+    gw.decided = true
+    h := gw.ResponseWriter.Header()
+    if compress && h.Get("Content-Encoding") == "" && gw.status != http.StatusNoContent && gw.status != http.StatusNotModified {
+        h.Set("Content-Encoding", "gzip")
+        h.Del("Content-Length")
+        gw.ResponseWriter.WriteHeader(gw.status)
+        gw.gz = gzip.NewWriter(gw.ResponseWriter)
+        _, err := gw.gz.Write(gw.buf)
+        gw.buf = nil
+        return err
+    }
+    gw.ResponseWriter.WriteHeader(gw.status)
+    _, err := gw.ResponseWriter.Write(gw.buf)
+    gw.buf = nil
+    return err
+}
+
+// close finishes the response: a body that stayed under the threshold is written plain.
+func (gw *gzipWriter) close() {
+    if !gw.decided {
+        if err := gw.start(false); err != nil {
+            slog.Error("Failed to write response", "error", err)
+        }
+        return
+    }
+    if gw.gz != nil {
+        if err := gw.gz.Close(); err != nil {
+            slog.Error("Failed to finish gzip response", "error", err)
+        }
+    }
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+    "compress/gzip"
+    "io"
+    "net/http"
+    "net/http/httptest"
+    "strings"
+    "testing"
+)
+
+func TestAcceptsGzip(t *testing.T) {
+    tests := []struct {
+        header string
+        want   bool
+    }{
+        {"", false},
+        {"gzip", true},
+        {"deflate, gzip;q=1.0, br", true},
+        {"GZIP", true},
+        {"gzip;q=0", false},
+        {"gzip; q=0", false},
+        {"br, deflate", false},
+        {"x-gzip", false},
+    }
+    for _, tt := range tests {
+        t.Run(tt.header, func(t *testing.T) {
+            if got := acceptsGzip(tt.header); got != tt.want {
+                t.Errorf("acceptsGzip(%q) = %v, want %v", tt.header, got, tt.want)
+            }
+        })
+    }
+}
+
+func TestWithGzip(t *testing.T) {
+    large := strings.Repeat("weather,", 200)
+    tests := []struct {
+        name           string
+        minBytes       int
+        accept         string
+        body           string
+        status         int
+        encoding       string
+        wantCompressed bool
+    }{
+        {"large body is compressed", 1024, "gzip", large, http.StatusOK, "", true},
+        {"small body is sent as is", 1024, "gzip", "ok", http.StatusOK, "", false},
+        {"client without gzip", 1024, "", large, http.StatusOK, "", false},
+        {"disabled", 0, "gzip", large, http.StatusOK, "", false},
+        {"error status keeps its code", 1024, "gzip", large, http.StatusBadRequest, "", true},
+        {"already encoded", 1024, "gzip", large, http.StatusOK, "br", false},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            handler := withGzip(tt.minBytes, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+                if tt.encoding != "" {
+                    w.Header().Set("Content-Encoding", tt.encoding)
+                }
+                w.WriteHeader(tt.status)
+                io.WriteString(w, tt.body)
+            }))
+            req := httptest.NewRequest(http.MethodGet, "/", nil)
+            if tt.accept != "" {
+                req.Header.Set("Accept-Encoding", tt.accept)
+            }
+            rec := httptest.NewRecorder()
+            handler.ServeHTTP(rec, req)
+            if rec.Code != tt.status {
+                t.Errorf("status = %d, want %d", rec.Code, tt.status)
+            }
+            compressed := rec.Header().Get("Content-Encoding") == "gzip"
+            if compressed != tt.wantCompressed {
+                t.Fatalf("compressed = %v, want %v", compressed, tt.wantCompressed)
+            }
+            body := rec.Body.String()
+            if compressed {
+                zr, err := gzip.NewReader(rec.Body)
+                if err != nil {
+                    t.Fatal(err)
+                }
+                b, err := io.ReadAll(zr)
+                if err != nil {
+                    t.Fatal(err)
+                }
+                body = string(b)
+            }
+            if body != tt.body {
+                t.Errorf("body has %d bytes, want %d", len(body), len(tt.body))
+            }
+        })
+    }
+}
+
+func TestWithGzipNoContent(t *testing.T) {
+    handler := withGzip(1, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        w.WriteHeader(http.StatusNoContent)
+    }))
+    req := httptest.NewRequest(http.MethodGet, "/", nil)
+    req.Header.Set("Accept-Encoding", "gzip")
+    rec := httptest.NewRecorder()
+    handler.ServeHTTP(rec, req)
+    if rec.Code != http.StatusNoContent || rec.Header().Get("Content-Encoding") != "" || rec.Body.Len() != 0 {
+        t.Errorf("got %d, encoding %q, %d bytes; want an empty 204", rec.Code, rec.Header().Get("Content-Encoding"), rec.Body.Len())
+    }
+}
+
+func TestWithGzipFlushCompresses(t *testing.T) {
+    handler := withGzip(1<<20, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        io.WriteString(w, "[1,")
+        w.(http.Flusher).Flush()
+        io.WriteString(w, "2]")
+    }))
+    req := httptest.NewRequest(http.MethodGet, "/", nil)
+    req.Header.Set("Accept-Encoding", "gzip")
+    rec := httptest.NewRecorder()
+    handler.ServeHTTP(rec, req)
+    if rec.Header().Get("Content-Encoding") != "gzip" {
+        t.Fatal("flushed response not compressed")
+    }
+    zr, err := gzip.NewReader(rec.Body)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if b, _ := io.ReadAll(zr); string(b) != "[1,2]" {
+        t.Errorf("body = %q, want [1,2]", b)
+    }
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+    "context"
+    "log/slog"
+    "time"
+)
+
+// maxHintProbes bounds the Open-Meteo requests spent looking for the earliest available date.
+const maxHintProbes = 16
+
+// earliestDateHint looks for the first date after the requested range that has data for the
+// coordinate, returning "" when there is none up to the latest available day or a probe
+// fails. Coverage is assumed to start at some date and continue from there, so the date is
+// found by bisecting between the end of the empty range and the latest day with one-day probes.
+func earliestDateHint(ctx context.Context, opts *requestOptions) string {
+    lo, err := time.Parse(dateLayout, opts.EndDate)
+    if err != nil {
+        return ""
+    }
+    latest := now().UTC().Truncate(24 * time.Hour)
+    if opts.Mode == "archive" {
+        latest = latest.AddDate(0, 0, -archiveLagDays)
+    }
+    if !latest.After(lo) {
+        return ""
+    }
+
+    probes := 0
+    probe := func(day time.Time) (bool, bool) {
+        probes++
+        date := day.Format(dateLayout)
+        m := *opts
+        m.EndDate = date
+        meteoResp, err := fetchOpenMeteo(ctx, &m, date)
+        if err != nil {
+            slog.Warn("Failed to probe for the earliest available date", "date", date, "error", err)
+            return false, false
+        }
+        return len(meteoResp.Daily.emptyVariables(m.Variables)) < len(m.Variables), true
+    }
+
+    // hi always has data and lo never does; narrow the gap until they are adjacent days.
+    hi := latest
+    if found, ok := probe(hi); !ok || !found {
+        return ""
+    }
+    for hi.Sub(lo) > 24*time.Hour && probes < maxHintProbes {
+        mid := lo.AddDate(0, 0, int(hi.Sub(lo).Hours()/24)/2)
+        found, ok := probe(mid)
+        if !ok {
+            break
+        }
+        if found {
+            hi = mid
+        } else {
+            lo = mid
+        }
+    }
+    return hi.Format(dateLayout)
+}
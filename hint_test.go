@@ -0,0 +1,82 @@
+package main
+
+import (
+    "context"
+    "net/http"
+    "net/http/httptest"
+    "sync"
+    "testing"
+    "time"
+)
+
+func TestEarliestDateHint(t *testing.T) {
+    tests := []struct {
+        name   string
+        first  string
+        end    string
+        status int
+        want   string
+    }{
+        {"coverage starts days later", "2024-01-03", "2023-12-05", 0, "2024-01-03"},
+        {"coverage starts the next day", "2023-12-06", "2023-12-05", 0, "2023-12-06"},
+        {"coverage starts at the latest day", "2024-06-23", "2023-12-05", 0, "2024-06-23"},
+        {"no coverage at all", "2024-07-01", "2023-12-05", 0, ""},
+        {"range ends at the latest day", "2024-01-03", "2024-06-23", 0, ""},
+        {"probe fails", "2024-01-03", "2023-12-05", http.StatusBadGateway, ""},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            fixClock(t, time.Date(2024, 6, 30, 12, 0, 0, 0, time.UTC))
+            var mu sync.Mutex
+            probes := 0
+            serve := serveDays(t, tt.first, 0)
+            stubOpenMeteo(t, func(w http.ResponseWriter, r *http.Request) {
+                mu.Lock()
+                probes++
+                mu.Unlock()
+                if start, end := r.URL.Query().Get("start_date"), r.URL.Query().Get("end_date"); start != end {
+                    t.Errorf("probe covers %s to %s, want one day", start, end)
+                }
+                if tt.status != 0 {
+                    http.Error(w, "unavailable", tt.status)
+                    return
+                }
+                serve(w, r)
+            })
+            opts := mustParseOptions(t, "latitude=52.52&longitude=13.41&start_date=2023-12-01&end_date="+tt.end)
+            if got := earliestDateHint(context.Background(), opts); got != tt.want {
+                t.Errorf("earliestDateHint() = %q, want %q", got, tt.want)
+            }
+            if probes > maxHintProbes {
+                t.Errorf("made %d probes, more than %d", probes, maxHintProbes)
+            }
+        })
+    }
+}
+
+func TestEmptyRangeHint(t *testing.T) {
+    tests := []struct {
+        name  string
+        query string
+        want  string
+    }{
+        {"hint requested", "&hint_earliest=true", "2024-01-03"},
+        {"hint not requested", "", ""},
+        {"hint with rows returned", "&hint_earliest=true&sink=none", "2024-01-03"},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            fixClock(t, time.Date(2024, 6, 30, 12, 0, 0, 0, time.UTC))
+            newFakeBigQuery(t)
+            stubOpenMeteo(t, serveDays(t, "2024-01-03", 0))
+            rec := httptest.NewRecorder()
+            fetchWeatherData(rec, httptest.NewRequest(http.MethodGet, "/?latitude=52.52&longitude=13.41&start_date=2023-12-01&end_date=2023-12-05"+tt.query, nil))
+            if rec.Code != http.StatusNoContent {
+                t.Fatalf("status = %d, want 204: %s", rec.Code, rec.Body)
+            }
+            if got := rec.Header().Get("X-Earliest-Available-Date"); got != tt.want {
+                t.Errorf("X-Earliest-Available-Date = %q, want %q", got, tt.want)
+            }
+        })
+    }
+}
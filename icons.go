@@ -0,0 +1,111 @@
+package main
+
+import (
+    "encoding/json"
+    "log/slog"
+    "net/http"
+    "os"
+    "strconv"
+)
+
+// weatherIcon names the icons for a weather code by day and by night.
+type weatherIcon struct {
+    Day   string `json:"day"`
+    Night string `json:"night"`
+}
+
+// defaultIcons maps WMO weather codes to the built-in icon set. Only clear and partly
+// cloudy skies differ by night; the others use the same icon for both.
+var defaultIcons = map[int64]weatherIcon{
+    0:  {"clear-day", "clear-night"},
+    1:  {"mostly-clear-day", "mostly-clear-night"},
+    2:  {"partly-cloudy-day", "partly-cloudy-night"},
+    3:  {"overcast", "overcast"},
+    45: {"fog", "fog"},
+    48: {"fog", "fog"},
+    51: {"drizzle", "drizzle"},
+    53: {"drizzle", "drizzle"},
+    55: {"drizzle", "drizzle"},
+    56: {"freezing-drizzle", "freezing-drizzle"},
+    57: {"freezing-drizzle", "freezing-drizzle"},
+    61: {"rain", "rain"},
+    63: {"rain", "rain"},
+    65: {"heavy-rain", "heavy-rain"},
+    66: {"freezing-rain", "freezing-rain"},
+    67: {"freezing-rain", "freezing-rain"},
+    71: {"snow", "snow"},
+    73: {"snow", "snow"},
+    75: {"heavy-snow", "heavy-snow"},
+    77: {"snow-grains", "snow-grains"},
+    80: {"showers-day", "showers-night"},
+    81: {"showers-day", "showers-night"},
+    82: {"heavy-showers", "heavy-showers"},
+    85: {"snow-showers-day", "snow-showers-night"},
+    86: {"snow-showers-day", "snow-showers-night"},
+    95: {"thunderstorm", "thunderstorm"},
+    96: {"thunderstorm-hail", "thunderstorm-hail"},
+    99: {"thunderstorm-hail", "thunderstorm-hail"},
+}
+
+// weatherIcons is the icon mapping in use: the defaults with any WEATHER_ICONS_JSON overrides.
+var weatherIcons = loadIcons(os.Getenv("WEATHER_ICONS_JSON"))
+
+// loadIcons applies a JSON object of code to {"day", "night"} overrides on top of the
+// defaults. Invalid JSON is logged and ignored, so a bad override never breaks the endpoint.
+func loadIcons(overrides string) map[int64]weatherIcon {
+    icons := make(map[int64]weatherIcon, len(defaultIcons))
+    for code, icon := range defaultIcons {
+        icons[code] = icon
+    }
+    if overrides == "" {
+        return icons
+    }
+
+    var custom map[int64]weatherIcon
+    if err := json.Unmarshal([]byte(overrides), &custom); err != nil {
+        slog.Error("Ignoring invalid WEATHER_ICONS_JSON", "error", err)
+        return icons
+    }
+    for code, icon := range custom {
+        icons[code] = icon
+    }
+    return icons
+}
+
+// serveIcons returns the weather code to icon mapping, or the icon of a single code when
+// code is given, optionally narrowed to variant=day or variant=night.
+func serveIcons(w http.ResponseWriter, r *http.Request) {
+    if r.Method != http.MethodGet {
+        http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+        return
+    }
+    q := r.URL.Query()
+    variant := q.Get("variant")
+    if variant != "" && variant != "day" && variant != "night" {
+        http.Error(w, "variant must be day or night", http.StatusBadRequest)
+        return
+    }
+    if q.Get("code") == "" {
+        writeJSON(w, http.StatusOK, map[string]interface{}{"icons": weatherIcons})
+        return
+    }
+
+    code, err := strconv.ParseInt(q.Get("code"), 10, 64)
+    if err != nil {
+        http.Error(w, "invalid code", http.StatusBadRequest)
+        return
+    }
+    icon, ok := weatherIcons[code]
+    if !ok {
+        http.Error(w, "Unknown weather code", http.StatusNotFound)
+        return
+    }
+    switch variant {
+    case "day":
+        writeJSON(w, http.StatusOK, map[string]interface{}{"code": code, "icon": icon.Day})
+    case "night":
+        writeJSON(w, http.StatusOK, map[string]interface{}{"code": code, "icon": icon.Night})
+    default:
+        writeJSON(w, http.StatusOK, map[string]interface{}{"code": code, "icon": icon})
+    }
+}
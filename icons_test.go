@@ -0,0 +1,91 @@
+package main
+
+import (
+    "encoding/json"
+    "net/http"
+    "net/http/httptest"
+    "testing"
+)
+
+func TestServeIcons(t *testing.T) {
+    tests := []struct {
+        name       string
+        method     string
+        query      string
+        wantStatus int
+        wantIcon   string
+    }{
+        {"clear day", http.MethodGet, "code=0&variant=day", http.StatusOK, "clear-day"},
+        {"clear night", http.MethodGet, "code=0&variant=night", http.StatusOK, "clear-night"},
+        {"showers at night", http.MethodGet, "code=80&variant=night", http.StatusOK, "showers-night"},
+        {"rain is the same by night", http.MethodGet, "code=63&variant=night", http.StatusOK, "rain"},
+        {"fog by day", http.MethodGet, "code=45&variant=day", http.StatusOK, "fog"},
+        {"unknown code", http.MethodGet, "code=42", http.StatusNotFound, ""},
+        {"invalid code", http.MethodGet, "code=rain", http.StatusBadRequest, ""},
+        {"invalid variant", http.MethodGet, "code=0&variant=dusk", http.StatusBadRequest, ""},
+        {"wrong method", http.MethodPost, "", http.StatusMethodNotAllowed, ""},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            rec := httptest.NewRecorder()
+            serveIcons(rec, httptest.NewRequest(tt.method, "/icons?"+tt.query, nil))
+            if rec.Code != tt.wantStatus {
+                t.Fatalf("status = %d, want %d", rec.Code, tt.wantStatus)
+            }
+            if tt.wantIcon == "" {
+                return
+            }
+            var body struct {
+                Icon string `json:"icon"`
+            }
+            if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+                t.Fatal(err)
+            }
+            if body.Icon != tt.wantIcon {
+                t.Errorf("icon = %q, want %q", body.Icon, tt.wantIcon)
+            }
+        })
+    }
+}
+
+func TestServeIconsBothVariants(t *testing.T) {
+    rec := httptest.NewRecorder()
+    serveIcons(rec, httptest.NewRequest(http.MethodGet, "/icons?code=2", nil))
+    var body struct {
+        Icon weatherIcon `json:"icon"`
+    }
+    if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+        t.Fatal(err)
+    }
+    if want := (weatherIcon{"partly-cloudy-day", "partly-cloudy-night"}); body.Icon != want {
+        t.Errorf("icon = %+v, want %+v", body.Icon, want)
+    }
+}
+
+func TestLoadIcons(t *testing.T) {
+    tests := []struct {
+        name      string
+        overrides string
+        code      int64
+        want      weatherIcon
+        wantOK    bool
+    }{
+        {"defaults", "", 0, weatherIcon{"clear-day", "clear-night"}, true},
+        {"override replaces a default", `{"0":{"day":"sun","night":"moon"}}`, 0, weatherIcon{"sun", "moon"}, true},
+        {"override keeps other defaults", `{"0":{"day":"sun","night":"moon"}}`, 3, weatherIcon{"overcast", "overcast"}, true},
+        {"override adds a code", `{"100":{"day":"x","night":"y"}}`, 100, weatherIcon{"x", "y"}, true},
+        {"invalid JSON keeps the defaults", `{"0":`, 0, weatherIcon{"clear-day", "clear-night"}, true},
+        {"unmapped code", "", 42, weatherIcon{}, false},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            got, ok := loadIcons(tt.overrides)[tt.code]
+            if ok != tt.wantOK || got != tt.want {
+                t.Errorf("icon %d = %+v, %v; want %+v, %v", tt.code, got, ok, tt.want, tt.wantOK)
+            }
+        })
+    }
+    if defaultIcons[0].Day != "clear-day" {
+        t.Error("loadIcons() modified the defaults")
+    }
+}
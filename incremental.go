@@ -0,0 +1,90 @@
+package main
+
+import (
+    "context"
+    "fmt"
+    "time"
+
+    "cloud.google.com/go/bigquery"
+    "cloud.google.com/go/civil"
+    "google.golang.org/api/iterator"
+)
+
+// latestStoredDate returns the most recent date stored in the table for a coordinate, or false if none.
+// Stored coordinates are Open-Meteo's snapped grid point, so rows are matched within
+// cfg.IncrementalTolerance degrees of the requested coordinate rather than exactly.
+func latestStoredDate(ctx context.Context, client *bigquery.Client, tableID string, latitude, longitude float64) (string, bool, error) {
+    query := client.Query(fmt.Sprintf(
+        "SELECT CAST(MAX(date) AS STRING) AS max_date FROM `%s.%s.%s` WHERE ABS(latitude - @latitude) <= @tolerance AND ABS(longitude - @longitude) <= @tolerance",
+        cfg.ProjectID, cfg.DatasetID, tableID,
+    ))
+    query.Parameters = []bigquery.QueryParameter{
+        {Name: "latitude", Value: latitude},
+        {Name: "longitude", Value: longitude},
+        {Name: "tolerance", Value: cfg.IncrementalTolerance},
+    }
+    it, err := query.Read(ctx)
+    if err != nil {
+        return "", false, err
+    }
+
+    var row struct {
+        MaxDate bigquery.NullString `bigquery:"max_date"`
+    }
+    if err := it.Next(&row); err != nil {
+        if err == iterator.Done {
+            return "", false, nil
+        }
+        return "", false, err
+    }
+    return row.MaxDate.StringVal, row.MaxDate.Valid, nil
+}
+
+// resolveIncrementalStart returns the day after the latest stored date, or the fallback
+// start date when the coordinate has no data yet.
+func resolveIncrementalStart(ctx context.Context, client *bigquery.Client, opts *requestOptions) (string, error) {
+    latest, ok, err := latestStoredDate(ctx, client, opts.dailyTable(), opts.Latitude, opts.Longitude)
+    if err != nil {
+        return "", err
+    }
+    if !ok {
+        return opts.StartDate, nil
+    }
+    last, err := time.Parse("2006-01-02", latest)
+    if err != nil {
+        return "", fmt.Errorf("invalid stored date %q: %w", latest, err)
+    }
+    return last.AddDate(0, 0, 1).Format("2006-01-02"), nil
+}
+
+// isFresh reports whether a row for the coordinate and date was inserted into the table within the window.
+// The date is compared as a DATE so the query only scans that day's partition.
+func isFresh(ctx context.Context, client *bigquery.Client, tableID string, latitude, longitude float64, date string, window time.Duration) (bool, error) {
+    day, err := civil.ParseDate(date)
+    if err != nil {
+        return false, fmt.Errorf("invalid date %q: %w", date, err)
+    }
+    query := client.Query(fmt.Sprintf(
+        "SELECT COUNT(*) AS n FROM `%s.%s.%s` WHERE date = @date AND ABS(latitude - @latitude) <= @tolerance AND ABS(longitude - @longitude) <= @tolerance AND inserted_at >= @since",
+        cfg.ProjectID, cfg.DatasetID, tableID,
+    ))
+    query.Parameters = []bigquery.QueryParameter{
+        {Name: "latitude", Value: latitude},
+        {Name: "longitude", Value: longitude},
+        {Name: "tolerance", Value: cfg.IncrementalTolerance},
+        {Name: "date", Value: day},
+        {Name: "since", Value: now().Add(-window)},
+    }
+    it, err := query.Read(ctx)
+    if err != nil {
+        return false, err
+    }
+
+    var row struct {
+        N int64 `bigquery:"n"`
+    }
+    if err := it.Next(&row); err != nil {
+        return false, err
+    }
+    return row.N > 0, nil
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+    "context"
+    "net/http"
+    "strings"
+    "testing"
+    "time"
+)
+
+func TestResolveIncrementalStart(t *testing.T) {
+    tests := []struct {
+        name   string
+        stored interface{}
+        want   string
+    }{
+        {"resumes the day after the latest stored date", "2024-03-31", "2024-04-01"},
+        {"crosses a year boundary", "2023-12-31", "2024-01-01"},
+        {"falls back to the start date without stored data", nil, "2004-01-01"},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            fake, client := newFakeBigQuery(t)
+            fake.answer = func(q *fakeQuery) *fakeResult {
+                return &fakeResult{Fields: fields("max_date", "STRING"), Rows: [][]interface{}{{tt.stored}}}
+            }
+            opts := mustParseOptions(t, "latitude=52.52&longitude=13.41&incremental=true&start_date=2004-01-01&end_date=2024-06-30")
+            got, err := resolveIncrementalStart(context.Background(), client, opts)
+            if err != nil {
+                t.Fatal(err)
+            }
+            if got != tt.want {
+                t.Errorf("resolveIncrementalStart() = %s, want %s", got, tt.want)
+            }
+            q := fake.received()[0]
+            if q.param("latitude") != "52.52" || q.param("tolerance") == "" {
+                t.Errorf("query parameters %+v lack the coordinate or tolerance", q.Params)
+            }
+        })
+    }
+}
+
+func TestIngestIncrementalUpToDate(t *testing.T) {
+    fake, client := newFakeBigQuery(t)
+    fake.answer = func(q *fakeQuery) *fakeResult {
+        return &fakeResult{Fields: fields("max_date", "STRING"), Rows: [][]interface{}{{"2024-06-30"}}}
+    }
+    opts := mustParseOptions(t, "latitude=52.52&longitude=13.41&incremental=true&start_date=2004-01-01&end_date=2024-06-30")
+    result, err := ingest(context.Background(), client, opts, time.Now())
+    if err != nil {
+        t.Fatal(err)
+    }
+    if !result.UpToDate || result.Rows != 0 {
+        t.Errorf("result = %+v, want up to date with no rows", result)
+    }
+}
+
+func TestIsFresh(t *testing.T) {
+    tests := []struct {
+        name    string
+        count   int64
+        date    string
+        want    bool
+        wantErr bool
+    }{
+        {"recent row", 1, "2024-06-30", true, false},
+        {"no recent row", 0, "2024-06-30", false, false},
+        {"invalid date", 0, "2024-06-31", false, true},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            fixClock(t, time.Date(2024, 7, 1, 12, 0, 0, 0, time.UTC))
+            fake, client := newFakeBigQuery(t)
+            fake.answer = func(q *fakeQuery) *fakeResult {
+                return &fakeResult{Fields: fields("n", "INTEGER"), Rows: [][]interface{}{{tt.count}}}
+            }
+            got, err := isFresh(context.Background(), client, "daily_weather", 52.52, 13.41, tt.date, 6*time.Hour)
+            if (err != nil) != tt.wantErr {
+                t.Fatalf("err = %v, wantErr %v", err, tt.wantErr)
+            }
+            if tt.wantErr {
+                if n := len(fake.received()); n != 0 {
+                    t.Errorf("ran %d queries for an invalid date", n)
+                }
+                return
+            }
+            if got != tt.want {
+                t.Errorf("isFresh() = %v, want %v", got, tt.want)
+            }
+            q := fake.received()[0]
+            if !strings.Contains(q.SQL, "date = @date") || strings.Contains(q.SQL, "CAST(date AS STRING)") {
+                t.Errorf("SQL %q does not compare the date column as a DATE", q.SQL)
+            }
+            if q.paramType("date") != "DATE" || q.param("date") != tt.date {
+                t.Errorf("date parameter = %q of type %q, want DATE %s", q.param("date"), q.paramType("date"), tt.date)
+            }
+            if q.paramType("since") != "TIMESTAMP" {
+                t.Errorf("since parameter has type %q, want TIMESTAMP", q.paramType("since"))
+            }
+        })
+    }
+}
+
+func TestIngestSkipIfFresh(t *testing.T) {
+    fake, client := newFakeBigQuery(t)
+    fake.answer = func(q *fakeQuery) *fakeResult {
+        return &fakeResult{Fields: fields("n", "INTEGER"), Rows: [][]interface{}{{1}}}
+    }
+    stubOpenMeteo(t, func(w http.ResponseWriter, r *http.Request) {
+        t.Error("Open-Meteo called for a fresh range")
+    })
+    opts := mustParseOptions(t, "latitude=52.52&longitude=13.41&skip_if_fresh=true&start_date=2024-06-01&end_date=2024-06-30")
+    result, err := ingest(context.Background(), client, opts, time.Now())
+    if err != nil {
+        t.Fatal(err)
+    }
+    if !result.Fresh || result.Rows != 0 {
+        t.Errorf("result = %+v, want fresh with no rows", result)
+    }
+}
@@ -0,0 +1,412 @@
+package main
+
+import (
+    "context"
+    "crypto/sha256"
+    "encoding/hex"
+    "fmt"
+    "log/slog"
+    "net/http"
+    "strconv"
+    "time"
+
+    "cloud.google.com/go/bigquery"
+    "cloud.google.com/go/civil"
+    "github.com/google/uuid"
+    "github.com/mmcloughlin/geohash"
+)
+
+// ingestResult summarizes the outcome of ingesting one location.
+type ingestResult struct {
+    BatchID   string
+    StartDate string
+    Rows      int
+    // UpToDate is set when incremental mode found nothing left to fetch.
+    UpToDate bool
+    // Empty is set when Open-Meteo returned no days for the range.
+    Empty bool
+    // EarliestDate is the first later date with data, looked up for an empty range when
+    // hint_earliest is set; empty otherwise.
+    EarliestDate string
+    // Reverified counts the stale days reverify_older_than re-fetched, and Revised those
+    // whose values had changed.
+    Reverified int
+    Revised    int
+    // Fresh is set when skip_if_fresh found recently inserted data and nothing was fetched.
+    Fresh bool
+    // VisibleRows is how many inserted rows a verify=true read-back saw; Verified is set when
+    // that matched Rows.
+    VisibleRows int
+    Verified    bool
+    // Coverage is the fraction of the expected days Open-Meteo returned, 1 when complete and
+    // 0 when not measured, as for model comparisons.
+    Coverage float64
+    // RateLimit is the upstream budget reported by the last Open-Meteo response, if any.
+    RateLimit *rateLimit
+    // Checksum is the hash of the fetched values for checksum=true, taken before finishRows
+    // so output options do not change it; empty otherwise.
+    Checksum string
+    // ChecksumStatus is "new", "unchanged", or "changed" for checksum=true; empty otherwise
+    // or when the comparison failed.
+    ChecksumStatus string
+    // Data holds the inserted daily rows when return_rows=true, for the response.
+    Data []*WeatherData
+    // Ranges holds the rows each requested range contributed, for jobs with several ranges.
+    Ranges []rangeRows
+    // Endpoint is the Open-Meteo base URL that served the last response.
+    Endpoint string
+    // SourceURLs are the redacted Open-Meteo URLs the rows were fetched from, one per request.
+    SourceURLs []string
+}
+
+// ingest fetches the weather data for one location and stores it in BigQuery.
+func ingest(ctx context.Context, client *bigquery.Client, opts *requestOptions, started time.Time) (*ingestResult, error) {
+    // Re-verification updates stored rows in place instead of inserting new ones.
+    if opts.ReverifyOlderThan > 0 {
+        return reverify(ctx, client, opts)
+    }
+
+    // Skip the whole fetch when the last day of the range was stored recently enough.
+    if opts.SkipIfFresh {
+        fresh, err := isFresh(ctx, client, opts.dailyTable(), opts.Latitude, opts.Longitude, opts.EndDate, opts.FreshWindow)
+        if err != nil {
+            return nil, &requestError{http.StatusInternalServerError, "BigQuery error", fmt.Errorf("failed to check freshness: %w", err)}
+        }
+        if fresh {
+            return &ingestResult{StartDate: opts.StartDate, Fresh: true}, nil
+        }
+    }
+
+    result, weatherData, err := fetchRows(ctx, client, opts)
+    if err != nil || result.UpToDate || result.Empty {
+        return result, err
+    }
+
+    // Monthly aggregates replace the daily rows and go to their own table, as do long rows
+    // and models merged into model-suffixed columns. An older schema_version keeps the daily table but
+    // builds rows and new tables with only its columns. A table_suffix applies to whichever
+    // table is used.
+    tableID, newMeta, rows, count := opts.dailyTable(), newTableMetadata, interface{}(weatherData), len(weatherData)
+    mergedModels := len(opts.Models) > 0 && opts.ModelLayout == "columns"
+    switch {
+    case opts.Aggregate == "monthly":
+        monthly := aggregateMonthly(weatherData)
+        tableID, newMeta, rows, count = cfg.MonthlyTableID+opts.TableSuffix, monthlyTableMetadata, monthly, len(monthly)
+    case opts.Layout == "long":
+        long := longRows(weatherData, opts.Variables)
+        tableID, newMeta, rows, count = cfg.LongTableID+opts.TableSuffix, longTableMetadata, long, len(long)
+    case mergedModels:
+        merged := mergeModelColumns(opts, weatherData)
+        newMeta = func() (*bigquery.TableMetadata, error) { return modelColumnsMetadata(opts), nil }
+        tableID, rows, count = cfg.ModelsTableID+opts.TableSuffix, merged, len(merged)
+    case opts.Rolling > 0:
+        if rows, err = rollingRows(weatherData); err != nil {
+            return nil, &requestError{http.StatusInternalServerError, "BigQuery error", err}
+        }
+        newMeta = rollingTableMetadata(opts.Rolling)
+    case opts.SchemaVersion != latestSchemaVersion:
+        version := schemaVersions[opts.SchemaVersion]
+        if rows, err = versionedRows(weatherData, version); err != nil {
+            return nil, &requestError{http.StatusInternalServerError, "BigQuery error", err}
+        }
+        newMeta = version.metadata
+    }
+
+    // Create the table on first use.
+    created, err := ensureTable(ctx, client, tableID, newMeta)
+    if err != nil {
+        return nil, &requestError{http.StatusInternalServerError, "BigQuery error", fmt.Errorf("failed to prepare table: %w", err)}
+    }
+    var extraColumns bigquery.Schema
+    switch {
+    case mergedModels:
+        extraColumns = modelColumnsMetadata(opts).Schema
+    case opts.Rolling > 0:
+        extraColumns = rollingSchema(opts.Rolling)
+    }
+    if extraColumns != nil && !created {
+        if err := addMissingColumns(ctx, client.Dataset(cfg.DatasetID).Table(tableID), extraColumns); err != nil {
+            return nil, &requestError{http.StatusInternalServerError, "BigQuery error", fmt.Errorf("failed to prepare table: %w", err)}
+        }
+    }
+
+    // Insert data into BigQuery, replacing the day's partition when a decorator was requested
+    // or appending through the Storage Write API when that was selected.
+    switch {
+    case opts.PartitionDecorator:
+        rows = weatherData
+        err = loadPartition(ctx, client, tableID, opts.StartDate, weatherData)
+    case opts.WriteAPI == "storage":
+        rows = weatherData
+        err = storageWriteRows(ctx, client, tableID, weatherData)
+    default:
+        err = putRows(ctx, client.Dataset(cfg.DatasetID).Table(tableID), rows)
+    }
+    if err != nil {
+        if ctx.Err() != nil {
+            slog.Warn("Insert interrupted by the request deadline; rows may be partially stored", "batch_id", result.BatchID, "rows", count)
+        }
+        message := "Failed to store data"
+        if cfg.DeadLetterURI != "" {
+            if dlErr := writeDeadLetter(ctx, opts, tableID, result.BatchID, weatherData, rows, err); dlErr != nil {
+                slog.Error("Failed to write dead letter", "batch_id", result.BatchID, "error", dlErr)
+            } else {
+                message += "; batch " + result.BatchID + " saved to the dead letter"
+            }
+        }
+        return nil, &requestError{http.StatusInternalServerError, message, fmt.Errorf("failed to insert data: %w", err)}
+    }
+    result.Rows = count
+    rowsPerIngestion.observe(float64(result.Rows))
+    totalRowsInserted.Add(uint64(result.Rows))
+    if opts.ReturnRows {
+        result.Data = weatherData
+    }
+
+    // Compare with the checksum of the previous run over the same range, without failing
+    // the request if that fails.
+    if opts.Checksum {
+        if result.ChecksumStatus, err = compareChecksum(ctx, client, opts, result.BatchID, result.Checksum); err != nil {
+            slog.Error("Failed to compare checksum", "batch_id", result.BatchID, "error", err)
+        } else if result.ChecksumStatus == "changed" {
+            slog.Warn("Upstream data changed since the last checksum", "batch_id", result.BatchID, "start_date", opts.StartDate, "end_date", opts.EndDate)
+        }
+    }
+
+    // Confirm the rows can be read back, flagging rather than failing a shortfall.
+    if opts.Verify {
+        // The monthly table has no date column to limit the count to the range.
+        var start, end civil.Date
+        if opts.Aggregate != "monthly" {
+            start, _ = civil.ParseDate(result.StartDate)
+            end, _ = civil.ParseDate(opts.EndDate)
+        }
+        visible, err := verifyInsert(ctx, client, tableID, result.BatchID, start, end, count)
+        if err != nil {
+            slog.Error("Failed to verify insert", "batch_id", result.BatchID, "error", err)
+        }
+        result.VisibleRows, result.Verified = visible, err == nil && visible >= count
+        if !result.Verified {
+            slog.Warn("Inserted rows not all visible", "batch_id", result.BatchID, "expected", count, "visible", visible)
+        }
+    }
+
+    // Keep the variables meta table current, without failing the request if that fails.
+    if cfg.RecordVariablesMeta {
+        if err := recordVariablesMeta(ctx, client, opts); err != nil {
+            slog.Error("Failed to record variables meta", "batch_id", result.BatchID, "error", err)
+        }
+    }
+
+    // Record how long the run took, without failing the request if that fails.
+    if cfg.RecordIngestRuns {
+        run := &IngestRun{
+            BatchID:     result.BatchID,
+            Latitude:    weatherData[0].Latitude,
+            Longitude:   weatherData[0].Longitude,
+            StartDate:   result.StartDate,
+            EndDate:     opts.EndDate,
+            RowCount:    result.Rows,
+            DurationMs:  time.Since(started).Milliseconds(),
+            CompletedAt: now(),
+            SourceIP:    bigquery.NullString{StringVal: opts.CallerIP, Valid: opts.CallerIP != ""},
+            UserAgent:   bigquery.NullString{StringVal: opts.UserAgent, Valid: opts.UserAgent != ""},
+        }
+        if err := recordIngestRun(ctx, client, run); err != nil {
+            slog.Error("Failed to record ingest run", "batch_id", result.BatchID, "error", err)
+        }
+    }
+
+    // Document what was fetched for lineage, without failing the request if that fails.
+    if cfg.ManifestURI != "" {
+        if err := writeManifest(ctx, newRunManifest(opts, result, tableID, weatherData, started)); err != nil {
+            slog.Error("Failed to write run manifest", "batch_id", result.BatchID, "error", err)
+        }
+    }
+    return result, nil
+}
+
+// fetchRows fetches the weather data for one location and converts it into rows. The
+// client is only used in incremental mode and may be nil otherwise.
+func fetchRows(ctx context.Context, client *bigquery.Client, opts *requestOptions) (*ingestResult, []*WeatherData, error) {
+    if len(opts.Models) > 0 {
+        return fetchModelRows(ctx, opts)
+    }
+    if len(opts.Ranges) > 0 {
+        return fetchRangeRows(ctx, opts)
+    }
+    result := &ingestResult{BatchID: uuid.NewString(), StartDate: opts.StartDate}
+
+    // In incremental mode, only fetch the days after the latest stored date.
+    if opts.Incremental {
+        start, err := resolveIncrementalStart(ctx, client, opts)
+        if err != nil {
+            return nil, nil, &requestError{http.StatusInternalServerError, "BigQuery error", fmt.Errorf("failed to resolve incremental start date: %w", err)}
+        }
+        result.StartDate = start
+        if start > opts.EndDate {
+            result.UpToDate = true
+            return result, nil, nil
+        }
+    }
+
+    // Fetch weather data from Open-Meteo.
+    meteoResp, err := fetchOpenMeteo(ctx, opts, result.StartDate)
+    if err != nil {
+        return nil, nil, err
+    }
+    result.RateLimit = meteoResp.RateLimit
+    result.Endpoint = meteoResp.Endpoint
+    result.SourceURLs = append(result.SourceURLs, meteoResp.SourceURL)
+    if len(meteoResp.Daily.Time.Dates) == 0 {
+        slog.Info("Open-Meteo returned an empty daily object", "latitude", opts.Latitude, "longitude", opts.Longitude)
+        return emptyResult(ctx, opts, result), nil, nil
+    }
+    if err := checkComplete(meteoResp.Daily, opts); err != nil {
+        return nil, nil, err
+    }
+
+    // Prepare data for BigQuery, filling a truncated tail if the response stopped short.
+    weatherData, coverage := fillTruncatedTail(ctx, opts, result.StartDate, convertDaily(meteoResp, opts, result.BatchID), result.BatchID)
+    result.Coverage = coverage
+    checksumFetched(result, weatherData, opts)
+    weatherData = finishRows(weatherData, opts)
+    if len(weatherData) == 0 {
+        return emptyResult(ctx, opts, result), nil, nil
+    }
+    return result, weatherData, nil
+}
+
+// emptyResult marks the result empty, adding the earliest date hint if it was requested.
+func emptyResult(ctx context.Context, opts *requestOptions, result *ingestResult) *ingestResult {
+    result.Empty = true
+    if opts.HintEarliest {
+        result.EarliestDate = earliestDateHint(ctx, opts)
+    }
+    return result
+}
+
+// convertDaily turns the daily arrays of an Open-Meteo response into BigQuery rows. The
+// values are as Open-Meteo reported them; finishRows converts snowfall to snowfall_unit.
+func convertDaily(meteoResp *OpenMeteoResponse, opts *requestOptions, batchID string) []*WeatherData {
+    // Rows from the same snapped grid point share a cell ID regardless of the requested coordinate.
+    gridCellID := geohash.EncodeWithPrecision(meteoResp.Latitude, meteoResp.Longitude, cfg.GeohashPrecision)
+    var source, sourceURLHash bigquery.NullString
+    if opts.Provenance {
+        source = bigquery.NullString{StringVal: "open-meteo-" + opts.Mode, Valid: true}
+        sum := sha256.Sum256([]byte(meteoResp.SourceURL))
+        sourceURLHash = bigquery.NullString{StringVal: hex.EncodeToString(sum[:]), Valid: true}
+    }
+    var loc *time.Location
+    if opts.DateAsTimestamp {
+        loc = responseLocation(meteoResp)
+    }
+    // Rows are carved out of one backing array rather than allocated one by one, which
+    // matters for 20-year ranges of over 7000 days.
+    n := len(meteoResp.Daily.Time.Dates)
+    entries := make([]WeatherData, n)
+    weatherData := make([]*WeatherData, 0, n)
+    for i := 0; i < n; i++ {
+        entry := &entries[i]
+        *entry = WeatherData{
+            Latitude:         meteoResp.Latitude,
+            Longitude:        meteoResp.Longitude,
+            Date:             meteoResp.Daily.Time.Dates[i],
+            MeanTemperature:  nullFloat64At(meteoResp.Daily.Temperature2mMean, i),
+            MinTemperature:   nullFloat64At(meteoResp.Daily.Temperature2mMin, i),
+            MaxTemperature:   nullFloat64At(meteoResp.Daily.Temperature2mMax, i),
+            RainSum:          nullFloat64At(meteoResp.Daily.RainSum, i),
+            SnowfallSum:      nullFloat64At(meteoResp.Daily.SnowfallSum, i),
+            InsertedAt:       now(),
+            BatchID:          batchID,
+            GridCellID:       gridCellID,
+            RowKey:           rowKey(meteoResp.Latitude, meteoResp.Longitude, meteoResp.Daily.Time.Dates[i], opts.Model),
+            Environment:      bigquery.NullString{StringVal: cfg.Environment, Valid: cfg.Environment != ""},
+            SourceModel:      bigquery.NullString{StringVal: opts.Model, Valid: opts.Model != ""},
+            FetchedAt:        meteoResp.FetchedAt,
+            GenerationTimeMs: bigquery.NullFloat64{Float64: meteoResp.GenerationTimeMs, Valid: true},
+            DatasetVersion:   bigquery.NullString{StringVal: opts.DatasetVersion, Valid: opts.DatasetVersion != ""},
+            ClientID:         bigquery.NullString{StringVal: opts.ClientID, Valid: opts.ClientID != ""},
+            Elevation:        bigquery.NullFloat64{Float64: meteoResp.Elevation, Valid: opts.IncludeElevation},
+            GridLatitude:     bigquery.NullFloat64{Float64: opts.Latitude, Valid: opts.Grid != nil},
+            GridLongitude:    bigquery.NullFloat64{Float64: opts.Longitude, Valid: opts.Grid != nil},
+            SnowfallUnit:     bigquery.NullString{StringVal: opts.SnowfallUnit, Valid: true},
+            Source:           source,
+            SourceURLHash:    sourceURLHash,
+        }
+        if loc != nil {
+            if midnight, err := time.ParseInLocation(dateLayout, entry.Date, loc); err == nil {
+                entry.DateTS = bigquery.NullTimestamp{Timestamp: midnight, Valid: true}
+            }
+        }
+        if opts.DateParts {
+            if date, err := time.Parse(dateLayout, entry.Date); err == nil {
+                entry.DayOfYear = bigquery.NullInt64{Int64: int64(date.YearDay()), Valid: true}
+                entry.Season = bigquery.NullString{StringVal: season(date.Month(), meteoResp.Latitude), Valid: true}
+            }
+        }
+        if i < len(meteoResp.Daily.Time.Unix) {
+            entry.DateUnix = bigquery.NullInt64{Int64: meteoResp.Daily.Time.Unix[i], Valid: true}
+        }
+        if hasVariable(opts.Variables, "weather_code") {
+            entry.WeatherCode = nullInt64At(meteoResp.Daily.WeatherCode, i)
+            if opts.WeatherDescription && entry.WeatherCode.Valid {
+                if desc, ok := describeWeatherCode(entry.WeatherCode.Int64); ok {
+                    entry.WeatherDescription = bigquery.NullString{StringVal: desc, Valid: true}
+                }
+            }
+        }
+        // Values outside their plausibility bounds are left to the out_of_bounds policy.
+        if hasVariable(opts.Variables, "surface_pressure_mean") {
+            entry.SurfacePressureMean = nullFloat64At(meteoResp.Daily.SurfacePressureMean, i)
+        }
+        if hasVariable(opts.Variables, "cloud_cover_mean") {
+            entry.CloudCoverMean = nullFloat64At(meteoResp.Daily.CloudCoverMean, i)
+        }
+        if hasVariable(opts.Variables, "et0_fao_evapotranspiration") {
+            entry.ET0 = nullFloat64At(meteoResp.Daily.ET0, i)
+        }
+        weatherData = append(weatherData, entry)
+    }
+    return weatherData
+}
+
+// season returns the meteorological season of a month at the latitude: winter is
+// December to February in the northern hemisphere and June to August in the southern one.
+// The equator is counted as northern.
+func season(month time.Month, latitude float64) string {
+    seasons := [4]string{"winter", "spring", "summer", "autumn"}
+    i := int(month) % 12 / 3
+    if latitude < 0 {
+        i = (i + 2) % 4
+    }
+    return seasons[i]
+}
+
+// rowKey returns a stable key for the (latitude, longitude, date) natural key: the first
+// 16 bytes of a SHA-256 over the canonical values, hex encoded. Rows from a specific model
+// also include it in the key, so per-model rows of the same day do not collide.
+func rowKey(latitude, longitude float64, date, model string) string {
+    key := strconv.FormatFloat(latitude, 'f', -1, 64) + "|" + strconv.FormatFloat(longitude, 'f', -1, 64) + "|" + date
+    if model != "" {
+        key += "|" + model
+    }
+    sum := sha256.Sum256([]byte(key))
+    return hex.EncodeToString(sum[:16])
+}
+
+// nullInt64At returns the i-th value of a nullable array, or NULL when absent.
+func nullInt64At(values []*int64, i int) bigquery.NullInt64 {
+    if i >= len(values) || values[i] == nil {
+        return bigquery.NullInt64{}
+    }
+    return bigquery.NullInt64{Int64: *values[i], Valid: true}
+}
+
+// nullFloat64At returns the i-th value of a nullable array, or NULL when absent.
+func nullFloat64At(values []*float64, i int) bigquery.NullFloat64 {
+    if i >= len(values) || values[i] == nil {
+        return bigquery.NullFloat64{}
+    }
+    return bigquery.NullFloat64{Float64: *values[i], Valid: true}
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+    "encoding/json"
+    "fmt"
+    "net/http"
+    "net/url"
+    "strconv"
+    "strings"
+    "time"
+)
+
+// maxJobBodyBytes caps the size of a POST job specification.
+const maxJobBodyBytes = 1 << 20
+
+// JobRequest is the JSON job specification accepted by POST requests. Query parameters
+// may still be used for settings not covered here; body fields take precedence.
+type JobRequest struct {
+    Latitude  *float64   `json:"latitude"`
+    Longitude *float64   `json:"longitude"`
+    Locations []Location `json:"locations"`
+    StartDate string     `json:"start_date"`
+    EndDate   string     `json:"end_date"`
+    PastDays  *int       `json:"past_days"`
+    Mode      string     `json:"mode"`
+    Daily     []string   `json:"daily"`
+    // Ranges requests several disjoint windows instead of start_date and end_date.
+    Ranges []dateRange `json:"ranges"`
+}
+
+// parseJobRequest decodes and validates a POST job specification, returning the options and,
+// for multi-location jobs, the locations to ingest.
+func parseJobRequest(r *http.Request) (*requestOptions, []Location, error) {
+    var job JobRequest
+    dec := json.NewDecoder(http.MaxBytesReader(nil, r.Body, maxJobBodyBytes))
+    dec.DisallowUnknownFields()
+    if err := dec.Decode(&job); err != nil {
+        return nil, nil, validationErrors{{Field: "body", Message: err.Error()}}
+    }
+    if errs := job.validate(); len(errs) > 0 {
+        return nil, nil, errs
+    }
+
+    q := withClientID(r, r.URL.Query())
+    if job.Latitude != nil {
+        q.Set("latitude", strconv.FormatFloat(*job.Latitude, 'f', -1, 64))
+        q.Set("longitude", strconv.FormatFloat(*job.Longitude, 'f', -1, 64))
+    }
+    setIfNotEmpty(q, "start_date", job.StartDate)
+    setIfNotEmpty(q, "end_date", job.EndDate)
+    if len(job.Ranges) > 0 {
+        start, end := rangeSpan(job.Ranges)
+        q.Set("start_date", start)
+        q.Set("end_date", end)
+    }
+    setIfNotEmpty(q, "mode", job.Mode)
+    setIfNotEmpty(q, "daily", strings.Join(job.Daily, ","))
+    if job.PastDays != nil {
+        q.Set("past_days", strconv.Itoa(*job.PastDays))
+    }
+
+    opts, err := parseQueryOptions(q, len(job.Locations) > 0)
+    if err != nil {
+        return nil, nil, err
+    }
+    for _, loc := range job.Locations {
+        if err := checkAllowedRegion(loc.Latitude, loc.Longitude); err != nil {
+            return nil, nil, err
+        }
+    }
+    if len(job.Ranges) > 0 {
+        if opts.Incremental || opts.SkipIfFresh || len(opts.Models) > 0 || opts.PartitionDecorator {
+            return nil, nil, validationErrors{{Field: "ranges", Message: "cannot be combined with incremental, skip_if_fresh, models, or partition_decorator"}}
+        }
+        opts.Ranges = job.Ranges
+    }
+    return withCaller(r, opts), job.Locations, nil
+}
+
+// validate checks the job against the field rules, collecting every violation.
+func (j *JobRequest) validate() validationErrors {
+    var errs validationErrors
+
+    switch {
+    case len(j.Locations) > 0 && (j.Latitude != nil || j.Longitude != nil):
+        errs.add("locations", "cannot be combined with latitude/longitude")
+    case len(j.Locations) > 0:
+        if len(j.Locations) > cfg.MaxCoordinates {
+            errs.add("locations", "at most %d locations are allowed", cfg.MaxCoordinates)
+        }
+        for i, loc := range j.Locations {
+            if err := validateCoordinates(loc.Latitude, loc.Longitude); err != nil {
+                errs.add(fmt.Sprintf("locations[%d]", i), "%v", err)
+            } else if err := checkNullIsland(loc.Latitude, loc.Longitude); err != nil {
+                errs.add(fmt.Sprintf("locations[%d]", i), "%v", err)
+            }
+        }
+    case j.Latitude == nil || j.Longitude == nil:
+        errs.add("latitude", "latitude and longitude are required unless locations is given")
+    default:
+        if err := validateCoordinates(*j.Latitude, *j.Longitude); err != nil {
+            errs.add("latitude", "%v", err)
+        }
+    }
+
+    if j.Mode != "" {
+        if _, ok := maxPastDays[j.Mode]; !ok {
+            errs.add("mode", "unsupported mode %q", j.Mode)
+        }
+    }
+    mode := j.Mode
+    if mode == "" {
+        mode = "archive"
+    }
+
+    var start, end time.Time
+    var err error
+    if j.StartDate != "" {
+        if start, err = time.Parse(dateLayout, j.StartDate); err != nil {
+            errs.add("start_date", "must be a YYYY-MM-DD date")
+        }
+    }
+    if j.EndDate != "" {
+        if end, err = time.Parse(dateLayout, j.EndDate); err != nil {
+            errs.add("end_date", "must be a YYYY-MM-DD date")
+        }
+    }
+    if !start.IsZero() && !end.IsZero() && start.After(end) {
+        errs.add("start_date", "must not be after end_date")
+    }
+    if j.PastDays != nil {
+        if limit, ok := maxPastDays[mode]; ok && (*j.PastDays < 1 || *j.PastDays > limit) {
+            errs.add("past_days", "must be between 1 and %d in %s mode", limit, mode)
+        }
+    }
+
+    if len(j.Ranges) > 0 {
+        if j.StartDate != "" || j.EndDate != "" || j.PastDays != nil {
+            errs.add("ranges", "cannot be combined with start_date, end_date, or past_days")
+        }
+        validateRanges(&errs, j.Ranges, mode)
+    }
+
+    for i, name := range j.Daily {
+        v, ok := lookupVariable(name, "daily")
+        switch {
+        case !ok:
+            errs.add(fmt.Sprintf("daily[%d]", i), "unsupported daily variable %q", name)
+        case !v.supportsMode(mode):
+            errs.add(fmt.Sprintf("daily[%d]", i), "daily variable %q is not available in %s mode", name, mode)
+        }
+    }
+    return errs
+}
+
+// setIfNotEmpty sets the query parameter when v is not empty.
+func setIfNotEmpty(q url.Values, key, v string) {
+    if v != "" {
+        q.Set(key, v)
+    }
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+    "errors"
+    "net/http"
+    "net/http/httptest"
+    "reflect"
+    "strings"
+    "testing"
+)
+
+// postJob builds a POST request with the JSON body and query string.
+func postJob(body, query string) *http.Request {
+    target := "/"
+    if query != "" {
+        target += "?" + query
+    }
+    return httptest.NewRequest(http.MethodPost, target, strings.NewReader(body))
+}
+
+func TestParseJobRequest(t *testing.T) {
+    tests := []struct {
+        name          string
+        body          string
+        query         string
+        wantStart     string
+        wantEnd       string
+        wantVariables int
+        wantLocations int
+    }{
+        {"single coordinate", `{"latitude":52.52,"longitude":13.41,"start_date":"2024-01-01","end_date":"2024-01-31"}`, "", "2024-01-01", "2024-01-31", 5, 0},
+        {"body overrides the query", `{"latitude":52.52,"longitude":13.41,"start_date":"2024-02-01","end_date":"2024-02-02"}`, "start_date=2023-01-01", "2024-02-01", "2024-02-02", 5, 0},
+        {"query fills the gaps", `{"latitude":52.52,"longitude":13.41,"daily":["weather_code"]}`, "start_date=2024-03-01&end_date=2024-03-02", "2024-03-01", "2024-03-02", 6, 0},
+        {"locations", `{"locations":[{"latitude":52.52,"longitude":13.41},{"latitude":48.85,"longitude":2.35,"id":"paris"}],"start_date":"2024-01-01","end_date":"2024-01-02"}`, "", "2024-01-01", "2024-01-02", 5, 2},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            opts, locations, err := parseJobRequest(postJob(tt.body, tt.query))
+            if err != nil {
+                t.Fatal(err)
+            }
+            if opts.StartDate != tt.wantStart || opts.EndDate != tt.wantEnd {
+                t.Errorf("range = %s..%s, want %s..%s", opts.StartDate, opts.EndDate, tt.wantStart, tt.wantEnd)
+            }
+            if len(opts.Variables) != tt.wantVariables {
+                t.Errorf("variables = %q, want %d", opts.Variables, tt.wantVariables)
+            }
+            if len(locations) != tt.wantLocations {
+                t.Errorf("got %d locations, want %d", len(locations), tt.wantLocations)
+            }
+        })
+    }
+}
+
+func TestParseJobRequestErrors(t *testing.T) {
+    tests := []struct {
+        name       string
+        body       string
+        wantFields []string
+    }{
+        {"malformed json", `{"latitude":`, []string{"body"}},
+        {"unknown field", `{"latitude":1,"longitude":2,"lat":3}`, []string{"body"}},
+        {"missing coordinates", `{"start_date":"2024-01-01"}`, []string{"latitude"}},
+        {"coordinates and locations", `{"latitude":1,"longitude":2,"locations":[{"latitude":3,"longitude":4}]}`, []string{"locations"}},
+        {"every violation is reported", `{"latitude":91,"longitude":2,"mode":"hourly","start_date":"2024-13-01","end_date":"yesterday","daily":["wind"]}`, []string{"latitude", "mode", "start_date", "end_date", "daily[0]"}},
+        {"inverted range", `{"latitude":1,"longitude":2,"start_date":"2024-02-01","end_date":"2024-01-01"}`, []string{"start_date"}},
+        {"past_days over the forecast limit", `{"latitude":1,"longitude":2,"mode":"forecast","past_days":93}`, []string{"past_days"}},
+        {"invalid location", `{"locations":[{"latitude":1,"longitude":2},{"latitude":1,"longitude":200}]}`, []string{"locations[1]"}},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            _, _, err := parseJobRequest(postJob(tt.body, ""))
+            var errs validationErrors
+            if !errors.As(err, &errs) {
+                t.Fatalf("err = %v, want validation errors", err)
+            }
+            var fields []string
+            for _, f := range errs {
+                fields = append(fields, f.Field)
+            }
+            if !reflect.DeepEqual(fields, tt.wantFields) {
+                t.Errorf("fields = %q, want %q", fields, tt.wantFields)
+            }
+        })
+    }
+}
+
+func TestParseJobRequestBodyLimit(t *testing.T) {
+    body := `{"latitude":1,"longitude":2,"daily":["` + strings.Repeat("x", maxJobBodyBytes) + `"]}`
+    if _, _, err := parseJobRequest(postJob(body, "")); err == nil {
+        t.Error("parseJobRequest() accepted a body over the limit")
+    }
+}
+
+func TestParseJobRequestPartitionDecorator(t *testing.T) {
+    tests := []struct {
+        name    string
+        body    string
+        wantErr bool
+    }{
+        {"single coordinate", `{"latitude":52.52,"longitude":13.41,"start_date":"2024-01-01","end_date":"2024-01-01"}`, false},
+        {"locations", `{"locations":[{"latitude":52.52,"longitude":13.41},{"latitude":48.85,"longitude":2.35}],"start_date":"2024-01-01","end_date":"2024-01-01"}`, true},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            _, _, err := parseJobRequest(postJob(tt.body, "partition_decorator=true"))
+            if (err != nil) != tt.wantErr {
+                t.Errorf("err = %v, wantErr %v", err, tt.wantErr)
+            }
+        })
+    }
+}
+
+func TestParseJobRequestClientID(t *testing.T) {
+    const body = `{"latitude":52.52,"longitude":13.41,"start_date":"2024-01-01","end_date":"2024-01-01"}`
+    tests := []struct {
+        name   string
+        query  string
+        header string
+        want   string
+    }{
+        {"parameter", "client_id=team-a", "", "team-a"},
+        {"header", "", "team-b", "team-b"},
+        {"not given", "", "", ""},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            req := postJob(body, tt.query)
+            if tt.header != "" {
+                req.Header.Set("X-Client-Id", tt.header)
+            }
+            opts, _, err := parseJobRequest(req)
+            if err != nil {
+                t.Fatal(err)
+            }
+            if opts.ClientID != tt.want {
+                t.Errorf("ClientID = %q, want %q", opts.ClientID, tt.want)
+            }
+        })
+    }
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+    "io"
+    "log/slog"
+    "strings"
+)
+
+// newLogger returns a JSON logger at the given level, using the field names Cloud Logging
+// recognizes for severity and message.
+func newLogger(w io.Writer, level slog.Level) *slog.Logger {
+    return slog.New(slog.NewJSONHandler(w, &slog.HandlerOptions{
+        Level: level,
+        ReplaceAttr: func(groups []string, a slog.Attr) slog.Attr {
+            if len(groups) > 0 {
+                return a
+            }
+            switch a.Key {
+            case slog.LevelKey:
+                a.Key = "severity"
+            case slog.MessageKey:
+                a.Key = "message"
+            }
+            return a
+        },
+    }))
+}
+
+// parseLogLevel maps debug, info, warn, or error to a slog level, defaulting to info.
+func parseLogLevel(s string) slog.Level {
+    switch strings.ToLower(s) {
+    case "debug":
+        return slog.LevelDebug
+    case "warn", "warning":
+        return slog.LevelWarn
+    case "error":
+        return slog.LevelError
+    }
+    return slog.LevelInfo
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+    "bytes"
+    "encoding/json"
+    "log/slog"
+    "strings"
+    "sync"
+    "testing"
+    "time"
+)
+
+// captureLogs sends the default logger's output at level and above to the returned buffer
+// for the rest of the test.
+func captureLogs(t *testing.T, level slog.Level) *logBuffer {
+    t.Helper()
+    buf := &logBuffer{}
+    saved := slog.Default()
+    slog.SetDefault(newLogger(buf, level))
+    t.Cleanup(func() { slog.SetDefault(saved) })
+    return buf
+}
+
+// logBuffer is a bytes.Buffer that is safe to write from the goroutines a handler leaves behind.
+type logBuffer struct {
+    mu  sync.Mutex
+    buf bytes.Buffer
+}
+
+func (b *logBuffer) Write(p []byte) (int, error) {
+    b.mu.Lock()
+    defer b.mu.Unlock()
+    return b.buf.Write(p)
+}
+
+func (b *logBuffer) String() string {
+    b.mu.Lock()
+    defer b.mu.Unlock()
+    return b.buf.String()
+}
+
+// waitFor waits up to a second for the logs to contain s, reporting whether they did.
+func (b *logBuffer) waitFor(s string) bool {
+    for deadline := time.Now().Add(time.Second); time.Now().Before(deadline); time.Sleep(time.Millisecond) {
+        if strings.Contains(b.String(), s) {
+            return true
+        }
+    }
+    return false
+}
+
+func TestParseLogLevel(t *testing.T) {
+    tests := map[string]slog.Level{
+        "":        slog.LevelInfo,
+        "info":    slog.LevelInfo,
+        "debug":   slog.LevelDebug,
+        "DEBUG":   slog.LevelDebug,
+        "warn":    slog.LevelWarn,
+        "warning": slog.LevelWarn,
+        "error":   slog.LevelError,
+        "verbose": slog.LevelInfo,
+    }
+    for s, want := range tests {
+        if got := parseLogLevel(s); got != want {
+            t.Errorf("parseLogLevel(%q) = %v, want %v", s, got, want)
+        }
+    }
+}
+
+func TestNewLogger(t *testing.T) {
+    tests := []struct {
+        name         string
+        level        slog.Level
+        log          func(*slog.Logger)
+        wantSeverity string
+        wantMessage  string
+    }{
+        {"info at info", slog.LevelInfo, func(l *slog.Logger) { l.Info("fetched", "rows", 3) }, "INFO", "fetched"},
+        {"debug is dropped at info", slog.LevelInfo, func(l *slog.Logger) { l.Debug("fetching") }, "", ""},
+        {"debug at debug", slog.LevelDebug, func(l *slog.Logger) { l.Debug("fetching") }, "DEBUG", "fetching"},
+        {"warn at error is dropped", slog.LevelError, func(l *slog.Logger) { l.Warn("retrying") }, "", ""},
+        {"error at warn", slog.LevelWarn, func(l *slog.Logger) { l.Error("failed") }, "ERROR", "failed"},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            var buf bytes.Buffer
+            tt.log(newLogger(&buf, tt.level))
+            if tt.wantMessage == "" {
+                if buf.Len() != 0 {
+                    t.Errorf("logged %q, want nothing", buf.String())
+                }
+                return
+            }
+            var entry map[string]interface{}
+            if err := json.Unmarshal(buf.Bytes(), &entry); err != nil {
+                t.Fatal(err)
+            }
+            if entry["severity"] != tt.wantSeverity || entry["message"] != tt.wantMessage {
+                t.Errorf("entry = %v, want severity %q and message %q", entry, tt.wantSeverity, tt.wantMessage)
+            }
+            if _, ok := entry[slog.LevelKey]; ok {
+                t.Errorf("entry kept the %q key: %v", slog.LevelKey, entry)
+            }
+        })
+    }
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+    "fmt"
+    "time"
+
+    "cloud.google.com/go/bigquery"
+)
+
+// LongWeather is one variable of one day in the long layout, for warehouses that prefer a
+// row per value over one column per variable.
+type LongWeather struct {
+    Latitude    float64              `bigquery:"latitude" json:"latitude"`
+    Longitude   float64              `bigquery:"longitude" json:"longitude"`
+    Date        string               `bigquery:"date" json:"date"`
+    Variable    string               `bigquery:"variable" json:"variable"`
+    Value       bigquery.NullFloat64 `bigquery:"value" json:"value"`
+    Unit        string               `bigquery:"unit" json:"unit"`
+    SourceModel bigquery.NullString  `bigquery:"source_model" json:"source_model"`
+    InsertedAt  time.Time            `bigquery:"inserted_at" json:"inserted_at"`
+    BatchID     string               `bigquery:"batch_id" json:"batch_id"`
+}
+
+// longRows expands each daily row into one row per requested variable, in the order the
+// variables were requested. A NULL value still gets its row, so every day lists every variable.
+func longRows(rows []*WeatherData, variables []string) []LongWeather {
+    out := make([]LongWeather, 0, len(rows)*len(variables))
+    for _, row := range rows {
+        for _, name := range variables {
+            long := LongWeather{
+                Latitude:    row.Latitude,
+                Longitude:   row.Longitude,
+                Date:        row.Date,
+                Variable:    name,
+                SourceModel: row.SourceModel,
+                InsertedAt:  row.InsertedAt,
+                BatchID:     row.BatchID,
+            }
+            if v, ok := lookupVariable(name, "daily"); ok {
+                long.Unit = v.Unit
+            }
+            if name == "snowfall_sum" && row.SnowfallUnit.Valid {
+                long.Unit = row.SnowfallUnit.StringVal
+            }
+            if name == "weather_code" {
+                if row.WeatherCode.Valid {
+                    long.Value = bigquery.NullFloat64{Float64: float64(row.WeatherCode.Int64), Valid: true}
+                }
+            } else if v := row.floatField(name); v != nil {
+                long.Value = *v
+            }
+            out = append(out, long)
+        }
+    }
+    return out
+}
+
+// longTableMetadata builds the schema, partitioning, and clustering for a new long table.
+func longTableMetadata() (*bigquery.TableMetadata, error) {
+    schema, err := bigquery.InferSchema(LongWeather{})
+    if err != nil {
+        return nil, fmt.Errorf("failed to infer schema: %w", err)
+    }
+    // The long table is partitioned like the daily one when that is partitioned by date,
+    // and clustered by variable since queries usually select a few variables.
+    meta := &bigquery.TableMetadata{Schema: dateSchema(schema)}
+    if cfg.PartitionField == "date" {
+        meta.TimePartitioning = &bigquery.TimePartitioning{Type: bigquery.TimePartitioningType(cfg.PartitionType), Field: "date"}
+    }
+    meta.Clustering = &bigquery.Clustering{Fields: []string{"variable"}}
+    return meta, nil
+}
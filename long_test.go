@@ -0,0 +1,149 @@
+package main
+
+import (
+    "fmt"
+    "net/http"
+    "net/http/httptest"
+    "reflect"
+    "strings"
+    "testing"
+    "time"
+
+    "cloud.google.com/go/bigquery"
+)
+
+func TestLongRows(t *testing.T) {
+    inserted := time.Date(2024, 1, 4, 0, 0, 0, 0, time.UTC)
+    base := LongWeather{Latitude: 52.5, Longitude: 13.4, InsertedAt: inserted, BatchID: "batch"}
+    long := func(date, variable string, value bigquery.NullFloat64, unit string) LongWeather {
+        l := base
+        l.Date, l.Variable, l.Value, l.Unit = date, variable, value, unit
+        return l
+    }
+    row := func(date string) *WeatherData {
+        return &WeatherData{Latitude: 52.5, Longitude: 13.4, Date: date, InsertedAt: inserted, BatchID: "batch"}
+    }
+    tests := []struct {
+        name      string
+        rows      []*WeatherData
+        variables []string
+        want      []LongWeather
+    }{
+        {
+            name: "two variables",
+            rows: func() []*WeatherData {
+                a, b := row("2024-01-01"), row("2024-01-02")
+                a.MaxTemperature, a.RainSum = nf(4), nf(0)
+                b.MaxTemperature, b.RainSum = nf(5), nf(0.4)
+                return []*WeatherData{a, b}
+            }(),
+            variables: []string{"temperature_2m_max", "rain_sum"},
+            want: []LongWeather{
+                long("2024-01-01", "temperature_2m_max", nf(4), "°C"),
+                long("2024-01-01", "rain_sum", nf(0), "mm"),
+                long("2024-01-02", "temperature_2m_max", nf(5), "°C"),
+                long("2024-01-02", "rain_sum", nf(0.4), "mm"),
+            },
+        },
+        {
+            name: "NULL values keep their rows",
+            rows: func() []*WeatherData {
+                a := row("2024-01-01")
+                a.MaxTemperature = nf(4)
+                return []*WeatherData{a}
+            }(),
+            variables: []string{"temperature_2m_max", "rain_sum"},
+            want: []LongWeather{
+                long("2024-01-01", "temperature_2m_max", nf(4), "°C"),
+                long("2024-01-01", "rain_sum", bigquery.NullFloat64{}, "mm"),
+            },
+        },
+        {
+            name: "weather code and snowfall unit",
+            rows: func() []*WeatherData {
+                a := row("2024-01-01")
+                a.WeatherCode = bigquery.NullInt64{Int64: 71, Valid: true}
+                a.SnowfallSum = nf(12)
+                a.SnowfallUnit = bigquery.NullString{StringVal: "mm", Valid: true}
+                return []*WeatherData{a}
+            }(),
+            variables: []string{"weather_code", "snowfall_sum"},
+            want: []LongWeather{
+                long("2024-01-01", "weather_code", nf(71), "wmo code"),
+                long("2024-01-01", "snowfall_sum", nf(12), "mm"),
+            },
+        },
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            if got := longRows(tt.rows, tt.variables); !reflect.DeepEqual(got, tt.want) {
+                t.Errorf("longRows() = %+v, want %+v", got, tt.want)
+            }
+        })
+    }
+}
+
+func TestLongLayoutInsert(t *testing.T) {
+    const body = `{"latitude":52.5,"longitude":13.4,"daily":{"time":["2024-01-01","2024-01-02"],"temperature_2m_max":[4,5],"rain_sum":[0,0.4]}}`
+    fake, _ := newFakeBigQuery(t)
+    withConfig(t, func(c *Config) { c.TableID, c.LongTableID = "daily_weather", "daily_weather_long" })
+    stubOpenMeteo(t, serveBody(body))
+    rec := httptest.NewRecorder()
+    fetchWeatherData(rec, httptest.NewRequest(http.MethodGet, "/?latitude=52.52&longitude=13.41&start_date=2024-01-01&end_date=2024-01-02&layout=long", nil))
+    if rec.Code != http.StatusOK {
+        t.Fatalf("status = %d: %s", rec.Code, rec.Body)
+    }
+    if n := len(fake.rows("daily_weather")); n != 0 {
+        t.Errorf("inserted %d wide rows, want none", n)
+    }
+    // The five core variables are always requested, so each day expands to five rows.
+    rows := fake.rows("daily_weather_long")
+    if len(rows) != 10 {
+        t.Fatalf("inserted %d long rows, want 10", len(rows))
+    }
+    var rain []string
+    for _, row := range rows {
+        if row["variable"] == "rain_sum" {
+            rain = append(rain, fmt.Sprint(row["date"], "=", row["value"]))
+        }
+    }
+    if strings.Join(rain, ",") != "2024-01-01=0,2024-01-02=0.4" {
+        t.Errorf("rain_sum rows %v", rain)
+    }
+    var clustered bool
+    if table := fake.table("daily_weather_long"); table != nil && table.Clustering != nil {
+        clustered = reflect.DeepEqual(table.Clustering.Fields, []string{"variable"})
+    }
+    if !clustered {
+        t.Error("long table is not clustered by variable")
+    }
+}
+
+func TestParseLayout(t *testing.T) {
+    tests := []struct {
+        query   string
+        want    string
+        wantErr bool
+    }{
+        {"", "wide", false},
+        {"&layout=long", "long", false},
+        {"&layout=narrow", "", true},
+        {"&layout=long&aggregate=monthly", "", true},
+        {"&layout=long&rolling=7", "", true},
+        {"&layout=long&incremental=true", "", true},
+    }
+    for _, tt := range tests {
+        t.Run(tt.query, func(t *testing.T) {
+            query := "latitude=52.52&longitude=13.41" + tt.query
+            if tt.wantErr {
+                if err := parseOptionsError(t, query); err == nil {
+                    t.Error("parseQueryOptions() succeeded, want an error")
+                }
+                return
+            }
+            if got := mustParseOptions(t, query).Layout; got != tt.want {
+                t.Errorf("Layout = %q, want %q", got, tt.want)
+            }
+        })
+    }
+}
@@ -2,11 +2,10 @@ package main
 
 import (
     "context"
-    "encoding/json"
     "fmt"
-    "io"
-    "log"
+    "log/slog"
     "net/http"
+    "os"
     "strconv"
     "time"
 
@@ -16,133 +15,297 @@ import (
 
 // OpenMeteoResponse defines the structure for the Open-Meteo API response.
 type OpenMeteoResponse struct {
-    Latitude  float64   `json:"latitude"`
-    Longitude float64   `json:"longitude"`
-    Daily     DailyData `json:"daily"`
+    Latitude         float64    `json:"latitude"`
+    Longitude        float64    `json:"longitude"`
+    UTCOffsetSeconds int64      `json:"utc_offset_seconds"`
+    Timezone         string     `json:"timezone"`
+    GenerationTimeMs float64    `json:"generationtime_ms"`
+    Elevation        float64    `json:"elevation"`
+    Daily            *DailyData `json:"daily"`
+
+    // RateLimit holds the rate-limit headers of the response; nil when absent.
+    RateLimit *rateLimit `json:"-"`
+    // Endpoint is the base URL that served the response, the primary or a mirror.
+    Endpoint string `json:"-"`
+    // SourceURL is the URL that served the response after any redirects, with the API key redacted.
+    SourceURL string `json:"-"`
+    // FetchedAt is when the response was received.
+    FetchedAt time.Time `json:"-"`
 }
 
 // DailyData defines the daily weather data arrays.
 type DailyData struct {
-    Time             []string  `json:"time"`
-    Temperature2mMin []float64 `json:"temperature_2m_min"`
-    Temperature2mMax []float64 `json:"temperature_2m_max"`
-    Temperature2mMean []float64 `json:"temperature_2m_mean"`
-    RainSum          []float64 `json:"rain_sum"`
-    SnowfallSum      []float64 `json:"snowfall_sum"`
+    Time                timeArray  `json:"time"`
+    Temperature2mMin    []*float64 `json:"temperature_2m_min"`
+    Temperature2mMax    []*float64 `json:"temperature_2m_max"`
+    Temperature2mMean   []*float64 `json:"temperature_2m_mean"`
+    RainSum             []*float64 `json:"rain_sum"`
+    SnowfallSum         []*float64 `json:"snowfall_sum"`
+    WeatherCode         []*int64   `json:"weather_code"`
+    SurfacePressureMean []*float64 `json:"surface_pressure_mean"`
+    CloudCoverMean      []*float64 `json:"cloud_cover_mean"`
+    ET0                 []*float64 `json:"et0_fao_evapotranspiration"`
 }
 
 // WeatherData represents the schema for BigQuery.
 type WeatherData struct {
-    Latitude        float64   `bigquery:"latitude"`
-    Longitude       float64   `bigquery:"longitude"`
-    Date            string    `bigquery:"date"`
-    MeanTemperature float64   `bigquery:"mean_temperature"`
-    MinTemperature  float64   `bigquery:"min_temperature"`
-    MaxTemperature  float64   `bigquery:"max_temperature"`
-    RainSum         float64   `bigquery:"rain_sum"`
-    SnowfallSum     float64   `bigquery:"snowfall_sum"`
-    InsertedAt      time.Time `bigquery:"inserted_at"`
+    Latitude        float64              `bigquery:"latitude" json:"latitude"`
+    Longitude       float64              `bigquery:"longitude" json:"longitude"`
+    Date            string               `bigquery:"date" json:"date"`
+    MeanTemperature bigquery.NullFloat64 `bigquery:"mean_temperature" json:"mean_temperature"`
+    MinTemperature  bigquery.NullFloat64 `bigquery:"min_temperature" json:"min_temperature"`
+    MaxTemperature  bigquery.NullFloat64 `bigquery:"max_temperature" json:"max_temperature"`
+    RainSum         bigquery.NullFloat64 `bigquery:"rain_sum" json:"rain_sum"`
+    SnowfallSum     bigquery.NullFloat64 `bigquery:"snowfall_sum" json:"snowfall_sum"`
+    InsertedAt      time.Time            `bigquery:"inserted_at" json:"inserted_at"`
+    BatchID         string               `bigquery:"batch_id" json:"batch_id"`
+    GridCellID      string               `bigquery:"grid_cell_id" json:"grid_cell_id"`
+    RowKey          string               `bigquery:"row_key" json:"row_key"`
+
+    // Environment tags the deployment that wrote the row; NULL when ENVIRONMENT is unset.
+    Environment bigquery.NullString `bigquery:"environment" json:"environment"`
+
+    WeatherCode        bigquery.NullInt64  `bigquery:"weather_code" json:"weather_code"`
+    WeatherDescription bigquery.NullString `bigquery:"weather_description" json:"weather_description"`
+
+    SurfacePressureMean bigquery.NullFloat64 `bigquery:"surface_pressure_mean" json:"surface_pressure_mean"`
+    CloudCoverMean      bigquery.NullFloat64 `bigquery:"cloud_cover_mean" json:"cloud_cover_mean"`
+
+    // SnowfallUnit is the unit of snowfall_sum: "cm" as Open-Meteo reports it, or "mm"
+    // when snowfall_unit=mm converted it to match rain_sum.
+    SnowfallUnit bigquery.NullString `bigquery:"snowfall_unit" json:"snowfall_unit"`
+
+    // ET0 is the FAO-56 reference evapotranspiration in mm.
+    ET0 bigquery.NullFloat64 `bigquery:"et0_fao_evapotranspiration" json:"et0_fao_evapotranspiration"`
+
+    // DateTS is local midnight of the date in the location's time zone; NULL unless
+    // date_as_timestamp=true.
+    DateTS bigquery.NullTimestamp `bigquery:"date_ts" json:"date_ts"`
+
+    // DateUnix is the start of the day as epoch seconds; NULL unless timeformat=unixtime.
+    DateUnix bigquery.NullInt64 `bigquery:"date_unix" json:"date_unix"`
+
+    // Open-Meteo cannot pin an archive version, so reproducibility is bounded instead: FetchedAt
+    // and GenerationTimeMs record when and how the upstream response was produced, and
+    // DatasetVersion is the caller's own label for the run; NULL when not given.
+    FetchedAt        time.Time            `bigquery:"fetched_at" json:"fetched_at"`
+    GenerationTimeMs bigquery.NullFloat64 `bigquery:"generationtime_ms" json:"generationtime_ms"`
+    DatasetVersion   bigquery.NullString  `bigquery:"dataset_version" json:"dataset_version"`
+
+    // Source names the Open-Meteo API the row came from and SourceURLHash identifies the
+    // exact upstream request; both NULL unless provenance=true.
+    Source        bigquery.NullString `bigquery:"source" json:"source"`
+    SourceURLHash bigquery.NullString `bigquery:"source_url_hash" json:"source_url_hash"`
+
+    // GridLatitude and GridLongitude are the requested grid point of a bounding box grid
+    // request, which Open-Meteo snaps to its own grid cell; NULL for other requests.
+    GridLatitude  bigquery.NullFloat64 `bigquery:"grid_latitude" json:"grid_latitude"`
+    GridLongitude bigquery.NullFloat64 `bigquery:"grid_longitude" json:"grid_longitude"`
+
+    // Elevation is the height in metres of the grid cell Open-Meteo used; NULL unless
+    // include_elevation=true.
+    Elevation bigquery.NullFloat64 `bigquery:"elevation" json:"elevation"`
+
+    // GDD is the day's growing degree days above gdd_base, and GDDCumulative their running
+    // total over the request when gdd_cumulative=true; NULL otherwise.
+    GDD           bigquery.NullFloat64 `bigquery:"gdd" json:"gdd"`
+    GDDCumulative bigquery.NullFloat64 `bigquery:"gdd_cumulative" json:"gdd_cumulative"`
+
+    // QualityScore is the fraction, 0 to 1, of the requested variables present and within
+    // their plausibility bounds; NULL unless quality_score=true.
+    QualityScore bigquery.NullFloat64 `bigquery:"quality_score" json:"quality_score"`
+
+    // Rolling holds the trailing N-day aggregates keyed by column name, such as
+    // mean_temperature_7d, when rolling was requested. The names depend on N, so they are
+    // inserted as extra columns rather than fields.
+    Rolling map[string]bigquery.NullFloat64 `bigquery:"-" json:"rolling,omitempty"`
+
+    // DayOfYear (1-366) and Season are derived from the date when date_parts=true; NULL otherwise.
+    DayOfYear bigquery.NullInt64  `bigquery:"day_of_year" json:"day_of_year"`
+    Season    bigquery.NullString `bigquery:"season" json:"season"`
+
+    // SourceModel is the Open-Meteo model the row came from; NULL unless models was requested.
+    SourceModel bigquery.NullString `bigquery:"source_model" json:"source_model"`
+
+    // ClientID is the client_id parameter or X-Client-Id header of the request; NULL when not given.
+    ClientID bigquery.NullString `bigquery:"client_id" json:"client_id"`
 }
 
 // init registers the HTTP function.
 func init() {
-    functions.HTTP("FetchWeatherData", fetchWeatherData)
+    slog.SetDefault(newLogger(os.Stderr, cfg.LogLevel))
+    if _, err := parseAllowedRegion(os.Getenv("ALLOWED_BBOX")); err != nil {
+        slog.Error("Invalid ALLOWED_BBOX; rejecting all coordinates", "error", err)
+    }
+    if _, err := parseRetryRules(os.Getenv("RETRY_RULES_JSON")); err != nil {
+        slog.Error("Ignoring invalid RETRY_RULES_JSON", "error", err)
+    } else if os.Getenv("RETRY_RULES_JSON") != "" {
+        slog.Info("Loaded retry rules", "upstream", cfg.RetryRules.Upstream, "bigquery", cfg.RetryRules.BigQuery)
+    }
+    if _, err := parsePlausibilityBounds(os.Getenv("PLAUSIBILITY_BOUNDS_JSON")); err != nil {
+        slog.Error("Ignoring invalid PLAUSIBILITY_BOUNDS_JSON", "error", err)
+    }
+    functions.HTTP("FetchWeatherData", newRouter().ServeHTTP)
+}
+
+// newRouter returns the handler serving every endpoint of the function.
+func newRouter() http.Handler {
+    mux := http.NewServeMux()
+    mux.HandleFunc("/healthz", healthz)
+    mux.HandleFunc("/metrics", serveMetrics)
+    mux.HandleFunc("/variables", listVariables)
+    mux.HandleFunc("/icons", serveIcons)
+    mux.HandleFunc("/query", queryWeather)
+    mux.HandleFunc("/normals", serveNormals)
+    mux.HandleFunc("/migrate", adminOnly(refuseWhenPaused(migrateSchema)))
+    mux.HandleFunc("/data", adminOnly(refuseWhenPaused(deleteWeather)))
+    mux.HandleFunc("/config", adminOnly(serveConfig))
+    mux.HandleFunc("/", fetchWeatherData)
+    return withCounterHeaders(limitInFlight(cfg.MaxInFlight, requireAuth(withGzip(cfg.GzipMinBytes, withRequestTimeout(cfg.RequestTimeout, recoverPanics(mux))))))
+}
+
+// requestDeadline is how long a request may run: the function timeout minus a margin
+// for writing the response.
+func requestDeadline() time.Duration {
+    return max(cfg.FunctionTimeout-deadlineMargin, time.Second)
+}
+
+// deadlineMargin is reserved at the end of the function timeout for responding.
+const deadlineMargin = 5 * time.Second
+
+// healthz reports that the function is up.
+func healthz(w http.ResponseWriter, r *http.Request) {
+    fmt.Fprint(w, "ok")
 }
 
 // fetchWeatherData handles the HTTP request, fetches weather data, and stores it in BigQuery.
 func fetchWeatherData(w http.ResponseWriter, r *http.Request) {
-    ctx := context.Background()
+    // Bound the request so retries give up cleanly before the platform timeout.
+    ctx, cancel := context.WithTimeout(r.Context(), requestDeadline())
+    defer cancel()
+    started := time.Now()
 
-    // Parse query parameters for latitude and longitude.
-    latStr := r.URL.Query().Get("latitude")
-    lonStr := r.URL.Query().Get("longitude")
-    if latStr == "" || lonStr == "" {
-        http.Error(w, "Missing latitude or longitude", http.StatusBadRequest)
+    // Parse and validate the query parameters, or the job specification for POST requests.
+    var opts *requestOptions
+    var locations []Location
+    var err error
+    if r.Method == http.MethodPost {
+        opts, locations, err = parseJobRequest(r)
+    } else {
+        opts, err = parseRequestOptions(r)
+    }
+    if err != nil {
+        writeBadRequest(w, err)
         return
     }
-    latitude, _ := strconv.ParseFloat(latStr, 64)
-    longitude, _ := strconv.ParseFloat(lonStr, 64)
-
-    // Define date range (last 20 years).
-    endDate := time.Now().Format("2006-01-02")
-    startDate := time.Now().AddDate(-20, 0, 0).Format("2006-01-02")
-
-    // Fetch weather data from Open-Meteo.
-    apiURL := fmt.Sprintf(
-        "https://archive-api.open-meteo.com/v1/archive?latitude=%f&longitude=%f&start_date=%s&end_date=%s&daily=temperature_2m_min,temperature_2m_max,temperature_2m_mean,rain_sum,snowfall_sum&timezone=auto",
-        latitude, longitude, startDate, endDate,
-    )
+    if opts.Grid != nil {
+        locations = opts.Grid.locations()
+    }
 
-    resp, err := http.Get(apiURL)
-    if err != nil {
-        log.Printf("Failed to make HTTP request: %v", err)
-        http.Error(w, "Failed to fetch data", http.StatusInternalServerError)
+    // While paused, only requests that return rows without storing them are served.
+    if cfg.IngestPaused && opts.Sink != "none" {
+        writePaused(w)
         return
     }
-    defer resp.Body.Close()
 
-    if resp.StatusCode != http.StatusOK {
-        body, _ := io.ReadAll(resp.Body)
-        log.Printf("Open-Meteo API returned status %d: %s", resp.StatusCode, string(body))
-        http.Error(w, "API error", http.StatusInternalServerError)
+    // Return the rows without touching BigQuery.
+    if opts.Sink == "none" {
+        returnRows(ctx, w, opts, len(locations) > 0)
         return
     }
 
-    body, err := io.ReadAll(resp.Body)
-    if err != nil {
-        log.Printf("Failed to read response body: %v", err)
-        http.Error(w, "Failed to read data", http.StatusInternalServerError)
+    if opts.ReturnRows && (len(locations) > 0 || opts.CoordsGCSURI != "") {
+        http.Error(w, "return_rows supports a single location", http.StatusBadRequest)
         return
     }
 
-    var meteoResp OpenMeteoResponse
-    if err := json.Unmarshal(body, &meteoResp); err != nil {
-        log.Printf("Failed to unmarshal JSON: %v", err)
-        http.Error(w, "Failed to parse data", http.StatusInternalServerError)
+    // Initialize BigQuery client.
+    client, err := newBigQueryClient(ctx)
+    if err != nil {
+        slog.Error("Failed to create BigQuery client", "error", err)
+        http.Error(w, "BigQuery error", http.StatusInternalServerError)
         return
     }
+    defer client.Close()
 
-    if len(meteoResp.Daily.Time) == 0 {
-        log.Printf("No data returned from API")
-        http.Error(w, "No data available", http.StatusNoContent)
+    // Ingest every location of a multi-location job.
+    if len(locations) > 0 {
+        writeMultiStatus(w, ingestLocations(ctx, client, opts, locations))
         return
     }
 
-    // Prepare data for BigQuery.
-    var weatherData []*WeatherData
-    for i := 0; i < len(meteoResp.Daily.Time); i++ {
-        entry := &WeatherData{
-            Latitude:        meteoResp.Latitude,
-            Longitude:       meteoResp.Longitude,
-            Date:            meteoResp.Daily.Time[i],
-            MeanTemperature: meteoResp.Daily.Temperature2mMean[i],
-            MinTemperature:  meteoResp.Daily.Temperature2mMin[i],
-            MaxTemperature:  meteoResp.Daily.Temperature2mMax[i],
-            RainSum:         meteoResp.Daily.RainSum[i],
-            SnowfallSum:     meteoResp.Daily.SnowfallSum[i],
-            InsertedAt:      time.Now(),
-        }
-        weatherData = append(weatherData, entry)
+    // Ingest every coordinate listed in a GCS file.
+    if opts.CoordsGCSURI != "" {
+        ingestFromGCS(ctx, w, client, opts)
+        return
     }
 
-    // Initialize BigQuery client.
-    client, err := bigquery.NewClient(ctx, "dataform-intro-469416")
+    result, err := ingest(ctx, client, opts, started)
     if err != nil {
-        log.Printf("Failed to create BigQuery client: %v", err)
-        http.Error(w, "BigQuery error", http.StatusInternalServerError)
+        writeError(w, err)
+        return
+    }
+    setUpstreamHeaders(w, result)
+    if result.Fresh {
+        fmt.Fprintf(w, "Data for %s is fresh, skipped", opts.EndDate)
+        return
+    }
+    if result.UpToDate {
+        fmt.Fprintf(w, "Already up to date through %s", opts.EndDate)
+        return
+    }
+    if result.Empty {
+        writeEmpty(w, result)
         return
     }
-    defer client.Close()
 
-    // Insert data into BigQuery.
-    datasetID := "weather_dataset"
-    tableID := "daily_weather"
-    inserter := client.Dataset(datasetID).Table(tableID).Inserter()
-    if err := inserter.Put(ctx, weatherData); err != nil {
-        log.Printf("Failed to insert data: %v", err)
-        http.Error(w, "Failed to store data", http.StatusInternalServerError)
+    // Return the stored rows instead of the summary, unless they exceed the response limit;
+    // the rows are already inserted, so that is reported in the summary rather than as a 413.
+    if opts.ReturnRows && (len(result.Data) <= cfg.MaxResponseRows || cfg.ResponseRowsPolicy == "truncate") {
+        w.Header().Set("X-Inserted-Rows", strconv.Itoa(result.Rows))
+        w.Header().Set("X-Batch-Id", result.BatchID)
+        if opts.Verify {
+            w.Header().Set("X-Verified", strconv.FormatBool(result.Verified))
+        }
+        if result.ChecksumStatus != "" {
+            w.Header().Set("X-Checksum-Status", result.ChecksumStatus)
+        }
+        writeRowsResponse(w, opts, result.Data)
         return
     }
 
-    fmt.Fprintf(w, "Successfully inserted %d rows into BigQuery", len(weatherData))
+    if opts.ReverifyOlderThan > 0 {
+        fmt.Fprintf(w, "Reverified %d days stored more than %s ago: %d changed, %d rows updated", result.Reverified, opts.ReverifyOlderThan, result.Revised, result.Rows)
+        return
+    }
+    if opts.Incremental {
+        fmt.Fprintf(w, "Successfully inserted %d rows into BigQuery starting %s", result.Rows, result.StartDate)
+    } else {
+        fmt.Fprintf(w, "Successfully inserted %d rows into BigQuery", result.Rows)
+    }
+    for _, r := range result.Ranges {
+        fmt.Fprintf(w, "; %s to %s: %d rows", r.Start, r.End, r.Rows)
+        if r.EarliestDate != "" {
+            fmt.Fprintf(w, " (earliest data %s)", r.EarliestDate)
+        }
+    }
+    if result.ChecksumStatus != "" {
+        fmt.Fprintf(w, "; checksum %s", result.ChecksumStatus)
+    }
+    if result.Coverage > 0 && result.Coverage < 1 {
+        fmt.Fprintf(w, "; covered %.1f%% of the requested days", result.Coverage*100)
+    }
+    if opts.Verify {
+        if result.Verified {
+            fmt.Fprint(w, "; verified")
+        } else {
+            fmt.Fprintf(w, "; verification found only %d of %d rows visible", result.VisibleRows, result.Rows)
+        }
+    }
+    if opts.ReturnRows {
+        fmt.Fprintf(w, "; rows not returned: %d exceed the response limit of %d", len(result.Data), cfg.MaxResponseRows)
+    }
+    if limit := result.RateLimit; limit != nil && limit.Remaining != "" {
+        fmt.Fprintf(w, "; upstream rate limit remaining %s", limit.Remaining)
+    }
 }
@@ -0,0 +1,88 @@
+package main
+
+import (
+    "context"
+    "encoding/json"
+    "fmt"
+    "log/slog"
+    "time"
+
+    "cloud.google.com/go/storage"
+)
+
+// manifestTimeout bounds writing a run manifest, which runs after the request context may
+// already have expired.
+const manifestTimeout = 30 * time.Second
+
+// runManifest records what one successful ingestion fetched and where it was stored, so
+// the lineage of a batch can be audited.
+type runManifest struct {
+    BatchID string `json:"batch_id"`
+    // Latitude and Longitude are the requested coordinate; GridLatitude and GridLongitude
+    // the grid point Open-Meteo snapped it to.
+    Latitude      float64   `json:"latitude"`
+    Longitude     float64   `json:"longitude"`
+    GridLatitude  float64   `json:"grid_latitude"`
+    GridLongitude float64   `json:"grid_longitude"`
+    StartDate     string    `json:"start_date"`
+    EndDate       string    `json:"end_date"`
+    Variables     []string  `json:"variables"`
+    Models        []string  `json:"models,omitempty"`
+    Mode          string    `json:"mode"`
+    Table         string    `json:"table"`
+    Rows          int       `json:"rows"`
+    Endpoint      string    `json:"endpoint"`
+    SourceURLs    []string  `json:"source_urls"`
+    StartedAt     time.Time `json:"started_at"`
+    CompletedAt   time.Time `json:"completed_at"`
+}
+
+// newRunManifest describes the ingestion of rows into tableID that began at started.
+func newRunManifest(opts *requestOptions, result *ingestResult, tableID string, rows []*WeatherData, started time.Time) *runManifest {
+    return &runManifest{
+        BatchID:       result.BatchID,
+        Latitude:      opts.Latitude,
+        Longitude:     opts.Longitude,
+        GridLatitude:  rows[0].Latitude,
+        GridLongitude: rows[0].Longitude,
+        StartDate:     result.StartDate,
+        EndDate:       opts.EndDate,
+        Variables:     opts.Variables,
+        Models:        opts.Models,
+        Mode:          opts.Mode,
+        Table:         cfg.DatasetID + "." + tableID,
+        Rows:          result.Rows,
+        Endpoint:      result.Endpoint,
+        SourceURLs:    result.SourceURLs,
+        StartedAt:     started,
+        CompletedAt:   now(),
+    }
+}
+
+// writeManifest stores m as <batch_id>.manifest.json under cfg.ManifestURI.
+func writeManifest(ctx context.Context, m *runManifest) error {
+    bucket, prefix, ok := splitGCSPrefix(cfg.ManifestURI)
+    if !ok {
+        return fmt.Errorf("invalid RUN_MANIFEST_GCS_URI %q", cfg.ManifestURI)
+    }
+    ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), manifestTimeout)
+    defer cancel()
+
+    body, err := json.MarshalIndent(m, "", "  ")
+    if err != nil {
+        return fmt.Errorf("failed to encode run manifest: %w", err)
+    }
+
+    gcs, err := storage.NewClient(ctx, clientOptions()...)
+    if err != nil {
+        return fmt.Errorf("failed to create storage client: %w", err)
+    }
+    defer gcs.Close()
+
+    name := prefix + m.BatchID + ".manifest.json"
+    if err := writeGCSObject(ctx, gcs, bucket, name, "application/json", body); err != nil {
+        return err
+    }
+    slog.Info("Wrote run manifest", "batch_id", m.BatchID, "uri", fmt.Sprintf("gs://%s/%s", bucket, name))
+    return nil
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+    "encoding/json"
+    "log/slog"
+    "net/http"
+    "net/http/httptest"
+    "reflect"
+    "strings"
+    "testing"
+    "time"
+)
+
+func TestRunManifest(t *testing.T) {
+    tests := []struct {
+        name         string
+        uri          string
+        uploadStatus int
+        wantPrefix   string
+    }{
+        {"written under the prefix", "gs://lineage/weather/", 0, "lineage/weather/"},
+        {"written at the bucket root", "gs://lineage", 0, "lineage/"},
+        {"manifests not configured", "", 0, ""},
+        {"invalid manifest URI", "lineage/weather", 0, ""},
+        {"manifest write fails", "gs://lineage/weather", http.StatusServiceUnavailable, ""},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            withConfig(t, func(c *Config) { c.ManifestURI = tt.uri })
+            logs := captureLogs(t, slog.LevelError)
+            fake, _ := newFakeBigQuery(t)
+            gcs := newFakeGCS(t, nil)
+            gcs.uploadStatus = tt.uploadStatus
+            stubOpenMeteo(t, serveBody(threeDays))
+
+            before := time.Now()
+            rec := httptest.NewRecorder()
+            fetchWeatherData(rec, httptest.NewRequest(http.MethodGet, "/?latitude=52.52&longitude=13.41&start_date=2024-01-01&end_date=2024-01-03&daily=weather_code", nil))
+            if rec.Code != http.StatusOK {
+                t.Fatalf("status = %d, want 200 whether or not the manifest was written: %s", rec.Code, rec.Body)
+            }
+            if tt.wantPrefix == "" {
+                if n := len(gcs.objects); n != 0 {
+                    t.Errorf("stored %d objects, want none", n)
+                }
+                if tt.uri != "" && !strings.Contains(logs.String(), "Failed to write run manifest") {
+                    t.Errorf("the failed manifest was not logged: %s", logs)
+                }
+                return
+            }
+
+            rows := fake.rows("daily_weather")
+            if len(rows) != 3 {
+                t.Fatalf("stored %d rows, want 3", len(rows))
+            }
+            batchID, _ := rows[0]["batch_id"].(string)
+            name := tt.wantPrefix + batchID + ".manifest.json"
+            raw, ok := gcs.object(name)
+            if !ok {
+                t.Fatalf("no manifest at %s among %v", name, gcs.objects)
+            }
+            if got := gcs.contentTypes[name]; got != "application/json" {
+                t.Errorf("manifest content type = %q, want application/json", got)
+            }
+            var m runManifest
+            if err := json.Unmarshal([]byte(raw), &m); err != nil {
+                t.Fatal(err)
+            }
+            if len(m.SourceURLs) != 1 || !strings.Contains(m.SourceURLs[0], "latitude=52.52") || m.Endpoint == "" {
+                t.Errorf("source URLs %q, endpoint %q; want the fetched URL", m.SourceURLs, m.Endpoint)
+            }
+            if m.StartedAt.Before(before.Add(-time.Second)) || m.CompletedAt.Before(m.StartedAt) || m.CompletedAt.After(time.Now()) {
+                t.Errorf("started %s, completed %s; want the run's timestamps", m.StartedAt, m.CompletedAt)
+            }
+            want := runManifest{
+                BatchID:       batchID,
+                Latitude:      52.52,
+                Longitude:     13.41,
+                GridLatitude:  52.5,
+                GridLongitude: 13.4,
+                StartDate:     "2024-01-01",
+                EndDate:       "2024-01-03",
+                Mode:          "archive",
+                Table:         cfg.DatasetID + ".daily_weather",
+                Rows:          3,
+            }
+            got := m
+            got.Variables, got.SourceURLs, got.Endpoint, got.StartedAt, got.CompletedAt = nil, nil, "", time.Time{}, time.Time{}
+            if batchID == "" || !reflect.DeepEqual(got, want) {
+                t.Errorf("manifest = %+v, want %+v", got, want)
+            }
+            if vars := strings.Join(m.Variables, ","); !strings.Contains(vars, "rain_sum") || !strings.Contains(vars, "weather_code") {
+                t.Errorf("variables = %s, want the core and requested variables", vars)
+            }
+        })
+    }
+}
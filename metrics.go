@@ -0,0 +1,73 @@
+package main
+
+import (
+    "fmt"
+    "math"
+    "net/http"
+    "strconv"
+    "sync/atomic"
+)
+
+// histogram is a minimal cumulative histogram for Prometheus text exposition.
+type histogram struct {
+    name    string
+    help    string
+    bounds  []float64
+    buckets []atomic.Uint64
+    count   atomic.Uint64
+    sumBits atomic.Uint64
+}
+
+// newHistogram returns a histogram with the given upper bucket bounds, in increasing order.
+func newHistogram(name, help string, bounds []float64) *histogram {
+    return &histogram{name: name, help: help, bounds: bounds, buckets: make([]atomic.Uint64, len(bounds))}
+}
+
+// observe records v. It is a no-op when metrics are disabled.
+func (h *histogram) observe(v float64) {
+    if !cfg.MetricsEnabled {
+        return
+    }
+    for i, bound := range h.bounds {
+        if v <= bound {
+            h.buckets[i].Add(1)
+        }
+    }
+    h.count.Add(1)
+    for {
+        old := h.sumBits.Load()
+        if h.sumBits.CompareAndSwap(old, math.Float64bits(math.Float64frombits(old)+v)) {
+            return
+        }
+    }
+}
+
+// write renders the histogram in the Prometheus text format.
+func (h *histogram) write(w http.ResponseWriter) {
+    fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s histogram\n", h.name, h.help, h.name)
+    for i, bound := range h.bounds {
+        fmt.Fprintf(w, "%s_bucket{le=\"%s\"} %d\n", h.name, strconv.FormatFloat(bound, 'g', -1, 64), h.buckets[i].Load())
+    }
+    count := h.count.Load()
+    fmt.Fprintf(w, "%s_bucket{le=\"+Inf\"} %d\n", h.name, count)
+    fmt.Fprintf(w, "%s_sum %s\n", h.name, strconv.FormatFloat(math.Float64frombits(h.sumBits.Load()), 'g', -1, 64))
+    fmt.Fprintf(w, "%s_count %d\n", h.name, count)
+}
+
+// rowsPerIngestion tracks how many rows each ingestion stored. The buckets cover single
+// days up to 20-year backfills.
+var rowsPerIngestion = newHistogram(
+    "weather_ingest_rows",
+    "Rows stored per ingestion.",
+    []float64{1, 30, 365, 3650, 7300, 14600},
+)
+
+// serveMetrics exposes the metrics in the Prometheus text format.
+func serveMetrics(w http.ResponseWriter, r *http.Request) {
+    if !cfg.MetricsEnabled {
+        http.NotFound(w, r)
+        return
+    }
+    w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+    rowsPerIngestion.write(w)
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+    "net/http"
+    "net/http/httptest"
+    "strings"
+    "testing"
+)
+
+func TestHistogram(t *testing.T) {
+    tests := []struct {
+        name    string
+        enabled bool
+        values  []float64
+        want    []string
+    }{
+        {
+            name:    "observations fill cumulative buckets",
+            enabled: true,
+            values:  []float64{1, 20, 400},
+            want: []string{
+                "# TYPE test_rows histogram",
+                `test_rows_bucket{le="1"} 1`,
+                `test_rows_bucket{le="30"} 2`,
+                `test_rows_bucket{le="365"} 2`,
+                `test_rows_bucket{le="+Inf"} 3`,
+                "test_rows_sum 421",
+                "test_rows_count 3",
+            },
+        },
+        {
+            name:    "disabled metrics record nothing",
+            enabled: false,
+            values:  []float64{1, 20},
+            want:    []string{`test_rows_bucket{le="1"} 0`, "test_rows_sum 0", "test_rows_count 0"},
+        },
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            withConfig(t, func(c *Config) { c.MetricsEnabled = tt.enabled })
+            h := newHistogram("test_rows", "Rows.", []float64{1, 30, 365})
+            for _, v := range tt.values {
+                h.observe(v)
+            }
+            rec := httptest.NewRecorder()
+            h.write(rec)
+            for _, line := range tt.want {
+                if !strings.Contains(rec.Body.String(), line+"\n") {
+                    t.Errorf("output lacks %q:\n%s", line, rec.Body)
+                }
+            }
+        })
+    }
+}
+
+func TestServeMetrics(t *testing.T) {
+    tests := []struct {
+        enabled bool
+        want    int
+    }{
+        {true, http.StatusOK},
+        {false, http.StatusNotFound},
+    }
+    for _, tt := range tests {
+        withConfig(t, func(c *Config) { c.MetricsEnabled = tt.enabled })
+        rec := httptest.NewRecorder()
+        serveMetrics(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+        if rec.Code != tt.want {
+            t.Errorf("enabled=%v: status = %d, want %d", tt.enabled, rec.Code, tt.want)
+        }
+        if tt.enabled && !strings.Contains(rec.Body.String(), "weather_ingest_rows_count") {
+            t.Errorf("metrics lack the rows histogram:\n%s", rec.Body)
+        }
+    }
+}
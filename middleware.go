@@ -0,0 +1,167 @@
+package main
+
+import (
+    "context"
+    "crypto/subtle"
+    "log/slog"
+    "net/http"
+    "runtime/debug"
+    "strconv"
+    "strings"
+    "sync"
+
+    "github.com/google/uuid"
+)
+
+// requireAuth rejects requests that do not carry the configured shared secret.
+// The check is skipped entirely when no secret is configured, and /healthz is always open.
+func requireAuth(next http.Handler) http.Handler {
+    return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        if cfg.AuthSecret == "" || r.URL.Path == "/healthz" {
+            next.ServeHTTP(w, r)
+            return
+        }
+        if !validToken(requestToken(r), cfg.AuthSecret) {
+            w.Header().Set("WWW-Authenticate", "Bearer")
+            http.Error(w, "Unauthorized", http.StatusUnauthorized)
+            return
+        }
+        next.ServeHTTP(w, r)
+    })
+}
+
+// requestToken extracts the caller's token from the Authorization or X-API-Key header.
+func requestToken(r *http.Request) string {
+    if auth := r.Header.Get("Authorization"); auth != "" {
+        if token, ok := strings.CutPrefix(auth, "Bearer "); ok {
+            return strings.TrimSpace(token)
+        }
+        return ""
+    }
+    return r.Header.Get("X-API-Key")
+}
+
+// validToken compares the token to the secret in constant time.
+func validToken(token, secret string) bool {
+    return token != "" && subtle.ConstantTimeCompare([]byte(token), []byte(secret)) == 1
+}
+
+// adminOnly refuses the wrapped endpoint when no shared secret is configured, since
+// requireAuth would otherwise leave it open to anyone who can reach the function.
+func adminOnly(next http.HandlerFunc) http.HandlerFunc {
+    return func(w http.ResponseWriter, r *http.Request) {
+        if cfg.AuthSecret == "" {
+            http.Error(w, "Admin endpoints require AUTH_SHARED_SECRET", http.StatusForbidden)
+            return
+        }
+        next(w, r)
+    }
+}
+
+// refuseWhenPaused refuses the wrapped write endpoint while INGEST_PAUSED is set.
+func refuseWhenPaused(next http.HandlerFunc) http.HandlerFunc {
+    return func(w http.ResponseWriter, r *http.Request) {
+        if cfg.IngestPaused {
+            writePaused(w)
+            return
+        }
+        next(w, r)
+    }
+}
+
+// writePaused answers a write request refused by the INGEST_PAUSED kill switch with a 503.
+func writePaused(w http.ResponseWriter) {
+    writeJSON(w, http.StatusServiceUnavailable, map[string]interface{}{
+        "error":   "paused",
+        "message": "Ingestion is paused; writes are refused until INGEST_PAUSED is cleared",
+    })
+}
+
+// limitInFlight sheds requests beyond max concurrent ones with a 503 and a Retry-After
+// header instead of letting them queue. A max of 0 disables the limit; /healthz is exempt.
+// The slot is released when the request returns, unless a handler kept it with
+// keepInFlightSlot to release it itself.
+func limitInFlight(max int, next http.Handler) http.Handler {
+    if max <= 0 {
+        return next
+    }
+    slots := make(chan struct{}, max)
+    return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        if r.URL.Path == "/healthz" {
+            next.ServeHTTP(w, r)
+            return
+        }
+        select {
+        case slots <- struct{}{}:
+            slot := &inFlightSlot{release: func() { <-slots }}
+            defer slot.releaseUnlessKept()
+            next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), inFlightSlotKey{}, slot)))
+        default:
+            w.Header().Set("Retry-After", strconv.Itoa(shedRetryAfterSeconds))
+            http.Error(w, "Too many requests in flight", http.StatusServiceUnavailable)
+        }
+    })
+}
+
+// shedRetryAfterSeconds is the Retry-After hint sent with shed requests.
+const shedRetryAfterSeconds = 1
+
+// inFlightSlotKey is the context key of the request's *inFlightSlot.
+type inFlightSlotKey struct{}
+
+// inFlightSlot is a request's place in the limitInFlight budget.
+type inFlightSlot struct {
+    mu      sync.Mutex
+    kept    bool
+    release func()
+}
+
+// releaseUnlessKept frees the slot when the request returns, unless it was kept.
+func (s *inFlightSlot) releaseUnlessKept() {
+    s.mu.Lock()
+    kept := s.kept
+    s.mu.Unlock()
+    if !kept {
+        s.release()
+    }
+}
+
+// keepInFlightSlot takes over the request's slot, if it holds one, so it stays taken after
+// the request returns, and returns the function that frees it. Work that outlives the
+// request, like a handler still running after a timeout, keeps counting against the limit.
+func keepInFlightSlot(ctx context.Context) func() {
+    slot, ok := ctx.Value(inFlightSlotKey{}).(*inFlightSlot)
+    if !ok {
+        return func() {}
+    }
+    slot.mu.Lock()
+    defer slot.mu.Unlock()
+    slot.kept = true
+    return slot.release
+}
+
+// recoverPanics turns a panic in the wrapped handler into a logged error with its stack and
+// a JSON 500 carrying an error ID to correlate the two. It has to run on the goroutine that
+// serves the request, so it sits inside withRequestTimeout. http.ErrAbortHandler is
+// re-raised, since net/http uses it to abort a response on purpose; withRequestTimeout
+// carries it back to the serving goroutine, where net/http recovers it.
+func recoverPanics(next http.Handler) http.Handler {
+    return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        defer func() {
+            p := recover()
+            if p == nil {
+                return
+            }
+            if p == http.ErrAbortHandler {
+                panic(p)
+            }
+            errorID := uuid.NewString()
+            slog.Error("Recovered from panic", "error_id", errorID, "path", r.URL.Path, "panic", p, "stack", string(debug.Stack()))
+            writeJSON(w, http.StatusInternalServerError, map[string]interface{}{
+                "error":    "internal error",
+                "error_id": errorID,
+            })
+        }()
+        next.ServeHTTP(w, r)
+    })
+}
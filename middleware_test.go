@@ -0,0 +1,301 @@
+package main
+
+import (
+    "context"
+    "encoding/json"
+    "fmt"
+    "log/slog"
+    "net/http"
+    "net/http/httptest"
+    "strings"
+    "testing"
+    "time"
+
+    "cloud.google.com/go/bigquery"
+)
+
+// okHandler answers every request with 200 "ok".
+var okHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+    w.Write([]byte("ok"))
+})
+
+func TestRequireAuth(t *testing.T) {
+    tests := []struct {
+        name    string
+        secret  string
+        path    string
+        headers map[string]string
+        want    int
+    }{
+        {"no secret configured", "", "/", nil, http.StatusOK},
+        {"missing token", "s3cret", "/", nil, http.StatusUnauthorized},
+        {"bearer token", "s3cret", "/", map[string]string{"Authorization": "Bearer s3cret"}, http.StatusOK},
+        {"api key header", "s3cret", "/", map[string]string{"X-API-Key": "s3cret"}, http.StatusOK},
+        {"wrong token", "s3cret", "/", map[string]string{"Authorization": "Bearer nope"}, http.StatusUnauthorized},
+        {"non-bearer scheme", "s3cret", "/", map[string]string{"Authorization": "Basic s3cret"}, http.StatusUnauthorized},
+        {"authorization takes precedence over api key", "s3cret", "/", map[string]string{"Authorization": "Bearer nope", "X-API-Key": "s3cret"}, http.StatusUnauthorized},
+        {"health check is open", "s3cret", "/healthz", nil, http.StatusOK},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            withConfig(t, func(c *Config) { c.AuthSecret = tt.secret })
+            req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+            for k, v := range tt.headers {
+                req.Header.Set(k, v)
+            }
+            rec := httptest.NewRecorder()
+            requireAuth(okHandler).ServeHTTP(rec, req)
+            if rec.Code != tt.want {
+                t.Errorf("status = %d, want %d", rec.Code, tt.want)
+            }
+            if rec.Code == http.StatusUnauthorized && rec.Header().Get("WWW-Authenticate") != "Bearer" {
+                t.Errorf("WWW-Authenticate = %q, want Bearer", rec.Header().Get("WWW-Authenticate"))
+            }
+        })
+    }
+}
+
+func TestLimitInFlight(t *testing.T) {
+    tests := []struct {
+        name     string
+        max      int
+        busy     int
+        path     string
+        want     int
+        wantHint bool
+    }{
+        {"under the limit", 2, 1, "/", http.StatusOK, false},
+        {"at the limit", 2, 2, "/", http.StatusServiceUnavailable, true},
+        {"health check is exempt", 1, 1, "/healthz", http.StatusOK, false},
+        {"disabled", 0, 5, "/", http.StatusOK, false},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            release := make(chan struct{})
+            started := make(chan struct{})
+            handler := limitInFlight(tt.max, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+                if r.Header.Get("X-Hold") != "" {
+                    started <- struct{}{}
+                    <-release
+                }
+                w.Write([]byte("ok"))
+            }))
+            done := make(chan struct{})
+            for i := 0; i < tt.busy; i++ {
+                go func() {
+                    req := httptest.NewRequest(http.MethodGet, "/", nil)
+                    req.Header.Set("X-Hold", "1")
+                    handler.ServeHTTP(httptest.NewRecorder(), req)
+                    done <- struct{}{}
+                }()
+                <-started
+            }
+
+            rec := httptest.NewRecorder()
+            handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, tt.path, nil))
+            close(release)
+            for i := 0; i < tt.busy; i++ {
+                <-done
+            }
+            if rec.Code != tt.want {
+                t.Errorf("status = %d, want %d", rec.Code, tt.want)
+            }
+            if got := rec.Header().Get("Retry-After") != ""; got != tt.wantHint {
+                t.Errorf("Retry-After set = %v, want %v", got, tt.wantHint)
+            }
+        })
+    }
+}
+
+func TestLimitInFlightReleasesSlots(t *testing.T) {
+    handler := limitInFlight(1, okHandler)
+    for i := 0; i < 3; i++ {
+        rec := httptest.NewRecorder()
+        handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+        if rec.Code != http.StatusOK {
+            t.Fatalf("request %d: status = %d, want 200", i+1, rec.Code)
+        }
+    }
+}
+
+func TestRecoverPanics(t *testing.T) {
+    tests := []struct {
+        name       string
+        handler    http.HandlerFunc
+        wantStatus int
+        wantLog    string
+    }{
+        {"no panic", okHandler, http.StatusOK, ""},
+        {"panic with a value", func(w http.ResponseWriter, r *http.Request) { panic("boom") }, http.StatusInternalServerError, "boom"},
+        {"runtime error", func(w http.ResponseWriter, r *http.Request) {
+            var values []float64
+            _ = values[len(r.URL.Path)]
+        }, http.StatusInternalServerError, "index out of range"},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            logs := captureLogs(t, slog.LevelError)
+            rec := httptest.NewRecorder()
+            recoverPanics(tt.handler).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/fetch", nil))
+            if rec.Code != tt.wantStatus {
+                t.Fatalf("status = %d, want %d", rec.Code, tt.wantStatus)
+            }
+            if tt.wantStatus == http.StatusOK {
+                if logs.String() != "" {
+                    t.Errorf("logged %s", logs)
+                }
+                return
+            }
+            var body map[string]string
+            if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+                t.Fatalf("body %q is not JSON: %v", rec.Body, err)
+            }
+            if body["error"] != "internal error" || body["error_id"] == "" {
+                t.Errorf("body = %v, want an internal error with an ID", body)
+            }
+            var entry map[string]interface{}
+            if err := json.Unmarshal([]byte(logs.String()), &entry); err != nil {
+                t.Fatalf("log %q is not one JSON entry: %v", logs, err)
+            }
+            if entry["error_id"] != body["error_id"] || entry["path"] != "/fetch" {
+                t.Errorf("log entry %v does not match the response %v", entry, body)
+            }
+            if !strings.Contains(fmt.Sprint(entry["panic"]), tt.wantLog) || !strings.Contains(fmt.Sprint(entry["stack"]), "recoverPanics") {
+                t.Errorf("log entry lacks the panic %q or the stack: %v", tt.wantLog, entry)
+            }
+        })
+    }
+}
+
+func TestRecoverPanicsReraisesAbort(t *testing.T) {
+    defer func() {
+        if p := recover(); p != http.ErrAbortHandler {
+            t.Errorf("recovered %v, want http.ErrAbortHandler re-raised", p)
+        }
+    }()
+    recoverPanics(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        panic(http.ErrAbortHandler)
+    })).ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+}
+
+func TestRecoverPanicsUnderRequestTimeout(t *testing.T) {
+    tests := []struct {
+        name       string
+        panicValue interface{}
+        afterTime  bool
+        wantPanic  interface{}
+        wantStatus int
+        wantLogged string
+    }{
+        {"abort reaches the serving goroutine", http.ErrAbortHandler, false, http.ErrAbortHandler, 0, ""},
+        {"panic becomes a 500", "boom", false, nil, http.StatusInternalServerError, "Recovered from panic"},
+        {"abort after the timeout is dropped", http.ErrAbortHandler, true, nil, http.StatusRequestTimeout, ""},
+        {"panic after the timeout is logged", "boom", true, nil, http.StatusRequestTimeout, "Discarded response of timed-out request"},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            logs := captureLogs(t, slog.LevelWarn)
+            finished := make(chan struct{})
+            handler := withRequestTimeout(20*time.Millisecond, recoverPanics(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+                defer close(finished)
+                if tt.afterTime {
+                    <-r.Context().Done()
+                }
+                panic(tt.panicValue)
+            })))
+            rec := httptest.NewRecorder()
+            func() {
+                defer func() {
+                    if p := recover(); p != tt.wantPanic {
+                        t.Errorf("serving goroutine recovered %v, want %v", p, tt.wantPanic)
+                    }
+                }()
+                handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+            }()
+            <-finished
+            if tt.wantPanic != nil {
+                return
+            }
+            if rec.Code != tt.wantStatus {
+                t.Errorf("status = %d, want %d: %s", rec.Code, tt.wantStatus, rec.Body)
+            }
+            if tt.wantLogged != "" && !logs.waitFor(tt.wantLogged) {
+                t.Errorf("logs lack %q: %s", tt.wantLogged, logs)
+            }
+        })
+    }
+}
+
+func TestRouterRecoversPanics(t *testing.T) {
+    saved := newBigQueryClient
+    newBigQueryClient = func(ctx context.Context) (*bigquery.Client, error) { panic("client factory failed") }
+    t.Cleanup(func() { newBigQueryClient = saved })
+    captureLogs(t, slog.LevelError)
+    stubOpenMeteo(t, serveBody(threeDays))
+
+    rec := httptest.NewRecorder()
+    newRouter().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/?latitude=52.52&longitude=13.41&start_date=2024-01-01&end_date=2024-01-03", nil))
+    if rec.Code != http.StatusInternalServerError || !strings.Contains(rec.Body.String(), `"error_id"`) {
+        t.Errorf("status = %d, body %q; want the recovered JSON 500", rec.Code, rec.Body)
+    }
+    if got := rec.Header().Get("Content-Type"); got != "application/json" {
+        t.Errorf("Content-Type = %q, want application/json", got)
+    }
+}
+
+func TestIngestPaused(t *testing.T) {
+    ingest := "/?latitude=52.52&longitude=13.41&start_date=2024-01-01&end_date=2024-01-03"
+    tests := []struct {
+        name        string
+        paused      bool
+        method      string
+        path        string
+        want        int
+        wantFetches int
+    }{
+        {"ingest", false, http.MethodGet, ingest, http.StatusOK, 1},
+        {"ingest while paused", true, http.MethodGet, ingest, http.StatusServiceUnavailable, 0},
+        {"rows returned without storing", true, http.MethodGet, ingest + "&sink=none", http.StatusOK, 1},
+        {"query", true, http.MethodGet, "/query?latitude=52.52&longitude=13.41", http.StatusOK, 0},
+        {"health check", true, http.MethodGet, "/healthz", http.StatusOK, 0},
+        {"migrate", true, http.MethodPost, "/migrate", http.StatusServiceUnavailable, 0},
+        {"delete", true, http.MethodDelete, "/data?latitude=52.52&longitude=13.41", http.StatusServiceUnavailable, 0},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            withConfig(t, func(c *Config) {
+                c.IngestPaused = tt.paused
+                c.AuthSecret = "s3cret"
+            })
+            fake, _ := newFakeBigQuery(t)
+            fetches := 0
+            stubOpenMeteo(t, func(w http.ResponseWriter, r *http.Request) {
+                fetches++
+                serveBody(threeDays)(w, r)
+            })
+
+            req := httptest.NewRequest(tt.method, tt.path, nil)
+            req.Header.Set("Authorization", "Bearer s3cret")
+            rec := httptest.NewRecorder()
+            newRouter().ServeHTTP(rec, req)
+            if rec.Code != tt.want {
+                t.Fatalf("status = %d, want %d: %s", rec.Code, tt.want, rec.Body)
+            }
+            if fetches != tt.wantFetches {
+                t.Errorf("fetched %d times, want %d", fetches, tt.wantFetches)
+            }
+            if tt.want != http.StatusServiceUnavailable {
+                return
+            }
+            var body struct {
+                Error string `json:"error"`
+            }
+            if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil || body.Error != "paused" {
+                t.Errorf("body %q, want a paused error", rec.Body)
+            }
+            if n := len(fake.received()); n != 0 || len(fake.rows("daily_weather")) != 0 {
+                t.Errorf("ran %d queries or stored rows while paused", n)
+            }
+        })
+    }
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+    "log/slog"
+    "net/http"
+
+    "cloud.google.com/go/bigquery"
+)
+
+// migrateSchema adds any columns of the WeatherData-derived schema that are missing from the
+// target table, and relaxes REQUIRED columns that the schema now allows to be NULL. It is
+// idempotent: a table that is already up to date is left unchanged.
+func migrateSchema(w http.ResponseWriter, r *http.Request) {
+    if r.Method != http.MethodPost {
+        http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+        return
+    }
+    ctx := r.Context()
+
+    client, err := newBigQueryClient(ctx)
+    if err != nil {
+        slog.Error("Failed to create BigQuery client", "error", err)
+        http.Error(w, "BigQuery error", http.StatusInternalServerError)
+        return
+    }
+    defer client.Close()
+
+    table := client.Dataset(cfg.DatasetID).Table(cfg.TableID)
+    meta, err := table.Metadata(ctx)
+    if err != nil {
+        if isHTTPStatus(err, http.StatusNotFound) {
+            http.Error(w, "Table not found", http.StatusNotFound)
+            return
+        }
+        slog.Error("Failed to read table metadata", "error", err)
+        http.Error(w, "BigQuery error", http.StatusInternalServerError)
+        return
+    }
+    want, err := newTableMetadata()
+    if err != nil {
+        slog.Error("Failed to build table schema", "error", err)
+        http.Error(w, "Internal error", http.StatusInternalServerError)
+        return
+    }
+
+    schema, added := missingColumns(meta.Schema, want.Schema)
+    relaxed := relaxColumns(schema, want.Schema)
+    if len(added) > 0 || len(relaxed) > 0 {
+        if _, err := table.Update(ctx, bigquery.TableMetadataToUpdate{Schema: schema}, meta.ETag); err != nil {
+            slog.Error("Failed to update table schema", "error", err)
+            http.Error(w, "Failed to update schema", http.StatusInternalServerError)
+            return
+        }
+        slog.Info("Migrated table schema", "table", cfg.TableID, "added", added, "relaxed", relaxed)
+    }
+    writeJSON(w, http.StatusOK, map[string]interface{}{"added": added, "relaxed": relaxed})
+}
+
+// missingColumns appends the fields of want that are not in have, returning the new schema
+// and the names of the added columns. Added columns are always NULLABLE, as BigQuery requires.
+func missingColumns(have, want bigquery.Schema) (bigquery.Schema, []string) {
+    existing := make(map[string]bool, len(have))
+    for _, f := range have {
+        existing[f.Name] = true
+    }
+
+    schema := append(bigquery.Schema{}, have...)
+    added := []string{}
+    for _, f := range want {
+        if existing[f.Name] {
+            continue
+        }
+        field := *f
+        field.Required = false
+        schema = append(schema, &field)
+        added = append(added, f.Name)
+    }
+    return schema, added
+}
+
+// relaxColumns marks the REQUIRED fields of schema that are NULLABLE in want as NULLABLE,
+// returning their names. Tables created before the weather values became nullable have
+// them as REQUIRED, which would reject rows with missing values.
+func relaxColumns(schema, want bigquery.Schema) []string {
+    nullable := make(map[string]bool, len(want))
+    for _, f := range want {
+        nullable[f.Name] = !f.Required
+    }
+
+    relaxed := []string{}
+    for i, f := range schema {
+        if f.Required && nullable[f.Name] {
+            field := *f
+            field.Required = false
+            schema[i] = &field
+            relaxed = append(relaxed, f.Name)
+        }
+    }
+    return relaxed
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+    "encoding/json"
+    "net/http"
+    "net/http/httptest"
+    "reflect"
+    "testing"
+
+    "cloud.google.com/go/bigquery"
+)
+
+// legacyWeatherRow is the shape of tables created before the weather values became nullable.
+type legacyWeatherRow struct {
+    Latitude        float64 `bigquery:"latitude"`
+    Longitude       float64 `bigquery:"longitude"`
+    Date            string  `bigquery:"date"`
+    MeanTemperature float64 `bigquery:"mean_temperature"`
+}
+
+func TestMissingColumns(t *testing.T) {
+    have := bigquery.Schema{{Name: "latitude", Type: bigquery.FloatFieldType, Required: true}}
+    want := bigquery.Schema{
+        {Name: "latitude", Type: bigquery.FloatFieldType, Required: true},
+        {Name: "batch_id", Type: bigquery.StringFieldType, Required: true},
+        {Name: "rain_sum", Type: bigquery.FloatFieldType},
+    }
+    schema, added := missingColumns(have, want)
+    if !reflect.DeepEqual(added, []string{"batch_id", "rain_sum"}) {
+        t.Errorf("added = %q, want batch_id and rain_sum", added)
+    }
+    if len(schema) != 3 || schema[1].Required || schema[2].Required {
+        t.Errorf("schema = %+v, want two NULLABLE columns appended", schema)
+    }
+    if !want[1].Required {
+        t.Error("missingColumns modified the wanted schema")
+    }
+    if _, added := missingColumns(want, want); len(added) != 0 {
+        t.Errorf("up-to-date schema added %q", added)
+    }
+}
+
+func TestRelaxColumns(t *testing.T) {
+    tests := []struct {
+        name string
+        have bigquery.Schema
+        want []string
+    }{
+        {"required value becomes nullable", bigquery.Schema{{Name: "rain_sum", Required: true}, {Name: "latitude", Required: true}}, []string{"rain_sum"}},
+        {"already nullable", bigquery.Schema{{Name: "rain_sum"}}, []string{}},
+        {"unknown column is kept", bigquery.Schema{{Name: "legacy", Required: true}}, []string{}},
+    }
+    target := bigquery.Schema{{Name: "rain_sum"}, {Name: "latitude", Required: true}}
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            if got := relaxColumns(tt.have, target); !reflect.DeepEqual(got, tt.want) {
+                t.Errorf("relaxColumns() = %q, want %q", got, tt.want)
+            }
+            for _, f := range tt.have {
+                if f.Name == "rain_sum" && f.Required {
+                    t.Error("rain_sum is still REQUIRED")
+                }
+            }
+        })
+    }
+}
+
+func TestMigrateSchema(t *testing.T) {
+    tests := []struct {
+        name        string
+        table       interface{}
+        method      string
+        wantStatus  int
+        wantAdded   bool
+        wantRelaxed []string
+    }{
+        {"legacy table", legacyWeatherRow{}, http.MethodPost, http.StatusOK, true, []string{"mean_temperature"}},
+        {"up to date", WeatherData{}, http.MethodPost, http.StatusOK, false, []string{}},
+        {"missing table", nil, http.MethodPost, http.StatusNotFound, false, nil},
+        {"wrong method", WeatherData{}, http.MethodGet, http.StatusMethodNotAllowed, false, nil},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            fake, _ := newFakeBigQuery(t)
+            if tt.table != nil {
+                fake.addTable(t, cfg.TableID, tt.table)
+            }
+            rec := httptest.NewRecorder()
+            migrateSchema(rec, httptest.NewRequest(tt.method, "/migrate", nil))
+            if rec.Code != tt.wantStatus {
+                t.Fatalf("status = %d, want %d: %s", rec.Code, tt.wantStatus, rec.Body)
+            }
+            if tt.wantStatus != http.StatusOK {
+                return
+            }
+            var body struct {
+                Added   []string `json:"added"`
+                Relaxed []string `json:"relaxed"`
+            }
+            if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+                t.Fatal(err)
+            }
+            if (len(body.Added) > 0) != tt.wantAdded || !reflect.DeepEqual(body.Relaxed, tt.wantRelaxed) {
+                t.Errorf("added %q, relaxed %q", body.Added, body.Relaxed)
+            }
+            // Running the migration again is a no-op.
+            rec = httptest.NewRecorder()
+            migrateSchema(rec, httptest.NewRequest(http.MethodPost, "/migrate", nil))
+            if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+                t.Fatal(err)
+            }
+            if len(body.Added) != 0 || len(body.Relaxed) != 0 {
+                t.Errorf("second run added %q, relaxed %q", body.Added, body.Relaxed)
+            }
+        })
+    }
+}
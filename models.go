@@ -0,0 +1,170 @@
+package main
+
+import (
+    "context"
+    "fmt"
+    "log/slog"
+    "regexp"
+    "sort"
+
+    "cloud.google.com/go/bigquery"
+    "github.com/google/uuid"
+)
+
+// maxModels caps how many models one request may compare.
+const maxModels = 5
+
+// modelNamePattern restricts model names to what Open-Meteo uses, which also keeps the
+// model-suffixed column names valid BigQuery identifiers.
+var modelNamePattern = regexp.MustCompile(`^[a-z0-9_]+$`)
+
+// modelColumn maps a daily variable to its WeatherData column for the merged layout.
+type modelColumn struct {
+    Column   string
+    Variable string
+    Type     bigquery.FieldType
+    value    func(*WeatherData) bigquery.Value
+}
+
+// modelColumns lists the values that are suffixed with the model name in the merged layout.
+var modelColumns = []modelColumn{
+    {"mean_temperature", "temperature_2m_mean", bigquery.FloatFieldType, func(r *WeatherData) bigquery.Value { return r.MeanTemperature }},
+    {"min_temperature", "temperature_2m_min", bigquery.FloatFieldType, func(r *WeatherData) bigquery.Value { return r.MinTemperature }},
+    {"max_temperature", "temperature_2m_max", bigquery.FloatFieldType, func(r *WeatherData) bigquery.Value { return r.MaxTemperature }},
+    {"rain_sum", "rain_sum", bigquery.FloatFieldType, func(r *WeatherData) bigquery.Value { return r.RainSum }},
+    {"snowfall_sum", "snowfall_sum", bigquery.FloatFieldType, func(r *WeatherData) bigquery.Value { return r.SnowfallSum }},
+    {"weather_code", "weather_code", bigquery.IntegerFieldType, func(r *WeatherData) bigquery.Value { return r.WeatherCode }},
+    {"surface_pressure_mean", "surface_pressure_mean", bigquery.FloatFieldType, func(r *WeatherData) bigquery.Value { return r.SurfacePressureMean }},
+    {"cloud_cover_mean", "cloud_cover_mean", bigquery.FloatFieldType, func(r *WeatherData) bigquery.Value { return r.CloudCoverMean }},
+    {"et0_fao_evapotranspiration", "et0_fao_evapotranspiration", bigquery.FloatFieldType, func(r *WeatherData) bigquery.Value { return r.ET0 }},
+}
+
+// parseModels validates the comma-separated models parameter.
+func parseModels(param string) ([]string, error) {
+    models := splitList(param)
+    if len(models) > maxModels {
+        return nil, fmt.Errorf("at most %d models can be compared", maxModels)
+    }
+    seen := make(map[string]bool, len(models))
+    for _, m := range models {
+        if !modelNamePattern.MatchString(m) {
+            return nil, fmt.Errorf("invalid model %q", m)
+        }
+        if seen[m] {
+            return nil, fmt.Errorf("duplicate model %q", m)
+        }
+        seen[m] = true
+    }
+    return models, nil
+}
+
+// fetchModelRows fetches the location once per requested model and returns the rows of all
+// models together, tagged with their source_model. A variable a model does not provide is
+// stored as NULL for that model rather than failing the comparison.
+func fetchModelRows(ctx context.Context, opts *requestOptions) (*ingestResult, []*WeatherData, error) {
+    result := &ingestResult{BatchID: uuid.NewString(), StartDate: opts.StartDate}
+    var rows []*WeatherData
+    for _, model := range opts.Models {
+        m := *opts
+        m.Model = model
+        meteoResp, err := fetchOpenMeteo(ctx, &m, m.StartDate)
+        if err != nil {
+            return nil, nil, err
+        }
+        result.RateLimit = meteoResp.RateLimit
+        result.Endpoint = meteoResp.Endpoint
+        result.SourceURLs = append(result.SourceURLs, meteoResp.SourceURL)
+        if err := checkComplete(meteoResp.Daily, &m); err != nil {
+            return nil, nil, err
+        }
+        for _, name := range meteoResp.Daily.emptyVariables(m.Variables) {
+            slog.Warn("Model does not provide variable; storing NULL", "model", model, "variable", name)
+        }
+        rows = append(rows, convertDaily(meteoResp, &m, result.BatchID)...)
+    }
+    checksumFetched(result, rows, opts)
+    if rows = finishRows(rows, opts); len(rows) == 0 {
+        slog.Info("No data returned from API", "latitude", opts.Latitude, "longitude", opts.Longitude, "models", opts.Models)
+        result.Empty = true
+        return result, nil, nil
+    }
+    return result, rows, nil
+}
+
+// modelRow is one day of the merged layout, with a <column>_<model> value per model.
+type modelRow map[string]bigquery.Value
+
+// Save implements bigquery.ValueSaver.
+func (r modelRow) Save() (map[string]bigquery.Value, string, error) {
+    return r, "", nil
+}
+
+// mergeModelColumns merges per-model rows into one row per day. The models may snap the
+// request to different grid points, so merged rows carry the requested coordinate.
+func mergeModelColumns(opts *requestOptions, rows []*WeatherData) []modelRow {
+    byDate := make(map[string]modelRow)
+    var dates []string
+    for _, row := range rows {
+        merged, ok := byDate[row.Date]
+        if !ok {
+            merged = modelRow{
+                "latitude":    opts.Latitude,
+                "longitude":   opts.Longitude,
+                "date":        row.Date,
+                "inserted_at": row.InsertedAt,
+                "batch_id":    row.BatchID,
+            }
+            byDate[row.Date] = merged
+            dates = append(dates, row.Date)
+        }
+        for _, col := range modelColumns {
+            if hasVariable(opts.Variables, col.Variable) {
+                merged[col.Column+"_"+row.SourceModel.StringVal] = col.value(row)
+            }
+        }
+    }
+
+    sort.Strings(dates)
+    out := make([]modelRow, 0, len(dates))
+    for _, date := range dates {
+        out = append(out, byDate[date])
+    }
+    return out
+}
+
+// modelColumnsMetadata builds the schema of the merged layout for the requested models and variables.
+func modelColumnsMetadata(opts *requestOptions) *bigquery.TableMetadata {
+    schema := bigquery.Schema{
+        {Name: "latitude", Type: bigquery.FloatFieldType},
+        {Name: "longitude", Type: bigquery.FloatFieldType},
+        {Name: "date", Type: bigquery.DateFieldType},
+        {Name: "inserted_at", Type: bigquery.TimestampFieldType},
+        {Name: "batch_id", Type: bigquery.StringFieldType},
+    }
+    for _, model := range opts.Models {
+        for _, col := range modelColumns {
+            if hasVariable(opts.Variables, col.Variable) {
+                schema = append(schema, &bigquery.FieldSchema{Name: col.Column + "_" + model, Type: col.Type})
+            }
+        }
+    }
+    return &bigquery.TableMetadata{Schema: schema}
+}
+
+// addMissingColumns adds the columns of want that the existing table lacks, so a comparison
+// with a model not seen before can be stored in the same table.
+func addMissingColumns(ctx context.Context, table *bigquery.Table, want bigquery.Schema) error {
+    meta, err := table.Metadata(ctx)
+    if err != nil {
+        return fmt.Errorf("failed to read table metadata: %w", err)
+    }
+    schema, added := missingColumns(meta.Schema, want)
+    if len(added) == 0 {
+        return nil
+    }
+    if _, err := table.Update(ctx, bigquery.TableMetadataToUpdate{Schema: schema}, meta.ETag); err != nil {
+        return fmt.Errorf("failed to add columns: %w", err)
+    }
+    slog.Info("Added columns to table", "table", table.TableID, "columns", added)
+    return nil
+}
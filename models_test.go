@@ -0,0 +1,108 @@
+package main
+
+import (
+    "context"
+    "net/http"
+    "reflect"
+    "testing"
+
+    "cloud.google.com/go/bigquery"
+)
+
+func TestParseModels(t *testing.T) {
+    tests := []struct {
+        param   string
+        want    []string
+        wantErr bool
+    }{
+        {"", nil, false},
+        {"icon_seamless", []string{"icon_seamless"}, false},
+        {"icon_seamless, gfs_seamless", []string{"icon_seamless", "gfs_seamless"}, false},
+        {"a,b,c,d,e", []string{"a", "b", "c", "d", "e"}, false},
+        {"a,b,c,d,e,f", nil, true},
+        {"icon_seamless,icon_seamless", nil, true},
+        {"ICON", nil, true},
+        {"icon-seamless", nil, true},
+        {"icon`; DROP", nil, true},
+    }
+    for _, tt := range tests {
+        t.Run(tt.param, func(t *testing.T) {
+            got, err := parseModels(tt.param)
+            if (err != nil) != tt.wantErr {
+                t.Fatalf("err = %v, wantErr %v", err, tt.wantErr)
+            }
+            if !tt.wantErr && len(got)+len(tt.want) > 0 && !reflect.DeepEqual(got, tt.want) {
+                t.Errorf("parseModels(%q) = %q, want %q", tt.param, got, tt.want)
+            }
+        })
+    }
+}
+
+func TestFetchModelRows(t *testing.T) {
+    stubOpenMeteo(t, func(w http.ResponseWriter, r *http.Request) {
+        switch r.URL.Query().Get("models") {
+        case "icon_seamless":
+            serveBody(`{"latitude":52.5,"longitude":13.4,"daily":{"time":["2024-01-01","2024-01-02"],"rain_sum":[1,2],"temperature_2m_mean":[3,4]}}`)(w, r)
+        case "gfs_seamless":
+            serveBody(`{"latitude":52.6,"longitude":13.3,"daily":{"time":["2024-01-01","2024-01-02"],"rain_sum":[5,6],"temperature_2m_mean":[null,null]}}`)(w, r)
+        default:
+            t.Errorf("unexpected models=%q", r.URL.Query().Get("models"))
+        }
+    })
+    opts := mustParseOptions(t, "latitude=52.52&longitude=13.41&start_date=2024-01-01&end_date=2024-01-02&models=icon_seamless,gfs_seamless")
+    result, rows, err := fetchModelRows(context.Background(), opts)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if len(rows) != 4 || len(result.SourceURLs) != 2 {
+        t.Fatalf("got %d rows from %d requests, want 4 from 2", len(rows), len(result.SourceURLs))
+    }
+    perModel := map[string]int{}
+    for _, row := range rows {
+        perModel[row.SourceModel.StringVal]++
+        if row.SourceModel.StringVal == "gfs_seamless" && row.MeanTemperature.Valid {
+            t.Errorf("gfs_seamless mean temperature = %+v, want NULL", row.MeanTemperature)
+        }
+    }
+    if perModel["icon_seamless"] != 2 || perModel["gfs_seamless"] != 2 {
+        t.Errorf("rows per model = %v", perModel)
+    }
+    if rows[0].RowKey == rows[1].RowKey {
+        t.Error("rows of different models share a row key")
+    }
+}
+
+func TestMergeModelColumns(t *testing.T) {
+    opts := mustParseOptions(t, "latitude=52.52&longitude=13.41&models=icon_seamless,gfs_seamless")
+    model := func(name string) bigquery.NullString { return bigquery.NullString{StringVal: name, Valid: true} }
+    rows := []*WeatherData{
+        {Date: "2024-01-02", Latitude: 52.5, SourceModel: model("icon_seamless"), RainSum: nf(2)},
+        {Date: "2024-01-01", Latitude: 52.5, SourceModel: model("icon_seamless"), RainSum: nf(1)},
+        {Date: "2024-01-01", Latitude: 52.6, SourceModel: model("gfs_seamless"), RainSum: nf(5)},
+    }
+    merged := mergeModelColumns(opts, rows)
+    if len(merged) != 2 {
+        t.Fatalf("got %d merged rows, want 2", len(merged))
+    }
+    tests := []struct {
+        row    int
+        column string
+        want   bigquery.Value
+    }{
+        {0, "date", "2024-01-01"},
+        {0, "latitude", 52.52},
+        {0, "rain_sum_icon_seamless", nf(1)},
+        {0, "rain_sum_gfs_seamless", nf(5)},
+        {1, "date", "2024-01-02"},
+        {1, "rain_sum_icon_seamless", nf(2)},
+        {1, "rain_sum_gfs_seamless", nil},
+    }
+    for _, tt := range tests {
+        if got := merged[tt.row][tt.column]; got != tt.want {
+            t.Errorf("row %d %s = %v, want %v", tt.row, tt.column, got, tt.want)
+        }
+    }
+    if _, ok := merged[0]["weather_code_icon_seamless"]; ok {
+        t.Error("merged row has a column for a variable that was not requested")
+    }
+}
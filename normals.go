@@ -0,0 +1,150 @@
+package main
+
+import (
+    "fmt"
+    "log/slog"
+    "net/http"
+    "strconv"
+
+    "cloud.google.com/go/bigquery"
+    "google.golang.org/api/iterator"
+)
+
+// Normal is the climate normal of one day of year or month over the baseline period.
+// Temperatures are averages of the daily values; precipitation is the average total for
+// the period, so a monthly normal reads as "mm per month".
+type Normal struct {
+    Period          int64                `bigquery:"period" json:"period"`
+    Years           int64                `bigquery:"years" json:"years"`
+    MeanTemperature bigquery.NullFloat64 `bigquery:"mean_temperature" json:"mean_temperature"`
+    MinTemperature  bigquery.NullFloat64 `bigquery:"min_temperature" json:"min_temperature"`
+    MaxTemperature  bigquery.NullFloat64 `bigquery:"max_temperature" json:"max_temperature"`
+    RainSum         bigquery.NullFloat64 `bigquery:"rain_sum" json:"rain_sum"`
+    SnowfallSum     bigquery.NullFloat64 `bigquery:"snowfall_sum" json:"snowfall_sum"`
+}
+
+// normalsPeriods maps the by parameter to the date part that is grouped on.
+var normalsPeriods = map[string]string{
+    "month": "MONTH",
+    "day":   "DAYOFYEAR",
+}
+
+// serveNormals computes climate normals for a coordinate from the stored history, grouped
+// by month (the default) or by=day of year, over the baseline_start..baseline_end years.
+// Every period must be covered by at least cfg.NormalsMinYears years, or the request
+// fails with a 422 rather than returning normals from too short a record.
+func serveNormals(w http.ResponseWriter, r *http.Request) {
+    if r.Method != http.MethodGet {
+        http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+        return
+    }
+    ctx := r.Context()
+    q := r.URL.Query()
+
+    latitude, longitude, err := parseCoordinates(q)
+    if err != nil {
+        http.Error(w, err.Error(), http.StatusBadRequest)
+        return
+    }
+    by := q.Get("by")
+    if by == "" {
+        by = "month"
+    }
+    part, ok := normalsPeriods[by]
+    if !ok {
+        http.Error(w, "by must be month or day", http.StatusBadRequest)
+        return
+    }
+    startYear, endYear := cfg.NormalsBaselineStart, cfg.NormalsBaselineEnd
+    if startYear, err = parseYear(q.Get("baseline_start"), startYear); err != nil {
+        http.Error(w, err.Error(), http.StatusBadRequest)
+        return
+    }
+    if endYear, err = parseYear(q.Get("baseline_end"), endYear); err != nil {
+        http.Error(w, err.Error(), http.StatusBadRequest)
+        return
+    }
+    if startYear > endYear {
+        http.Error(w, "baseline_start is after baseline_end", http.StatusBadRequest)
+        return
+    }
+    minYears := min(cfg.NormalsMinYears, endYear-startYear+1)
+
+    client, err := newBigQueryClient(ctx)
+    if err != nil {
+        slog.Error("Failed to create BigQuery client", "error", err)
+        http.Error(w, "BigQuery error", http.StatusInternalServerError)
+        return
+    }
+    defer client.Close()
+
+    query := client.Query(fmt.Sprintf(`SELECT
+  EXTRACT(%[1]s FROM date) AS period,
+  COUNT(DISTINCT EXTRACT(YEAR FROM date)) AS years,
+  AVG(mean_temperature) AS mean_temperature,
+  AVG(min_temperature) AS min_temperature,
+  AVG(max_temperature) AS max_temperature,
+  SUM(rain_sum) / COUNT(DISTINCT EXTRACT(YEAR FROM date)) AS rain_sum,
+  SUM(snowfall_sum) / COUNT(DISTINCT EXTRACT(YEAR FROM date)) AS snowfall_sum
+FROM `+"`%[2]s.%[3]s.%[4]s`"+`
+WHERE ABS(latitude - @latitude) <= @tolerance AND ABS(longitude - @longitude) <= @tolerance
+  AND EXTRACT(YEAR FROM date) BETWEEN @start_year AND @end_year
+GROUP BY period
+ORDER BY period`, part, cfg.ProjectID, cfg.DatasetID, cfg.TableID))
+    query.Parameters = []bigquery.QueryParameter{
+        {Name: "latitude", Value: latitude},
+        {Name: "longitude", Value: longitude},
+        {Name: "tolerance", Value: cfg.IncrementalTolerance},
+        {Name: "start_year", Value: startYear},
+        {Name: "end_year", Value: endYear},
+    }
+    it, err := query.Read(ctx)
+    if err != nil {
+        slog.Error("Failed to query normals", "error", err)
+        http.Error(w, "Failed to query data", http.StatusInternalServerError)
+        return
+    }
+
+    normals := []Normal{}
+    shortest := int64(-1)
+    for {
+        var n Normal
+        err := it.Next(&n)
+        if err == iterator.Done {
+            break
+        }
+        if err != nil {
+            slog.Error("Failed to read normals", "error", err)
+            http.Error(w, "Failed to query data", http.StatusInternalServerError)
+            return
+        }
+        if shortest < 0 || n.Years < shortest {
+            shortest = n.Years
+        }
+        normals = append(normals, n)
+    }
+    if len(normals) == 0 || shortest < int64(minYears) {
+        http.Error(w, fmt.Sprintf("Insufficient history: normals need %d years between %d and %d, found %d", minYears, startYear, endYear, max(shortest, 0)), http.StatusUnprocessableEntity)
+        return
+    }
+
+    writeJSON(w, http.StatusOK, map[string]interface{}{
+        "latitude":  latitude,
+        "longitude": longitude,
+        "by":        by,
+        "baseline":  map[string]int{"start": startYear, "end": endYear},
+        "normals":   normals,
+    })
+}
+
+// parseYear parses a four-digit year parameter, returning the fallback when it is empty.
+func parseYear(s string, fallback int) (int, error) {
+    if s == "" {
+        return fallback, nil
+    }
+    y, err := strconv.Atoi(s)
+    if err != nil || y < 1940 || y > 9999 {
+        return 0, fmt.Errorf("invalid year %q", s)
+    }
+    return y, nil
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+    "encoding/json"
+    "net/http"
+    "net/http/httptest"
+    "strings"
+    "testing"
+)
+
+// normalRows answers a normals query with one row per period, each covered by years years.
+func normalRows(periods int, years int64) func(q *fakeQuery) *fakeResult {
+    return func(q *fakeQuery) *fakeResult {
+        res := &fakeResult{Fields: fields("period", "INTEGER", "years", "INTEGER", "mean_temperature", "FLOAT", "min_temperature", "FLOAT", "max_temperature", "FLOAT", "rain_sum", "FLOAT", "snowfall_sum", "FLOAT")}
+        for p := 1; p <= periods; p++ {
+            res.Rows = append(res.Rows, []interface{}{p, years, 9.5, 5.1, 13.8, 48.2, nil})
+        }
+        return res
+    }
+}
+
+func TestServeNormals(t *testing.T) {
+    tests := []struct {
+        name        string
+        query       string
+        answer      func(q *fakeQuery) *fakeResult
+        wantStatus  int
+        wantPart    string
+        wantNormals int
+    }{
+        {"monthly by default", "latitude=52.52&longitude=13.41", normalRows(12, 30), http.StatusOK, "EXTRACT(MONTH FROM date)", 12},
+        {"by day of year", "latitude=52.52&longitude=13.41&by=day", normalRows(366, 30), http.StatusOK, "EXTRACT(DAYOFYEAR FROM date)", 366},
+        {"short baseline needs fewer years", "latitude=52.52&longitude=13.41&baseline_start=2001&baseline_end=2010", normalRows(12, 10), http.StatusOK, "EXTRACT(MONTH FROM date)", 12},
+        {"insufficient history", "latitude=52.52&longitude=13.41", normalRows(12, 12), http.StatusUnprocessableEntity, "", 0},
+        {"no history", "latitude=52.52&longitude=13.41", nil, http.StatusUnprocessableEntity, "", 0},
+        {"invalid by", "latitude=52.52&longitude=13.41&by=week", nil, http.StatusBadRequest, "", 0},
+        {"invalid year", "latitude=52.52&longitude=13.41&baseline_start=1800", nil, http.StatusBadRequest, "", 0},
+        {"inverted baseline", "latitude=52.52&longitude=13.41&baseline_start=2020&baseline_end=2000", nil, http.StatusBadRequest, "", 0},
+        {"missing coordinates", "by=month", nil, http.StatusBadRequest, "", 0},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            withConfig(t, func(c *Config) { c.NormalsBaselineStart, c.NormalsBaselineEnd, c.NormalsMinYears = 1991, 2020, 25 })
+            fake, _ := newFakeBigQuery(t)
+            fake.answer = tt.answer
+            rec := httptest.NewRecorder()
+            serveNormals(rec, httptest.NewRequest(http.MethodGet, "/normals?"+tt.query, nil))
+            if rec.Code != tt.wantStatus {
+                t.Fatalf("status = %d, want %d: %s", rec.Code, tt.wantStatus, rec.Body)
+            }
+            if tt.wantStatus == http.StatusBadRequest {
+                if n := len(fake.received()); n != 0 {
+                    t.Errorf("ran %d queries for an invalid request", n)
+                }
+                return
+            }
+            if tt.wantStatus != http.StatusOK {
+                return
+            }
+            var body struct {
+                Normals []Normal `json:"normals"`
+            }
+            if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+                t.Fatal(err)
+            }
+            if len(body.Normals) != tt.wantNormals {
+                t.Errorf("got %d normals, want %d", len(body.Normals), tt.wantNormals)
+            }
+            if n := body.Normals[0]; n.Period != 1 || n.RainSum.Float64 != 48.2 || n.SnowfallSum.Valid {
+                t.Errorf("first normal = %+v", n)
+            }
+            q := fake.received()[0]
+            if !strings.Contains(q.SQL, tt.wantPart) {
+                t.Errorf("SQL %q lacks %q", q.SQL, tt.wantPart)
+            }
+            if q.param("tolerance") == "" || q.param("start_year") == "" {
+                t.Errorf("parameters %+v lack the tolerance or baseline", q.Params)
+            }
+        })
+    }
+}
+
+func TestParseYear(t *testing.T) {
+    tests := []struct {
+        s       string
+        want    int
+        wantErr bool
+    }{
+        {"", 1991, false},
+        {"2000", 2000, false},
+        {"1940", 1940, false},
+        {"1939", 0, true},
+        {"20x0", 0, true},
+    }
+    for _, tt := range tests {
+        t.Run(tt.s, func(t *testing.T) {
+            got, err := parseYear(tt.s, 1991)
+            if (err != nil) != tt.wantErr || got != tt.want {
+                t.Errorf("parseYear(%q) = %d, %v; want %d, wantErr %v", tt.s, got, err, tt.want, tt.wantErr)
+            }
+        })
+    }
+}
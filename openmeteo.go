@@ -0,0 +1,363 @@
+package main
+
+import (
+    "bytes"
+    "context"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "io"
+    "log/slog"
+    "net/http"
+    "net/url"
+    "strings"
+    "time"
+    _ "time/tzdata" // time zone names from Open-Meteo must resolve without system tzdata
+)
+
+// httpClient is shared by every Open-Meteo request so connections are reused across
+// requests and across the workers of a multi-location ingestion.
+var httpClient = newHTTPClient()
+
+// newHTTPClient builds the Open-Meteo client on a copy of the default transport, with the
+// connection pool tuned from the configuration. The default keeps only two idle
+// connections per host, which forces new handshakes when several workers fetch at once.
+func newHTTPClient() *http.Client {
+    transport := http.DefaultTransport.(*http.Transport).Clone()
+    transport.MaxIdleConns = cfg.MaxIdleConns
+    transport.MaxIdleConnsPerHost = cfg.MaxIdleConnsPerHost
+    transport.IdleConnTimeout = cfg.IdleConnTimeout
+    return &http.Client{Transport: transport, CheckRedirect: checkRedirect}
+}
+
+// errTooManyRedirects stops a redirect chain longer than cfg.MaxRedirects.
+var errTooManyRedirects = errors.New("too many redirects")
+
+// checkRedirect logs each redirect Open-Meteo issues, so endpoint changes are visible, and
+// stops following after cfg.MaxRedirects.
+func checkRedirect(req *http.Request, via []*http.Request) error {
+    from := via[len(via)-1].URL.String()
+    if len(via) > cfg.MaxRedirects {
+        slog.Warn("Not following Open-Meteo redirect", "from", redactAPIKey(from), "to", redactAPIKey(req.URL.String()), "redirects", len(via))
+        return fmt.Errorf("%w: stopped after %d", errTooManyRedirects, cfg.MaxRedirects)
+    }
+    slog.Info("Following Open-Meteo redirect", "from", redactAPIKey(from), "to", redactAPIKey(req.URL.String()))
+    return nil
+}
+
+// apiBaseURLs maps each mode to its free Open-Meteo endpoint.
+var apiBaseURLs = map[string]string{
+    "archive":  "https://archive-api.open-meteo.com/v1/archive",
+    "forecast": "https://api.open-meteo.com/v1/forecast",
+}
+
+// customerAPIBaseURLs maps each mode to the commercial endpoint used with an API key.
+var customerAPIBaseURLs = map[string]string{
+    "archive":  "https://customer-archive-api.open-meteo.com/v1/archive",
+    "forecast": "https://customer-api.open-meteo.com/v1/forecast",
+}
+
+// apiBaseURL returns the endpoint for the mode, switching to the commercial endpoint
+// when an API key is configured.
+func apiBaseURL(mode string) string {
+    if cfg.OpenMeteoAPIKey != "" {
+        return customerAPIBaseURLs[mode]
+    }
+    return apiBaseURLs[mode]
+}
+
+// apiEndpoints returns the endpoint for the mode followed by the configured mirrors, each
+// serving the same path as the primary endpoint.
+func apiEndpoints(mode string) []string {
+    primary := apiBaseURL(mode)
+    endpoints := []string{primary}
+    u, err := url.Parse(primary)
+    if err != nil {
+        return endpoints
+    }
+    for _, mirror := range cfg.Mirrors {
+        endpoints = append(endpoints, strings.TrimSuffix(mirror, "/")+u.Path)
+    }
+    return endpoints
+}
+
+// fetchOpenMeteo requests the daily data for the location from startDate to the end of the range.
+// When an endpoint still fails after its retries, the next mirror is tried before giving up.
+func fetchOpenMeteo(ctx context.Context, opts *requestOptions, startDate string) (*OpenMeteoResponse, error) {
+    query := fmt.Sprintf(
+        "?latitude=%f&longitude=%f&start_date=%s&end_date=%s&daily=%s&timezone=auto",
+        opts.Latitude, opts.Longitude, startDate, opts.EndDate, variableList(opts.Variables),
+    )
+    if opts.TimeFormat == "unixtime" {
+        query += "&timeformat=unixtime"
+    }
+    if opts.Model != "" {
+        query += "&models=" + url.QueryEscape(opts.Model)
+    }
+    if cfg.OpenMeteoAPIKey != "" {
+        query += "&apikey=" + url.QueryEscape(cfg.OpenMeteoAPIKey)
+    }
+
+    var body []byte
+    var limit *rateLimit
+    var endpoint, finalURL string
+    var err error
+    policy := retryPolicy{Attempts: cfg.MaxRetries + 1, Initial: 500 * time.Millisecond, Max: 8 * time.Second}
+    for i, base := range apiEndpoints(opts.Mode) {
+        if i > 0 {
+            slog.Warn("Open-Meteo endpoint failed; trying mirror", "failed", endpoint, "mirror", base, "error", err)
+        }
+        endpoint = base
+        apiURL := base + query
+        slog.Debug("Fetching weather data", "url", redactAPIKey(apiURL))
+        err = retryWithBackoff(ctx, policy, isRetryableFetchError, func() error {
+            var err error
+            body, limit, finalURL, err = fetchOnce(ctx, apiURL)
+            return err
+        })
+        // A request the endpoint rejected would be rejected by its mirrors too.
+        if err == nil || ctx.Err() != nil || !isRetryableFetchError(err) {
+            break
+        }
+    }
+    if err != nil {
+        var statusErr *upstreamStatusError
+        if errors.As(err, &statusErr) {
+            return nil, &requestError{http.StatusInternalServerError, "API error", err}
+        }
+        return nil, &requestError{http.StatusInternalServerError, "Failed to fetch data", err}
+    }
+
+    meteoResp, err := decodeResponse(body)
+    if errors.Is(err, errNoDailyObject) {
+        return nil, &requestError{http.StatusBadGateway, "Upstream response has no daily data", err}
+    }
+    // A payload that is not valid JSON or does not match the structs means the upstream or a
+    // proxy in between sent something other than Open-Meteo data.
+    var syntaxErr *json.SyntaxError
+    var typeErr *json.UnmarshalTypeError
+    if errors.As(err, &syntaxErr) || errors.As(err, &typeErr) {
+        return nil, &requestError{http.StatusBadGateway, "Upstream returned invalid data", fmt.Errorf("failed to unmarshal JSON: %w", err)}
+    }
+    if err != nil {
+        return nil, &requestError{http.StatusInternalServerError, "Failed to parse data", fmt.Errorf("failed to unmarshal JSON: %w", err)}
+    }
+    meteoResp.RateLimit = limit
+    meteoResp.Endpoint = endpoint
+    meteoResp.SourceURL = redactAPIKey(finalURL)
+    meteoResp.FetchedAt = now()
+    if endpoint != apiBaseURL(opts.Mode) {
+        slog.Info("Served by Open-Meteo mirror", "mirror", endpoint)
+    }
+    return meteoResp, nil
+}
+
+// errNoDailyObject is returned when an upstream response lacks the daily object entirely.
+var errNoDailyObject = errors.New("Open-Meteo response has no daily object")
+
+// truncate shortens s to at most n bytes for logging.
+func truncate(s string, n int) string {
+    if len(s) <= n {
+        return s
+    }
+    return s[:n] + "..."
+}
+
+// upstreamStatusError reports a non-200 response from Open-Meteo.
+type upstreamStatusError struct {
+    StatusCode int
+    Body       string
+}
+
+func (e *upstreamStatusError) Error() string {
+    return fmt.Sprintf("Open-Meteo API returned status %d: %s", e.StatusCode, e.Body)
+}
+
+// fetchOnce makes a single request to Open-Meteo and returns the response body, any
+// rate-limit headers it carried, and the URL that served it after any redirects.
+func fetchOnce(ctx context.Context, apiURL string) ([]byte, *rateLimit, string, error) {
+    req, err := http.NewRequestWithContext(ctx, http.MethodGet, apiURL, nil)
+    if err != nil {
+        return nil, nil, "", fmt.Errorf("failed to build HTTP request: %s", redactAPIKey(err.Error()))
+    }
+    resp, err := httpClient.Do(req)
+    if err != nil {
+        if ctxErr := ctx.Err(); ctxErr != nil {
+            return nil, nil, "", ctxErr
+        }
+        // A redirect loop will not resolve on retry, so it is not a retryable fetchError.
+        if errors.Is(err, errTooManyRedirects) {
+            return nil, nil, "", errors.New(redactAPIKey(err.Error()))
+        }
+        return nil, nil, "", &fetchError{redactAPIKey(err.Error())}
+    }
+    defer resp.Body.Close()
+    finalURL := resp.Request.URL.String()
+
+    limit := parseRateLimit(resp.Header)
+    if limit != nil {
+        slog.Debug("Open-Meteo rate limit", "limit", limit.Limit, "remaining", limit.Remaining, "reset", limit.Reset)
+    }
+    if resp.StatusCode != http.StatusOK {
+        body, _ := io.ReadAll(resp.Body)
+        return nil, limit, finalURL, &upstreamStatusError{resp.StatusCode, string(body)}
+    }
+
+    body, err := io.ReadAll(resp.Body)
+    if err != nil {
+        return nil, limit, finalURL, &fetchError{fmt.Sprintf("failed to read response body: %v", err)}
+    }
+    return body, limit, finalURL, nil
+}
+
+// rateLimit is the usage budget Open-Meteo reported in its response headers.
+type rateLimit struct {
+    Limit     string
+    Remaining string
+    Reset     string
+}
+
+// parseRateLimit reads the X-RateLimit-* headers, or the unprefixed RateLimit-* form,
+// returning nil when the response carried neither.
+func parseRateLimit(h http.Header) *rateLimit {
+    for _, prefix := range []string{"X-RateLimit-", "RateLimit-"} {
+        limit := &rateLimit{
+            Limit:     h.Get(prefix + "Limit"),
+            Remaining: h.Get(prefix + "Remaining"),
+            Reset:     h.Get(prefix + "Reset"),
+        }
+        if limit.Limit != "" || limit.Remaining != "" || limit.Reset != "" {
+            return limit
+        }
+    }
+    return nil
+}
+
+// setUpstreamHeaders passes the remaining upstream budget, if known, and the endpoint that
+// served the data on to the caller.
+func setUpstreamHeaders(w http.ResponseWriter, result *ingestResult) {
+    if limit := result.RateLimit; limit != nil && limit.Remaining != "" {
+        w.Header().Set("X-Upstream-RateLimit-Remaining", limit.Remaining)
+    }
+    if result.Endpoint != "" {
+        w.Header().Set("X-Upstream-Endpoint", result.Endpoint)
+    }
+}
+
+// fetchError is a transport-level failure talking to Open-Meteo, with the API key redacted.
+type fetchError struct {
+    msg string
+}
+
+func (e *fetchError) Error() string {
+    return "failed to make HTTP request: " + e.msg
+}
+
+// isRetryableFetchError reports whether a failed fetch should be retried under the upstream
+// retry rules: by default transport failures, rate limiting, and server errors are, and
+// other client errors are not.
+func isRetryableFetchError(err error) bool {
+    rule := cfg.RetryRules.Upstream
+    if rule.matchesError(err) {
+        return true
+    }
+    var statusErr *upstreamStatusError
+    if errors.As(err, &statusErr) {
+        return rule.matchesStatus(statusErr.StatusCode)
+    }
+    var fetchErr *fetchError
+    return rule.Transport && errors.As(err, &fetchErr)
+}
+
+// decodeResponse parses the Open-Meteo payload. In strict mode, fields not present in the
+// structs are logged to surface upstream schema drift, but never fail the request.
+func decodeResponse(body []byte) (*OpenMeteoResponse, error) {
+    var meteoResp OpenMeteoResponse
+    if err := json.Unmarshal(body, &meteoResp); err != nil {
+        return nil, err
+    }
+    // A missing daily object, unlike an empty one, means the request or the upstream went
+    // wrong rather than that there is no data for the range.
+    if meteoResp.Daily == nil {
+        slog.Error("Open-Meteo response has no daily object", "body", truncate(string(body), 512))
+        return nil, errNoDailyObject
+    }
+
+    if cfg.StrictDecode {
+        dec := json.NewDecoder(bytes.NewReader(body))
+        dec.DisallowUnknownFields()
+        var strict OpenMeteoResponse
+        if err := dec.Decode(&strict); err != nil {
+            slog.Warn("Strict decode found unexpected upstream payload", "error", err)
+        }
+    }
+
+    // With timeformat=unixtime each day is local midnight as epoch seconds; derive the
+    // local calendar dates from the location's time zone.
+    if t := &meteoResp.Daily.Time; t.Unix != nil {
+        var loc *time.Location
+        if meteoResp.Timezone != "" {
+            loc, _ = time.LoadLocation(meteoResp.Timezone)
+        }
+        t.Dates = make([]string, len(t.Unix))
+        for i, sec := range t.Unix {
+            t.Dates[i] = localDate(sec, loc, meteoResp.UTCOffsetSeconds)
+        }
+    }
+    return &meteoResp, nil
+}
+
+// responseLocation returns the time zone of the response, falling back to its fixed UTC
+// offset when the zone name is missing or cannot be loaded.
+func responseLocation(meteoResp *OpenMeteoResponse) *time.Location {
+    if meteoResp.Timezone != "" {
+        if loc, err := time.LoadLocation(meteoResp.Timezone); err == nil {
+            return loc
+        }
+    }
+    return time.FixedZone("", int(meteoResp.UTCOffsetSeconds))
+}
+
+// localDate returns the calendar date of a local-midnight epoch. The response carries a
+// single UTC offset, which is wrong for days on the other side of a DST change, so the
+// named time zone is used when it can be loaded. Otherwise the fixed offset is applied and
+// the result rounded to the nearest midnight, which absorbs DST shifts of up to 12 hours
+// so both sides of a transition still map to distinct, correct dates. Only daily data is
+// ingested; hourly timestamps, where a DST fall-back repeats an hour, are not handled here.
+func localDate(sec int64, loc *time.Location, offset int64) string {
+    if loc != nil {
+        return time.Unix(sec, 0).In(loc).Format(dateLayout)
+    }
+    return time.Unix(sec+offset, 0).UTC().Add(12 * time.Hour).Truncate(24 * time.Hour).Format(dateLayout)
+}
+
+// timeArray is the daily time array, which Open-Meteo sends as ISO 8601 dates or, with
+// timeformat=unixtime, as epoch seconds. Dates is always filled after decodeResponse.
+type timeArray struct {
+    Dates []string
+    Unix  []int64
+}
+
+// UnmarshalJSON accepts either an array of date strings or an array of integers.
+func (t *timeArray) UnmarshalJSON(b []byte) error {
+    var dates []string
+    if err := json.Unmarshal(b, &dates); err == nil {
+        *t = timeArray{Dates: dates}
+        return nil
+    }
+    var unix []int64
+    if err := json.Unmarshal(b, &unix); err != nil {
+        return fmt.Errorf("time must be an array of dates or epoch seconds: %w", err)
+    }
+    *t = timeArray{Unix: unix}
+    return nil
+}
+
+// redactAPIKey replaces the configured API key in s so it never reaches the logs.
+func redactAPIKey(s string) string {
+    if cfg.OpenMeteoAPIKey == "" {
+        return s
+    }
+    s = strings.ReplaceAll(s, url.QueryEscape(cfg.OpenMeteoAPIKey), "REDACTED")
+    return strings.ReplaceAll(s, cfg.OpenMeteoAPIKey, "REDACTED")
+}
@@ -0,0 +1,676 @@
+package main
+
+import (
+    "context"
+    "errors"
+    "io"
+    "log/slog"
+    "net"
+    "net/http"
+    "net/http/httptest"
+    "net/url"
+    "reflect"
+    "strings"
+    "sync"
+    "testing"
+    "time"
+)
+
+// stubOpenMeteo points both the free and the customer endpoints of every mode at a test
+// server running handler, with retries disabled. It returns the server URL.
+func stubOpenMeteo(t *testing.T, handler http.HandlerFunc) string {
+    t.Helper()
+    srv := httptest.NewServer(handler)
+    t.Cleanup(srv.Close)
+    withConfig(t, func(c *Config) { c.MaxRetries = 0 })
+    for _, endpoints := range []map[string]string{apiBaseURLs, customerAPIBaseURLs} {
+        endpoints := endpoints
+        saved := make(map[string]string, len(endpoints))
+        for mode, u := range endpoints {
+            saved[mode] = u
+        }
+        endpoints["archive"] = srv.URL + "/v1/archive"
+        endpoints["forecast"] = srv.URL + "/v1/forecast"
+        t.Cleanup(func() {
+            for mode, u := range saved {
+                endpoints[mode] = u
+            }
+        })
+    }
+    return srv.URL
+}
+
+// serveBody answers every request with the JSON body.
+func serveBody(body string) http.HandlerFunc {
+    return func(w http.ResponseWriter, r *http.Request) {
+        w.Header().Set("Content-Type", "application/json")
+        w.Write([]byte(body))
+    }
+}
+
+func TestDecodeResponseStrict(t *testing.T) {
+    tests := []struct {
+        name     string
+        strict   bool
+        body     string
+        wantWarn bool
+    }{
+        {"known fields", true, `{"latitude":52.5,"longitude":13.4,"daily":{"time":["2024-01-01"],"rain_sum":[0.2]}}`, false},
+        {"unknown field in strict mode", true, `{"latitude":52.5,"longitude":13.4,"hourly_units":{},"daily":{"time":["2024-01-01"]}}`, true},
+        {"unknown daily field in strict mode", true, `{"latitude":52.5,"longitude":13.4,"daily":{"time":["2024-01-01"],"wind_speed_10m_max":[3]}}`, true},
+        {"unknown field in lenient mode", false, `{"latitude":52.5,"longitude":13.4,"hourly_units":{},"daily":{"time":["2024-01-01"]}}`, false},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            withConfig(t, func(c *Config) { c.StrictDecode = tt.strict })
+            logs := captureLogs(t, slog.LevelWarn)
+            resp, err := decodeResponse([]byte(tt.body))
+            if err != nil {
+                t.Fatalf("decodeResponse() failed: %v", err)
+            }
+            if resp.Latitude != 52.5 || len(resp.Daily.Time.Dates) != 1 {
+                t.Errorf("decoded %+v", resp)
+            }
+            if warned := strings.Contains(logs.String(), "Strict decode found unexpected upstream payload"); warned != tt.wantWarn {
+                t.Errorf("warned = %v, want %v; logs: %s", warned, tt.wantWarn, logs)
+            }
+        })
+    }
+}
+
+func TestAPIBaseURL(t *testing.T) {
+    tests := []struct {
+        mode string
+        key  string
+        want string
+    }{
+        {"archive", "", "https://archive-api.open-meteo.com/v1/archive"},
+        {"forecast", "", "https://api.open-meteo.com/v1/forecast"},
+        {"archive", "k3y", "https://customer-archive-api.open-meteo.com/v1/archive"},
+        {"forecast", "k3y", "https://customer-api.open-meteo.com/v1/forecast"},
+    }
+    for _, tt := range tests {
+        t.Run(tt.mode+"/"+tt.key, func(t *testing.T) {
+            withConfig(t, func(c *Config) { c.OpenMeteoAPIKey = tt.key })
+            if got := apiBaseURL(tt.mode); got != tt.want {
+                t.Errorf("apiBaseURL(%q) = %q, want %q", tt.mode, got, tt.want)
+            }
+        })
+    }
+}
+
+func TestFetchOpenMeteoAPIKey(t *testing.T) {
+    tests := []struct {
+        name    string
+        key     string
+        wantKey string
+    }{
+        {"free endpoint", "", ""},
+        {"customer endpoint", "k3y&x=1", "k3y&x=1"},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            var gotKey string
+            var hasKey bool
+            stubOpenMeteo(t, func(w http.ResponseWriter, r *http.Request) {
+                gotKey, hasKey = r.URL.Query().Get("apikey"), r.URL.Query().Has("apikey")
+                serveBody(`{"latitude":52.5,"longitude":13.4,"daily":{"time":["2024-01-01"]}}`)(w, r)
+            })
+            withConfig(t, func(c *Config) { c.OpenMeteoAPIKey = tt.key })
+            resp, err := fetchOpenMeteo(context.Background(), mustParseOptions(t, "latitude=52.52&longitude=13.41&start_date=2024-01-01&end_date=2024-01-01"), "2024-01-01")
+            if err != nil {
+                t.Fatal(err)
+            }
+            if gotKey != tt.wantKey || hasKey != (tt.wantKey != "") {
+                t.Errorf("apikey = %q (sent %v), want %q", gotKey, hasKey, tt.wantKey)
+            }
+            if tt.key != "" && strings.Contains(resp.SourceURL, "k3y") {
+                t.Errorf("SourceURL %q leaks the API key", resp.SourceURL)
+            }
+        })
+    }
+}
+
+func TestDecodeResponseUnixtime(t *testing.T) {
+    tests := []struct {
+        name      string
+        body      string
+        wantDates []string
+        wantUnix  []int64
+    }{
+        {
+            name:      "iso8601 dates",
+            body:      `{"daily":{"time":["2024-01-01","2024-01-02"]}}`,
+            wantDates: []string{"2024-01-01", "2024-01-02"},
+        },
+        {
+            name:      "epoch seconds in a named zone",
+            body:      `{"timezone":"Europe/Berlin","utc_offset_seconds":3600,"daily":{"time":[1704063600,1704150000]}}`,
+            wantDates: []string{"2024-01-01", "2024-01-02"},
+            wantUnix:  []int64{1704063600, 1704150000},
+        },
+        {
+            name:      "epoch seconds at UTC",
+            body:      `{"timezone":"GMT","utc_offset_seconds":0,"daily":{"time":[1704067200]}}`,
+            wantDates: []string{"2024-01-01"},
+            wantUnix:  []int64{1704067200},
+        },
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            resp := mustDecode(t, tt.body)
+            if !reflect.DeepEqual(resp.Daily.Time.Dates, tt.wantDates) {
+                t.Errorf("Dates = %q, want %q", resp.Daily.Time.Dates, tt.wantDates)
+            }
+            if !reflect.DeepEqual(resp.Daily.Time.Unix, tt.wantUnix) {
+                t.Errorf("Unix = %v, want %v", resp.Daily.Time.Unix, tt.wantUnix)
+            }
+        })
+    }
+    if _, err := decodeResponse([]byte(`{"daily":{"time":[true]}}`)); err == nil {
+        t.Error("decodeResponse() accepted a time array of booleans")
+    }
+}
+
+func TestFetchOpenMeteoTimeFormat(t *testing.T) {
+    tests := []struct {
+        query string
+        want  string
+    }{
+        {"", ""},
+        {"&timeformat=iso8601", ""},
+        {"&timeformat=unixtime", "unixtime"},
+    }
+    for _, tt := range tests {
+        t.Run(tt.query, func(t *testing.T) {
+            var got string
+            stubOpenMeteo(t, func(w http.ResponseWriter, r *http.Request) {
+                got = r.URL.Query().Get("timeformat")
+                serveBody(`{"latitude":52.5,"longitude":13.4,"daily":{"time":["2024-01-01"]}}`)(w, r)
+            })
+            opts := mustParseOptions(t, "latitude=52.52&longitude=13.41&start_date=2024-01-01&end_date=2024-01-01"+tt.query)
+            if _, err := fetchOpenMeteo(context.Background(), opts, "2024-01-01"); err != nil {
+                t.Fatal(err)
+            }
+            if got != tt.want {
+                t.Errorf("timeformat = %q, want %q", got, tt.want)
+            }
+        })
+    }
+}
+
+func TestLocalDate(t *testing.T) {
+    berlin, err := time.LoadLocation("Europe/Berlin")
+    if err != nil {
+        t.Skip(err)
+    }
+    tests := []struct {
+        name   string
+        sec    int64
+        loc    *time.Location
+        offset int64
+        want   string
+    }{
+        {"before spring change", 1711753200, berlin, 3600, "2024-03-30"},
+        {"day of spring change", 1711839600, berlin, 3600, "2024-03-31"},
+        {"after spring change", 1711922400, berlin, 3600, "2024-04-01"},
+        {"day of autumn change", 1729980000, berlin, 7200, "2024-10-27"},
+        {"after autumn change", 1730070000, berlin, 7200, "2024-10-28"},
+        {"fixed offset before change", 1711839600, nil, 3600, "2024-03-31"},
+        {"fixed offset after spring change", 1711922400, nil, 3600, "2024-04-01"},
+        {"fixed offset after autumn change", 1730070000, nil, 7200, "2024-10-28"},
+        {"negative fixed offset across change", 1710129600, nil, -18000, "2024-03-11"},
+        {"UTC", 1704067200, nil, 0, "2024-01-01"},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            if got := localDate(tt.sec, tt.loc, tt.offset); got != tt.want {
+                t.Errorf("localDate(%d) = %q, want %q", tt.sec, got, tt.want)
+            }
+        })
+    }
+}
+
+func TestResponseLocation(t *testing.T) {
+    tests := []struct {
+        name       string
+        timezone   string
+        offset     int64
+        wantName   string
+        wantOffset int
+    }{
+        {"named zone", "Europe/Berlin", 3600, "Europe/Berlin", 7200},
+        {"missing zone", "", -18000, "", -18000},
+        {"unknown zone", "Mars/Olympus_Mons", 19800, "", 19800},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            loc := responseLocation(&OpenMeteoResponse{Timezone: tt.timezone, UTCOffsetSeconds: tt.offset})
+            // Midsummer, so a named zone reports its DST offset rather than the response's.
+            _, offset := time.Date(2024, 7, 1, 0, 0, 0, 0, loc).Zone()
+            if loc.String() != tt.wantName || offset != tt.wantOffset {
+                t.Errorf("responseLocation() = %q at %d, want %q at %d", loc.String(), offset, tt.wantName, tt.wantOffset)
+            }
+        })
+    }
+}
+
+func TestDecodeResponseAcrossDST(t *testing.T) {
+    tests := []struct {
+        name     string
+        timezone string
+    }{
+        {"named zone", "Europe/Berlin"},
+        {"unknown zone uses the fixed offset", "Mars/Olympus_Mons"},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            body := `{"timezone":"` + tt.timezone + `","utc_offset_seconds":3600,"daily":{"time":[1711753200,1711839600,1711922400]}}`
+            want := []string{"2024-03-30", "2024-03-31", "2024-04-01"}
+            if got := mustDecode(t, body).Daily.Time.Dates; !reflect.DeepEqual(got, want) {
+                t.Errorf("Dates = %q, want %q", got, want)
+            }
+        })
+    }
+}
+
+func TestNewHTTPClient(t *testing.T) {
+    tests := []struct {
+        name    string
+        idle    int
+        perHost int
+        timeout time.Duration
+    }{
+        {"defaults", 100, 16, 90 * time.Second},
+        {"tuned", 4, 2, time.Second},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            withConfig(t, func(c *Config) {
+                c.MaxIdleConns, c.MaxIdleConnsPerHost, c.IdleConnTimeout = tt.idle, tt.perHost, tt.timeout
+            })
+            transport := newHTTPClient().Transport.(*http.Transport)
+            if transport.MaxIdleConns != tt.idle || transport.MaxIdleConnsPerHost != tt.perHost || transport.IdleConnTimeout != tt.timeout {
+                t.Errorf("transport pool = %d/%d/%v, want %d/%d/%v", transport.MaxIdleConns, transport.MaxIdleConnsPerHost, transport.IdleConnTimeout, tt.idle, tt.perHost, tt.timeout)
+            }
+            if transport == http.DefaultTransport {
+                t.Error("newHTTPClient() modified the default transport")
+            }
+        })
+    }
+}
+
+func TestHTTPClientReusesConnections(t *testing.T) {
+    var mu sync.Mutex
+    conns := 0
+    srv := httptest.NewUnstartedServer(serveBody(`{"daily":{"time":["2024-01-01"]}}`))
+    srv.Config.ConnState = func(_ net.Conn, state http.ConnState) {
+        if state == http.StateNew {
+            mu.Lock()
+            conns++
+            mu.Unlock()
+        }
+    }
+    srv.Start()
+    defer srv.Close()
+
+    client := newHTTPClient()
+    for i := 0; i < 5; i++ {
+        resp, err := client.Get(srv.URL)
+        if err != nil {
+            t.Fatal(err)
+        }
+        io.Copy(io.Discard, resp.Body)
+        resp.Body.Close()
+    }
+    mu.Lock()
+    defer mu.Unlock()
+    if conns != 1 {
+        t.Errorf("opened %d connections for 5 sequential requests, want 1", conns)
+    }
+}
+
+func TestParseRateLimit(t *testing.T) {
+    tests := []struct {
+        name    string
+        headers map[string]string
+        want    *rateLimit
+    }{
+        {"absent", nil, nil},
+        {"prefixed", map[string]string{"X-RateLimit-Limit": "10000", "X-RateLimit-Remaining": "9876", "X-RateLimit-Reset": "3600"}, &rateLimit{"10000", "9876", "3600"}},
+        {"unprefixed", map[string]string{"RateLimit-Remaining": "12"}, &rateLimit{Remaining: "12"}},
+        {"prefixed wins", map[string]string{"X-RateLimit-Remaining": "5", "RateLimit-Remaining": "7"}, &rateLimit{Remaining: "5"}},
+        {"unrelated headers", map[string]string{"Retry-After": "30"}, nil},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            h := http.Header{}
+            for k, v := range tt.headers {
+                h.Set(k, v)
+            }
+            if got := parseRateLimit(h); !reflect.DeepEqual(got, tt.want) {
+                t.Errorf("parseRateLimit() = %+v, want %+v", got, tt.want)
+            }
+        })
+    }
+}
+
+func TestIngestReportsRateLimit(t *testing.T) {
+    tests := []struct {
+        name          string
+        remaining     string
+        wantHeader    string
+        wantInSummary bool
+    }{
+        {"budget reported", "42", "42", true},
+        {"no rate-limit headers", "", "", false},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            newFakeBigQuery(t)
+            logs := captureLogs(t, slog.LevelDebug)
+            stubOpenMeteo(t, func(w http.ResponseWriter, r *http.Request) {
+                if tt.remaining != "" {
+                    w.Header().Set("X-RateLimit-Remaining", tt.remaining)
+                }
+                serveBody(threeDays)(w, r)
+            })
+            rec := httptest.NewRecorder()
+            fetchWeatherData(rec, httptest.NewRequest(http.MethodGet, "/?latitude=52.52&longitude=13.41&start_date=2024-01-01&end_date=2024-01-03", nil))
+            if rec.Code != http.StatusOK {
+                t.Fatalf("status = %d: %s", rec.Code, rec.Body)
+            }
+            if got := rec.Header().Get("X-Upstream-RateLimit-Remaining"); got != tt.wantHeader {
+                t.Errorf("X-Upstream-RateLimit-Remaining = %q, want %q", got, tt.wantHeader)
+            }
+            if got := strings.Contains(rec.Body.String(), "upstream rate limit remaining "+tt.remaining); got != tt.wantInSummary {
+                t.Errorf("body %q reports the budget: %v, want %v", rec.Body, got, tt.wantInSummary)
+            }
+            if got := strings.Contains(logs.String(), "Open-Meteo rate limit"); got != tt.wantInSummary {
+                t.Errorf("rate limit logged = %v, want %v", got, tt.wantInSummary)
+            }
+        })
+    }
+}
+
+func TestFetchOpenMeteoOmitsDatasetVersion(t *testing.T) {
+    var query url.Values
+    stubOpenMeteo(t, func(w http.ResponseWriter, r *http.Request) {
+        query = r.URL.Query()
+        serveBody(`{"latitude":52.5,"longitude":13.4,"daily":{"time":["2024-01-01"]}}`)(w, r)
+    })
+    opts := mustParseOptions(t, "latitude=52.52&longitude=13.41&start_date=2024-01-01&end_date=2024-01-01&dataset_version=v2")
+    if _, err := fetchOpenMeteo(context.Background(), opts, "2024-01-01"); err != nil {
+        t.Fatal(err)
+    }
+    for name, values := range query {
+        for _, v := range values {
+            if v == "v2" {
+                t.Errorf("dataset_version sent upstream as %s", name)
+            }
+        }
+    }
+}
+
+func TestFetchOpenMeteoDailyObject(t *testing.T) {
+    tests := []struct {
+        name       string
+        body       string
+        wantStatus int
+        wantDays   int
+        wantLog    string
+    }{
+        {"days", `{"latitude":52.5,"longitude":13.4,"daily":{"time":["2024-01-01"]}}`, 0, 1, ""},
+        {"empty daily object", `{"latitude":52.5,"longitude":13.4,"daily":{}}`, 0, 0, ""},
+        {"empty time array", `{"latitude":52.5,"longitude":13.4,"daily":{"time":[]}}`, 0, 0, ""},
+        {"missing daily object", `{"latitude":52.5,"longitude":13.4}`, http.StatusBadGateway, 0, "Open-Meteo response has no daily object"},
+        {"null daily object", `{"latitude":52.5,"longitude":13.4,"daily":null}`, http.StatusBadGateway, 0, "Open-Meteo response has no daily object"},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            logs := captureLogs(t, slog.LevelError)
+            stubOpenMeteo(t, serveBody(tt.body))
+            opts := mustParseOptions(t, "latitude=52.52&longitude=13.41&start_date=2024-01-01&end_date=2024-01-01")
+            resp, err := fetchOpenMeteo(context.Background(), opts, "2024-01-01")
+            if tt.wantStatus != 0 {
+                var reqErr *requestError
+                if !errors.As(err, &reqErr) || reqErr.Status != tt.wantStatus {
+                    t.Fatalf("err = %v, want a %d request error", err, tt.wantStatus)
+                }
+                if !strings.Contains(logs.String(), tt.wantLog) {
+                    t.Errorf("logs lack %q: %s", tt.wantLog, logs)
+                }
+                return
+            }
+            if err != nil {
+                t.Fatal(err)
+            }
+            if len(resp.Daily.Time.Dates) != tt.wantDays {
+                t.Errorf("got %d days, want %d", len(resp.Daily.Time.Dates), tt.wantDays)
+            }
+        })
+    }
+}
+
+func TestFetchRowsEmptyDailyObject(t *testing.T) {
+    logs := captureLogs(t, slog.LevelInfo)
+    stubOpenMeteo(t, serveBody(`{"latitude":52.5,"longitude":13.4,"daily":{}}`))
+    opts := mustParseOptions(t, "latitude=52.52&longitude=13.41&start_date=2024-01-01&end_date=2024-01-01")
+    result, rows, err := fetchRows(context.Background(), nil, opts)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if !result.Empty || len(rows) != 0 {
+        t.Errorf("result = %+v with %d rows, want empty", result, len(rows))
+    }
+    if !strings.Contains(logs.String(), "Open-Meteo returned an empty daily object") {
+        t.Errorf("empty daily object not logged: %s", logs)
+    }
+}
+
+func TestAPIEndpoints(t *testing.T) {
+    tests := []struct {
+        name    string
+        mirrors []string
+        want    []string
+    }{
+        {"primary only", nil, []string{"https://archive-api.open-meteo.com/v1/archive"}},
+        {
+            "mirrors serve the primary path",
+            []string{"https://mirror-a.example.com", "https://mirror-b.example.com/"},
+            []string{"https://archive-api.open-meteo.com/v1/archive", "https://mirror-a.example.com/v1/archive", "https://mirror-b.example.com/v1/archive"},
+        },
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            withConfig(t, func(c *Config) {
+                c.Mirrors = tt.mirrors
+                c.OpenMeteoAPIKey = ""
+            })
+            if got := apiEndpoints("archive"); !reflect.DeepEqual(got, tt.want) {
+                t.Errorf("apiEndpoints() = %q, want %q", got, tt.want)
+            }
+        })
+    }
+}
+
+func TestFetchOpenMeteoMirrors(t *testing.T) {
+    const body = `{"latitude":52.5,"longitude":13.4,"daily":{"time":["2024-01-01"]}}`
+    // serve answers with body, or with the status when it is not 200.
+    serve := func(status int, hits *int) http.HandlerFunc {
+        return func(w http.ResponseWriter, r *http.Request) {
+            *hits++
+            if status != http.StatusOK {
+                http.Error(w, "unavailable", status)
+                return
+            }
+            serveBody(body)(w, r)
+        }
+    }
+    tests := []struct {
+        name       string
+        statuses   []int
+        wantHits   []int
+        wantServer int
+        wantErr    bool
+    }{
+        {"primary serves", []int{200, 200, 200}, []int{1, 0, 0}, 0, false},
+        {"first mirror fails, second serves", []int{503, 500, 200}, []int{1, 1, 1}, 2, false},
+        {"all fail", []int{503, 503, 503}, []int{1, 1, 1}, -1, true},
+        {"rejected requests are not retried on mirrors", []int{400, 200, 200}, []int{1, 0, 0}, -1, true},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            hits := make([]int, len(tt.statuses))
+            urls := []string{stubOpenMeteo(t, serve(tt.statuses[0], &hits[0]))}
+            var mirrors []string
+            for i := 1; i < len(tt.statuses); i++ {
+                srv := httptest.NewServer(serve(tt.statuses[i], &hits[i]))
+                t.Cleanup(srv.Close)
+                urls = append(urls, srv.URL)
+                mirrors = append(mirrors, srv.URL)
+            }
+            withConfig(t, func(c *Config) { c.Mirrors = mirrors })
+
+            opts := mustParseOptions(t, "latitude=52.52&longitude=13.41&start_date=2024-01-01&end_date=2024-01-01")
+            resp, err := fetchOpenMeteo(context.Background(), opts, "2024-01-01")
+            if (err != nil) != tt.wantErr {
+                t.Fatalf("err = %v, wantErr %v", err, tt.wantErr)
+            }
+            if !reflect.DeepEqual(hits, tt.wantHits) {
+                t.Errorf("requests per endpoint = %v, want %v", hits, tt.wantHits)
+            }
+            if tt.wantErr {
+                return
+            }
+            if want := urls[tt.wantServer] + "/v1/archive"; resp.Endpoint != want {
+                t.Errorf("Endpoint = %q, want %q", resp.Endpoint, want)
+            }
+        })
+    }
+}
+
+func TestSetUpstreamHeaders(t *testing.T) {
+    tests := []struct {
+        name         string
+        result       *ingestResult
+        wantEndpoint string
+        wantBudget   string
+    }{
+        {"nothing known", &ingestResult{}, "", ""},
+        {"mirror and budget", &ingestResult{Endpoint: "https://mirror.example.com/v1/archive", RateLimit: &rateLimit{Remaining: "7"}}, "https://mirror.example.com/v1/archive", "7"},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            rec := httptest.NewRecorder()
+            setUpstreamHeaders(rec, tt.result)
+            if got := rec.Header().Get("X-Upstream-Endpoint"); got != tt.wantEndpoint {
+                t.Errorf("X-Upstream-Endpoint = %q, want %q", got, tt.wantEndpoint)
+            }
+            if got := rec.Header().Get("X-Upstream-RateLimit-Remaining"); got != tt.wantBudget {
+                t.Errorf("X-Upstream-RateLimit-Remaining = %q, want %q", got, tt.wantBudget)
+            }
+        })
+    }
+}
+
+func TestFetchOpenMeteoInvalidData(t *testing.T) {
+    tests := []struct {
+        name string
+        body string
+        want int
+    }{
+        {"valid", `{"latitude":52.5,"longitude":13.4,"daily":{"time":["2024-01-01"],"rain_sum":[0.5]}}`, 0},
+        {"NaN coordinate", `{"latitude":NaN,"longitude":13.4,"daily":{"time":["2024-01-01"]}}`, http.StatusBadGateway},
+        {"infinite coordinate", `{"latitude":52.5,"longitude":1e999,"daily":{"time":["2024-01-01"]}}`, http.StatusBadGateway},
+        {"NaN value", `{"latitude":52.5,"longitude":13.4,"daily":{"time":["2024-01-01"],"rain_sum":[NaN]}}`, http.StatusBadGateway},
+        {"string coordinate", `{"latitude":"52.5","longitude":13.4,"daily":{"time":["2024-01-01"]}}`, http.StatusBadGateway},
+        {"string value", `{"latitude":52.5,"longitude":13.4,"daily":{"time":["2024-01-01"],"rain_sum":["wet"]}}`, http.StatusBadGateway},
+        {"truncated body", `{"latitude":52.5,"longitude":13.4,"daily":{"time":["2024-01`, http.StatusBadGateway},
+        {"proxy error page", `<html><body>Bad Gateway</body></html>`, http.StatusBadGateway},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            stubOpenMeteo(t, serveBody(tt.body))
+            opts := mustParseOptions(t, "latitude=52.52&longitude=13.41&start_date=2024-01-01&end_date=2024-01-01")
+            _, err := fetchOpenMeteo(context.Background(), opts, "2024-01-01")
+            if tt.want == 0 {
+                if err != nil {
+                    t.Fatal(err)
+                }
+                return
+            }
+            var reqErr *requestError
+            if !errors.As(err, &reqErr) || reqErr.Status != tt.want || reqErr.Message != "Upstream returned invalid data" {
+                t.Errorf("err = %v, want a %d invalid data error", err, tt.want)
+            }
+        })
+    }
+}
+
+func TestFetchOpenMeteoRedirects(t *testing.T) {
+    const body = `{"latitude":52.5,"longitude":13.4,"daily":{"time":["2024-01-01"],"rain_sum":[0.5]}}`
+    tests := []struct {
+        name         string
+        redirects    int
+        maxRedirects int
+        wantErr      bool
+        wantLog      string
+    }{
+        {"no redirect", 0, 3, false, ""},
+        {"followed", 2, 3, false, "Following Open-Meteo redirect"},
+        {"at the limit", 3, 3, false, "Following Open-Meteo redirect"},
+        {"over the limit", 4, 3, true, "Not following Open-Meteo redirect"},
+        {"redirects disabled", 1, 0, true, "Not following Open-Meteo redirect"},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            logs := captureLogs(t, slog.LevelInfo)
+            var mu sync.Mutex
+            requests := 0
+            stubOpenMeteo(t, func(w http.ResponseWriter, r *http.Request) {
+                mu.Lock()
+                requests++
+                mu.Unlock()
+                // Each hop moves one level deeper under /regional until the chain ends.
+                hops := strings.Count(r.URL.Path, "/regional")
+                if hops < tt.redirects {
+                    http.Redirect(w, r, "/regional"+r.URL.RequestURI(), http.StatusFound)
+                    return
+                }
+                serveBody(body)(w, r)
+            })
+            withConfig(t, func(c *Config) { c.MaxRedirects, c.MaxRetries = tt.maxRedirects, 2 })
+            opts := mustParseOptions(t, "latitude=52.52&longitude=13.41&start_date=2024-01-01&end_date=2024-01-01")
+
+            resp, err := fetchOpenMeteo(context.Background(), opts, "2024-01-01")
+            if tt.wantErr {
+                if err == nil || !strings.Contains(err.Error(), "too many redirects") {
+                    t.Fatalf("err = %v, want too many redirects", err)
+                }
+                // A redirect loop is not retried.
+                if want := tt.maxRedirects + 1; requests != want {
+                    t.Errorf("made %d requests, want %d without retries", requests, want)
+                }
+            } else {
+                if err != nil {
+                    t.Fatal(err)
+                }
+                u, err := url.Parse(resp.SourceURL)
+                if err != nil {
+                    t.Fatal(err)
+                }
+                if want := strings.Repeat("/regional", tt.redirects) + "/v1/archive"; u.Path != want {
+                    t.Errorf("SourceURL path = %q, want the final URL %q", u.Path, want)
+                }
+            }
+            if tt.wantLog == "" {
+                if strings.Contains(logs.String(), "redirect") {
+                    t.Errorf("logged a redirect: %s", logs)
+                }
+            } else if !strings.Contains(logs.String(), tt.wantLog) {
+                t.Errorf("logs lack %q: %s", tt.wantLog, logs)
+            }
+        })
+    }
+}
@@ -0,0 +1,527 @@
+package main
+
+import (
+    "fmt"
+    "log/slog"
+    "math"
+    "net/http"
+    "net/url"
+    "regexp"
+    "strconv"
+    "time"
+)
+
+const dateLayout = "2006-01-02"
+
+// now returns the current time. It is a variable so tests and reproducible backfills can
+// fix the clock that default date ranges and insert timestamps are based on.
+var now = time.Now
+
+// maxTableIDLength is BigQuery's limit on table names.
+const maxTableIDLength = 1024
+
+// maxDatasetVersionLength caps the dataset_version label.
+const maxDatasetVersionLength = 64
+
+// clientIDPattern restricts client_id to a short identifier safe to group by in the warehouse.
+var clientIDPattern = regexp.MustCompile(`^[A-Za-z0-9._-]{1,64}$`)
+
+// tableSuffixPattern restricts table_suffix so the suffixed name stays a valid table ID.
+var tableSuffixPattern = regexp.MustCompile(`^[A-Za-z0-9_]+$`)
+
+// maxPastDays is the largest past_days window accepted per mode. The forecast API only
+// keeps a short history, while the archive reaches back to 1940.
+var maxPastDays = map[string]int{
+    "forecast": 92,
+    "archive":  36500,
+}
+
+// requestOptions holds the per-request settings parsed from the query string.
+type requestOptions struct {
+    Latitude           float64
+    Longitude          float64
+    Mode               string
+    StartDate          string
+    EndDate            string
+    Variables          []string
+    WeatherDescription bool
+    Incremental        bool
+    CoordsGCSURI       string
+    CoordsFormat       string
+    SkipIfFresh        bool
+    FreshWindow        time.Duration
+    // Sink is "bigquery" to store rows, or "none" to only return them.
+    Sink string
+    // Aggregate is "monthly" to store monthly aggregates instead of daily rows, or empty.
+    Aggregate string
+    // Format is the serialization of returned rows: "json", "influx", "geojson", or "arrow".
+    Format string
+    // Round is the number of decimal places weather values are rounded to, or -1 for none.
+    Round int
+    // Models lists the Open-Meteo models to fetch and compare; empty for the default model.
+    Models []string
+    // ModelLayout is "rows" to store one row per model, or "columns" to merge the models
+    // into model-suffixed columns of one row per day.
+    ModelLayout string
+    // Layout is "wide" to store one row per day, or "long" to store one row per day and
+    // variable in the long table.
+    Layout string
+    // Model is the single model fetched by one Open-Meteo call; set per call from Models.
+    Model string
+    // NodataSentinel, when set, replaces NULL weather values with a sentinel such as -999
+    // for consumers that cannot handle NULLs. Unlike a NULL, a sentinel is an ordinary number
+    // to BigQuery: AVG, SUM, and comparisons include it unless every query filters it out,
+    // so it is off by default.
+    NodataSentinel *float64
+    // Months limits the rows to these calendar months; empty keeps every month.
+    Months map[time.Month]bool
+    // DateParts adds the derived day_of_year and season columns.
+    DateParts bool
+    // ZeroPrecipAsNull stores zero precipitation as NULL on days with no other data.
+    ZeroPrecipAsNull bool
+    // MinFields drops rows with fewer than this many non-NULL requested variables; 0 keeps all.
+    MinFields int
+    // ReverifyOlderThan re-fetches the stored days of the range inserted longer ago than
+    // this and upserts their fresh values; 0 to ingest normally.
+    ReverifyOlderThan time.Duration
+    // QualityScore stores the fraction of requested variables present and plausible per row.
+    QualityScore bool
+    // HintEarliest looks up the earliest date with data when the range comes back empty.
+    HintEarliest bool
+    // RefetchTail requests the missing days again when a response ends before the range does.
+    RefetchTail bool
+    // Verify polls the table after inserting until the inserted rows are visible.
+    Verify bool
+    // RequireComplete rejects the ingestion with a 422 when a requested variable is entirely NULL.
+    RequireComplete bool
+    // TimeFormat is the Open-Meteo timeformat: "iso8601", or "unixtime" to also store date_unix.
+    TimeFormat string
+    // GDDBase is the base temperature in °C for growing degree days; nil to skip them.
+    GDDBase *float64
+    // GDDCumulative also stores the running total of the growing degree days.
+    GDDCumulative bool
+    // SnowfallUnit is "cm", the Open-Meteo unit and the default, or "mm" to store snowfall
+    // in the same unit as rain.
+    SnowfallUnit string
+    // Provenance stores the source API and a hash of the upstream request URL with each row.
+    Provenance bool
+    // OutOfBounds is what happens to values outside the plausibility bounds: "log", the
+    // default, "null" to clear them, or "reject" to drop their rows.
+    OutOfBounds string
+    // Checksum compares a hash of the fetched values with the one stored by the previous
+    // run over the same range, to detect upstream revisions.
+    Checksum bool
+    // Grid is the bounding box grid whose points are ingested instead of a single
+    // coordinate; nil otherwise.
+    Grid *gridSpec
+    // Preview is how many rows from each end of the range to return instead of storing
+    // them; 0 for no preview.
+    Preview int
+    // ReturnRows also writes the inserted rows to the response, as with sink=none.
+    ReturnRows bool
+    // Rolling is the window in days of the trailing rolling aggregates; 0 for none.
+    Rolling int
+    // RollingPartial computes rolling windows missing days over the days present instead
+    // of storing NULL.
+    RollingPartial bool
+    // IncludeElevation stores the grid cell elevation reported by Open-Meteo.
+    IncludeElevation bool
+    // DateAsTimestamp also stores the date as a local-midnight TIMESTAMP in date_ts.
+    DateAsTimestamp bool
+    // SchemaVersion selects the registered column set rows are stored with.
+    SchemaVersion string
+    // WriteAPI is "insert" for streaming inserts or "storage" for the Storage Write API.
+    WriteAPI string
+    // PartitionDecorator overwrites the single day's partition with a load job instead of
+    // streaming rows, making reloads of that day idempotent. The partition holds the day
+    // for every coordinate, so all other locations' rows for the day are deleted too.
+    PartitionDecorator bool
+    // DatasetVersion is a caller-supplied label stored with each row. Open-Meteo has no
+    // archive version pinning, so it is recorded but not sent upstream.
+    DatasetVersion string
+    // ClientID attributes the rows to the requesting team; empty when not given.
+    ClientID string
+    // Ranges are the disjoint windows of a POST job; StartDate and EndDate then span them all.
+    Ranges []dateRange
+    // CallerIP and UserAgent identify the caller for the ingest run record; empty unless
+    // RECORD_CALLER_INFO is set.
+    CallerIP  string
+    UserAgent string
+    // Table overrides the daily table for this location, as chosen by a routing rule.
+    Table string
+    // TableSuffix is appended to the target table name, for date- or region-sharded tables.
+    TableSuffix string
+}
+
+// parseRequestOptions parses and validates the query parameters of an ingestion request.
+// A place name given as name is geocoded to the coordinates to fetch.
+func parseRequestOptions(r *http.Request) (*requestOptions, error) {
+    q := withClientID(r, r.URL.Query())
+    grid, err := parseGrid(q)
+    if err != nil {
+        return nil, err
+    }
+    if grid != nil && (q.Get("latitude") != "" || q.Get("longitude") != "" || q.Get("coords_gcs_uri") != "") {
+        return nil, fmt.Errorf("a bounding box grid cannot be combined with latitude/longitude or coords_gcs_uri")
+    }
+    if name := q.Get("name"); name != "" {
+        if q.Get("latitude") != "" || q.Get("longitude") != "" || q.Get("coords_gcs_uri") != "" || grid != nil {
+            return nil, fmt.Errorf("name cannot be combined with latitude/longitude, coords_gcs_uri, or a bounding box grid")
+        }
+        loc, err := geocode(r.Context(), name)
+        if err != nil {
+            return nil, err
+        }
+        q.Set("latitude", strconv.FormatFloat(loc.Latitude, 'f', -1, 64))
+        q.Set("longitude", strconv.FormatFloat(loc.Longitude, 'f', -1, 64))
+    }
+    if grid != nil {
+        for _, loc := range grid.locations() {
+            if err := checkAllowedRegion(loc.Latitude, loc.Longitude); err != nil {
+                return nil, err
+            }
+        }
+    }
+    opts, err := parseQueryOptions(q, grid != nil)
+    if err != nil {
+        return nil, err
+    }
+    opts.Grid = grid
+    return withCaller(r, opts), nil
+}
+
+// withClientID fills the client_id parameter from the X-Client-Id header when the query
+// does not set it, so either can be used for attribution.
+func withClientID(r *http.Request, q url.Values) url.Values {
+    if q.Get("client_id") == "" {
+        if id := r.Header.Get("X-Client-Id"); id != "" {
+            q.Set("client_id", id)
+        }
+    }
+    return q
+}
+
+// parseQueryOptions parses and validates ingestion parameters. When multi is set, the
+// coordinates are supplied separately and the latitude/longitude parameters are not required.
+func parseQueryOptions(q url.Values, multi bool) (*requestOptions, error) {
+    // Coordinates come from the file instead when a GCS coordinates list is given.
+    var latitude, longitude float64
+    var err error
+    coordsURI := q.Get("coords_gcs_uri")
+    if coordsURI == "" && !multi {
+        if q.Get("latitude") == "" && q.Get("longitude") == "" && cfg.DefaultCoordinates != nil {
+            latitude, longitude = cfg.DefaultCoordinates.Latitude, cfg.DefaultCoordinates.Longitude
+            slog.Info("Using default coordinates", "latitude", latitude, "longitude", longitude)
+        } else if latitude, longitude, err = parseCoordinates(q); err != nil {
+            return nil, err
+        } else if err = checkNullIsland(latitude, longitude); err != nil {
+            return nil, err
+        }
+        latitude, longitude, _ = normalizeCoordinates(latitude, longitude)
+        if err = checkAllowedRegion(latitude, longitude); err != nil {
+            return nil, err
+        }
+    }
+
+    mode := q.Get("mode")
+    if mode == "" {
+        mode = "archive"
+    }
+    if _, ok := maxPastDays[mode]; !ok {
+        return nil, fmt.Errorf("unsupported mode %q", mode)
+    }
+
+    // Validate the requested daily variables against the supported list.
+    variables, err := parseDailyVariables(q.Get("daily"), mode)
+    if err != nil {
+        return nil, err
+    }
+
+    // Define date range (last 20 years).
+    today := now()
+    opts := &requestOptions{
+        Latitude:  latitude,
+        Longitude: longitude,
+        Mode:      mode,
+        StartDate: today.AddDate(-20, 0, 0).Format(dateLayout),
+        EndDate:   today.Format(dateLayout),
+        Variables: variables,
+
+        CoordsGCSURI: coordsURI,
+        CoordsFormat: q.Get("coords_format"),
+    }
+    if err := opts.parseDateRange(q.Get("start_date"), q.Get("end_date"), q.Get("past_days")); err != nil {
+        return nil, err
+    }
+    opts.WeatherDescription, _ = strconv.ParseBool(q.Get("weather_description"))
+    opts.Incremental, _ = strconv.ParseBool(q.Get("incremental"))
+    if opts.Round, err = parseRound(q.Get("round")); err != nil {
+        return nil, err
+    }
+
+    opts.SkipIfFresh, _ = strconv.ParseBool(q.Get("skip_if_fresh"))
+    opts.FreshWindow = cfg.FreshnessWindow
+    if s := q.Get("fresh_window"); s != "" {
+        if opts.FreshWindow, err = time.ParseDuration(s); err != nil || opts.FreshWindow <= 0 {
+            return nil, fmt.Errorf("invalid fresh_window %q", s)
+        }
+    }
+
+    opts.Sink = q.Get("sink")
+    if opts.Sink == "" {
+        opts.Sink = "bigquery"
+    }
+    if v, _ := strconv.ParseBool(q.Get("return_only")); v {
+        opts.Sink = "none"
+    }
+    if s := q.Get("preview"); s != "" {
+        if opts.Preview, err = strconv.Atoi(s); err != nil || opts.Preview < 1 || opts.Preview > maxPreviewRows {
+            return nil, fmt.Errorf("preview must be between 1 and %d", maxPreviewRows)
+        }
+        // A preview is a dry run: nothing is stored.
+        opts.Sink = "none"
+    }
+    opts.Aggregate = q.Get("aggregate")
+    if opts.Aggregate != "" && opts.Aggregate != "monthly" {
+        return nil, fmt.Errorf("unsupported aggregate %q", opts.Aggregate)
+    }
+
+    if opts.Models, err = parseModels(q.Get("models")); err != nil {
+        return nil, err
+    }
+    opts.ModelLayout = q.Get("model_layout")
+    if opts.ModelLayout == "" {
+        opts.ModelLayout = "rows"
+    }
+    opts.Layout = q.Get("layout")
+    if opts.Layout == "" {
+        opts.Layout = "wide"
+    }
+
+    if s := q.Get("nodata_sentinel"); s != "" {
+        v, err := strconv.ParseFloat(s, 64)
+        if err != nil || math.IsNaN(v) || math.IsInf(v, 0) {
+            return nil, fmt.Errorf("invalid nodata_sentinel %q", s)
+        }
+        opts.NodataSentinel = &v
+    }
+
+    if s := q.Get("gdd_base"); s != "" {
+        v, err := strconv.ParseFloat(s, 64)
+        if err != nil || math.IsNaN(v) || math.IsInf(v, 0) {
+            return nil, fmt.Errorf("invalid gdd_base %q", s)
+        }
+        opts.GDDBase = &v
+    }
+    opts.GDDCumulative, _ = strconv.ParseBool(q.Get("gdd_cumulative"))
+    if s := q.Get("rolling"); s != "" {
+        if opts.Rolling, err = strconv.Atoi(s); err != nil || opts.Rolling < 2 || opts.Rolling > maxRollingDays {
+            return nil, fmt.Errorf("rolling must be between 2 and %d days", maxRollingDays)
+        }
+    }
+    opts.RollingPartial, _ = strconv.ParseBool(q.Get("rolling_partial"))
+    if opts.GDDCumulative && opts.GDDBase == nil {
+        return nil, fmt.Errorf("gdd_cumulative requires gdd_base")
+    }
+
+    opts.RequireComplete, _ = strconv.ParseBool(q.Get("require_complete"))
+    opts.Verify, _ = strconv.ParseBool(q.Get("verify"))
+    opts.RefetchTail, _ = strconv.ParseBool(q.Get("refetch_tail"))
+    opts.HintEarliest, _ = strconv.ParseBool(q.Get("hint_earliest"))
+    opts.QualityScore, _ = strconv.ParseBool(q.Get("quality_score"))
+    if s := q.Get("reverify_older_than"); s != "" {
+        if opts.ReverifyOlderThan, err = time.ParseDuration(s); err != nil || opts.ReverifyOlderThan <= 0 {
+            return nil, fmt.Errorf("invalid reverify_older_than %q", s)
+        }
+    }
+    if opts.Months, err = parseMonths(q.Get("months")); err != nil {
+        return nil, err
+    }
+    opts.DateParts, _ = strconv.ParseBool(q.Get("date_parts"))
+    opts.ZeroPrecipAsNull, _ = strconv.ParseBool(q.Get("zero_precip_as_null"))
+    if s := q.Get("min_fields"); s != "" {
+        if opts.MinFields, err = strconv.Atoi(s); err != nil || opts.MinFields < 0 || opts.MinFields > len(opts.Variables) {
+            return nil, fmt.Errorf("min_fields must be between 0 and %d", len(opts.Variables))
+        }
+    }
+    opts.TimeFormat = q.Get("timeformat")
+    if opts.TimeFormat == "" {
+        opts.TimeFormat = "iso8601"
+    }
+    if opts.TimeFormat != "iso8601" && opts.TimeFormat != "unixtime" {
+        return nil, fmt.Errorf("unsupported timeformat %q", opts.TimeFormat)
+    }
+
+    opts.DateAsTimestamp, _ = strconv.ParseBool(q.Get("date_as_timestamp"))
+    opts.IncludeElevation, _ = strconv.ParseBool(q.Get("include_elevation"))
+    opts.Provenance, _ = strconv.ParseBool(q.Get("provenance"))
+    opts.ReturnRows, _ = strconv.ParseBool(q.Get("return_rows"))
+    opts.Checksum, _ = strconv.ParseBool(q.Get("checksum"))
+    opts.OutOfBounds = q.Get("out_of_bounds")
+    if opts.OutOfBounds == "" {
+        opts.OutOfBounds = "log"
+    }
+    if opts.OutOfBounds != "log" && opts.OutOfBounds != "null" && opts.OutOfBounds != "reject" {
+        return nil, fmt.Errorf("unsupported out_of_bounds %q; use log, null, or reject", opts.OutOfBounds)
+    }
+    opts.SnowfallUnit = q.Get("snowfall_unit")
+    if opts.SnowfallUnit == "" {
+        opts.SnowfallUnit = "cm"
+    }
+    if opts.SnowfallUnit != "cm" && opts.SnowfallUnit != "mm" {
+        return nil, fmt.Errorf("unsupported snowfall_unit %q; use cm or mm", opts.SnowfallUnit)
+    }
+
+    opts.DatasetVersion = q.Get("dataset_version")
+    if len(opts.DatasetVersion) > maxDatasetVersionLength {
+        return nil, fmt.Errorf("dataset_version must be at most %d characters", maxDatasetVersionLength)
+    }
+
+    opts.ClientID = q.Get("client_id")
+    if opts.ClientID != "" && !clientIDPattern.MatchString(opts.ClientID) {
+        return nil, fmt.Errorf("client_id must be 1-64 letters, digits, dots, underscores, or hyphens")
+    }
+
+    opts.TableSuffix = q.Get("table_suffix")
+    if opts.TableSuffix != "" {
+        if !tableSuffixPattern.MatchString(opts.TableSuffix) {
+            return nil, fmt.Errorf("table_suffix may only contain letters, digits, and underscores")
+        }
+        if max(len(cfg.TableID), len(cfg.MonthlyTableID), len(cfg.ModelsTableID))+len(opts.TableSuffix) > maxTableIDLength {
+            return nil, fmt.Errorf("table_suffix makes the table name longer than %d characters", maxTableIDLength)
+        }
+    }
+
+    opts.PartitionDecorator, _ = strconv.ParseBool(q.Get("partition_decorator"))
+    opts.SchemaVersion = q.Get("schema_version")
+    if opts.SchemaVersion == "" {
+        opts.SchemaVersion = latestSchemaVersion
+    }
+    if _, ok := schemaVersions[opts.SchemaVersion]; !ok {
+        return nil, fmt.Errorf("unsupported schema_version %q", opts.SchemaVersion)
+    }
+    opts.WriteAPI = q.Get("write_api")
+    if opts.WriteAPI == "" {
+        opts.WriteAPI = "insert"
+    }
+    if opts.WriteAPI != "insert" && opts.WriteAPI != "storage" {
+        return nil, fmt.Errorf("unsupported write_api %q", opts.WriteAPI)
+    }
+
+    opts.Format = q.Get("format")
+    if opts.Format == "" {
+        opts.Format = "json"
+    }
+    switch {
+    case opts.Format != "json" && opts.Format != "influx" && opts.Format != "geojson" && opts.Format != "arrow":
+        return nil, fmt.Errorf("unsupported format %q", opts.Format)
+    case opts.Format != "json" && opts.Aggregate != "":
+        return nil, fmt.Errorf("format=%s does not support aggregate", opts.Format)
+    case opts.Format != "json" && opts.Sink != "none" && !opts.ReturnRows:
+        return nil, fmt.Errorf("format=%s requires sink=none or return_rows", opts.Format)
+    case opts.Preview > 0 && (opts.Format != "json" || opts.Aggregate != "" || opts.ModelLayout == "columns" || opts.ReturnRows):
+        return nil, fmt.Errorf("preview cannot be combined with format, aggregate, model_layout=columns, or return_rows")
+    case opts.Sink != "bigquery" && opts.Sink != "none":
+        return nil, fmt.Errorf("unsupported sink %q", opts.Sink)
+    case opts.Sink == "none" && (opts.Incremental || opts.SkipIfFresh || opts.Checksum):
+        return nil, fmt.Errorf("incremental, skip_if_fresh, and checksum require the bigquery sink")
+    case opts.ModelLayout != "rows" && opts.ModelLayout != "columns":
+        return nil, fmt.Errorf("unsupported model_layout %q", opts.ModelLayout)
+    case opts.Layout != "wide" && opts.Layout != "long":
+        return nil, fmt.Errorf("unsupported layout %q", opts.Layout)
+    case opts.Layout == "long" && (opts.Aggregate != "" || opts.ModelLayout == "columns" || opts.Rolling > 0 || opts.PartitionDecorator || opts.WriteAPI == "storage" || opts.SchemaVersion != latestSchemaVersion || opts.Format != "json" || opts.Preview > 0 || opts.Incremental || opts.SkipIfFresh || opts.Checksum):
+        return nil, fmt.Errorf("layout=long cannot be combined with aggregate, model_layout=columns, rolling, partition_decorator, write_api=storage, an older schema_version, format, preview, incremental, skip_if_fresh, or checksum")
+    case opts.ReverifyOlderThan > 0 && (opts.Sink != "bigquery" || opts.Incremental || opts.SkipIfFresh || opts.Aggregate != "" || len(opts.Models) > 0 || opts.Layout != "wide" || opts.Rolling > 0 || opts.PartitionDecorator || opts.WriteAPI == "storage" || opts.SchemaVersion != latestSchemaVersion || opts.Checksum || opts.ReturnRows):
+        return nil, fmt.Errorf("reverify_older_than cannot be combined with sink=none, incremental, skip_if_fresh, aggregate, models, layout=long, rolling, partition_decorator, write_api=storage, an older schema_version, checksum, or return_rows")
+    case len(opts.Models) > 0 && (opts.Aggregate != "" || opts.Incremental || opts.SkipIfFresh):
+        return nil, fmt.Errorf("models cannot be combined with aggregate, incremental, or skip_if_fresh")
+    case opts.PartitionDecorator && opts.StartDate != opts.EndDate:
+        return nil, fmt.Errorf("partition_decorator requires a single-day range")
+    case opts.PartitionDecorator && (cfg.PartitionField == "" || cfg.PartitionType != "DAY"):
+        return nil, fmt.Errorf("partition_decorator requires a table partitioned by day")
+    case opts.PartitionDecorator && (opts.Aggregate != "" || len(opts.Models) > 0 || opts.Incremental || opts.Sink != "bigquery"):
+        return nil, fmt.Errorf("partition_decorator cannot be combined with aggregate, models, incremental, or sink=none")
+    case opts.PartitionDecorator && (multi || opts.Grid != nil || opts.CoordsGCSURI != ""):
+        return nil, fmt.Errorf("partition_decorator supports a single location, as it replaces the day's partition for every coordinate")
+    case opts.Rolling > 0 && (opts.Aggregate != "" || opts.ModelLayout == "columns" || opts.PartitionDecorator || opts.WriteAPI == "storage" || opts.SchemaVersion != latestSchemaVersion):
+        return nil, fmt.Errorf("rolling cannot be combined with aggregate, model_layout=columns, partition_decorator, write_api=storage, or an older schema_version")
+    case opts.SchemaVersion != latestSchemaVersion && (opts.Aggregate != "" || opts.ModelLayout == "columns" || opts.PartitionDecorator || opts.WriteAPI == "storage"):
+        return nil, fmt.Errorf("schema_version %s cannot be combined with aggregate, model_layout=columns, partition_decorator, or write_api=storage", opts.SchemaVersion)
+    case opts.WriteAPI == "storage" && (opts.Aggregate != "" || opts.ModelLayout == "columns" || opts.PartitionDecorator):
+        return nil, fmt.Errorf("write_api=storage cannot be combined with aggregate, model_layout=columns, or partition_decorator")
+    case opts.NodataSentinel != nil && opts.Aggregate != "":
+        return nil, fmt.Errorf("nodata_sentinel cannot be combined with aggregate")
+    case len(opts.Models) > 0 && opts.ModelLayout == "columns" && opts.Format != "json":
+        return nil, fmt.Errorf("model_layout=columns requires format=json")
+    }
+    return opts, nil
+}
+
+// parseDateRange applies explicit start/end dates, or a past_days window which overrides them.
+func (o *requestOptions) parseDateRange(start, end, pastDays string) error {
+    if pastDays != "" {
+        n, err := strconv.Atoi(pastDays)
+        if err != nil || n < 1 || n > maxPastDays[o.Mode] {
+            return fmt.Errorf("past_days must be between 1 and %d in %s mode", maxPastDays[o.Mode], o.Mode)
+        }
+        // The window is the last n days, ending today.
+        today := now()
+        o.StartDate = today.AddDate(0, 0, -(n - 1)).Format(dateLayout)
+        o.EndDate = today.Format(dateLayout)
+        return nil
+    }
+
+    if start != "" {
+        if _, err := time.Parse(dateLayout, start); err != nil {
+            return fmt.Errorf("invalid start_date %q", start)
+        }
+        o.StartDate = start
+    }
+    if end != "" {
+        if _, err := time.Parse(dateLayout, end); err != nil {
+            return fmt.Errorf("invalid end_date %q", end)
+        }
+        o.EndDate = end
+    }
+    if o.StartDate > o.EndDate {
+        return fmt.Errorf("start_date %s is after end_date %s", o.StartDate, o.EndDate)
+    }
+    return nil
+}
+
+// parseMonths parses the comma-separated months parameter, 1 for January through 12.
+func parseMonths(param string) (map[time.Month]bool, error) {
+    var months map[time.Month]bool
+    for _, s := range splitList(param) {
+        m, err := strconv.Atoi(s)
+        if err != nil || m < 1 || m > 12 {
+            return nil, fmt.Errorf("invalid month %q; months must be between 1 and 12", s)
+        }
+        if months == nil {
+            months = make(map[time.Month]bool)
+        }
+        months[time.Month(m)] = true
+    }
+    return months, nil
+}
+
+// parseRound parses the round parameter, falling back to the configured default.
+func parseRound(s string) (int, error) {
+    if s == "" {
+        return cfg.DefaultRound, nil
+    }
+    n, err := strconv.Atoi(s)
+    if err != nil || n < 0 || n > 10 {
+        return 0, fmt.Errorf("round must be between 0 and 10")
+    }
+    return n, nil
+}
+
+// dailyTable returns the daily table the request reads and writes: the routed table or the
+// default one, including any table_suffix.
+func (o *requestOptions) dailyTable() string {
+    if o.Table != "" {
+        return o.Table + o.TableSuffix
+    }
+    return cfg.TableID + o.TableSuffix
+}
@@ -0,0 +1,493 @@
+package main
+
+import (
+    "net/http"
+    "net/http/httptest"
+    "net/url"
+    "reflect"
+    "strings"
+    "testing"
+    "time"
+)
+
+// fixClock makes now return the given instant for the rest of the test.
+func fixClock(t *testing.T, at time.Time) {
+    t.Helper()
+    saved := now
+    now = func() time.Time { return at }
+    t.Cleanup(func() { now = saved })
+}
+
+// floatPtr returns a pointer to v.
+func floatPtr(v float64) *float64 {
+    return &v
+}
+
+// mustParseOptions parses the ingestion parameters in query, failing the test on error.
+func mustParseOptions(t testing.TB, query string) *requestOptions {
+    t.Helper()
+    q, err := url.ParseQuery(query)
+    if err != nil {
+        t.Fatal(err)
+    }
+    opts, err := parseQueryOptions(q, false)
+    if err != nil {
+        t.Fatalf("parseQueryOptions(%q) failed: %v", query, err)
+    }
+    return opts
+}
+
+// parseOptionsError parses the ingestion parameters in query and returns the error.
+func parseOptionsError(t *testing.T, query string) error {
+    t.Helper()
+    q, err := url.ParseQuery(query)
+    if err != nil {
+        t.Fatal(err)
+    }
+    _, err = parseQueryOptions(q, false)
+    return err
+}
+
+func TestPastDays(t *testing.T) {
+    fixClock(t, time.Date(2024, 6, 30, 15, 0, 0, 0, time.UTC))
+    tests := []struct {
+        name      string
+        query     string
+        wantStart string
+        wantEnd   string
+        wantErr   bool
+    }{
+        {"one day is today", "past_days=1", "2024-06-30", "2024-06-30", false},
+        {"a week ending today", "past_days=7", "2024-06-24", "2024-06-30", false},
+        {"overrides explicit dates", "past_days=2&start_date=2020-01-01&end_date=2020-12-31", "2024-06-29", "2024-06-30", false},
+        {"forecast limit", "mode=forecast&past_days=92", "2024-03-31", "2024-06-30", false},
+        {"beyond the forecast limit", "mode=forecast&past_days=93", "", "", true},
+        {"archive reaches further back", "past_days=3650", "2014-07-04", "2024-06-30", false},
+        {"zero", "past_days=0", "", "", true},
+        {"not a number", "past_days=week", "", "", true},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            query := "latitude=52.52&longitude=13.41&" + tt.query
+            if tt.wantErr {
+                if err := parseOptionsError(t, query); err == nil {
+                    t.Error("parseQueryOptions() succeeded, want an error")
+                }
+                return
+            }
+            opts := mustParseOptions(t, query)
+            if opts.StartDate != tt.wantStart || opts.EndDate != tt.wantEnd {
+                t.Errorf("range = %s..%s, want %s..%s", opts.StartDate, opts.EndDate, tt.wantStart, tt.wantEnd)
+            }
+        })
+    }
+}
+
+func TestDefaultCoordinatesFallback(t *testing.T) {
+    tests := []struct {
+        name     string
+        defaults *Location
+        query    string
+        wantLat  float64
+        wantLon  float64
+        wantErr  bool
+    }{
+        {"omitted uses the default", &Location{Latitude: 48.85, Longitude: 2.35}, "", 48.85, 2.35, false},
+        {"explicit coordinates win", &Location{Latitude: 48.85, Longitude: 2.35}, "latitude=52.52&longitude=13.41", 52.52, 13.41, false},
+        {"one coordinate is not replaced", &Location{Latitude: 48.85, Longitude: 2.35}, "latitude=52.52", 0, 0, true},
+        {"no default configured", nil, "", 0, 0, true},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            withConfig(t, func(c *Config) { c.DefaultCoordinates = tt.defaults })
+            if tt.wantErr {
+                if err := parseOptionsError(t, tt.query); err == nil {
+                    t.Error("parseQueryOptions() succeeded, want an error")
+                }
+                return
+            }
+            opts := mustParseOptions(t, tt.query)
+            if opts.Latitude != tt.wantLat || opts.Longitude != tt.wantLon {
+                t.Errorf("coordinates = %v,%v; want %v,%v", opts.Latitude, opts.Longitude, tt.wantLat, tt.wantLon)
+            }
+        })
+    }
+}
+
+func TestParseNodataSentinel(t *testing.T) {
+    tests := []struct {
+        query   string
+        want    *float64
+        wantErr bool
+    }{
+        {"", nil, false},
+        {"nodata_sentinel=-999", floatPtr(-999), false},
+        {"nodata_sentinel=NaN", nil, true},
+        {"nodata_sentinel=none", nil, true},
+        {"nodata_sentinel=-999&aggregate=monthly", nil, true},
+    }
+    for _, tt := range tests {
+        t.Run(tt.query, func(t *testing.T) {
+            query := "latitude=52.52&longitude=13.41&" + tt.query
+            if tt.wantErr {
+                if err := parseOptionsError(t, query); err == nil {
+                    t.Error("parseQueryOptions() succeeded, want an error")
+                }
+                return
+            }
+            got := mustParseOptions(t, query).NodataSentinel
+            if (got == nil) != (tt.want == nil) || (got != nil && *got != *tt.want) {
+                t.Errorf("NodataSentinel = %v, want %v", got, tt.want)
+            }
+        })
+    }
+}
+
+func TestTableSuffix(t *testing.T) {
+    tests := []struct {
+        name    string
+        query   string
+        routed  string
+        want    string
+        wantErr bool
+    }{
+        {"no suffix", "", "", "daily_weather", false},
+        {"suffix", "table_suffix=_2024", "", "daily_weather_2024", false},
+        {"routed table keeps the suffix", "table_suffix=_eu", "weather_europe", "weather_europe_eu", false},
+        {"invalid characters", "table_suffix=-2024", "", "", true},
+        {"injection", "table_suffix=x`;DROP", "", "", true},
+        {"too long", "table_suffix=" + strings.Repeat("x", maxTableIDLength), "", "", true},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            withConfig(t, func(c *Config) { c.TableID = "daily_weather" })
+            query := "latitude=52.52&longitude=13.41&" + tt.query
+            if tt.wantErr {
+                if err := parseOptionsError(t, url.PathEscape(query)); err == nil {
+                    t.Error("parseQueryOptions() succeeded, want an error")
+                }
+                return
+            }
+            opts := mustParseOptions(t, query)
+            opts.Table = tt.routed
+            if got := opts.dailyTable(); got != tt.want {
+                t.Errorf("dailyTable() = %q, want %q", got, tt.want)
+            }
+        })
+    }
+}
+
+func TestParseTimeFormat(t *testing.T) {
+    tests := []struct {
+        query   string
+        want    string
+        wantErr bool
+    }{
+        {"", "iso8601", false},
+        {"timeformat=iso8601", "iso8601", false},
+        {"timeformat=unixtime", "unixtime", false},
+        {"timeformat=UNIXTIME", "", true},
+        {"timeformat=rfc3339", "", true},
+    }
+    for _, tt := range tests {
+        t.Run(tt.query, func(t *testing.T) {
+            query := "latitude=52.52&longitude=13.41&" + tt.query
+            if tt.wantErr {
+                if err := parseOptionsError(t, query); err == nil {
+                    t.Error("parseQueryOptions() succeeded, want an error")
+                }
+                return
+            }
+            if got := mustParseOptions(t, query).TimeFormat; got != tt.want {
+                t.Errorf("TimeFormat = %q, want %q", got, tt.want)
+            }
+        })
+    }
+}
+
+func TestPartitionDecoratorOptions(t *testing.T) {
+    tests := []struct {
+        name    string
+        query   string
+        multi   bool
+        wantErr bool
+    }{
+        {"single location and day", "latitude=52.52&longitude=13.41&start_date=2024-01-01&end_date=2024-01-01", false, false},
+        {"several days", "latitude=52.52&longitude=13.41&start_date=2024-01-01&end_date=2024-01-02", false, true},
+        {"posted locations", "start_date=2024-01-01&end_date=2024-01-01", true, true},
+        {"grid", "min_lat=52&min_lon=13&max_lat=53&max_lon=14&step=0.5&start_date=2024-01-01&end_date=2024-01-01", false, true},
+        {"coordinates file", "coords_gcs_uri=gs://coords/daily.csv&start_date=2024-01-01&end_date=2024-01-01", false, true},
+        {"aggregate", "latitude=52.52&longitude=13.41&start_date=2024-01-01&end_date=2024-01-01&aggregate=monthly", false, true},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            q, err := url.ParseQuery(tt.query + "&partition_decorator=true")
+            if err != nil {
+                t.Fatal(err)
+            }
+            opts, err := parseQueryOptions(q, tt.multi)
+            if (err != nil) != tt.wantErr {
+                t.Fatalf("err = %v, wantErr %v", err, tt.wantErr)
+            }
+            if !tt.wantErr && !opts.PartitionDecorator {
+                t.Error("PartitionDecorator not set")
+            }
+        })
+    }
+}
+
+func TestParseMinFields(t *testing.T) {
+    tests := []struct {
+        query   string
+        want    int
+        wantErr bool
+    }{
+        {"", 0, false},
+        {"min_fields=3", 3, false},
+        {"min_fields=5", 5, false},
+        {"min_fields=6", 0, true},
+        {"min_fields=6&daily=weather_code", 6, false},
+        {"min_fields=-1", 0, true},
+        {"min_fields=two", 0, true},
+    }
+    for _, tt := range tests {
+        t.Run(tt.query, func(t *testing.T) {
+            query := "latitude=52.52&longitude=13.41&" + tt.query
+            if tt.wantErr {
+                if err := parseOptionsError(t, query); err == nil {
+                    t.Error("parseQueryOptions() succeeded, want an error")
+                }
+                return
+            }
+            if got := mustParseOptions(t, query).MinFields; got != tt.want {
+                t.Errorf("MinFields = %d, want %d", got, tt.want)
+            }
+        })
+    }
+}
+
+func TestParseDatasetVersion(t *testing.T) {
+    tests := []struct {
+        name    string
+        value   string
+        wantErr bool
+    }{
+        {"unset", "", false},
+        {"label", "era5-2024-06", false},
+        {"at the limit", strings.Repeat("v", maxDatasetVersionLength), false},
+        {"too long", strings.Repeat("v", maxDatasetVersionLength+1), true},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            query := "latitude=52.52&longitude=13.41&dataset_version=" + tt.value
+            if tt.wantErr {
+                if err := parseOptionsError(t, query); err == nil {
+                    t.Error("parseQueryOptions() succeeded, want an error")
+                }
+                return
+            }
+            if got := mustParseOptions(t, query).DatasetVersion; got != tt.value {
+                t.Errorf("DatasetVersion = %q, want %q", got, tt.value)
+            }
+        })
+    }
+}
+
+func TestParseMonths(t *testing.T) {
+    tests := []struct {
+        param   string
+        want    map[time.Month]bool
+        wantErr bool
+    }{
+        {"", nil, false},
+        {"7", map[time.Month]bool{time.July: true}, false},
+        {"12, 1,2", map[time.Month]bool{time.December: true, time.January: true, time.February: true}, false},
+        {"7,7", map[time.Month]bool{time.July: true}, false},
+        {"0", nil, true},
+        {"13", nil, true},
+        {"jul", nil, true},
+    }
+    for _, tt := range tests {
+        t.Run(tt.param, func(t *testing.T) {
+            got, err := parseMonths(tt.param)
+            if (err != nil) != tt.wantErr {
+                t.Fatalf("err = %v, wantErr %v", err, tt.wantErr)
+            }
+            if !reflect.DeepEqual(got, tt.want) {
+                t.Errorf("parseMonths(%q) = %v, want %v", tt.param, got, tt.want)
+            }
+        })
+    }
+}
+
+func TestDefaultDateRange(t *testing.T) {
+    tests := []struct {
+        name      string
+        now       time.Time
+        query     string
+        wantStart string
+        wantEnd   string
+    }{
+        {"twenty years back", time.Date(2024, 6, 30, 15, 0, 0, 0, time.UTC), "", "2004-06-30", "2024-06-30"},
+        {"leap day", time.Date(2024, 2, 29, 0, 0, 0, 0, time.UTC), "", "2004-02-29", "2024-02-29"},
+        {"leap day to a common year", time.Date(2120, 2, 29, 0, 0, 0, 0, time.UTC), "", "2100-03-01", "2120-02-29"},
+        {"explicit start keeps the default end", time.Date(2024, 6, 30, 0, 0, 0, 0, time.UTC), "&start_date=2024-01-01", "2024-01-01", "2024-06-30"},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            fixClock(t, tt.now)
+            opts := mustParseOptions(t, "latitude=52.52&longitude=13.41"+tt.query)
+            if opts.StartDate != tt.wantStart || opts.EndDate != tt.wantEnd {
+                t.Errorf("range = %s..%s, want %s..%s", opts.StartDate, opts.EndDate, tt.wantStart, tt.wantEnd)
+            }
+        })
+    }
+}
+
+func TestParseRequestOptionsClientID(t *testing.T) {
+    tests := []struct {
+        name    string
+        query   string
+        header  string
+        want    string
+        wantErr bool
+    }{
+        {"not given", "", "", "", false},
+        {"parameter", "&client_id=team-a", "", "team-a", false},
+        {"header", "", "team.b", "team.b", false},
+        {"parameter takes precedence", "&client_id=team-a", "team.b", "team-a", false},
+        {"at the limit", "&client_id=" + strings.Repeat("c", 64), "", strings.Repeat("c", 64), false},
+        {"too long", "&client_id=" + strings.Repeat("c", 65), "", "", true},
+        {"invalid parameter", "&client_id=team%20a", "", "", true},
+        {"invalid header", "", "team;drop", "", true},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            req := httptest.NewRequest(http.MethodPost, "/?latitude=52.52&longitude=13.41"+tt.query, nil)
+            if tt.header != "" {
+                req.Header.Set("X-Client-Id", tt.header)
+            }
+            opts, err := parseRequestOptions(req)
+            if (err != nil) != tt.wantErr {
+                t.Fatalf("err = %v, wantErr %v", err, tt.wantErr)
+            }
+            if !tt.wantErr && opts.ClientID != tt.want {
+                t.Errorf("ClientID = %q, want %q", opts.ClientID, tt.want)
+            }
+        })
+    }
+}
+
+func TestParseGDD(t *testing.T) {
+    tests := []struct {
+        query          string
+        wantBase       *float64
+        wantCumulative bool
+        wantErr        bool
+    }{
+        {"", nil, false, false},
+        {"gdd_base=10", floatPtr(10), false, false},
+        {"gdd_base=-2.5&gdd_cumulative=true", floatPtr(-2.5), true, false},
+        {"gdd_base=warm", nil, false, true},
+        {"gdd_base=NaN", nil, false, true},
+        {"gdd_base=Inf", nil, false, true},
+        {"gdd_cumulative=true", nil, false, true},
+    }
+    for _, tt := range tests {
+        t.Run(tt.query, func(t *testing.T) {
+            query := "latitude=52.52&longitude=13.41&" + tt.query
+            if tt.wantErr {
+                if err := parseOptionsError(t, query); err == nil {
+                    t.Error("parseQueryOptions() succeeded, want an error")
+                }
+                return
+            }
+            opts := mustParseOptions(t, query)
+            if !reflect.DeepEqual(opts.GDDBase, tt.wantBase) || opts.GDDCumulative != tt.wantCumulative {
+                t.Errorf("gdd = %v/%v, want %v/%v", opts.GDDBase, opts.GDDCumulative, tt.wantBase, tt.wantCumulative)
+            }
+        })
+    }
+}
+
+func TestParseSnowfallUnit(t *testing.T) {
+    tests := []struct {
+        value   string
+        want    string
+        wantErr bool
+    }{
+        {"", "cm", false},
+        {"cm", "cm", false},
+        {"mm", "mm", false},
+        {"MM", "", true},
+        {"in", "", true},
+    }
+    for _, tt := range tests {
+        t.Run(tt.value, func(t *testing.T) {
+            query := "latitude=52.52&longitude=13.41&snowfall_unit=" + tt.value
+            if tt.wantErr {
+                if err := parseOptionsError(t, query); err == nil {
+                    t.Error("parseQueryOptions() succeeded, want an error")
+                }
+                return
+            }
+            if got := mustParseOptions(t, query).SnowfallUnit; got != tt.want {
+                t.Errorf("SnowfallUnit = %q, want %q", got, tt.want)
+            }
+        })
+    }
+}
+
+func TestParseGeoJSONFormat(t *testing.T) {
+    tests := []struct {
+        query   string
+        wantErr bool
+    }{
+        {"format=geojson&sink=none", false},
+        {"format=geojson&return_rows=true", false},
+        {"format=geojson", true},
+        {"format=geojson&sink=none&aggregate=monthly", true},
+        {"format=GeoJSON&sink=none", true},
+    }
+    for _, tt := range tests {
+        t.Run(tt.query, func(t *testing.T) {
+            query := "latitude=52.52&longitude=13.41&" + tt.query
+            if tt.wantErr {
+                if err := parseOptionsError(t, query); err == nil {
+                    t.Error("parseQueryOptions() succeeded, want an error")
+                }
+                return
+            }
+            if got := mustParseOptions(t, query).Format; got != "geojson" {
+                t.Errorf("Format = %q, want geojson", got)
+            }
+        })
+    }
+}
+
+func TestParseReturnRows(t *testing.T) {
+    tests := []struct {
+        query   string
+        want    bool
+        wantErr bool
+    }{
+        {"", false, false},
+        {"&return_rows=true", true, false},
+        {"&return_rows=true&format=influx", true, false},
+        {"&format=influx", false, true},
+    }
+    for _, tt := range tests {
+        t.Run(tt.query, func(t *testing.T) {
+            query := "latitude=52.52&longitude=13.41" + tt.query
+            if tt.wantErr {
+                if err := parseOptionsError(t, query); err == nil {
+                    t.Error("parseQueryOptions() succeeded, want an error")
+                }
+                return
+            }
+            if got := mustParseOptions(t, query).ReturnRows; got != tt.want {
+                t.Errorf("ReturnRows = %v, want %v", got, tt.want)
+            }
+        })
+    }
+}
@@ -0,0 +1,413 @@
+package main
+
+import (
+    "context"
+    "encoding/base64"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "log/slog"
+    "math"
+    "net/http"
+    "net/url"
+    "slices"
+    "strconv"
+    "strings"
+
+    "cloud.google.com/go/bigquery"
+    "cloud.google.com/go/civil"
+    "google.golang.org/api/iterator"
+)
+
+const (
+    defaultQueryPageSize = 100
+    maxQueryPageSize     = 1000
+)
+
+// queryJobLabel labels the jobs /query starts, so a page token can only reopen them.
+const queryJobLabel = "daily_weather_endpoint"
+
+// errInvalidPageToken is returned for a page token that does not point at a /query job.
+var errInvalidPageToken = errors.New("invalid page_token")
+
+// QueryResponse is the JSON body returned by the /query endpoint.
+type QueryResponse struct {
+    Rows          []map[string]bigquery.Value `json:"rows"`
+    NextPageToken string                      `json:"next_page_token,omitempty"`
+}
+
+// queryCursor identifies a page of a query job's results so paging can resume
+// across requests without re-running the query.
+type queryCursor struct {
+    JobID    string `json:"job"`
+    Location string `json:"loc"`
+    Token    string `json:"tok"`
+}
+
+// queryWeather reads stored rows for a coordinate, one page at a time.
+func queryWeather(w http.ResponseWriter, r *http.Request) {
+    if r.Method != http.MethodGet {
+        http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+        return
+    }
+    ctx := r.Context()
+    q := r.URL.Query()
+
+    pageSize, err := parsePageSize(q.Get("limit"))
+    if err != nil {
+        http.Error(w, err.Error(), http.StatusBadRequest)
+        return
+    }
+
+    client, err := newBigQueryClient(ctx)
+    if err != nil {
+        slog.Error("Failed to create BigQuery client", "error", err)
+        http.Error(w, "BigQuery error", http.StatusInternalServerError)
+        return
+    }
+    defer client.Close()
+
+    var it *bigquery.RowIterator
+    var cursor queryCursor
+    if token := q.Get("page_token"); token != "" {
+        if cursor, err = decodeCursor(token); err != nil {
+            http.Error(w, "Invalid page_token", http.StatusBadRequest)
+            return
+        }
+        it, err = readJob(ctx, client, cursor)
+        if errors.Is(err, errInvalidPageToken) {
+            http.Error(w, "Invalid page_token", http.StatusBadRequest)
+            return
+        }
+    } else {
+        latitude, longitude, perr := parseCoordinates(q)
+        if perr != nil {
+            http.Error(w, perr.Error(), http.StatusBadRequest)
+            return
+        }
+        fields, perr := parseFields(q.Get("fields"))
+        if perr != nil {
+            http.Error(w, perr.Error(), http.StatusBadRequest)
+            return
+        }
+        defaults, perr := parseCoalesce(q.Get("coalesce"), fields)
+        if perr != nil {
+            http.Error(w, perr.Error(), http.StatusBadRequest)
+            return
+        }
+        startDate, endDate, perr := parseDateBounds(q)
+        if perr != nil {
+            http.Error(w, perr.Error(), http.StatusBadRequest)
+            return
+        }
+        it, cursor, err = runQuery(ctx, client, fields, defaults, latitude, longitude, startDate, endDate)
+    }
+    if err != nil {
+        slog.Error("Failed to query data", "error", err)
+        http.Error(w, "Failed to query data", http.StatusInternalServerError)
+        return
+    }
+
+    resp, err := readPage(it, pageSize, cursor)
+    if err != nil {
+        slog.Error("Failed to read query results", "error", err)
+        http.Error(w, "Failed to query data", http.StatusInternalServerError)
+        return
+    }
+    writeJSON(w, http.StatusOK, resp)
+}
+
+// runQuery starts the query for a coordinate and optional date range, selecting the given
+// columns (all when empty) with NULLs of the coalesced columns replaced by their defaults,
+// and returns the result iterator and a cursor pointing at its first page. Stored grid
+// points within cfg.IncrementalTolerance degrees of the coordinate match, since Open-Meteo
+// snaps requests to its grid.
+func runQuery(ctx context.Context, client *bigquery.Client, fields []string, defaults []columnDefault, latitude, longitude float64, startDate, endDate civil.Date) (*bigquery.RowIterator, queryCursor, error) {
+    params := []bigquery.QueryParameter{
+        {Name: "latitude", Value: latitude},
+        {Name: "longitude", Value: longitude},
+        {Name: "tolerance", Value: cfg.IncrementalTolerance},
+    }
+    coalesced := make(map[string]string, len(defaults))
+    for i, d := range defaults {
+        name := fmt.Sprintf("coalesce_%d", i)
+        coalesced[d.Column] = fmt.Sprintf("IFNULL(`%s`, @%s) AS `%s`", d.Column, name, d.Column)
+        params = append(params, bigquery.QueryParameter{Name: name, Value: d.Value})
+    }
+
+    var selectList string
+    if len(fields) > 0 {
+        columns := make([]string, len(fields))
+        for i, f := range fields {
+            columns[i] = "`" + f + "`"
+            if expr, ok := coalesced[f]; ok {
+                columns[i] = expr
+            }
+        }
+        selectList = strings.Join(columns, ", ")
+    } else {
+        selectList = "*"
+        if len(defaults) > 0 {
+            exprs := make([]string, len(defaults))
+            for i, d := range defaults {
+                exprs[i] = coalesced[d.Column]
+            }
+            selectList = "* REPLACE (" + strings.Join(exprs, ", ") + ")"
+        }
+    }
+    sql := fmt.Sprintf("SELECT %s FROM %s WHERE ABS(latitude - @latitude) <= @tolerance AND ABS(longitude - @longitude) <= @tolerance", selectList, queryTable())
+    sql += dateBoundsFilter(startDate, endDate, &params)
+    sql += " ORDER BY date, latitude, longitude"
+
+    query := client.Query(sql)
+    query.Parameters = params
+    query.Labels = map[string]string{queryJobLabel: "query"}
+    job, err := query.Run(ctx)
+    if err != nil {
+        return nil, queryCursor{}, err
+    }
+    it, err := job.Read(ctx)
+    if err != nil {
+        return nil, queryCursor{}, err
+    }
+    return it, queryCursor{JobID: job.ID(), Location: job.Location()}, nil
+}
+
+// queryTable returns the quoted name of the table /query reads.
+func queryTable() string {
+    return fmt.Sprintf("`%s.%s.%s`", cfg.ProjectID, cfg.DatasetID, cfg.TableID)
+}
+
+// readJob reopens the results of an earlier query job. Page tokens come from the client, so
+// the job must be one runQuery started against the table, or errInvalidPageToken is
+// returned; otherwise a crafted token could read the results of any job in the project.
+func readJob(ctx context.Context, client *bigquery.Client, cursor queryCursor) (*bigquery.RowIterator, error) {
+    job, err := client.JobFromIDLocation(ctx, cursor.JobID, cursor.Location)
+    if isHTTPStatus(err, http.StatusNotFound) {
+        return nil, errInvalidPageToken
+    }
+    if err != nil {
+        return nil, err
+    }
+    config, err := job.Config()
+    if err != nil {
+        return nil, err
+    }
+    if !isQueryJob(config) {
+        return nil, errInvalidPageToken
+    }
+    return job.Read(ctx)
+}
+
+// isQueryJob reports whether config is that of a job runQuery started.
+func isQueryJob(config bigquery.JobConfig) bool {
+    qc, ok := config.(*bigquery.QueryConfig)
+    return ok && qc.Labels[queryJobLabel] == "query" && strings.Contains(qc.Q, " FROM "+queryTable()+" WHERE ")
+}
+
+// readPage reads one page from it and builds the response, including the token for the next page.
+func readPage(it *bigquery.RowIterator, pageSize int, cursor queryCursor) (*QueryResponse, error) {
+    var values [][]bigquery.Value
+    next, err := iterator.NewPager(it, pageSize, cursor.Token).NextPage(&values)
+    if err != nil {
+        return nil, err
+    }
+
+    resp := &QueryResponse{Rows: make([]map[string]bigquery.Value, 0, len(values))}
+    for _, row := range values {
+        m := make(map[string]bigquery.Value, len(row))
+        for i, field := range it.Schema {
+            if i < len(row) {
+                m[field.Name] = row[i]
+            }
+        }
+        resp.Rows = append(resp.Rows, m)
+    }
+    if next != "" {
+        cursor.Token = next
+        resp.NextPageToken = encodeCursor(cursor)
+    }
+    return resp, nil
+}
+
+// parsePageSize parses the limit parameter, applying the default and the maximum page size.
+func parsePageSize(s string) (int, error) {
+    if s == "" {
+        return defaultQueryPageSize, nil
+    }
+    n, err := strconv.Atoi(s)
+    if err != nil || n <= 0 {
+        return 0, fmt.Errorf("invalid limit %q", s)
+    }
+    return min(n, maxQueryPageSize), nil
+}
+
+// parseDateBounds parses the optional start_date and end_date parameters, which must be
+// YYYY-MM-DD dates in order. An omitted bound is returned as the zero date.
+func parseDateBounds(q url.Values) (civil.Date, civil.Date, error) {
+    var bounds [2]civil.Date
+    for i, param := range []string{"start_date", "end_date"} {
+        v := q.Get(param)
+        if v == "" {
+            continue
+        }
+        d, err := civil.ParseDate(v)
+        if err != nil {
+            return civil.Date{}, civil.Date{}, fmt.Errorf("invalid %s %q", param, v)
+        }
+        bounds[i] = d
+    }
+    if !bounds[0].IsZero() && !bounds[1].IsZero() && bounds[1].Before(bounds[0]) {
+        return civil.Date{}, civil.Date{}, fmt.Errorf("start_date must not be after end_date")
+    }
+    return bounds[0], bounds[1], nil
+}
+
+// dateBoundsFilter returns the conditions restricting the date column to the bounds that
+// are set, appending their parameters. The bounds are DATE parameters compared with the
+// column directly, so the query is pruned to the partitions in the range.
+func dateBoundsFilter(start, end civil.Date, params *[]bigquery.QueryParameter) string {
+    var sql string
+    if !start.IsZero() {
+        sql += " AND date >= @start_date"
+        *params = append(*params, bigquery.QueryParameter{Name: "start_date", Value: start})
+    }
+    if !end.IsZero() {
+        sql += " AND date <= @end_date"
+        *params = append(*params, bigquery.QueryParameter{Name: "end_date", Value: end})
+    }
+    return sql
+}
+
+// parseFields validates the comma-separated fields parameter against the table schema.
+// Later pages reuse the query job, so fields only applies to the first request.
+func parseFields(param string) ([]string, error) {
+    fields := splitList(param)
+    if len(fields) == 0 {
+        return nil, nil
+    }
+    meta, err := newTableMetadata()
+    if err != nil {
+        return nil, err
+    }
+    known := make(map[string]bool, len(meta.Schema))
+    for _, f := range meta.Schema {
+        known[f.Name] = true
+    }
+    for _, f := range fields {
+        if !known[f] {
+            return nil, fmt.Errorf("unknown field %q", f)
+        }
+    }
+    return fields, nil
+}
+
+// columnDefault replaces NULLs of Column in /query results with Value.
+type columnDefault struct {
+    Column string
+    Value  interface{}
+}
+
+// parseCoalesce validates the coalesce parameter, a comma-separated list of column:default
+// pairs such as rain_sum:0,weather_description:unknown. Each column must exist in the table
+// schema, and in fields when that is given, and its default must parse as the column's
+// type; date and timestamp columns cannot be coalesced. Like fields, it only applies to
+// the first page request.
+func parseCoalesce(param string, fields []string) ([]columnDefault, error) {
+    pairs := splitList(param)
+    if len(pairs) == 0 {
+        return nil, nil
+    }
+    meta, err := newTableMetadata()
+    if err != nil {
+        return nil, err
+    }
+    types := make(map[string]bigquery.FieldType, len(meta.Schema))
+    for _, f := range meta.Schema {
+        types[f.Name] = f.Type
+    }
+
+    defaults := make([]columnDefault, 0, len(pairs))
+    seen := make(map[string]bool, len(pairs))
+    for _, pair := range pairs {
+        column, raw, ok := strings.Cut(pair, ":")
+        if !ok {
+            return nil, fmt.Errorf("invalid coalesce entry %q; want column:default", pair)
+        }
+        typ, known := types[column]
+        if !known {
+            return nil, fmt.Errorf("unknown coalesce column %q", column)
+        }
+        if len(fields) > 0 && !slices.Contains(fields, column) {
+            return nil, fmt.Errorf("coalesce column %q is not in fields", column)
+        }
+        if seen[column] {
+            return nil, fmt.Errorf("duplicate coalesce column %q", column)
+        }
+        seen[column] = true
+
+        var value interface{}
+        switch typ {
+        case bigquery.FloatFieldType:
+            value, err = strconv.ParseFloat(raw, 64)
+        case bigquery.IntegerFieldType:
+            value, err = strconv.ParseInt(raw, 10, 64)
+        case bigquery.BooleanFieldType:
+            value, err = strconv.ParseBool(raw)
+        case bigquery.StringFieldType:
+            value = raw
+        default:
+            return nil, fmt.Errorf("coalesce column %q has type %s, which cannot be coalesced", column, typ)
+        }
+        if err != nil {
+            return nil, fmt.Errorf("invalid coalesce default %q for %s column %q", raw, typ, column)
+        }
+        if f, ok := value.(float64); ok && (math.IsNaN(f) || math.IsInf(f, 0)) {
+            return nil, fmt.Errorf("invalid coalesce default %q for %s column %q", raw, typ, column)
+        }
+        defaults = append(defaults, columnDefault{Column: column, Value: value})
+    }
+    return defaults, nil
+}
+
+// parseCoordinates parses the latitude and longitude query parameters.
+func parseCoordinates(q url.Values) (float64, float64, error) {
+    latStr, lonStr := q.Get("latitude"), q.Get("longitude")
+    if latStr == "" || lonStr == "" {
+        return 0, 0, fmt.Errorf("Missing latitude or longitude")
+    }
+    latitude, err := strconv.ParseFloat(latStr, 64)
+    if err != nil {
+        return 0, 0, fmt.Errorf("invalid latitude %q", latStr)
+    }
+    longitude, err := strconv.ParseFloat(lonStr, 64)
+    if err != nil {
+        return 0, 0, fmt.Errorf("invalid longitude %q", lonStr)
+    }
+    if err := validateCoordinates(latitude, longitude); err != nil {
+        return 0, 0, err
+    }
+    return latitude, longitude, nil
+}
+
+// encodeCursor serializes a cursor into an opaque page token.
+func encodeCursor(c queryCursor) string {
+    b, _ := json.Marshal(c)
+    return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// decodeCursor parses a page token produced by encodeCursor.
+func decodeCursor(token string) (queryCursor, error) {
+    var c queryCursor
+    b, err := base64.RawURLEncoding.DecodeString(token)
+    if err != nil {
+        return c, err
+    }
+    if err := json.Unmarshal(b, &c); err != nil {
+        return c, err
+    }
+    if c.JobID == "" {
+        return c, fmt.Errorf("missing job in page token")
+    }
+    return c, nil
+}
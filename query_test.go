@@ -0,0 +1,385 @@
+package main
+
+import (
+    "context"
+    "encoding/json"
+    "fmt"
+    "net/http"
+    "net/http/httptest"
+    "net/url"
+    "regexp"
+    "strings"
+    "testing"
+
+    "cloud.google.com/go/civil"
+)
+
+// storedRows answers a /query with n stored days of one coordinate.
+func storedRows(n int) func(q *fakeQuery) *fakeResult {
+    return func(q *fakeQuery) *fakeResult {
+        res := &fakeResult{Fields: fields("latitude", "FLOAT", "longitude", "FLOAT", "date", "DATE", "rain_sum", "FLOAT")}
+        for i := 0; i < n; i++ {
+            res.Rows = append(res.Rows, []interface{}{52.5, 13.4, civil.Date{Year: 2024, Month: 1, Day: 1}.AddDays(i).String(), float64(i)})
+        }
+        return res
+    }
+}
+
+// getQuery calls the /query handler and decodes its response.
+func getQuery(t *testing.T, params string) (int, QueryResponse) {
+    t.Helper()
+    rec := httptest.NewRecorder()
+    queryWeather(rec, httptest.NewRequest(http.MethodGet, "/query?"+params, nil))
+    var resp QueryResponse
+    if rec.Code == http.StatusOK {
+        if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+            t.Fatal(err)
+        }
+    }
+    return rec.Code, resp
+}
+
+func TestQueryPaging(t *testing.T) {
+    tests := []struct {
+        name      string
+        rows      int
+        limit     string
+        wantPages []int
+    }{
+        {"several pages", 5, "2", []int{2, 2, 1}},
+        {"exact pages", 4, "2", []int{2, 2}},
+        {"single page", 3, "10", []int{3}},
+        {"default page size", 150, "", []int{defaultQueryPageSize, 50}},
+        {"limit is capped", 1500, "5000", []int{maxQueryPageSize, 500}},
+        {"no rows", 0, "2", []int{0}},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            fake, _ := newFakeBigQuery(t)
+            fake.answer = storedRows(tt.rows)
+
+            params := "latitude=52.52&longitude=13.41"
+            if tt.limit != "" {
+                params += "&limit=" + tt.limit
+            }
+            var pages []int
+            var dates []string
+            for {
+                status, resp := getQuery(t, params)
+                if status != http.StatusOK {
+                    t.Fatalf("page %d: status %d", len(pages)+1, status)
+                }
+                pages = append(pages, len(resp.Rows))
+                for _, row := range resp.Rows {
+                    dates = append(dates, fmt.Sprint(row["date"]))
+                }
+                if resp.NextPageToken == "" {
+                    break
+                }
+                params = "page_token=" + url.QueryEscape(resp.NextPageToken)
+                if tt.limit != "" {
+                    params += "&limit=" + tt.limit
+                }
+            }
+            if fmt.Sprint(pages) != fmt.Sprint(tt.wantPages) {
+                t.Errorf("page sizes = %v, want %v", pages, tt.wantPages)
+            }
+            if len(dates) != tt.rows {
+                t.Errorf("read %d rows, want %d", len(dates), tt.rows)
+            }
+            if n := len(fake.received()); n != 1 {
+                t.Errorf("ran %d queries, want 1 reused across pages", n)
+            }
+        })
+    }
+}
+
+func TestQueryFilters(t *testing.T) {
+    tests := []struct {
+        name       string
+        params     string
+        wantStatus int
+        wantSQL    []string
+        wantTypes  map[string]string
+    }{
+        {
+            name:       "date range uses DATE parameters",
+            params:     "latitude=52.52&longitude=13.41&start_date=2024-01-01&end_date=2024-01-31",
+            wantStatus: http.StatusOK,
+            wantSQL:    []string{"date >= @start_date", "date <= @end_date", "ABS(latitude - @latitude) <= @tolerance"},
+            wantTypes:  map[string]string{"start_date": "DATE", "end_date": "DATE"},
+        },
+        {
+            name:       "open-ended range",
+            params:     "latitude=52.52&longitude=13.41&start_date=2024-01-01",
+            wantStatus: http.StatusOK,
+            wantSQL:    []string{"date >= @start_date"},
+            wantTypes:  map[string]string{"start_date": "DATE", "end_date": ""},
+        },
+        {name: "invalid start date", params: "latitude=52.52&longitude=13.41&start_date=2024-13-01", wantStatus: http.StatusBadRequest},
+        {name: "non-date end", params: "latitude=52.52&longitude=13.41&end_date=' OR 1=1", wantStatus: http.StatusBadRequest},
+        {name: "inverted range", params: "latitude=52.52&longitude=13.41&start_date=2024-02-01&end_date=2024-01-01", wantStatus: http.StatusBadRequest},
+        {name: "invalid limit", params: "latitude=52.52&longitude=13.41&limit=0", wantStatus: http.StatusBadRequest},
+        {name: "missing coordinates", params: "start_date=2024-01-01", wantStatus: http.StatusBadRequest},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            fake, _ := newFakeBigQuery(t)
+            fake.answer = storedRows(1)
+            status, _ := getQuery(t, url.PathEscape(tt.params))
+            if status != tt.wantStatus {
+                t.Fatalf("status = %d, want %d", status, tt.wantStatus)
+            }
+            if tt.wantStatus != http.StatusOK {
+                if n := len(fake.received()); n != 0 {
+                    t.Errorf("ran %d queries for an invalid request", n)
+                }
+                return
+            }
+            q := fake.received()[0]
+            for _, want := range tt.wantSQL {
+                if !strings.Contains(q.SQL, want) {
+                    t.Errorf("SQL %q lacks %q", q.SQL, want)
+                }
+            }
+            if strings.Contains(q.SQL, "CAST(date AS STRING)") {
+                t.Errorf("SQL %q casts the partitioning column", q.SQL)
+            }
+            for name, typ := range tt.wantTypes {
+                if got := q.paramType(name); got != typ {
+                    t.Errorf("parameter %s has type %q, want %q", name, got, typ)
+                }
+            }
+        })
+    }
+}
+
+func TestQueryPageTokenMustPointAtQueryJob(t *testing.T) {
+    fake, client := newFakeBigQuery(t)
+    fake.answer = storedRows(3)
+
+    // A job of some other query in the project, as a crafted token could name it.
+    other := client.Query("SELECT secret FROM `other.dataset.table`")
+    job, err := other.Run(context.Background())
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    tests := []struct {
+        name   string
+        cursor queryCursor
+        want   int
+    }{
+        {"other job", queryCursor{JobID: job.ID(), Location: job.Location()}, http.StatusBadRequest},
+        {"unknown job", queryCursor{JobID: "no_such_job"}, http.StatusBadRequest},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            status, _ := getQuery(t, "page_token="+url.QueryEscape(encodeCursor(tt.cursor)))
+            if status != tt.want {
+                t.Errorf("status = %d, want %d", status, tt.want)
+            }
+        })
+    }
+    if status, _ := getQuery(t, "page_token=not-base64!"); status != http.StatusBadRequest {
+        t.Errorf("malformed token: status = %d, want 400", status)
+    }
+}
+
+func TestParseDateBounds(t *testing.T) {
+    tests := []struct {
+        query     string
+        wantStart civil.Date
+        wantEnd   civil.Date
+        wantErr   bool
+    }{
+        {"", civil.Date{}, civil.Date{}, false},
+        {"start_date=2024-01-01&end_date=2024-01-01", civil.Date{Year: 2024, Month: 1, Day: 1}, civil.Date{Year: 2024, Month: 1, Day: 1}, false},
+        {"end_date=2024-02-29", civil.Date{}, civil.Date{Year: 2024, Month: 2, Day: 29}, false},
+        {"start_date=2023-02-29", civil.Date{}, civil.Date{}, true},
+        {"start_date=20240101", civil.Date{}, civil.Date{}, true},
+        {"start_date=2024-01-02&end_date=2024-01-01", civil.Date{}, civil.Date{}, true},
+    }
+    for _, tt := range tests {
+        t.Run(tt.query, func(t *testing.T) {
+            q, _ := url.ParseQuery(tt.query)
+            start, end, err := parseDateBounds(q)
+            if (err != nil) != tt.wantErr {
+                t.Fatalf("err = %v, wantErr %v", err, tt.wantErr)
+            }
+            if start != tt.wantStart || end != tt.wantEnd {
+                t.Errorf("parseDateBounds() = %v, %v; want %v, %v", start, end, tt.wantStart, tt.wantEnd)
+            }
+        })
+    }
+}
+
+func TestCursorRoundTrip(t *testing.T) {
+    tests := []queryCursor{
+        {JobID: "job_1", Location: "EU", Token: "2"},
+        {JobID: "job_2"},
+    }
+    for _, c := range tests {
+        got, err := decodeCursor(encodeCursor(c))
+        if err != nil || got != c {
+            t.Errorf("decodeCursor(encodeCursor(%+v)) = %+v, %v", c, got, err)
+        }
+    }
+    if _, err := decodeCursor(encodeCursor(queryCursor{Token: "2"})); err == nil {
+        t.Error("decodeCursor accepted a token without a job")
+    }
+}
+
+func TestQueryFields(t *testing.T) {
+    tests := []struct {
+        name       string
+        fields     string
+        wantStatus int
+        wantSelect string
+    }{
+        {"all columns by default", "", http.StatusOK, "SELECT * FROM"},
+        {"subset in the given order", "date,rain_sum", http.StatusOK, "SELECT `date`, `rain_sum` FROM"},
+        {"spaces are trimmed", " latitude , date ", http.StatusOK, "SELECT `latitude`, `date` FROM"},
+        {"unknown column", "date,secret", http.StatusBadRequest, ""},
+        {"injection attempt", "date` FROM x --", http.StatusBadRequest, ""},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            fake, _ := newFakeBigQuery(t)
+            fake.answer = storedRows(1)
+            status, _ := getQuery(t, "latitude=52.52&longitude=13.41&fields="+url.QueryEscape(tt.fields))
+            if status != tt.wantStatus {
+                t.Fatalf("status = %d, want %d", status, tt.wantStatus)
+            }
+            if tt.wantStatus != http.StatusOK {
+                if n := len(fake.received()); n != 0 {
+                    t.Errorf("ran %d queries for an invalid request", n)
+                }
+                return
+            }
+            if q := fake.received()[0]; !strings.HasPrefix(q.SQL, tt.wantSelect) {
+                t.Errorf("SQL %q does not start with %q", q.SQL, tt.wantSelect)
+            }
+        })
+    }
+}
+
+func TestParseFields(t *testing.T) {
+    tests := []struct {
+        param   string
+        want    []string
+        wantErr bool
+    }{
+        {"", nil, false},
+        {"date", []string{"date"}, false},
+        {"latitude,longitude,mean_temperature", []string{"latitude", "longitude", "mean_temperature"}, false},
+        {"Date", nil, true},
+        {"date,nope", nil, true},
+    }
+    for _, tt := range tests {
+        t.Run(tt.param, func(t *testing.T) {
+            got, err := parseFields(tt.param)
+            if (err != nil) != tt.wantErr {
+                t.Fatalf("err = %v, wantErr %v", err, tt.wantErr)
+            }
+            if fmt.Sprint(got) != fmt.Sprint(tt.want) {
+                t.Errorf("parseFields() = %q, want %q", got, tt.want)
+            }
+        })
+    }
+}
+
+func TestParseCoalesce(t *testing.T) {
+    tests := []struct {
+        name    string
+        param   string
+        fields  []string
+        want    []columnDefault
+        wantErr bool
+    }{
+        {"unset", "", nil, nil, false},
+        {"typed defaults", "rain_sum:0, weather_code:-1,weather_description:unknown", nil,
+            []columnDefault{{"rain_sum", 0.0}, {"weather_code", int64(-1)}, {"weather_description", "unknown"}}, false},
+        {"column in fields", "rain_sum:-9999", []string{"date", "rain_sum"}, []columnDefault{{"rain_sum", -9999.0}}, false},
+        {"column not in fields", "rain_sum:0", []string{"date"}, nil, true},
+        {"unknown column", "secret:0", nil, nil, true},
+        {"missing default", "rain_sum", nil, nil, true},
+        {"duplicate column", "rain_sum:0,rain_sum:1", nil, nil, true},
+        {"float default for an integer", "weather_code:1.5", nil, nil, true},
+        {"non-numeric default", "rain_sum:none", nil, nil, true},
+        {"non-finite default", "rain_sum:NaN", nil, nil, true},
+        {"date column", "date:2024-01-01", nil, nil, true},
+        {"timestamp column", "inserted_at:2024-01-01T00:00:00Z", nil, nil, true},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            got, err := parseCoalesce(tt.param, tt.fields)
+            if (err != nil) != tt.wantErr {
+                t.Fatalf("err = %v, wantErr %v", err, tt.wantErr)
+            }
+            if fmt.Sprintf("%#v", got) != fmt.Sprintf("%#v", tt.want) {
+                t.Errorf("parseCoalesce() = %#v, want %#v", got, tt.want)
+            }
+        })
+    }
+}
+
+func TestQueryCoalesce(t *testing.T) {
+    tests := []struct {
+        name       string
+        params     string
+        wantStatus int
+        wantSelect string
+        wantRain   interface{}
+        wantCode   interface{}
+    }{
+        {"NULLs are kept by default", "", http.StatusOK, "SELECT * FROM", nil, nil},
+        {"all columns", "&coalesce=rain_sum:0,weather_code:-1", http.StatusOK,
+            "SELECT * REPLACE (IFNULL(`rain_sum`, @coalesce_0) AS `rain_sum`, IFNULL(`weather_code`, @coalesce_1) AS `weather_code`) FROM", 0.0, -1.0},
+        {"selected fields", "&fields=date,rain_sum,weather_code&coalesce=rain_sum:-9999", http.StatusOK,
+            "SELECT `date`, IFNULL(`rain_sum`, @coalesce_0) AS `rain_sum`, `weather_code` FROM", -9999.0, nil},
+        {"invalid default", "&coalesce=rain_sum:none", http.StatusBadRequest, "", nil, nil},
+    }
+    ifnull := regexp.MustCompile("IFNULL\\(`(\\w+)`, @(\\w+)\\)")
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            fake, _ := newFakeBigQuery(t)
+            // The fake applies the IFNULL expressions of the query to its stored NULLs.
+            fake.answer = func(q *fakeQuery) *fakeResult {
+                values := map[string]interface{}{}
+                for _, m := range ifnull.FindAllStringSubmatch(q.SQL, -1) {
+                    values[m[1]] = q.param(m[2])
+                }
+                return &fakeResult{
+                    Fields: fields("date", "DATE", "rain_sum", "FLOAT", "weather_code", "INTEGER"),
+                    Rows:   [][]interface{}{{"2024-01-01", 1.5, 3}, {"2024-01-02", values["rain_sum"], values["weather_code"]}},
+                }
+            }
+            status, resp := getQuery(t, "latitude=52.52&longitude=13.41"+tt.params)
+            if status != tt.wantStatus {
+                t.Fatalf("status = %d, want %d", status, tt.wantStatus)
+            }
+            if tt.wantStatus != http.StatusOK {
+                if n := len(fake.received()); n != 0 {
+                    t.Errorf("ran %d queries for an invalid request", n)
+                }
+                return
+            }
+            if q := fake.received()[0]; !strings.HasPrefix(q.SQL, tt.wantSelect) {
+                t.Errorf("SQL %q does not start with %q", q.SQL, tt.wantSelect)
+            }
+            if len(resp.Rows) != 2 {
+                t.Fatalf("got %d rows, want 2", len(resp.Rows))
+            }
+            if got := resp.Rows[0]["rain_sum"]; got != 1.5 {
+                t.Errorf("stored rain_sum = %v, want 1.5", got)
+            }
+            if got := resp.Rows[1]["rain_sum"]; got != tt.wantRain {
+                t.Errorf("NULL rain_sum read as %v, want %v", got, tt.wantRain)
+            }
+            if got := resp.Rows[1]["weather_code"]; got != tt.wantCode {
+                t.Errorf("NULL weather_code read as %v, want %v", got, tt.wantCode)
+            }
+        })
+    }
+}
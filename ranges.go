@@ -0,0 +1,134 @@
+package main
+
+import (
+    "context"
+    "fmt"
+    "log/slog"
+    "time"
+
+    "github.com/google/uuid"
+)
+
+// maxRanges caps how many date ranges one job may request.
+const maxRanges = 50
+
+// dateRange is one window of a job that requests several disjoint ranges.
+type dateRange struct {
+    Start string `json:"start"`
+    End   string `json:"end"`
+}
+
+// rangeRows reports how many rows one requested range contributed, how much of it
+// Open-Meteo covered, and, when it contributed none, the earliest date hint if requested.
+type rangeRows struct {
+    dateRange
+    Rows         int     `json:"rows"`
+    Coverage     float64 `json:"coverage"`
+    EarliestDate string  `json:"earliest_date,omitempty"`
+}
+
+// validateRanges checks each range and its span, which must fit the maximum past_days
+// window of the mode.
+func validateRanges(errs *validationErrors, ranges []dateRange, mode string) {
+    if len(ranges) > maxRanges {
+        errs.add("ranges", "at most %d ranges are allowed", maxRanges)
+    }
+    for i, r := range ranges {
+        field := fmt.Sprintf("ranges[%d]", i)
+        start, err1 := time.Parse(dateLayout, r.Start)
+        end, err2 := time.Parse(dateLayout, r.End)
+        switch {
+        case err1 != nil || err2 != nil:
+            errs.add(field, "start and end must be YYYY-MM-DD dates")
+        case start.After(end):
+            errs.add(field, "start must not be after end")
+        case spanDays(start, end) > maxPastDays[mode]:
+            errs.add(field, "must span at most %d days in %s mode", maxPastDays[mode], mode)
+        }
+    }
+}
+
+// rangeSpan returns the earliest start and latest end of the ranges.
+func rangeSpan(ranges []dateRange) (string, string) {
+    start, end := ranges[0].Start, ranges[0].End
+    for _, r := range ranges[1:] {
+        start, end = min(start, r.Start), max(end, r.End)
+    }
+    return start, end
+}
+
+// fetchRangeRows fetches each requested range in turn and merges the rows, keeping one row
+// per date where ranges overlap, so the job is inserted once. Each range's truncated tail is
+// filled on its own, and its row count is taken after finishRows, so it matches what is stored.
+func fetchRangeRows(ctx context.Context, opts *requestOptions) (*ingestResult, []*WeatherData, error) {
+    result := &ingestResult{BatchID: uuid.NewString(), StartDate: opts.StartDate}
+    // owner maps each date to the first range that returned it.
+    owner := make(map[string]int)
+    var rows []*WeatherData
+    var covered, expected float64
+    for i, r := range opts.Ranges {
+        ro := *opts
+        ro.StartDate, ro.EndDate = r.Start, r.End
+        meteoResp, err := fetchOpenMeteo(ctx, &ro, ro.StartDate)
+        if err != nil {
+            return nil, nil, err
+        }
+        result.RateLimit = meteoResp.RateLimit
+        result.Endpoint = meteoResp.Endpoint
+        result.SourceURLs = append(result.SourceURLs, meteoResp.SourceURL)
+        if err := checkComplete(meteoResp.Daily, &ro); err != nil {
+            return nil, nil, err
+        }
+
+        var coverage float64
+        fetched := convertDaily(meteoResp, &ro, result.BatchID)
+        if len(fetched) > 0 {
+            fetched, coverage = fillTruncatedTail(ctx, &ro, ro.StartDate, fetched, result.BatchID)
+        }
+        for _, row := range fetched {
+            if _, ok := owner[row.Date]; !ok {
+                owner[row.Date] = i
+                rows = append(rows, row)
+            }
+        }
+        result.Ranges = append(result.Ranges, rangeRows{dateRange: r, Coverage: coverage})
+
+        start, err1 := time.Parse(dateLayout, r.Start)
+        last, err2 := time.Parse(dateLayout, expectedLastDate(&ro))
+        if err1 == nil && err2 == nil && !last.Before(start) {
+            days := float64(spanDays(start, last))
+            covered += coverage * days
+            expected += days
+        }
+    }
+    if expected > 0 {
+        result.Coverage = covered / expected
+    }
+
+    checksumFetched(result, rows, opts)
+    rows = finishRows(rows, opts)
+    for _, row := range rows {
+        result.Ranges[owner[row.Date]].Rows++
+    }
+    for i := range result.Ranges {
+        r := &result.Ranges[i]
+        slog.Info("Fetched date range", "start", r.Start, "end", r.End, "rows", r.Rows, "coverage", r.Coverage)
+        if r.Rows == 0 && opts.HintEarliest {
+            ro := *opts
+            ro.StartDate, ro.EndDate = r.Start, r.End
+            r.EarliestDate = earliestDateHint(ctx, &ro)
+        }
+    }
+    if len(rows) == 0 {
+        slog.Info("No data returned from API", "latitude", opts.Latitude, "longitude", opts.Longitude, "ranges", len(opts.Ranges))
+        result.Empty = true
+        // The hint of the range ending last is the hint for the whole job.
+        for _, r := range result.Ranges {
+            if r.End == opts.EndDate {
+                result.EarliestDate = r.EarliestDate
+            }
+        }
+        return result, nil, nil
+    }
+    return result, rows, nil
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+    "context"
+    "encoding/json"
+    "net/http"
+    "reflect"
+    "testing"
+    "time"
+)
+
+// serveDays answers each Open-Meteo request with the requested days from first on, one
+// value per core variable, capping each response at perResponse days when it is positive.
+func serveDays(t *testing.T, first string, perResponse int) http.HandlerFunc {
+    return func(w http.ResponseWriter, r *http.Request) {
+        start, err1 := time.Parse(dateLayout, max(r.URL.Query().Get("start_date"), first))
+        end, err2 := time.Parse(dateLayout, r.URL.Query().Get("end_date"))
+        if err1 != nil || err2 != nil {
+            t.Errorf("unexpected request %s", r.URL.RawQuery)
+        }
+        daily := map[string][]interface{}{"time": {}}
+        for day := start; !day.After(end); day = day.AddDate(0, 0, 1) {
+            if perResponse > 0 && len(daily["time"]) == perResponse {
+                break
+            }
+            daily["time"] = append(daily["time"], day.Format(dateLayout))
+            for _, v := range []string{"temperature_2m_min", "temperature_2m_max", "temperature_2m_mean", "rain_sum", "snowfall_sum"} {
+                daily[v] = append(daily[v], 1.0)
+            }
+        }
+        w.Header().Set("Content-Type", "application/json")
+        json.NewEncoder(w).Encode(map[string]interface{}{"latitude": 52.5, "longitude": 13.4, "daily": daily})
+    }
+}
+
+func TestValidateRanges(t *testing.T) {
+    tests := []struct {
+        name    string
+        ranges  []dateRange
+        wantErr bool
+    }{
+        {"valid", []dateRange{{"2024-01-01", "2024-01-31"}, {"2024-03-01", "2024-03-01"}}, false},
+        {"not a date", []dateRange{{"2024-01-01", "January"}}, true},
+        {"inverted", []dateRange{{"2024-02-01", "2024-01-01"}}, true},
+        {"too long for forecast", []dateRange{{"2024-01-01", "2024-12-31"}}, true},
+        {"too many", make([]dateRange, maxRanges+1), true},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            var errs validationErrors
+            validateRanges(&errs, tt.ranges, "forecast")
+            if (len(errs) > 0) != tt.wantErr {
+                t.Errorf("errors = %v, wantErr %v", errs, tt.wantErr)
+            }
+        })
+    }
+}
+
+func TestRangeSpan(t *testing.T) {
+    ranges := []dateRange{{"2024-03-01", "2024-03-10"}, {"2024-01-05", "2024-01-06"}, {"2024-02-01", "2024-04-01"}}
+    if start, end := rangeSpan(ranges); start != "2024-01-05" || end != "2024-04-01" {
+        t.Errorf("rangeSpan() = %s, %s; want 2024-01-05, 2024-04-01", start, end)
+    }
+}
+
+func TestFetchRangeRows(t *testing.T) {
+    tests := []struct {
+        name         string
+        query        string
+        first        string
+        perResponse  int
+        ranges       []dateRange
+        want         []rangeRows
+        wantCoverage float64
+        wantEarliest string
+    }{
+        {
+            name:         "overlaps are counted once",
+            ranges:       []dateRange{{"2024-01-01", "2024-01-03"}, {"2024-01-02", "2024-01-05"}},
+            want:         []rangeRows{{dateRange{"2024-01-01", "2024-01-03"}, 3, 1, ""}, {dateRange{"2024-01-02", "2024-01-05"}, 2, 1, ""}},
+            wantCoverage: 1,
+        },
+        {
+            name:         "counts are taken after finishRows",
+            query:        "&months=2",
+            ranges:       []dateRange{{"2024-01-30", "2024-02-02"}, {"2024-02-10", "2024-02-11"}},
+            want:         []rangeRows{{dateRange{"2024-01-30", "2024-02-02"}, 2, 1, ""}, {dateRange{"2024-02-10", "2024-02-11"}, 2, 1, ""}},
+            wantCoverage: 1,
+        },
+        {
+            name:         "truncated tails are left unfilled",
+            perResponse:  2,
+            ranges:       []dateRange{{"2024-01-01", "2024-01-03"}, {"2024-01-10", "2024-01-13"}},
+            want:         []rangeRows{{dateRange{"2024-01-01", "2024-01-03"}, 2, 2.0 / 3, ""}, {dateRange{"2024-01-10", "2024-01-13"}, 2, 0.5, ""}},
+            wantCoverage: 4.0 / 7,
+        },
+        {
+            name:         "truncated tails are refetched per range",
+            query:        "&refetch_tail=true",
+            perResponse:  2,
+            ranges:       []dateRange{{"2024-01-01", "2024-01-03"}, {"2024-01-10", "2024-01-13"}},
+            want:         []rangeRows{{dateRange{"2024-01-01", "2024-01-03"}, 3, 1, ""}, {dateRange{"2024-01-10", "2024-01-13"}, 4, 1, ""}},
+            wantCoverage: 1,
+        },
+        {
+            name:         "empty range gets a hint",
+            query:        "&hint_earliest=true",
+            first:        "2024-01-03",
+            ranges:       []dateRange{{"2023-12-01", "2023-12-05"}, {"2024-01-01", "2024-01-05"}},
+            want:         []rangeRows{{dateRange{"2023-12-01", "2023-12-05"}, 0, 0, "2024-01-03"}, {dateRange{"2024-01-01", "2024-01-05"}, 3, 0.6, ""}},
+            wantCoverage: 0.3,
+        },
+        {
+            name:         "all ranges empty",
+            query:        "&hint_earliest=true",
+            first:        "2024-01-03",
+            ranges:       []dateRange{{"2023-11-01", "2023-11-02"}, {"2023-12-01", "2023-12-05"}},
+            want:         []rangeRows{{dateRange{"2023-11-01", "2023-11-02"}, 0, 0, "2024-01-03"}, {dateRange{"2023-12-01", "2023-12-05"}, 0, 0, "2024-01-03"}},
+            wantEarliest: "2024-01-03",
+        },
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            fixClock(t, time.Date(2024, 6, 30, 12, 0, 0, 0, time.UTC))
+            stubOpenMeteo(t, serveDays(t, tt.first, tt.perResponse))
+            start, end := rangeSpan(tt.ranges)
+            opts := mustParseOptions(t, "latitude=52.52&longitude=13.41&start_date="+start+"&end_date="+end+tt.query)
+            opts.Ranges = tt.ranges
+
+            result, rows, err := fetchRangeRows(context.Background(), opts)
+            if err != nil {
+                t.Fatal(err)
+            }
+            if !reflect.DeepEqual(result.Ranges, tt.want) {
+                t.Errorf("Ranges = %+v, want %+v", result.Ranges, tt.want)
+            }
+            total := 0
+            for _, r := range tt.want {
+                total += r.Rows
+            }
+            if len(rows) != total || result.Empty != (total == 0) {
+                t.Errorf("got %d rows, empty %v; want %d", len(rows), result.Empty, total)
+            }
+            if diff := result.Coverage - tt.wantCoverage; diff > 1e-9 || diff < -1e-9 {
+                t.Errorf("Coverage = %v, want %v", result.Coverage, tt.wantCoverage)
+            }
+            if result.EarliestDate != tt.wantEarliest {
+                t.Errorf("EarliestDate = %q, want %q", result.EarliestDate, tt.wantEarliest)
+            }
+        })
+    }
+}
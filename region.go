@@ -0,0 +1,44 @@
+package main
+
+import (
+    "fmt"
+    "net/http"
+    "strconv"
+)
+
+// parseAllowedRegion parses ALLOWED_BBOX, "min_latitude,min_longitude,max_latitude,max_longitude",
+// returning nil when it is empty. Unlike table routes, an invalid box is not ignored: that would
+// silently lift the restriction, so a box containing nothing is returned with the error and
+// every coordinate is rejected instead.
+func parseAllowedRegion(s string) (*boundingBox, error) {
+    if s == "" {
+        return nil, nil
+    }
+    parts := splitList(s)
+    var v [4]float64
+    var err error
+    if len(parts) != len(v) {
+        err = fmt.Errorf("want 4 comma-separated numbers, got %d", len(parts))
+    }
+    for i := 0; err == nil && i < len(v); i++ {
+        v[i], err = strconv.ParseFloat(parts[i], 64)
+    }
+    box := &boundingBox{MinLatitude: v[0], MinLongitude: v[1], MaxLatitude: v[2], MaxLongitude: v[3]}
+    if err == nil && (box.MinLatitude > box.MaxLatitude || box.MinLongitude > box.MaxLongitude) {
+        err = fmt.Errorf("inverted bbox")
+    }
+    if err != nil {
+        // An inverted box contains nothing.
+        return &boundingBox{MinLatitude: 1, MaxLatitude: -1}, err
+    }
+    return box, nil
+}
+
+// checkAllowedRegion fails with a 403 when the coordinate lies outside cfg.AllowedRegion.
+func checkAllowedRegion(latitude, longitude float64) error {
+    if cfg.AllowedRegion == nil || cfg.AllowedRegion.contains(latitude, longitude) {
+        return nil
+    }
+    msg := fmt.Sprintf("Coordinates %.4f,%.4f are outside the region this service covers", latitude, longitude)
+    return &requestError{http.StatusForbidden, msg, fmt.Errorf("coordinates %v,%v outside ALLOWED_BBOX", latitude, longitude)}
+}
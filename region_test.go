@@ -0,0 +1,166 @@
+package main
+
+import (
+    "encoding/json"
+    "errors"
+    "net/http"
+    "net/http/httptest"
+    "strings"
+    "sync"
+    "testing"
+)
+
+// germany is a rough bounding box around Germany.
+var germany = &boundingBox{MinLatitude: 47, MinLongitude: 5.5, MaxLatitude: 55.1, MaxLongitude: 15.1}
+
+func TestParseAllowedRegion(t *testing.T) {
+    tests := []struct {
+        value   string
+        want    *boundingBox
+        wantErr bool
+    }{
+        {"", nil, false},
+        {"47,5.5,55.1,15.1", germany, false},
+        {" 47, 5.5 ,55.1,15.1 ", germany, false},
+        {"47,5.5,55.1", &boundingBox{MinLatitude: 1, MaxLatitude: -1}, true},
+        {"47,5.5,55.1,east", &boundingBox{MinLatitude: 1, MaxLatitude: -1}, true},
+        {"55.1,5.5,47,15.1", &boundingBox{MinLatitude: 1, MaxLatitude: -1}, true},
+    }
+    for _, tt := range tests {
+        t.Run(tt.value, func(t *testing.T) {
+            got, err := parseAllowedRegion(tt.value)
+            if (err != nil) != tt.wantErr {
+                t.Fatalf("err = %v, wantErr %v", err, tt.wantErr)
+            }
+            if (got == nil) != (tt.want == nil) || got != nil && *got != *tt.want {
+                t.Errorf("parseAllowedRegion() = %+v, want %+v", got, tt.want)
+            }
+            if tt.wantErr && got.contains(0, 0) {
+                t.Error("an invalid box allows coordinates")
+            }
+        })
+    }
+}
+
+func TestLoadConfigAllowedRegion(t *testing.T) {
+    t.Setenv("ALLOWED_BBOX", "47,5.5,55.1,15.1")
+    c := loadConfig()
+    if c.AllowedRegion == nil || *c.AllowedRegion != *germany {
+        t.Fatalf("AllowedRegion = %+v, want %+v", c.AllowedRegion, germany)
+    }
+    body, err := json.Marshal(redactedConfig(c))
+    if err != nil {
+        t.Fatal(err)
+    }
+    if !strings.Contains(string(body), `"AllowedRegion":{"min_latitude":47,"min_longitude":5.5,"max_latitude":55.1,"max_longitude":15.1}`) {
+        t.Errorf("/config body %s does not show the allowed region", body)
+    }
+}
+
+func TestCheckAllowedRegion(t *testing.T) {
+    tests := []struct {
+        name     string
+        region   *boundingBox
+        lat, lon float64
+        want     int
+    }{
+        {"unrestricted", nil, -33.87, 151.21, 0},
+        {"inside", germany, 52.52, 13.41, 0},
+        {"on the edge", germany, 47, 15.1, 0},
+        {"outside", germany, 48.85, 2.35, http.StatusForbidden},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            withConfig(t, func(c *Config) { c.AllowedRegion = tt.region })
+            err := checkAllowedRegion(tt.lat, tt.lon)
+            if tt.want == 0 {
+                if err != nil {
+                    t.Errorf("err = %v, want none", err)
+                }
+                return
+            }
+            var reqErr *requestError
+            if !errors.As(err, &reqErr) || reqErr.Status != tt.want || !strings.Contains(reqErr.Message, "outside the region") {
+                t.Errorf("err = %v, want a %d outside the region error", err, tt.want)
+            }
+        })
+    }
+}
+
+func TestAllowedRegionRejectsBeforeIO(t *testing.T) {
+    tests := []struct {
+        name   string
+        method string
+        query  string
+        body   string
+        want   int
+    }{
+        {"inside", http.MethodGet, "latitude=52.52&longitude=13.41&start_date=2024-01-01&end_date=2024-01-03&skip_if_fresh=true", "", http.StatusOK},
+        {"outside", http.MethodGet, "latitude=48.85&longitude=2.35&start_date=2024-01-01&end_date=2024-01-03&skip_if_fresh=true", "", http.StatusForbidden},
+        {"outside with rows returned", http.MethodGet, "latitude=48.85&longitude=2.35&start_date=2024-01-01&end_date=2024-01-03&sink=none", "", http.StatusForbidden},
+        {"job location outside", http.MethodPost, "", `{"locations":[{"latitude":52.52,"longitude":13.41},{"latitude":48.85,"longitude":2.35}],"start_date":"2024-01-01","end_date":"2024-01-03"}`, http.StatusForbidden},
+        {"grid partly outside", http.MethodGet, "min_lat=54&min_lon=14&max_lat=55&max_lon=16&step=1&start_date=2024-01-01&end_date=2024-01-03", "", http.StatusForbidden},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            withConfig(t, func(c *Config) { c.AllowedRegion = germany })
+            fake, _ := newFakeBigQuery(t)
+            // Nothing is stored yet, so skip_if_fresh fetches.
+            fake.answer = func(q *fakeQuery) *fakeResult {
+                return &fakeResult{Fields: fields("n", "INTEGER"), Rows: [][]interface{}{{0}}}
+            }
+            var mu sync.Mutex
+            fetches := 0
+            stubOpenMeteo(t, func(w http.ResponseWriter, r *http.Request) {
+                mu.Lock()
+                fetches++
+                mu.Unlock()
+                serveBody(threeDays)(w, r)
+            })
+
+            rec := httptest.NewRecorder()
+            fetchWeatherData(rec, httptest.NewRequest(tt.method, "/?"+tt.query, strings.NewReader(tt.body)))
+            if rec.Code != tt.want {
+                t.Fatalf("status = %d, want %d: %s", rec.Code, tt.want, rec.Body)
+            }
+            if tt.want == http.StatusOK {
+                return
+            }
+            if n := len(fake.received()); n != 0 || fetches != 0 {
+                t.Errorf("ran %d queries and %d fetches for a rejected request", n, fetches)
+            }
+            if !strings.Contains(rec.Body.String(), "outside the region") {
+                t.Errorf("body %q does not explain the rejection", rec.Body)
+            }
+        })
+    }
+}
+
+func TestAllowedRegionGCSCoordinates(t *testing.T) {
+    withConfig(t, func(c *Config) { c.AllowedRegion = germany })
+    newFakeBigQuery(t)
+    newFakeGCS(t, map[string]string{"coords/daily.csv": "latitude,longitude\n52.52,13.41\n48.85,2.35\n"})
+    var mu sync.Mutex
+    fetched := make(map[string]bool)
+    stubOpenMeteo(t, func(w http.ResponseWriter, r *http.Request) {
+        mu.Lock()
+        fetched[r.URL.Query().Get("latitude")] = true
+        mu.Unlock()
+        serveBody(threeDays)(w, r)
+    })
+
+    rec := httptest.NewRecorder()
+    fetchWeatherData(rec, httptest.NewRequest(http.MethodGet, "/?coords_gcs_uri=gs://coords/daily.csv&start_date=2024-01-01&end_date=2024-01-03", nil))
+    var body struct {
+        Locations []LocationResult `json:"locations"`
+    }
+    if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+        t.Fatalf("%v: %s", err, rec.Body)
+    }
+    if len(body.Locations) != 2 || body.Locations[0].Status != "ok" || !strings.Contains(body.Locations[1].Error, "outside the region") {
+        t.Errorf("locations = %+v, want the second rejected", body.Locations)
+    }
+    if len(fetched) != 1 || !fetched["52.520000"] {
+        t.Errorf("fetched %v, want only the location inside the region", fetched)
+    }
+}
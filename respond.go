@@ -0,0 +1,139 @@
+package main
+
+import (
+    "context"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "log/slog"
+    "net/http"
+    "strconv"
+    "strings"
+    "time"
+
+    "google.golang.org/api/googleapi"
+)
+
+// requestError is an error carrying the status and message to return to the client.
+// Err holds the underlying cause, which is logged but not shown to the client.
+type requestError struct {
+    Status  int
+    Message string
+    Err     error
+}
+
+func (e *requestError) Error() string {
+    return e.Err.Error()
+}
+
+func (e *requestError) Unwrap() error {
+    return e.Err
+}
+
+// fieldViolation describes one problem with a request field.
+type fieldViolation struct {
+    Field   string `json:"field"`
+    Message string `json:"message"`
+}
+
+// validationErrors collects every violation found in a request so they can be reported together.
+type validationErrors []fieldViolation
+
+func (v validationErrors) Error() string {
+    msgs := make([]string, len(v))
+    for i, f := range v {
+        msgs[i] = f.Field + ": " + f.Message
+    }
+    return strings.Join(msgs, "; ")
+}
+
+// add records a violation for field.
+func (v *validationErrors) add(field, format string, args ...interface{}) {
+    *v = append(*v, fieldViolation{Field: field, Message: fmt.Sprintf(format, args...)})
+}
+
+// writeBadRequest writes a 400 for err, as a structured list when it holds validation errors.
+// A requestError, such as a failed geocoding lookup, keeps its own status.
+func writeBadRequest(w http.ResponseWriter, err error) {
+    var reqErr *requestError
+    if errors.As(err, &reqErr) {
+        writeError(w, err)
+        return
+    }
+    var verrs validationErrors
+    if errors.As(err, &verrs) {
+        writeJSON(w, http.StatusBadRequest, map[string]interface{}{
+            "error":      "invalid request",
+            "violations": verrs,
+        })
+        return
+    }
+    http.Error(w, err.Error(), http.StatusBadRequest)
+}
+
+// quotaRetryAfter is the Retry-After hint sent when a BigQuery quota was exceeded.
+const quotaRetryAfter = 30 * time.Second
+
+// writeError logs err and writes the matching error response.
+func writeError(w http.ResponseWriter, err error) {
+    var reqErr *requestError
+    if isDeadlineError(err) {
+        reqErr = &requestError{http.StatusGatewayTimeout, "Request deadline exceeded", err}
+    } else if isQuotaError(err) {
+        reqErr = &requestError{http.StatusTooManyRequests, quotaMessage, err}
+        w.Header().Set("Retry-After", strconv.Itoa(int(quotaRetryAfter.Seconds())))
+    } else if !errors.As(err, &reqErr) {
+        reqErr = &requestError{http.StatusInternalServerError, "Internal error", err}
+    }
+    slog.Error("Request failed", "status", reqErr.Status, "error", reqErr.Err)
+    http.Error(w, reqErr.Message, reqErr.Status)
+}
+
+// writeJSON writes v as a JSON response with the given status code.
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+    v = sanitizeNonFinite(v)
+    w.Header().Set("Content-Type", "application/json")
+    w.WriteHeader(status)
+    if err := json.NewEncoder(w).Encode(v); err != nil {
+        slog.Error("Failed to encode JSON response", "error", err)
+    }
+}
+
+// errorMessage returns the client-facing message for err.
+func errorMessage(err error) string {
+    if isDeadlineError(err) {
+        return "Request deadline exceeded"
+    }
+    if isQuotaError(err) {
+        return quotaMessage
+    }
+    var reqErr *requestError
+    if errors.As(err, &reqErr) {
+        return reqErr.Message
+    }
+    return "Internal error"
+}
+
+// isDeadlineError reports whether err means the request ran out of time, either because a
+// retry was abandoned ahead of the deadline or because the deadline itself passed.
+func isDeadlineError(err error) bool {
+    return errors.Is(err, errRetryDeadline) || errors.Is(err, context.DeadlineExceeded)
+}
+
+// quotaMessage tells callers the failure was a BigQuery quota, not a storage error, and
+// that they should back off.
+const quotaMessage = "BigQuery quota exceeded; retry later"
+
+// isQuotaError reports whether err is a BigQuery rateLimitExceeded or quotaExceeded error.
+func isQuotaError(err error) bool {
+    var apiErr *googleapi.Error
+    if !errors.As(err, &apiErr) {
+        return false
+    }
+    for _, item := range apiErr.Errors {
+        if item.Reason == "rateLimitExceeded" || item.Reason == "quotaExceeded" {
+            return true
+        }
+    }
+    return false
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+    "context"
+    "errors"
+    "fmt"
+    "net/http"
+    "net/http/httptest"
+    "strings"
+    "testing"
+
+    "google.golang.org/api/googleapi"
+)
+
+// quotaError is a BigQuery error with the given reason.
+func quotaError(reason string) error {
+    return &googleapi.Error{Code: http.StatusForbidden, Errors: []googleapi.ErrorItem{{Reason: reason}}}
+}
+
+func TestIsQuotaError(t *testing.T) {
+    tests := []struct {
+        err  error
+        want bool
+    }{
+        {quotaError("rateLimitExceeded"), true},
+        {quotaError("quotaExceeded"), true},
+        {fmt.Errorf("insert: %w", quotaError("quotaExceeded")), true},
+        {quotaError("accessDenied"), false},
+        {&googleapi.Error{Code: http.StatusTooManyRequests}, false},
+        {errors.New("quotaExceeded"), false},
+    }
+    for _, tt := range tests {
+        if got := isQuotaError(tt.err); got != tt.want {
+            t.Errorf("isQuotaError(%v) = %v, want %v", tt.err, got, tt.want)
+        }
+    }
+}
+
+func TestWriteError(t *testing.T) {
+    tests := []struct {
+        name           string
+        err            error
+        wantStatus     int
+        wantMessage    string
+        wantRetryAfter string
+    }{
+        {"quota", &requestError{http.StatusInternalServerError, "Failed to store data", quotaError("rateLimitExceeded")}, http.StatusTooManyRequests, quotaMessage, "30"},
+        {"deadline", fmt.Errorf("insert: %w", context.DeadlineExceeded), http.StatusGatewayTimeout, "Request deadline exceeded", ""},
+        {"abandoned retry", fmt.Errorf("%w: boom", errRetryDeadline), http.StatusGatewayTimeout, "Request deadline exceeded", ""},
+        {"request error", &requestError{http.StatusBadGateway, "Upstream failed", errors.New("502")}, http.StatusBadGateway, "Upstream failed", ""},
+        {"other error", errors.New("secret details"), http.StatusInternalServerError, "Internal error", ""},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            rec := httptest.NewRecorder()
+            writeError(rec, tt.err)
+            if rec.Code != tt.wantStatus || strings.TrimSpace(rec.Body.String()) != tt.wantMessage {
+                t.Errorf("response = %d %q, want %d %q", rec.Code, rec.Body, tt.wantStatus, tt.wantMessage)
+            }
+            if got := rec.Header().Get("Retry-After"); got != tt.wantRetryAfter {
+                t.Errorf("Retry-After = %q, want %q", got, tt.wantRetryAfter)
+            }
+            if got := errorMessage(tt.err); got != tt.wantMessage {
+                t.Errorf("errorMessage() = %q, want %q", got, tt.wantMessage)
+            }
+        })
+    }
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+    "context"
+    "errors"
+    "fmt"
+    "log/slog"
+    "time"
+)
+
+// errRetryDeadline is returned when a retry is abandoned because the next backoff would
+// outlast the request deadline.
+var errRetryDeadline = errors.New("retry abandoned: backoff would exceed the request deadline")
+
+// retryPolicy bounds a retry loop.
+type retryPolicy struct {
+    // Attempts is the maximum number of attempts, including the first; 0 means no limit.
+    Attempts int
+    // Window bounds the total time spent retrying; 0 means no limit.
+    Window  time.Duration
+    Initial time.Duration
+    Max     time.Duration
+}
+
+// retryWithBackoff calls op until it succeeds or returns an error retryable rejects, backing
+// off exponentially between attempts. Before each retry it checks the context deadline, and
+// gives up with errRetryDeadline rather than sleeping past it, so the caller can still
+// respond before the platform kills the request.
+func retryWithBackoff(ctx context.Context, p retryPolicy, retryable func(error) bool, op func() error) error {
+    start := time.Now()
+    backoff := p.Initial
+    for attempt := 1; ; attempt++ {
+        err := op()
+        if err == nil || !retryable(err) {
+            return err
+        }
+        if p.Attempts > 0 && attempt >= p.Attempts {
+            return err
+        }
+        if p.Window > 0 && time.Since(start)+backoff > p.Window {
+            return err
+        }
+        if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < backoff {
+            return fmt.Errorf("%w: %v", errRetryDeadline, err)
+        }
+
+        slog.Warn("Retrying after error", "attempt", attempt, "backoff", backoff, "error", err)
+        select {
+        case <-ctx.Done():
+            return ctx.Err()
+        case <-time.After(backoff):
+        }
+        backoff = min(backoff*2, p.Max)
+    }
+}
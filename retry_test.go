@@ -0,0 +1,119 @@
+package main
+
+import (
+    "context"
+    "errors"
+    "fmt"
+    "net/http"
+    "testing"
+    "time"
+
+    "google.golang.org/api/googleapi"
+)
+
+var errTransient = errors.New("transient")
+
+func TestRetryWithBackoff(t *testing.T) {
+    fast := retryPolicy{Attempts: 3, Initial: time.Millisecond, Max: 2 * time.Millisecond}
+    tests := []struct {
+        name      string
+        policy    retryPolicy
+        failures  int
+        deadline  time.Duration
+        wantCalls int
+        wantErr   error
+    }{
+        {"first attempt succeeds", fast, 0, 0, 1, nil},
+        {"succeeds after retries", fast, 2, 0, 3, nil},
+        {"gives up after the attempts", fast, 5, 0, 3, errTransient},
+        {"window stops retrying", retryPolicy{Window: 5 * time.Millisecond, Initial: 4 * time.Millisecond, Max: 4 * time.Millisecond}, 5, 0, 2, errTransient},
+        {"deadline shorter than the backoff", retryPolicy{Attempts: 3, Initial: time.Hour, Max: time.Hour}, 5, time.Minute, 1, errRetryDeadline},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            ctx := context.Background()
+            if tt.deadline > 0 {
+                var cancel context.CancelFunc
+                ctx, cancel = context.WithTimeout(ctx, tt.deadline)
+                defer cancel()
+            }
+            calls := 0
+            err := retryWithBackoff(ctx, tt.policy, func(err error) bool { return errors.Is(err, errTransient) }, func() error {
+                calls++
+                if calls <= tt.failures {
+                    return errTransient
+                }
+                return nil
+            })
+            if !errors.Is(err, tt.wantErr) || (tt.wantErr == nil) != (err == nil) {
+                t.Errorf("err = %v, want %v", err, tt.wantErr)
+            }
+            if calls != tt.wantCalls {
+                t.Errorf("op called %d times, want %d", calls, tt.wantCalls)
+            }
+        })
+    }
+}
+
+func TestRetryWithBackoffStopsOnPermanentError(t *testing.T) {
+    permanent := errors.New("permanent")
+    calls := 0
+    err := retryWithBackoff(context.Background(), retryPolicy{Attempts: 5, Initial: time.Millisecond}, func(err error) bool { return errors.Is(err, errTransient) }, func() error {
+        calls++
+        return permanent
+    })
+    if err != permanent || calls != 1 {
+        t.Errorf("retryWithBackoff() = %v after %d calls, want the permanent error after 1", err, calls)
+    }
+}
+
+func TestRetryWithBackoffCancelled(t *testing.T) {
+    ctx, cancel := context.WithCancel(context.Background())
+    calls := 0
+    err := retryWithBackoff(ctx, retryPolicy{Initial: time.Hour, Max: time.Hour}, func(error) bool { return true }, func() error {
+        calls++
+        cancel()
+        return errTransient
+    })
+    if !errors.Is(err, context.Canceled) || calls != 1 {
+        t.Errorf("retryWithBackoff() = %v after %d calls, want context.Canceled after 1", err, calls)
+    }
+}
+
+func TestIsRetryableFetchError(t *testing.T) {
+    tests := []struct {
+        err  error
+        want bool
+    }{
+        {&upstreamStatusError{StatusCode: http.StatusTooManyRequests}, true},
+        {&upstreamStatusError{StatusCode: http.StatusBadGateway}, true},
+        {&upstreamStatusError{StatusCode: http.StatusBadRequest}, false},
+        {&fetchError{"connection reset"}, true},
+        {fmt.Errorf("wrapped: %w", &fetchError{"timeout"}), true},
+        {errors.New("stopped after 10 redirects"), false},
+    }
+    for _, tt := range tests {
+        if got := isRetryableFetchError(tt.err); got != tt.want {
+            t.Errorf("isRetryableFetchError(%v) = %v, want %v", tt.err, got, tt.want)
+        }
+    }
+}
+
+func TestIsTransientBigQueryError(t *testing.T) {
+    tests := []struct {
+        err  error
+        want bool
+    }{
+        {&googleapi.Error{Code: http.StatusInternalServerError}, true},
+        {&googleapi.Error{Code: http.StatusServiceUnavailable}, true},
+        {fmt.Errorf("insert: %w", &googleapi.Error{Code: http.StatusBadGateway}), true},
+        {&googleapi.Error{Code: http.StatusBadRequest}, false},
+        {&googleapi.Error{Code: http.StatusNotFound}, false},
+        {errors.New("not an API error"), false},
+    }
+    for _, tt := range tests {
+        if got := isTransientBigQueryError(tt.err); got != tt.want {
+            t.Errorf("isTransientBigQueryError(%v) = %v, want %v", tt.err, got, tt.want)
+        }
+    }
+}
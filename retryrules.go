@@ -0,0 +1,98 @@
+package main
+
+import (
+    "encoding/json"
+    "fmt"
+    "strconv"
+    "strings"
+)
+
+// retryRule decides which failed calls to retry. Statuses lists HTTP status codes, either
+// exact ("408") or by class ("5xx"); ErrorSubstrings matches anywhere in the error message,
+// case-insensitively. Transport retries connection-level failures that carry no status;
+// it only applies to Open-Meteo fetches.
+type retryRule struct {
+    Statuses        []string `json:"statuses"`
+    ErrorSubstrings []string `json:"error_substrings"`
+    Transport       bool     `json:"transport"`
+}
+
+// retryRuleSet holds the rules for Open-Meteo fetches and BigQuery calls.
+type retryRuleSet struct {
+    Upstream retryRule `json:"upstream"`
+    BigQuery retryRule `json:"bigquery"`
+}
+
+// defaultRetryRules retries rate limiting, server errors, and transport failures from
+// Open-Meteo, and the transient 500, 502, and 503 errors from BigQuery.
+var defaultRetryRules = retryRuleSet{
+    Upstream: retryRule{Statuses: []string{"429", "5xx"}, Transport: true},
+    BigQuery: retryRule{Statuses: []string{"500", "502", "503"}},
+}
+
+// parseRetryRules parses a JSON object with upstream and bigquery rules. A section left
+// out keeps its default. An invalid value returns the defaults with the error, so a bad
+// config does not turn retries off or make every error retryable.
+func parseRetryRules(s string) (retryRuleSet, error) {
+    if s == "" {
+        return defaultRetryRules, nil
+    }
+    var sections map[string]json.RawMessage
+    if err := json.Unmarshal([]byte(s), &sections); err != nil {
+        return defaultRetryRules, err
+    }
+    rules := defaultRetryRules
+    for name, raw := range sections {
+        var rule retryRule
+        if err := json.Unmarshal(raw, &rule); err != nil {
+            return defaultRetryRules, fmt.Errorf("section %q: %w", name, err)
+        }
+        if err := rule.validate(); err != nil {
+            return defaultRetryRules, fmt.Errorf("section %q: %w", name, err)
+        }
+        switch name {
+        case "upstream":
+            rules.Upstream = rule
+        case "bigquery":
+            rules.BigQuery = rule
+        default:
+            return defaultRetryRules, fmt.Errorf("unknown section %q", name)
+        }
+    }
+    return rules, nil
+}
+
+// validate checks that every status is a code from 100 to 599 or a class like 5xx.
+func (r retryRule) validate() error {
+    for _, s := range r.Statuses {
+        if len(s) == 3 && strings.HasSuffix(s, "xx") && s[0] >= '1' && s[0] <= '5' {
+            continue
+        }
+        if code, err := strconv.Atoi(s); err != nil || code < 100 || code > 599 {
+            return fmt.Errorf("invalid status %q", s)
+        }
+    }
+    return nil
+}
+
+// matchesStatus reports whether the status code is listed, exactly or by class.
+func (r retryRule) matchesStatus(code int) bool {
+    exact, class := strconv.Itoa(code), strconv.Itoa(code/100)+"xx"
+    for _, s := range r.Statuses {
+        if s == exact || s == class {
+            return true
+        }
+    }
+    return false
+}
+
+// matchesError reports whether the error message contains one of the substrings.
+func (r retryRule) matchesError(err error) bool {
+    msg := strings.ToLower(err.Error())
+    for _, sub := range r.ErrorSubstrings {
+        if sub != "" && strings.Contains(msg, strings.ToLower(sub)) {
+            return true
+        }
+    }
+    return false
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+    "errors"
+    "net/http"
+    "reflect"
+    "testing"
+
+    "google.golang.org/api/googleapi"
+)
+
+func TestParseRetryRules(t *testing.T) {
+    tests := []struct {
+        name    string
+        value   string
+        want    retryRuleSet
+        wantErr bool
+    }{
+        {"unset", "", defaultRetryRules, false},
+        {"upstream only", `{"upstream":{"statuses":["408","5xx"]}}`, retryRuleSet{Upstream: retryRule{Statuses: []string{"408", "5xx"}}, BigQuery: defaultRetryRules.BigQuery}, false},
+        {"both", `{"upstream":{"transport":true},"bigquery":{"statuses":["503"],"error_substrings":["rateLimitExceeded"]}}`,
+            retryRuleSet{Upstream: retryRule{Transport: true}, BigQuery: retryRule{Statuses: []string{"503"}, ErrorSubstrings: []string{"rateLimitExceeded"}}}, false},
+        {"invalid JSON", `{"upstream":`, defaultRetryRules, true},
+        {"invalid section", `{"upstream":{"statuses":429}}`, defaultRetryRules, true},
+        {"invalid status", `{"bigquery":{"statuses":["50x"]}}`, defaultRetryRules, true},
+        {"status out of range", `{"bigquery":{"statuses":["600"]}}`, defaultRetryRules, true},
+        {"unknown section", `{"storage":{"statuses":["503"]}}`, defaultRetryRules, true},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            got, err := parseRetryRules(tt.value)
+            if (err != nil) != tt.wantErr {
+                t.Fatalf("err = %v, wantErr %v", err, tt.wantErr)
+            }
+            if !reflect.DeepEqual(got, tt.want) {
+                t.Errorf("parseRetryRules() = %+v, want %+v", got, tt.want)
+            }
+        })
+    }
+}
+
+func TestLoadConfigRetryRules(t *testing.T) {
+    t.Setenv("RETRY_RULES_JSON", `{"bigquery":{"statuses":["429"]}}`)
+    if got := loadConfig().RetryRules.BigQuery.Statuses; !reflect.DeepEqual(got, []string{"429"}) {
+        t.Errorf("BigQuery statuses = %q, want [429]", got)
+    }
+    t.Setenv("RETRY_RULES_JSON", `not json`)
+    if got := loadConfig().RetryRules; !reflect.DeepEqual(got, defaultRetryRules) {
+        t.Errorf("invalid rules loaded as %+v, want the defaults", got)
+    }
+}
+
+func TestCustomRetryRules(t *testing.T) {
+    rules := retryRuleSet{
+        Upstream: retryRule{Statuses: []string{"408", "503"}, ErrorSubstrings: []string{"Connection Refused"}},
+        BigQuery: retryRule{Statuses: []string{"4xx"}, ErrorSubstrings: []string{"backendError"}},
+    }
+    tests := []struct {
+        name     string
+        err      error
+        upstream bool
+        bigquery bool
+    }{
+        {"408", &upstreamStatusError{StatusCode: http.StatusRequestTimeout}, true, false},
+        {"429 is no longer retried", &upstreamStatusError{StatusCode: http.StatusTooManyRequests}, false, false},
+        {"502 is no longer retried", &upstreamStatusError{StatusCode: http.StatusBadGateway}, false, false},
+        {"503", &upstreamStatusError{StatusCode: http.StatusServiceUnavailable}, true, false},
+        {"transport failure without Transport", &fetchError{"read: connection reset"}, false, false},
+        {"error substring", &fetchError{"dial tcp: connection refused"}, true, false},
+        {"BigQuery class", &googleapi.Error{Code: http.StatusConflict}, false, true},
+        {"BigQuery 500 is no longer retried", &googleapi.Error{Code: http.StatusInternalServerError}, false, false},
+        {"BigQuery reason", &googleapi.Error{Code: http.StatusInternalServerError, Message: "backendError"}, false, true},
+        {"plain error", errors.New("boom"), false, false},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            withConfig(t, func(c *Config) { c.RetryRules = rules })
+            if got := isRetryableFetchError(tt.err); got != tt.upstream {
+                t.Errorf("isRetryableFetchError() = %v, want %v", got, tt.upstream)
+            }
+            if got := isTransientBigQueryError(tt.err); got != tt.bigquery {
+                t.Errorf("isTransientBigQueryError() = %v, want %v", got, tt.bigquery)
+            }
+        })
+    }
+}
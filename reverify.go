@@ -0,0 +1,232 @@
+package main
+
+import (
+    "context"
+    "fmt"
+    "log/slog"
+    "net/http"
+    "slices"
+    "sort"
+    "strings"
+    "time"
+
+    "cloud.google.com/go/bigquery"
+    "cloud.google.com/go/civil"
+    "google.golang.org/api/iterator"
+)
+
+// revisedRow holds the value columns of one stored day: read back from the table to
+// compare against a fresh fetch, and passed as a source row of the upsert. Latitude and
+// Longitude are the stored coordinates, so the upsert matches exactly the rows read.
+// SnowfallUnit and WeatherDescription are only upserted, so they follow the fresh values.
+type revisedRow struct {
+    Latitude            float64              `bigquery:"latitude"`
+    Longitude           float64              `bigquery:"longitude"`
+    Date                civil.Date           `bigquery:"date"`
+    MeanTemperature     bigquery.NullFloat64 `bigquery:"mean_temperature"`
+    MinTemperature      bigquery.NullFloat64 `bigquery:"min_temperature"`
+    MaxTemperature      bigquery.NullFloat64 `bigquery:"max_temperature"`
+    RainSum             bigquery.NullFloat64 `bigquery:"rain_sum"`
+    SnowfallSum         bigquery.NullFloat64 `bigquery:"snowfall_sum"`
+    WeatherCode         bigquery.NullInt64   `bigquery:"weather_code"`
+    SurfacePressureMean bigquery.NullFloat64 `bigquery:"surface_pressure_mean"`
+    CloudCoverMean      bigquery.NullFloat64 `bigquery:"cloud_cover_mean"`
+    ET0                 bigquery.NullFloat64 `bigquery:"et0_fao_evapotranspiration"`
+    SnowfallUnit        bigquery.NullString  `bigquery:"snowfall_unit"`
+    WeatherDescription  bigquery.NullString  `bigquery:"weather_description"`
+    InsertedAt          time.Time            `bigquery:"inserted_at"`
+    BatchID             string               `bigquery:"batch_id"`
+}
+
+// newRevisedRow copies the value columns of a fetched row onto the key of a stored row.
+func newRevisedRow(stored revisedRow, r *WeatherData) revisedRow {
+    return revisedRow{
+        Latitude:            stored.Latitude,
+        Longitude:           stored.Longitude,
+        Date:                stored.Date,
+        MeanTemperature:     r.MeanTemperature,
+        MinTemperature:      r.MinTemperature,
+        MaxTemperature:      r.MaxTemperature,
+        RainSum:             r.RainSum,
+        SnowfallSum:         r.SnowfallSum,
+        WeatherCode:         r.WeatherCode,
+        SurfacePressureMean: r.SurfacePressureMean,
+        CloudCoverMean:      r.CloudCoverMean,
+        ET0:                 r.ET0,
+        SnowfallUnit:        r.SnowfallUnit,
+        WeatherDescription:  r.WeatherDescription,
+        InsertedAt:          r.InsertedAt,
+        BatchID:             r.BatchID,
+    }
+}
+
+// weatherData returns the row as WeatherData, so it can be compared column by column.
+func (r revisedRow) weatherData() *WeatherData {
+    return &WeatherData{
+        Date:                r.Date.String(),
+        MeanTemperature:     r.MeanTemperature,
+        MinTemperature:      r.MinTemperature,
+        MaxTemperature:      r.MaxTemperature,
+        RainSum:             r.RainSum,
+        SnowfallSum:         r.SnowfallSum,
+        WeatherCode:         r.WeatherCode,
+        SurfacePressureMean: r.SurfacePressureMean,
+        CloudCoverMean:      r.CloudCoverMean,
+        ET0:                 r.ET0,
+    }
+}
+
+// reverifyColumns returns the value columns of the requested variables.
+func reverifyColumns(opts *requestOptions) []modelColumn {
+    var columns []modelColumn
+    for _, col := range modelColumns {
+        if hasVariable(opts.Variables, col.Variable) {
+            columns = append(columns, col)
+        }
+    }
+    return columns
+}
+
+// reverify re-fetches the stored days of the range whose rows were inserted longer than
+// reverify_older_than ago and upserts the fresh values over them, so archive corrections
+// reach old data. Every re-verified row gets the new inserted_at and batch_id, changed or
+// not, so it is not selected again until it ages past the cutoff once more.
+func reverify(ctx context.Context, client *bigquery.Client, opts *requestOptions) (*ingestResult, error) {
+    tableID := opts.dailyTable()
+    cutoff := now().Add(-opts.ReverifyOlderThan)
+    columns := reverifyColumns(opts)
+    stored, err := staleRows(ctx, client, tableID, opts, columns, cutoff)
+    if err != nil {
+        return nil, &requestError{http.StatusInternalServerError, "BigQuery error", fmt.Errorf("failed to select rows to reverify: %w", err)}
+    }
+    if len(stored) == 0 {
+        return &ingestResult{StartDate: opts.StartDate}, nil
+    }
+    dates := make([]civil.Date, 0, len(stored))
+    for date := range stored {
+        dates = append(dates, date)
+    }
+    sort.Slice(dates, func(i, j int) bool { return dates[i].Before(dates[j]) })
+
+    // Fetch the span of the stale days once and keep only those days.
+    m := *opts
+    m.StartDate, m.EndDate = dates[0].String(), dates[len(dates)-1].String()
+    result, rows, err := fetchRows(ctx, client, &m)
+    if err != nil || result.Empty {
+        return result, err
+    }
+    result.StartDate = opts.StartDate
+    var source []revisedRow
+    for _, row := range rows {
+        date, err := civil.ParseDate(row.Date)
+        if err != nil {
+            continue
+        }
+        olds, ok := stored[date]
+        if !ok {
+            continue
+        }
+        result.Reverified++
+        changed := false
+        for _, old := range olds {
+            for _, col := range columns {
+                if col.value(row) != col.value(old.weatherData()) {
+                    changed = true
+                }
+            }
+            source = append(source, newRevisedRow(old, row))
+        }
+        if changed {
+            result.Revised++
+        }
+    }
+    if len(source) == 0 {
+        return result, nil
+    }
+
+    updated, err := upsertRevisedRows(ctx, client, tableID, columns, cutoff, source)
+    if err != nil {
+        return nil, &requestError{http.StatusInternalServerError, "BigQuery error", fmt.Errorf("failed to upsert reverified rows: %w", err)}
+    }
+    result.Rows = int(updated)
+    slog.Info("Reverified stored rows", "table", tableID, "days", result.Reverified, "changed", result.Revised, "rows_updated", updated)
+    return result, nil
+}
+
+// staleRows reads the stored values of the coordinate's days in the range whose rows were
+// inserted before the cutoff, keyed by date. A day can hold rows for several stored
+// coordinates within the tolerance; each is kept once.
+func staleRows(ctx context.Context, client *bigquery.Client, tableID string, opts *requestOptions, columns []modelColumn, cutoff time.Time) (map[civil.Date][]revisedRow, error) {
+    start, err := civil.ParseDate(opts.StartDate)
+    if err != nil {
+        return nil, fmt.Errorf("invalid start date %q: %w", opts.StartDate, err)
+    }
+    end, err := civil.ParseDate(opts.EndDate)
+    if err != nil {
+        return nil, fmt.Errorf("invalid end date %q: %w", opts.EndDate, err)
+    }
+    selected := []string{"latitude", "longitude", "date"}
+    for _, col := range columns {
+        selected = append(selected, col.Column)
+    }
+    query := client.Query(fmt.Sprintf(
+        "SELECT %s FROM `%s.%s.%s` WHERE ABS(latitude - @latitude) <= @tolerance AND ABS(longitude - @longitude) <= @tolerance AND date BETWEEN @start_date AND @end_date AND inserted_at < @cutoff",
+        strings.Join(selected, ", "), cfg.ProjectID, cfg.DatasetID, tableID,
+    ))
+    query.Parameters = []bigquery.QueryParameter{
+        {Name: "latitude", Value: opts.Latitude},
+        {Name: "longitude", Value: opts.Longitude},
+        {Name: "tolerance", Value: cfg.IncrementalTolerance},
+        {Name: "start_date", Value: start},
+        {Name: "end_date", Value: end},
+        {Name: "cutoff", Value: cutoff},
+    }
+    it, err := query.Read(ctx)
+    if err != nil {
+        return nil, err
+    }
+    stored := make(map[civil.Date][]revisedRow)
+    for {
+        var row revisedRow
+        err := it.Next(&row)
+        if err == iterator.Done {
+            return stored, nil
+        }
+        if err != nil {
+            return nil, err
+        }
+        if !slices.ContainsFunc(stored[row.Date], func(r revisedRow) bool {
+            return r.Latitude == row.Latitude && r.Longitude == row.Longitude
+        }) {
+            stored[row.Date] = append(stored[row.Date], row)
+        }
+    }
+}
+
+// upsertRevisedRows merges the fresh values over the stale rows with the same stored
+// coordinates and date, and returns how many rows were updated. The snowfall unit is
+// always rewritten with snowfall_sum, and the weather description with weather_code, so
+// neither is left describing the old value.
+func upsertRevisedRows(ctx context.Context, client *bigquery.Client, tableID string, columns []modelColumn, cutoff time.Time, source []revisedRow) (int64, error) {
+    set := []string{"inserted_at = s.inserted_at", "batch_id = s.batch_id"}
+    for _, col := range columns {
+        set = append(set, fmt.Sprintf("%s = s.%s", col.Column, col.Column))
+        switch col.Column {
+        case "snowfall_sum":
+            set = append(set, "snowfall_unit = s.snowfall_unit")
+        case "weather_code":
+            set = append(set, "weather_description = s.weather_description")
+        }
+    }
+    query := client.Query(fmt.Sprintf(
+        "MERGE `%s.%s.%s` AS t USING UNNEST(@rows) AS s "+
+            "ON t.latitude = s.latitude AND t.longitude = s.longitude AND t.date = s.date AND t.inserted_at < @cutoff "+
+            "WHEN MATCHED THEN UPDATE SET %s",
+        cfg.ProjectID, cfg.DatasetID, tableID, strings.Join(set, ", "),
+    ))
+    query.Parameters = []bigquery.QueryParameter{
+        {Name: "rows", Value: source},
+        {Name: "cutoff", Value: cutoff},
+    }
+    return runDML(ctx, query)
+}
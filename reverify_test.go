@@ -0,0 +1,230 @@
+package main
+
+import (
+    "net/http"
+    "net/http/httptest"
+    "regexp"
+    "strings"
+    "testing"
+    "time"
+)
+
+// staleColumns are the stored columns of the reverify test rows, in order.
+var staleColumns = []string{"latitude", "longitude", "date", "min_temperature", "max_temperature", "mean_temperature", "rain_sum", "snowfall_sum"}
+
+func TestReverify(t *testing.T) {
+    tests := []struct {
+        name        string
+        stored      [][]interface{}
+        wantBody    string
+        wantFetches int
+        wantSource  []string
+    }{
+        {
+            name: "revised and unchanged days",
+            stored: [][]interface{}{
+                {52.5201, 13.41, "2024-01-01", -1, 4, 1.5, 0, 0},
+                {52.52, 13.4102, "2024-01-02", 0, 5, 2.5, 0.3, 0},
+                // A repeated row of a stored coordinate is upserted once.
+                {52.52, 13.4102, "2024-01-02", 0, 5, 2.5, 0.3, 0},
+                {52.5199, 13.41, "2024-01-02", 0, 5, 2.5, 0.4, 0},
+            },
+            wantBody:    "Reverified 2 days stored more than 720h0m0s ago: 1 changed, 3 rows updated",
+            wantFetches: 1,
+            wantSource:  []string{"52.5201 13.41 2024-01-01 0", "52.52 13.4102 2024-01-02 0.4", "52.5199 13.41 2024-01-02 0.4"},
+        },
+        {
+            name:     "nothing stale",
+            wantBody: "Reverified 0 days",
+        },
+    }
+    selected := regexp.MustCompile(`^SELECT (.*) FROM`)
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            fixClock(t, time.Date(2024, 6, 30, 12, 0, 0, 0, time.UTC))
+            fake, _ := newFakeBigQuery(t)
+            fake.answer = func(q *fakeQuery) *fakeResult {
+                if strings.HasPrefix(q.SQL, "MERGE") {
+                    return &fakeResult{Affected: 3}
+                }
+                m := selected.FindStringSubmatch(q.SQL)
+                if m == nil || !strings.Contains(q.SQL, "inserted_at < @cutoff") {
+                    return nil
+                }
+                types := map[string]string{"date": "DATE"}
+                var names []string
+                for _, c := range strings.Split(m[1], ", ") {
+                    typ := types[c]
+                    if typ == "" {
+                        typ = "FLOAT"
+                    }
+                    names = append(names, c, typ)
+                }
+                return &fakeResult{Fields: fields(names...), Rows: storedColumns(tt.stored, strings.Split(m[1], ", "))}
+            }
+            fetches := 0
+            stubOpenMeteo(t, func(w http.ResponseWriter, r *http.Request) {
+                fetches++
+                if got := r.URL.Query().Get("start_date") + ".." + r.URL.Query().Get("end_date"); got != "2024-01-01..2024-01-02" {
+                    t.Errorf("fetched %s, want the span of the stale days", got)
+                }
+                serveBody(threeDays)(w, r)
+            })
+
+            rec := httptest.NewRecorder()
+            fetchWeatherData(rec, httptest.NewRequest(http.MethodGet, "/?latitude=52.52&longitude=13.41&start_date=2024-01-01&end_date=2024-01-03&reverify_older_than=720h", nil))
+            if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), tt.wantBody) {
+                t.Fatalf("status = %d, body %q; want 200 and %q", rec.Code, rec.Body, tt.wantBody)
+            }
+            if fetches != tt.wantFetches {
+                t.Errorf("fetched %d times, want %d", fetches, tt.wantFetches)
+            }
+
+            var merge *fakeQuery
+            for _, q := range fake.received() {
+                switch {
+                case strings.HasPrefix(q.SQL, "MERGE"):
+                    merge = q
+                case strings.Contains(q.SQL, "inserted_at < @cutoff"):
+                    if strings.Contains(q.SQL, "CAST(") {
+                        t.Errorf("stale rows query casts the date: %s", q.SQL)
+                    }
+                    for name, want := range map[string]string{"start_date": "2024-01-01", "end_date": "2024-01-03"} {
+                        if q.paramType(name) != "DATE" || q.param(name) != want {
+                            t.Errorf("%s = %s %q, want DATE %q", name, q.paramType(name), q.param(name), want)
+                        }
+                    }
+                }
+            }
+            if tt.wantSource == nil {
+                if merge != nil {
+                    t.Errorf("ran %s, want no upsert", merge.SQL)
+                }
+                return
+            }
+            if merge == nil {
+                t.Fatal("no upsert ran")
+            }
+            if !strings.Contains(merge.SQL, "t.latitude = s.latitude AND t.longitude = s.longitude AND t.date = s.date") || strings.Contains(merge.SQL, "@tolerance") || strings.Contains(merge.SQL, "CAST(") {
+                t.Errorf("upsert does not match the stored rows exactly: %s", merge.SQL)
+            }
+            var source []string
+            for _, p := range merge.Params {
+                if p.Name != "rows" {
+                    continue
+                }
+                for _, f := range p.ParameterType.ArrayType.StructTypes {
+                    if f.Name == "date" && f.Type.Type != "DATE" {
+                        t.Errorf("source row date is a %s, want a DATE", f.Type.Type)
+                    }
+                }
+                for _, v := range p.ParameterValue.ArrayValues {
+                    s := v.StructValues
+                    source = append(source, strings.Join([]string{s["latitude"].Value, s["longitude"].Value, s["date"].Value, s["rain_sum"].Value}, " "))
+                }
+            }
+            if strings.Join(source, ",") != strings.Join(tt.wantSource, ",") {
+                t.Errorf("upserted %q, want %q", source, tt.wantSource)
+            }
+        })
+    }
+}
+
+// storedColumns picks the selected columns out of rows listed in staleColumns order.
+func storedColumns(rows [][]interface{}, selected []string) [][]interface{} {
+    var out [][]interface{}
+    for _, row := range rows {
+        var picked []interface{}
+        for _, c := range selected {
+            var v interface{}
+            for i, name := range staleColumns {
+                if name == c {
+                    v = row[i]
+                }
+            }
+            picked = append(picked, v)
+        }
+        out = append(out, picked)
+    }
+    return out
+}
+
+func TestReverifyUpsertsUnitAndDescription(t *testing.T) {
+    tests := []struct {
+        name      string
+        query     string
+        wantSet   []string
+        wantUnset []string
+        wantRow   map[string]string
+    }{
+        {
+            name:      "snowfall unit follows snowfall_sum",
+            query:     "&snowfall_unit=mm",
+            wantSet:   []string{"snowfall_sum = s.snowfall_sum", "snowfall_unit = s.snowfall_unit"},
+            wantUnset: []string{"weather_description"},
+            wantRow:   map[string]string{"snowfall_sum": "20", "snowfall_unit": "mm"},
+        },
+        {
+            name:    "description follows weather_code",
+            query:   "&daily=weather_code&weather_description=true",
+            wantSet: []string{"weather_code = s.weather_code", "weather_description = s.weather_description", "snowfall_unit = s.snowfall_unit"},
+            wantRow: map[string]string{"weather_code": "61", "weather_description": wmoDescriptions[61], "snowfall_unit": "cm"},
+        },
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            fixClock(t, time.Date(2024, 6, 30, 12, 0, 0, 0, time.UTC))
+            fake, _ := newFakeBigQuery(t)
+            fake.answer = func(q *fakeQuery) *fakeResult {
+                if strings.HasPrefix(q.SQL, "MERGE") {
+                    return &fakeResult{Affected: 1}
+                }
+                if !strings.Contains(q.SQL, "inserted_at < @cutoff") {
+                    return nil
+                }
+                return &fakeResult{
+                    Fields: fields("latitude", "FLOAT", "longitude", "FLOAT", "date", "DATE", "snowfall_sum", "FLOAT", "weather_code", "INTEGER"),
+                    Rows:   [][]interface{}{{52.52, 13.41, "2024-01-01", 2, 3}},
+                }
+            }
+            stubOpenMeteo(t, serveBody(`{"latitude":52.5,"longitude":13.4,"daily":{"time":["2024-01-01"],`+
+                `"temperature_2m_min":[-1],"temperature_2m_max":[4],"temperature_2m_mean":[1.5],"rain_sum":[0],"snowfall_sum":[2],"weather_code":[61]}}`))
+
+            rec := httptest.NewRecorder()
+            fetchWeatherData(rec, httptest.NewRequest(http.MethodGet, "/?latitude=52.52&longitude=13.41&start_date=2024-01-01&end_date=2024-01-01&reverify_older_than=720h"+tt.query, nil))
+            if rec.Code != http.StatusOK {
+                t.Fatalf("status = %d: %s", rec.Code, rec.Body)
+            }
+            var merge *fakeQuery
+            for _, q := range fake.received() {
+                if strings.HasPrefix(q.SQL, "MERGE") {
+                    merge = q
+                }
+            }
+            if merge == nil {
+                t.Fatal("no upsert ran")
+            }
+            for _, want := range tt.wantSet {
+                if !strings.Contains(merge.SQL, want) {
+                    t.Errorf("upsert lacks %q: %s", want, merge.SQL)
+                }
+            }
+            for _, unwanted := range tt.wantUnset {
+                if strings.Contains(merge.SQL, unwanted) {
+                    t.Errorf("upsert sets %s: %s", unwanted, merge.SQL)
+                }
+            }
+            for _, p := range merge.Params {
+                if p.Name != "rows" {
+                    continue
+                }
+                row := p.ParameterValue.ArrayValues[0].StructValues
+                for name, want := range tt.wantRow {
+                    if got := row[name].Value; got != want {
+                        t.Errorf("source %s = %q, want %q", name, got, want)
+                    }
+                }
+            }
+        })
+    }
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+    "fmt"
+    "slices"
+    "time"
+
+    "cloud.google.com/go/bigquery"
+)
+
+// maxRollingDays caps the rolling window.
+const maxRollingDays = 366
+
+// rollingColumns are the values given trailing N-day aggregates: temperatures are averaged
+// and precipitation summed.
+var rollingColumns = []struct {
+    Column   string
+    Variable string
+    Sum      bool
+}{
+    {"mean_temperature", "temperature_2m_mean", false},
+    {"min_temperature", "temperature_2m_min", false},
+    {"max_temperature", "temperature_2m_max", false},
+    {"rain_sum", "rain_sum", true},
+    {"snowfall_sum", "snowfall_sum", true},
+}
+
+// rollingColumn names the N-day column of a value, such as mean_temperature_7d.
+func rollingColumn(column string, days int) string {
+    return fmt.Sprintf("%s_%dd", column, days)
+}
+
+// addRolling sets the trailing rolling aggregates of each row over the calendar days up to
+// and including its date, per model. A window missing any day, at the start of the range
+// or in a gap, is NULL unless partial is set, in which case it covers the days present.
+// rows must be sorted by date.
+func addRolling(rows []*WeatherData, days int, partial bool) {
+    series := make(map[string][]*WeatherData)
+    for _, row := range rows {
+        series[row.SourceModel.StringVal] = append(series[row.SourceModel.StringVal], row)
+    }
+    for _, rows := range series {
+        dates := make([]time.Time, len(rows))
+        for i, row := range rows {
+            dates[i], _ = time.Parse(dateLayout, row.Date)
+        }
+        first := 0
+        for i, row := range rows {
+            for dates[first].Before(dates[i].AddDate(0, 0, -(days - 1))) {
+                first++
+            }
+            row.Rolling = make(map[string]bigquery.NullFloat64, len(rollingColumns))
+            for _, col := range rollingColumns {
+                row.Rolling[rollingColumn(col.Column, days)] = rollingValue(rows[first:i+1], col.Variable, col.Sum, days, partial)
+            }
+        }
+    }
+}
+
+// rollingValue aggregates the variable over the window rows, which span at most days days.
+func rollingValue(window []*WeatherData, variable string, sum bool, days int, partial bool) bigquery.NullFloat64 {
+    total, n := 0.0, 0
+    for _, row := range window {
+        if v := row.floatField(variable); v != nil && v.Valid {
+            total += v.Float64
+            n++
+        }
+    }
+    if n == 0 || (n < days && !partial) {
+        return bigquery.NullFloat64{}
+    }
+    if !sum {
+        total /= float64(n)
+    }
+    return bigquery.NullFloat64{Float64: total, Valid: true}
+}
+
+// rollingRow saves a WeatherData row together with its rolling columns.
+type rollingRow struct {
+    row    *WeatherData
+    schema bigquery.Schema
+}
+
+// Save implements bigquery.ValueSaver.
+func (r rollingRow) Save() (map[string]bigquery.Value, string, error) {
+    values, _, err := (&bigquery.StructSaver{Struct: r.row, Schema: r.schema}).Save()
+    if err != nil {
+        return nil, "", err
+    }
+    for name, v := range r.row.Rolling {
+        values[name] = v
+    }
+    return values, "", nil
+}
+
+// rollingRows wraps the rows so their rolling columns are inserted too.
+func rollingRows(rows []*WeatherData) ([]bigquery.ValueSaver, error) {
+    schema, err := bigquery.InferSchema(WeatherData{})
+    if err != nil {
+        return nil, fmt.Errorf("failed to infer schema: %w", err)
+    }
+    out := make([]bigquery.ValueSaver, len(rows))
+    for i, row := range rows {
+        out[i] = rollingRow{row, schema}
+    }
+    return out, nil
+}
+
+// rollingSchema lists the rolling columns for a window of days.
+func rollingSchema(days int) bigquery.Schema {
+    schema := make(bigquery.Schema, 0, len(rollingColumns))
+    for _, col := range rollingColumns {
+        schema = append(schema, &bigquery.FieldSchema{Name: rollingColumn(col.Column, days), Type: bigquery.FloatFieldType})
+    }
+    return schema
+}
+
+// rollingTableMetadata builds the daily table metadata with the rolling columns added.
+func rollingTableMetadata(days int) func() (*bigquery.TableMetadata, error) {
+    return func() (*bigquery.TableMetadata, error) {
+        meta, err := newTableMetadata()
+        if err != nil {
+            return nil, err
+        }
+        // Clipped so the append cannot write into the cached inferred schema's spare capacity.
+        meta.Schema = append(slices.Clip(meta.Schema), rollingSchema(days)...)
+        return meta, nil
+    }
+}
@@ -0,0 +1,202 @@
+package main
+
+import (
+    "math"
+    "net/http"
+    "net/http/httptest"
+    "strings"
+    "testing"
+
+    "cloud.google.com/go/bigquery"
+)
+
+// rainRows builds rows of rain on the given dates, for the one-model series addRolling
+// aggregates. A negative amount is a NULL value.
+func rainRows(dates []string, rain []float64) []*WeatherData {
+    rows := make([]*WeatherData, len(dates))
+    for i, date := range dates {
+        rows[i] = &WeatherData{Date: date, MeanTemperature: nf(float64(i))}
+        if rain[i] >= 0 {
+            rows[i].RainSum = nf(rain[i])
+        }
+    }
+    return rows
+}
+
+func TestAddRolling(t *testing.T) {
+    null := bigquery.NullFloat64{}
+    consecutive := []string{"2024-01-01", "2024-01-02", "2024-01-03", "2024-01-04"}
+    tests := []struct {
+        name     string
+        dates    []string
+        rain     []float64
+        days     int
+        partial  bool
+        wantRain []bigquery.NullFloat64
+        wantMean []bigquery.NullFloat64
+    }{
+        {
+            name:     "leading edge is NULL",
+            dates:    consecutive,
+            rain:     []float64{1, 2, 3, 4},
+            days:     3,
+            wantRain: []bigquery.NullFloat64{null, null, nf(6), nf(9)},
+            wantMean: []bigquery.NullFloat64{null, null, nf(1), nf(2)},
+        },
+        {
+            name:     "leading edge is partial",
+            dates:    consecutive,
+            rain:     []float64{1, 2, 3, 4},
+            days:     3,
+            partial:  true,
+            wantRain: []bigquery.NullFloat64{nf(1), nf(3), nf(6), nf(9)},
+            wantMean: []bigquery.NullFloat64{nf(0), nf(0.5), nf(1), nf(2)},
+        },
+        {
+            name:     "gap in the dates",
+            dates:    []string{"2024-01-01", "2024-01-02", "2024-01-04", "2024-01-05"},
+            rain:     []float64{1, 2, 3, 4},
+            days:     2,
+            wantRain: []bigquery.NullFloat64{null, nf(3), null, nf(7)},
+            wantMean: []bigquery.NullFloat64{null, nf(0.5), null, nf(2.5)},
+        },
+        {
+            name:     "NULL value in the window",
+            dates:    consecutive,
+            rain:     []float64{1, -1, 3, 4},
+            days:     2,
+            wantRain: []bigquery.NullFloat64{null, null, null, nf(7)},
+            wantMean: []bigquery.NullFloat64{null, nf(0.5), nf(1.5), nf(2.5)},
+        },
+        {
+            name:     "NULL value in a partial window",
+            dates:    consecutive,
+            rain:     []float64{1, -1, 3, 4},
+            days:     2,
+            partial:  true,
+            wantRain: []bigquery.NullFloat64{nf(1), nf(1), nf(3), nf(7)},
+            wantMean: []bigquery.NullFloat64{nf(0), nf(0.5), nf(1.5), nf(2.5)},
+        },
+        {
+            name:     "window longer than the range",
+            dates:    consecutive[:2],
+            rain:     []float64{1, 2},
+            days:     7,
+            partial:  true,
+            wantRain: []bigquery.NullFloat64{nf(1), nf(3)},
+            wantMean: []bigquery.NullFloat64{nf(0), nf(0.5)},
+        },
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            rows := rainRows(tt.dates, tt.rain)
+            addRolling(rows, tt.days, tt.partial)
+            rainCol, meanCol := rollingColumn("rain_sum", tt.days), rollingColumn("mean_temperature", tt.days)
+            for i, row := range rows {
+                if got := row.Rolling[rainCol]; got != tt.wantRain[i] {
+                    t.Errorf("%s %s = %+v, want %+v", row.Date, rainCol, got, tt.wantRain[i])
+                }
+                if got := row.Rolling[meanCol]; got != tt.wantMean[i] {
+                    t.Errorf("%s %s = %+v, want %+v", row.Date, meanCol, got, tt.wantMean[i])
+                }
+                if len(row.Rolling) != len(rollingColumns) {
+                    t.Errorf("%s has %d rolling columns, want %d", row.Date, len(row.Rolling), len(rollingColumns))
+                }
+            }
+        })
+    }
+}
+
+func TestAddRollingPerModel(t *testing.T) {
+    rows := rainRows([]string{"2024-01-01", "2024-01-01", "2024-01-02", "2024-01-02"}, []float64{1, 10, 2, 20})
+    for i, model := range []string{"a", "b", "a", "b"} {
+        rows[i].SourceModel = bigquery.NullString{StringVal: model, Valid: true}
+    }
+    addRolling(rows, 2, false)
+    if got := rows[2].Rolling["rain_sum_2d"]; got != nf(3) {
+        t.Errorf("model a rain_sum_2d = %+v, want 3", got)
+    }
+    if got := rows[3].Rolling["rain_sum_2d"]; got != nf(30) {
+        t.Errorf("model b rain_sum_2d = %+v, want 30", got)
+    }
+}
+
+func TestRollingTableMetadata(t *testing.T) {
+    week, err := rollingTableMetadata(7)()
+    if err != nil {
+        t.Fatal(err)
+    }
+    if _, err := rollingTableMetadata(30)(); err != nil {
+        t.Fatal(err)
+    }
+    names := make(map[string]bool)
+    for _, f := range week.Schema {
+        names[f.Name] = true
+    }
+    for _, col := range rollingColumns {
+        if !names[col.Column] || !names[rollingColumn(col.Column, 7)] {
+            t.Errorf("schema lacks %s or its 7-day column", col.Column)
+        }
+        if names[rollingColumn(col.Column, 30)] {
+            t.Errorf("7-day schema has the 30-day %s column", col.Column)
+        }
+    }
+}
+
+func TestRollingInsert(t *testing.T) {
+    fake, _ := newFakeBigQuery(t)
+    withConfig(t, func(c *Config) { c.TableID = "daily_weather" })
+    stubOpenMeteo(t, serveBody(threeDays))
+    rec := httptest.NewRecorder()
+    fetchWeatherData(rec, httptest.NewRequest(http.MethodGet, "/?latitude=52.52&longitude=13.41&start_date=2024-01-01&end_date=2024-01-03&rolling=2", nil))
+    if rec.Code != http.StatusOK {
+        t.Fatalf("status = %d: %s", rec.Code, rec.Body)
+    }
+    var created bool
+    for _, f := range fake.table("daily_weather").Schema.Fields {
+        created = created || f.Name == "rain_sum_2d"
+    }
+    if !created {
+        t.Error("table was created without rain_sum_2d")
+    }
+    rows := fake.rows("daily_weather")
+    if len(rows) != 3 {
+        t.Fatalf("inserted %d rows, want 3", len(rows))
+    }
+    if got, ok := rows[0]["rain_sum_2d"]; ok && got != nil {
+        t.Errorf("first rain_sum_2d = %v, want NULL", got)
+    }
+    if got, _ := rows[2]["rain_sum_2d"].(float64); math.Abs(got-1.6) > 1e-9 {
+        t.Errorf("last rain_sum_2d = %v, want 1.6", rows[2]["rain_sum_2d"])
+    }
+}
+
+func TestParseRolling(t *testing.T) {
+    tests := []struct {
+        query   string
+        want    int
+        wantErr bool
+    }{
+        {"", 0, false},
+        {"&rolling=7", 7, false},
+        {"&rolling=366", 366, false},
+        {"&rolling=1", 0, true},
+        {"&rolling=367", 0, true},
+        {"&rolling=week", 0, true},
+        {"&rolling=7&aggregate=monthly", 0, true},
+    }
+    for _, tt := range tests {
+        t.Run(tt.query, func(t *testing.T) {
+            query := "latitude=52.52&longitude=13.41" + tt.query
+            if tt.wantErr {
+                if err := parseOptionsError(t, query); err == nil || !strings.Contains(err.Error(), "rolling") {
+                    t.Errorf("err = %v, want a rolling error", err)
+                }
+                return
+            }
+            if got := mustParseOptions(t, query).Rolling; got != tt.want {
+                t.Errorf("Rolling = %d, want %d", got, tt.want)
+            }
+        })
+    }
+}
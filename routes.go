@@ -0,0 +1,80 @@
+package main
+
+import (
+    "encoding/json"
+    "fmt"
+    "log/slog"
+    "os"
+    "slices"
+)
+
+// tableRoute sends the locations it matches to Table instead of the default daily table.
+// A route matches a location whose ID is listed, or whose coordinate lies within the box.
+type tableRoute struct {
+    Table       string       `json:"table"`
+    LocationIDs []string     `json:"location_ids"`
+    BBox        *boundingBox `json:"bbox"`
+}
+
+// boundingBox is an inclusive latitude/longitude box.
+type boundingBox struct {
+    MinLatitude  float64 `json:"min_latitude"`
+    MinLongitude float64 `json:"min_longitude"`
+    MaxLatitude  float64 `json:"max_latitude"`
+    MaxLongitude float64 `json:"max_longitude"`
+}
+
+// contains reports whether the coordinate lies within the box.
+func (b *boundingBox) contains(latitude, longitude float64) bool {
+    return latitude >= b.MinLatitude && latitude <= b.MaxLatitude &&
+        longitude >= b.MinLongitude && longitude <= b.MaxLongitude
+}
+
+// tableRoutes are the routing rules from TABLE_ROUTES_JSON, applied in order.
+var tableRoutes = loadTableRoutes(os.Getenv("TABLE_ROUTES_JSON"))
+
+// loadTableRoutes parses a JSON array of routes. Invalid rules are logged and the whole set
+// is ignored, so a bad config falls back to the default table rather than misrouting rows.
+func loadTableRoutes(s string) []tableRoute {
+    if s == "" {
+        return nil
+    }
+    var routes []tableRoute
+    if err := json.Unmarshal([]byte(s), &routes); err != nil {
+        slog.Error("Ignoring invalid TABLE_ROUTES_JSON", "error", err)
+        return nil
+    }
+    for i, r := range routes {
+        if err := r.validate(); err != nil {
+            slog.Error("Ignoring invalid TABLE_ROUTES_JSON", "route", i, "error", err)
+            return nil
+        }
+    }
+    return routes
+}
+
+// validate checks that the route names a valid table and has something to match on.
+func (r tableRoute) validate() error {
+    switch {
+    case r.Table == "" || !tableSuffixPattern.MatchString(r.Table):
+        return fmt.Errorf("invalid table %q", r.Table)
+    case r.BBox == nil && len(r.LocationIDs) == 0:
+        return fmt.Errorf("route for %s needs bbox or location_ids", r.Table)
+    case r.BBox != nil && (r.BBox.MinLatitude > r.BBox.MaxLatitude || r.BBox.MinLongitude > r.BBox.MaxLongitude):
+        return fmt.Errorf("route for %s has an inverted bbox", r.Table)
+    }
+    return nil
+}
+
+// routeTable returns the table of the first route matching the location, or "" for the default.
+func routeTable(loc Location) string {
+    for _, r := range tableRoutes {
+        if loc.ID != "" && slices.Contains(r.LocationIDs, loc.ID) {
+            return r.Table
+        }
+        if r.BBox != nil && r.BBox.contains(loc.Latitude, loc.Longitude) {
+            return r.Table
+        }
+    }
+    return ""
+}
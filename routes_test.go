@@ -0,0 +1,85 @@
+package main
+
+import (
+    "context"
+    "testing"
+)
+
+// withTableRoutes replaces the routing rules for the rest of the test.
+func withTableRoutes(t *testing.T, routes []tableRoute) {
+    t.Helper()
+    saved := tableRoutes
+    tableRoutes = routes
+    t.Cleanup(func() { tableRoutes = saved })
+}
+
+func TestLoadTableRoutes(t *testing.T) {
+    tests := []struct {
+        name string
+        json string
+        want int
+    }{
+        {"unset", "", 0},
+        {"bbox and ids", `[{"table":"tenant_eu","bbox":{"min_latitude":35,"min_longitude":-10,"max_latitude":70,"max_longitude":30}},{"table":"tenant_a","location_ids":["a1"]}]`, 2},
+        {"invalid JSON", `[{"table":`, 0},
+        {"route without a match rule", `[{"table":"tenant_a"}]`, 0},
+        {"invalid table name", `[{"table":"a.b","location_ids":["x"]}]`, 0},
+        {"inverted bbox", `[{"table":"t","bbox":{"min_latitude":10,"max_latitude":0,"min_longitude":0,"max_longitude":1}}]`, 0},
+        {"one bad route drops the set", `[{"table":"ok","location_ids":["x"]},{"table":""}]`, 0},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            if got := loadTableRoutes(tt.json); len(got) != tt.want {
+                t.Errorf("loaded %d routes, want %d", len(got), tt.want)
+            }
+        })
+    }
+}
+
+func TestRouteTable(t *testing.T) {
+    withTableRoutes(t, loadTableRoutes(`[
+        {"table":"tenant_a","location_ids":["a1","a2"]},
+        {"table":"tenant_eu","bbox":{"min_latitude":35,"min_longitude":-10,"max_latitude":70,"max_longitude":30}},
+        {"table":"tenant_berlin","bbox":{"min_latitude":52,"min_longitude":13,"max_latitude":53,"max_longitude":14}}
+    ]`))
+    tests := []struct {
+        name string
+        loc  Location
+        want string
+    }{
+        {"by id", Location{Latitude: -33.9, Longitude: 151.2, ID: "a2"}, "tenant_a"},
+        {"id wins over bbox", Location{Latitude: 52.5, Longitude: 13.4, ID: "a1"}, "tenant_a"},
+        {"first matching bbox", Location{Latitude: 52.5, Longitude: 13.4}, "tenant_eu"},
+        {"bbox edge is inclusive", Location{Latitude: 70, Longitude: -10}, "tenant_eu"},
+        {"no match uses the default", Location{Latitude: 40.7, Longitude: -74, ID: "nyc"}, ""},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            if got := routeTable(tt.loc); got != tt.want {
+                t.Errorf("routeTable(%+v) = %q, want %q", tt.loc, got, tt.want)
+            }
+        })
+    }
+}
+
+func TestIngestLocationsRoutesTables(t *testing.T) {
+    withTableRoutes(t, loadTableRoutes(`[{"table":"tenant_a","location_ids":["a1"]}]`))
+    fake, client := newFakeBigQuery(t)
+    stubOpenMeteo(t, serveBody(threeDays))
+    withConfig(t, func(c *Config) { c.TableID = "daily_weather" })
+    opts := mustParseOptions(t, "latitude=52.52&longitude=13.41&start_date=2024-01-01&end_date=2024-01-03")
+    results := ingestLocations(context.Background(), client, opts, []Location{
+        {Latitude: 52.52, Longitude: 13.41, ID: "a1"},
+        {Latitude: 48.85, Longitude: 2.35, ID: "paris"},
+    })
+    for i, res := range results {
+        if res.Error != "" {
+            t.Fatalf("location %d failed: %s", i, res.Error)
+        }
+    }
+    for table, want := range map[string]int{"tenant_a": 3, "daily_weather": 3} {
+        if got := len(fake.rows(table)); got != want {
+            t.Errorf("%s has %d rows, want %d", table, got, want)
+        }
+    }
+}
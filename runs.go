@@ -0,0 +1,69 @@
+package main
+
+import (
+    "context"
+    "fmt"
+    "net"
+    "net/http"
+    "strings"
+    "time"
+
+    "cloud.google.com/go/bigquery"
+)
+
+// IngestRun is the metadata recorded for each ingestion run in the ingest runs table.
+type IngestRun struct {
+    BatchID     string    `bigquery:"batch_id"`
+    Latitude    float64   `bigquery:"latitude"`
+    Longitude   float64   `bigquery:"longitude"`
+    StartDate   string    `bigquery:"start_date"`
+    EndDate     string    `bigquery:"end_date"`
+    RowCount    int       `bigquery:"row_count"`
+    DurationMs  int64     `bigquery:"duration_ms"`
+    CompletedAt time.Time `bigquery:"completed_at"`
+    // SourceIP and UserAgent identify the caller; NULL unless RECORD_CALLER_INFO is set.
+    SourceIP  bigquery.NullString `bigquery:"source_ip"`
+    UserAgent bigquery.NullString `bigquery:"user_agent"`
+}
+
+// callerIP returns the client address of the request: the first X-Forwarded-For entry,
+// set by the load balancer in front of the function, or else the remote address.
+func callerIP(r *http.Request) string {
+    if fwd := r.Header.Get("X-Forwarded-For"); fwd != "" {
+        first, _, _ := strings.Cut(fwd, ",")
+        return strings.TrimSpace(first)
+    }
+    if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+        return host
+    }
+    return r.RemoteAddr
+}
+
+// withCaller records who made the request on the options when RECORD_CALLER_INFO is set.
+func withCaller(r *http.Request, opts *requestOptions) *requestOptions {
+    if cfg.RecordCallerInfo {
+        opts.CallerIP = callerIP(r)
+        opts.UserAgent = r.UserAgent()
+    }
+    return opts
+}
+
+// recordIngestRun writes the run record for a completed ingestion, creating the table on first use.
+func recordIngestRun(ctx context.Context, client *bigquery.Client, run *IngestRun) error {
+    if _, err := ensureTable(ctx, client, cfg.IngestRunsTable, ingestRunsMetadata); err != nil {
+        return err
+    }
+    if err := putRows(ctx, client.Dataset(cfg.DatasetID).Table(cfg.IngestRunsTable), run); err != nil {
+        return fmt.Errorf("failed to insert ingest run: %w", err)
+    }
+    return nil
+}
+
+// ingestRunsMetadata builds the schema for a new ingest runs table.
+func ingestRunsMetadata() (*bigquery.TableMetadata, error) {
+    schema, err := bigquery.InferSchema(IngestRun{})
+    if err != nil {
+        return nil, fmt.Errorf("failed to infer schema: %w", err)
+    }
+    return &bigquery.TableMetadata{Schema: schema}, nil
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+    "context"
+    "net/http"
+    "net/http/httptest"
+    "strings"
+    "testing"
+    "time"
+)
+
+func TestCallerIP(t *testing.T) {
+    tests := []struct {
+        name      string
+        forwarded string
+        remote    string
+        want      string
+    }{
+        {"remote address", "", "203.0.113.7:5123", "203.0.113.7"},
+        {"first forwarded entry", "198.51.100.1, 10.0.0.1", "10.0.0.2:80", "198.51.100.1"},
+        {"single forwarded entry", " 198.51.100.2 ", "10.0.0.2:80", "198.51.100.2"},
+        {"remote without port", "", "203.0.113.8", "203.0.113.8"},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            r := httptest.NewRequest(http.MethodGet, "/", nil)
+            r.RemoteAddr = tt.remote
+            if tt.forwarded != "" {
+                r.Header.Set("X-Forwarded-For", tt.forwarded)
+            }
+            if got := callerIP(r); got != tt.want {
+                t.Errorf("callerIP() = %q, want %q", got, tt.want)
+            }
+        })
+    }
+}
+
+func TestWithCaller(t *testing.T) {
+    tests := []struct {
+        name      string
+        record    bool
+        wantIP    string
+        wantAgent string
+    }{
+        {"disabled", false, "", ""},
+        {"enabled", true, "203.0.113.7", "cron/1.0"},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            withConfig(t, func(c *Config) { c.RecordCallerInfo = tt.record })
+            r := httptest.NewRequest(http.MethodGet, "/", nil)
+            r.RemoteAddr = "203.0.113.7:5123"
+            r.Header.Set("User-Agent", "cron/1.0")
+            opts := withCaller(r, &requestOptions{})
+            if opts.CallerIP != tt.wantIP || opts.UserAgent != tt.wantAgent {
+                t.Errorf("caller = %q/%q, want %q/%q", opts.CallerIP, opts.UserAgent, tt.wantIP, tt.wantAgent)
+            }
+        })
+    }
+}
+
+func TestRecordIngestRun(t *testing.T) {
+    tests := []struct {
+        name   string
+        exists bool
+    }{
+        {"creates the runs table", false},
+        {"appends to an existing table", true},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            fake, client := newFakeBigQuery(t)
+            if tt.exists {
+                fake.addTable(t, cfg.IngestRunsTable, IngestRun{})
+            }
+            run := &IngestRun{
+                BatchID:     "batch-1",
+                Latitude:    52.52,
+                Longitude:   13.41,
+                StartDate:   "2024-01-01",
+                EndDate:     "2024-01-31",
+                RowCount:    31,
+                DurationMs:  1250,
+                CompletedAt: time.Date(2024, 2, 1, 0, 0, 0, 0, time.UTC),
+            }
+            if err := recordIngestRun(context.Background(), client, run); err != nil {
+                t.Fatal(err)
+            }
+            if fake.table(cfg.IngestRunsTable) == nil {
+                t.Fatal("runs table was not created")
+            }
+            rows := fake.rows(cfg.IngestRunsTable)
+            if len(rows) != 1 {
+                t.Fatalf("inserted %d rows, want 1", len(rows))
+            }
+            if rows[0]["batch_id"] != "batch-1" || rows[0]["duration_ms"] == nil {
+                t.Errorf("inserted row = %v", rows[0])
+            }
+            if _, ok := rows[0]["source_ip"]; ok && rows[0]["source_ip"] != nil {
+                t.Errorf("source_ip = %v, want NULL", rows[0]["source_ip"])
+            }
+        })
+    }
+}
+
+func TestIngestRunCallerInfo(t *testing.T) {
+    tests := []struct {
+        name      string
+        record    bool
+        method    string
+        body      string
+        wantIP    interface{}
+        wantAgent interface{}
+    }{
+        {"disabled", false, http.MethodGet, "", nil, nil},
+        {"enabled", true, http.MethodGet, "", "198.51.100.1", "backfill/2.1"},
+        {"enabled for jobs", true, http.MethodPost, `{"locations":[{"latitude":52.52,"longitude":13.41}],"start_date":"2024-01-01","end_date":"2024-01-03"}`, "198.51.100.1", "backfill/2.1"},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            fake, _ := newFakeBigQuery(t)
+            withConfig(t, func(c *Config) { c.RecordIngestRuns, c.RecordCallerInfo = true, tt.record })
+            stubOpenMeteo(t, serveBody(threeDays))
+
+            r := httptest.NewRequest(tt.method, "/?latitude=52.52&longitude=13.41&start_date=2024-01-01&end_date=2024-01-03", strings.NewReader(tt.body))
+            r.Header.Set("X-Forwarded-For", "198.51.100.1, 10.0.0.1")
+            r.Header.Set("User-Agent", "backfill/2.1")
+            rec := httptest.NewRecorder()
+            fetchWeatherData(rec, r)
+            if rec.Code != http.StatusOK && rec.Code != http.StatusMultiStatus {
+                t.Fatalf("status = %d: %s", rec.Code, rec.Body)
+            }
+            rows := fake.rows(cfg.IngestRunsTable)
+            if len(rows) != 1 {
+                t.Fatalf("recorded %d runs, want 1", len(rows))
+            }
+            if rows[0]["source_ip"] != tt.wantIP || rows[0]["user_agent"] != tt.wantAgent {
+                t.Errorf("caller = %v/%v, want %v/%v", rows[0]["source_ip"], rows[0]["user_agent"], tt.wantIP, tt.wantAgent)
+            }
+            if n := len(fake.rows(cfg.TableID)); n != 3 {
+                t.Fatalf("inserted %d weather rows, want 3", n)
+            }
+            if _, ok := fake.rows(cfg.TableID)[0]["source_ip"]; ok {
+                t.Error("weather rows carry the caller")
+            }
+        })
+    }
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+    "math"
+    "reflect"
+
+    "cloud.google.com/go/bigquery"
+)
+
+// nullFloat64Type is the reflected type of the nullable float columns.
+var nullFloat64Type = reflect.TypeOf(bigquery.NullFloat64{})
+
+// sanitizeNonFinite replaces NaN and infinite values within v, so encoding/json, which
+// rejects them, cannot fail part way through a response. Nullable floats and the float
+// values of maps, as returned by queries, become NULL or cfg.NonFiniteSentinel when one is
+// configured; plain float fields, which cannot be NULL, become the sentinel or 0. v is
+// walked through pointers, slices, maps, and struct fields and changed in place; when it
+// is not a pointer the sanitized copy is returned.
+func sanitizeNonFinite(v interface{}) interface{} {
+    // Holding v in an addressable interface lets a struct passed by value be replaced.
+    sanitizeValue(reflect.ValueOf(&v).Elem())
+    return v
+}
+
+func sanitizeValue(v reflect.Value) {
+    switch v.Kind() {
+    case reflect.Pointer:
+        if !v.IsNil() {
+            sanitizeValue(v.Elem())
+        }
+    case reflect.Interface:
+        if v.IsNil() {
+            return
+        }
+        elem := v.Elem()
+        switch {
+        case elem.Kind() == reflect.Float32 || elem.Kind() == reflect.Float64:
+            if !isFinite(elem.Float()) && v.CanSet() {
+                v.Set(finiteValue(v.Type()))
+            }
+        case elem.Kind() == reflect.Pointer || !v.CanSet():
+            sanitizeValue(elem)
+        default:
+            // The value held by an interface is not addressable, so a copy is sanitized and
+            // stored back.
+            c := reflect.New(elem.Type()).Elem()
+            c.Set(elem)
+            sanitizeValue(c)
+            v.Set(c)
+        }
+    case reflect.Float32, reflect.Float64:
+        if !isFinite(v.Float()) && v.CanSet() {
+            v.Set(finiteValue(v.Type()))
+        }
+    case reflect.Slice, reflect.Array:
+        for i := 0; i < v.Len(); i++ {
+            sanitizeValue(v.Index(i))
+        }
+    case reflect.Struct:
+        if v.Type() == nullFloat64Type {
+            if n := v.Interface().(bigquery.NullFloat64); n.Valid && !isFinite(n.Float64) && v.CanSet() {
+                v.Set(reflect.ValueOf(finiteNull()))
+            }
+            return
+        }
+        for i := 0; i < v.NumField(); i++ {
+            if v.Type().Field(i).IsExported() {
+                sanitizeValue(v.Field(i))
+            }
+        }
+    case reflect.Map:
+        // Map values are not addressable, so each is sanitized as a copy and stored back.
+        iter := v.MapRange()
+        for iter.Next() {
+            c := reflect.New(v.Type().Elem()).Elem()
+            c.Set(iter.Value())
+            sanitizeValue(c)
+            v.SetMapIndex(iter.Key(), c)
+        }
+    }
+}
+
+// isFinite reports whether f is neither NaN nor infinite.
+func isFinite(f float64) bool {
+    return !math.IsNaN(f) && !math.IsInf(f, 0)
+}
+
+// finiteNull is the replacement for a non-finite nullable float.
+func finiteNull() bigquery.NullFloat64 {
+    if cfg.NonFiniteSentinel != nil {
+        return bigquery.NullFloat64{Float64: *cfg.NonFiniteSentinel, Valid: true}
+    }
+    return bigquery.NullFloat64{}
+}
+
+// finiteValue is the replacement for a non-finite float or interface value of type t: the
+// sentinel when one is configured, otherwise the zero value, which is JSON null for the
+// interface values of query rows.
+func finiteValue(t reflect.Type) reflect.Value {
+    if cfg.NonFiniteSentinel != nil {
+        return reflect.ValueOf(*cfg.NonFiniteSentinel).Convert(t)
+    }
+    return reflect.Zero(t)
+}
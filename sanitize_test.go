@@ -0,0 +1,113 @@
+package main
+
+import (
+    "encoding/json"
+    "math"
+    "net/http"
+    "net/http/httptest"
+    "testing"
+
+    "cloud.google.com/go/bigquery"
+)
+
+func TestSanitizeNonFinite(t *testing.T) {
+    nan, inf := math.NaN(), math.Inf(1)
+    type result struct {
+        Coverage float64              `json:"coverage"`
+        Score    bigquery.NullFloat64 `json:"score"`
+        Rows     int                  `json:"rows"`
+    }
+    tests := []struct {
+        name     string
+        sentinel *float64
+        v        func() interface{}
+        want     string
+    }{
+        {"finite values are kept", nil, func() interface{} { return &result{0.5, nf(0.75), 3} }, `{"coverage":0.5,"score":0.75,"rows":3}`},
+        {"plain float becomes 0", nil, func() interface{} { return &result{nan, nf(inf), 3} }, `{"coverage":0,"score":null,"rows":3}`},
+        {"struct passed by value", nil, func() interface{} { return result{math.Inf(-1), nf(1), 3} }, `{"coverage":0,"score":1,"rows":3}`},
+        {"sentinel", floatPtr(-9999), func() interface{} { return &result{nan, nf(inf), 3} }, `{"coverage":-9999,"score":-9999,"rows":3}`},
+        {"query row values", nil, func() interface{} {
+            return []map[string]bigquery.Value{{"mean": nan, "n": int64(2), "max": 4.5}}
+        }, `[{"max":4.5,"mean":null,"n":2}]`},
+        {"query row sentinel", floatPtr(-1), func() interface{} { return map[string]interface{}{"mean": inf} }, `{"mean":-1}`},
+        {"nested in a map", nil, func() interface{} {
+            return map[string]interface{}{"ranges": []result{{nan, nf(nan), 0}}, "summary": result{inf, nf(1), 1}}
+        }, `{"ranges":[{"coverage":0,"score":null,"rows":0}],"summary":{"coverage":0,"score":1,"rows":1}}`},
+        {"float map values", nil, func() interface{} { return map[string]float64{"a": nan, "b": 2} }, `{"a":0,"b":2}`},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            withConfig(t, func(c *Config) { c.NonFiniteSentinel = tt.sentinel })
+            b, err := json.Marshal(sanitizeNonFinite(tt.v()))
+            if err != nil {
+                t.Fatalf("encoding failed after sanitizing: %v", err)
+            }
+            if string(b) != tt.want {
+                t.Errorf("encoded %s, want %s", b, tt.want)
+            }
+        })
+    }
+}
+
+func TestSanitizeNonFiniteInPlace(t *testing.T) {
+    withConfig(t, func(c *Config) { c.NonFiniteSentinel = nil })
+    row := &WeatherData{Date: "2024-01-01", MeanTemperature: nf(math.NaN()), RainSum: nf(1.5)}
+    sanitizeNonFinite(row)
+    if row.MeanTemperature.Valid || row.RainSum != nf(1.5) {
+        t.Errorf("row = %+v, %+v; want NULL, 1.5", row.MeanTemperature, row.RainSum)
+    }
+}
+
+func TestNonFiniteResponses(t *testing.T) {
+    nan := math.NaN()
+    tests := []struct {
+        name  string
+        write func(w http.ResponseWriter)
+    }{
+        {"writeJSON", func(w http.ResponseWriter) {
+            writeJSON(w, http.StatusOK, map[string]interface{}{"locations": []LocationResult{{Latitude: nan}}, "coverage": nan})
+        }},
+        {"streamJSON", func(w http.ResponseWriter) {
+            streamJSON(w, []*WeatherData{{Date: "2024-01-01", GDD: nf(nan)}, {Date: "2024-01-02", QualityScore: nf(math.Inf(1))}})
+        }},
+        {"streamGeoJSON", func(w http.ResponseWriter) {
+            streamGeoJSON(w, []*WeatherData{{Date: "2024-01-01", GDD: nf(nan)}})
+        }},
+        {"preview", func(w http.ResponseWriter) {
+            writeJSON(w, http.StatusOK, previewRows([]*WeatherData{{Date: "2024-01-01", ET0: nf(nan)}}, 1))
+        }},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            withConfig(t, func(c *Config) { c.NonFiniteSentinel = nil })
+            rec := httptest.NewRecorder()
+            tt.write(rec)
+            if !json.Valid(rec.Body.Bytes()) {
+                t.Errorf("response is not valid JSON: %s", rec.Body)
+            }
+        })
+    }
+}
+
+func TestLoadConfigNonFiniteSentinel(t *testing.T) {
+    tests := []struct {
+        value string
+        want  *float64
+    }{
+        {"", nil},
+        {"-9999", floatPtr(-9999)},
+        {"NaN", nil},
+        {"Inf", nil},
+        {"none", nil},
+    }
+    for _, tt := range tests {
+        t.Run(tt.value, func(t *testing.T) {
+            t.Setenv("NONFINITE_JSON_SENTINEL", tt.value)
+            got := loadConfig().NonFiniteSentinel
+            if (got == nil) != (tt.want == nil) || got != nil && *got != *tt.want {
+                t.Errorf("NonFiniteSentinel = %v, want %v", got, tt.want)
+            }
+        })
+    }
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+    "fmt"
+    "slices"
+
+    "cloud.google.com/go/bigquery"
+)
+
+// latestSchemaVersion is the schema version of WeatherData with every column.
+const latestSchemaVersion = "v2"
+
+// schemaVersion selects the columns rows are built with and new tables are created with.
+// A nil column list means every WeatherData column.
+type schemaVersion struct {
+    Columns []string
+}
+
+// schemaVersions is the registry of versions a request may target with schema_version, so
+// consumers of an older layout keep getting it as columns are added.
+var schemaVersions = map[string]schemaVersion{
+    // v1 is the original table: the five core values with their coordinate and date.
+    "v1": {Columns: []string{"latitude", "longitude", "date", "mean_temperature", "min_temperature", "max_temperature", "rain_sum", "snowfall_sum", "inserted_at"}},
+    "v2": {},
+}
+
+// versionedRow saves a WeatherData row with only the columns of a schema version.
+type versionedRow struct {
+    row     *WeatherData
+    schema  bigquery.Schema
+    columns []string
+}
+
+// Save implements bigquery.ValueSaver.
+func (r versionedRow) Save() (map[string]bigquery.Value, string, error) {
+    values, _, err := (&bigquery.StructSaver{Struct: r.row, Schema: r.schema}).Save()
+    if err != nil {
+        return nil, "", err
+    }
+    for name := range values {
+        if !slices.Contains(r.columns, name) {
+            delete(values, name)
+        }
+    }
+    return values, "", nil
+}
+
+// versionedRows builds the rows for a schema version.
+func versionedRows(rows []*WeatherData, v schemaVersion) ([]bigquery.ValueSaver, error) {
+    schema, err := bigquery.InferSchema(WeatherData{})
+    if err != nil {
+        return nil, fmt.Errorf("failed to infer schema: %w", err)
+    }
+    out := make([]bigquery.ValueSaver, len(rows))
+    for i, row := range rows {
+        out[i] = versionedRow{row, schema, v.Columns}
+    }
+    return out, nil
+}
+
+// metadata builds the table metadata for a new table of the schema version: the daily
+// table metadata with the columns outside the version removed.
+func (v schemaVersion) metadata() (*bigquery.TableMetadata, error) {
+    meta, err := newTableMetadata()
+    if err != nil || v.Columns == nil {
+        return meta, err
+    }
+    // The inferred schema is cached and shared, so the version's columns are copied out
+    // rather than deleted in place.
+    var schema bigquery.Schema
+    for _, f := range meta.Schema {
+        if slices.Contains(v.Columns, f.Name) {
+            schema = append(schema, f)
+        }
+    }
+    meta.Schema = schema
+    return meta, nil
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+    "net/http"
+    "net/http/httptest"
+    "sort"
+    "strings"
+    "testing"
+
+    "cloud.google.com/go/bigquery"
+)
+
+func TestSchemaVersionInsert(t *testing.T) {
+    v1 := schemaVersions["v1"].Columns
+    tests := []struct {
+        version     string
+        wantColumns []string
+        wantMissing []string
+    }{
+        {"v1", v1, []string{"batch_id", "weather_code", "surface_pressure_mean"}},
+        {"v2", append(v1, "batch_id", "weather_code", "surface_pressure_mean"), nil},
+    }
+    for _, tt := range tests {
+        t.Run(tt.version, func(t *testing.T) {
+            fake, _ := newFakeBigQuery(t)
+            table := "daily_" + tt.version
+            withConfig(t, func(c *Config) { c.TableID = table })
+            stubOpenMeteo(t, serveBody(threeDays))
+
+            rec := httptest.NewRecorder()
+            fetchWeatherData(rec, httptest.NewRequest(http.MethodGet, "/?latitude=52.52&longitude=13.41&start_date=2024-01-01&end_date=2024-01-03&daily=weather_code,surface_pressure_mean&schema_version="+tt.version, nil))
+            if rec.Code != http.StatusOK {
+                t.Fatalf("status = %d: %s", rec.Code, rec.Body)
+            }
+
+            created := make(map[string]bool)
+            for _, f := range fake.table(table).Schema.Fields {
+                created[f.Name] = true
+            }
+            rows := fake.rows(table)
+            if len(rows) != 3 {
+                t.Fatalf("inserted %d rows, want 3", len(rows))
+            }
+            for _, name := range tt.wantColumns {
+                if !created[name] {
+                    t.Errorf("created table lacks %s", name)
+                }
+                if _, ok := rows[0][name]; !ok {
+                    t.Errorf("inserted row lacks %s", name)
+                }
+            }
+            for _, name := range tt.wantMissing {
+                if created[name] {
+                    t.Errorf("created table has %s, outside %s", name, tt.version)
+                }
+                if _, ok := rows[0][name]; ok {
+                    t.Errorf("inserted row has %s, outside %s", name, tt.version)
+                }
+            }
+        })
+    }
+}
+
+func TestSchemaVersionMetadata(t *testing.T) {
+    meta, err := schemaVersions["v1"].metadata()
+    if err != nil {
+        t.Fatal(err)
+    }
+    var got []string
+    for _, f := range meta.Schema {
+        got = append(got, f.Name)
+    }
+    want := append([]string(nil), schemaVersions["v1"].Columns...)
+    sort.Strings(got)
+    sort.Strings(want)
+    if strings.Join(got, ",") != strings.Join(want, ",") {
+        t.Errorf("v1 schema = %v, want %v", got, want)
+    }
+    // The inferred schema is shared with every other row builder.
+    inferred, _ := bigquery.InferSchema(WeatherData{})
+    for i, f := range inferred {
+        if f == nil {
+            t.Fatalf("building the v1 schema cleared inferred field %d", i)
+        }
+    }
+    latest, err := schemaVersions[latestSchemaVersion].metadata()
+    if err != nil {
+        t.Fatal(err)
+    }
+    full, _ := newTableMetadata()
+    if len(latest.Schema) != len(full.Schema) {
+        t.Errorf("%s schema has %d columns, want all %d", latestSchemaVersion, len(latest.Schema), len(full.Schema))
+    }
+}
+
+func TestParseSchemaVersion(t *testing.T) {
+    tests := []struct {
+        query   string
+        want    string
+        wantErr bool
+    }{
+        {"", latestSchemaVersion, false},
+        {"&schema_version=v1", "v1", false},
+        {"&schema_version=v2", "v2", false},
+        {"&schema_version=v3", "", true},
+        {"&schema_version=v1&write_api=storage", "", true},
+        {"&schema_version=v1&aggregate=monthly", "", true},
+    }
+    for _, tt := range tests {
+        t.Run(tt.query, func(t *testing.T) {
+            query := "latitude=52.52&longitude=13.41" + tt.query
+            if tt.wantErr {
+                if err := parseOptionsError(t, query); err == nil {
+                    t.Error("parseQueryOptions() succeeded, want an error")
+                }
+                return
+            }
+            if got := mustParseOptions(t, query).SchemaVersion; got != tt.want {
+                t.Errorf("SchemaVersion = %q, want %q", got, tt.want)
+            }
+        })
+    }
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+    "context"
+    "fmt"
+    "net/http"
+    "strconv"
+)
+
+// returnRows fetches the rows for one location and writes them in the requested format, without
+// creating a BigQuery client. The response is capped at cfg.MaxResponseRows daily rows
+// (100,000 by default): larger results are rejected with a 413, or with
+// MAX_RESPONSE_ROWS_POLICY=truncate cut to the cap and flagged with X-Truncated and X-Total-Rows.
+func returnRows(ctx context.Context, w http.ResponseWriter, opts *requestOptions, multi bool) {
+    if multi || opts.CoordsGCSURI != "" {
+        http.Error(w, "sink=none supports a single location", http.StatusBadRequest)
+        return
+    }
+
+    result, weatherData, err := fetchRows(ctx, nil, opts)
+    if err != nil {
+        writeError(w, err)
+        return
+    }
+    setUpstreamHeaders(w, result)
+    if result.Empty {
+        writeEmpty(w, result)
+        return
+    }
+    if opts.Preview > 0 {
+        writeJSON(w, http.StatusOK, previewRows(weatherData, opts.Preview))
+        return
+    }
+    writeRowsResponse(w, opts, weatherData)
+}
+
+// maxPreviewRows caps preview=N.
+const maxPreviewRows = 100
+
+// rowsPreview is the response of preview=N.
+type rowsPreview struct {
+    TotalRows int            `json:"total_rows"`
+    Head      []*WeatherData `json:"head"`
+    Tail      []*WeatherData `json:"tail"`
+}
+
+// previewRows returns the first n and last n rows with the total count. When the range
+// has fewer than 2n rows, the tail holds only the rows not already in the head.
+func previewRows(rows []*WeatherData, n int) rowsPreview {
+    head := rows[:min(n, len(rows))]
+    tail := rows[max(len(rows)-n, len(head)):]
+    return rowsPreview{TotalRows: len(rows), Head: head, Tail: tail}
+}
+
+// writeEmpty answers a request whose range had no data with a 204, passing the earliest
+// date hint in X-Earliest-Available-Date since a 204 carries no body.
+func writeEmpty(w http.ResponseWriter, result *ingestResult) {
+    if result.EarliestDate != "" {
+        w.Header().Set("X-Earliest-Available-Date", result.EarliestDate)
+    }
+    w.WriteHeader(http.StatusNoContent)
+}
+
+// writeRowsResponse serializes the rows in the shape and format the options ask for, capped
+// at cfg.MaxResponseRows.
+func writeRowsResponse(w http.ResponseWriter, opts *requestOptions, weatherData []*WeatherData) {
+    if len(weatherData) > cfg.MaxResponseRows {
+        if cfg.ResponseRowsPolicy != "truncate" {
+            http.Error(w, fmt.Sprintf("Response would contain %d rows, more than the limit of %d; narrow the date range", len(weatherData), cfg.MaxResponseRows), http.StatusRequestEntityTooLarge)
+            return
+        }
+        // The body keeps its usual shape, so the truncation is signalled in headers.
+        w.Header().Set("X-Truncated", "true")
+        w.Header().Set("X-Total-Rows", strconv.Itoa(len(weatherData)))
+        weatherData = weatherData[:cfg.MaxResponseRows]
+    }
+    if opts.Aggregate == "monthly" {
+        streamJSON(w, aggregateMonthly(weatherData))
+        return
+    }
+    if opts.Layout == "long" {
+        streamJSON(w, longRows(weatherData, opts.Variables))
+        return
+    }
+    if len(opts.Models) > 0 && opts.ModelLayout == "columns" {
+        streamJSON(w, mergeModelColumns(opts, weatherData))
+        return
+    }
+    writeRows(w, opts.Format, weatherData)
+}
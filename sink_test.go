@@ -0,0 +1,286 @@
+package main
+
+import (
+    "context"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "net/http"
+    "net/http/httptest"
+    "reflect"
+    "strings"
+    "testing"
+    "time"
+
+    "cloud.google.com/go/bigquery"
+)
+
+// threeDays is an Open-Meteo payload with three days of core variables.
+const threeDays = `{"latitude":52.5,"longitude":13.4,"daily":{"time":["2024-01-01","2024-01-02","2024-01-03"],` +
+    `"temperature_2m_min":[-1,0,1],"temperature_2m_max":[4,5,6],"temperature_2m_mean":[1.5,2.5,3.5],"rain_sum":[0,0.4,1.2],"snowfall_sum":[0,0,0]}}`
+
+// noBigQuery fails the test if a handler tries to create a BigQuery client.
+func noBigQuery(t *testing.T) {
+    t.Helper()
+    saved := newBigQueryClient
+    newBigQueryClient = func(ctx context.Context) (*bigquery.Client, error) {
+        t.Error("BigQuery client created")
+        return nil, errors.New("no BigQuery in this test")
+    }
+    t.Cleanup(func() { newBigQueryClient = saved })
+}
+
+func TestReturnRows(t *testing.T) {
+    tests := []struct {
+        name       string
+        body       string
+        multi      bool
+        query      string
+        wantStatus int
+        wantRows   int
+    }{
+        {"rows are returned", threeDays, false, "", http.StatusOK, 3},
+        {"empty range", `{"latitude":52.5,"longitude":13.4,"daily":{"time":[]}}`, false, "", http.StatusNoContent, 0},
+        {"multiple locations", threeDays, true, "", http.StatusBadRequest, 0},
+        {"coordinates file", threeDays, false, "&coords_gcs_uri=gs://coords/daily.csv", http.StatusBadRequest, 0},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            noBigQuery(t)
+            stubOpenMeteo(t, serveBody(tt.body))
+            query := "latitude=52.52&longitude=13.41&start_date=2024-01-01&end_date=2024-01-03&sink=none" + tt.query
+            opts := mustParseOptions(t, query)
+            rec := httptest.NewRecorder()
+            returnRows(context.Background(), rec, opts, tt.multi)
+            if rec.Code != tt.wantStatus {
+                t.Fatalf("status = %d, want %d: %s", rec.Code, tt.wantStatus, rec.Body)
+            }
+            if tt.wantStatus != http.StatusOK {
+                return
+            }
+            var rows []map[string]interface{}
+            if err := json.Unmarshal(rec.Body.Bytes(), &rows); err != nil {
+                t.Fatal(err)
+            }
+            if len(rows) != tt.wantRows {
+                t.Errorf("got %d rows, want %d", len(rows), tt.wantRows)
+            }
+            if len(rows) > 0 && rows[1]["rain_sum"] != 0.4 {
+                t.Errorf("row 1 = %v", rows[1])
+            }
+        })
+    }
+}
+
+func TestWriteRowsResponseCap(t *testing.T) {
+    tests := []struct {
+        name          string
+        max           int
+        policy        string
+        rows          int
+        wantStatus    int
+        wantRows      int
+        wantTruncated string
+        wantTotal     string
+    }{
+        {"under the cap", 5, "reject", 3, http.StatusOK, 3, "", ""},
+        {"at the cap", 3, "reject", 3, http.StatusOK, 3, "", ""},
+        {"over the cap is rejected", 2, "reject", 3, http.StatusRequestEntityTooLarge, 0, "", ""},
+        {"over the cap is truncated", 2, "truncate", 3, http.StatusOK, 2, "true", "3"},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            withConfig(t, func(c *Config) { c.MaxResponseRows, c.ResponseRowsPolicy = tt.max, tt.policy })
+            rec := httptest.NewRecorder()
+            writeRowsResponse(rec, mustParseOptions(t, "latitude=52.52&longitude=13.41&sink=none"), manyRows(tt.rows))
+            if rec.Code != tt.wantStatus {
+                t.Fatalf("status = %d, want %d", rec.Code, tt.wantStatus)
+            }
+            if rec.Header().Get("X-Truncated") != tt.wantTruncated || rec.Header().Get("X-Total-Rows") != tt.wantTotal {
+                t.Errorf("X-Truncated %q, X-Total-Rows %q; want %q, %q", rec.Header().Get("X-Truncated"), rec.Header().Get("X-Total-Rows"), tt.wantTruncated, tt.wantTotal)
+            }
+            if tt.wantStatus != http.StatusOK {
+                return
+            }
+            var rows []map[string]interface{}
+            if err := json.Unmarshal(rec.Body.Bytes(), &rows); err != nil {
+                t.Fatal(err)
+            }
+            if len(rows) != tt.wantRows {
+                t.Errorf("got %d rows, want %d", len(rows), tt.wantRows)
+            }
+        })
+    }
+}
+
+func TestReturnRowsWithInsert(t *testing.T) {
+    tests := []struct {
+        name        string
+        query       string
+        max         int
+        policy      string
+        wantRows    int
+        wantSummary bool
+    }{
+        {"rows are inserted and returned", "", 100, "reject", 3, false},
+        {"influx", "&format=influx", 100, "reject", 3, false},
+        {"truncated to the response limit", "", 2, "truncate", 2, false},
+        {"over the response limit", "", 2, "reject", 0, true},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            fake, _ := newFakeBigQuery(t)
+            withConfig(t, func(c *Config) {
+                c.TableID = "daily_weather"
+                c.MaxResponseRows, c.ResponseRowsPolicy = tt.max, tt.policy
+            })
+            stubOpenMeteo(t, serveBody(threeDays))
+
+            rec := httptest.NewRecorder()
+            fetchWeatherData(rec, httptest.NewRequest(http.MethodGet, "/?latitude=52.52&longitude=13.41&start_date=2024-01-01&end_date=2024-01-03&return_rows=true"+tt.query, nil))
+            if rec.Code != http.StatusOK {
+                t.Fatalf("status = %d, want 200: %s", rec.Code, rec.Body)
+            }
+            if n := len(fake.rows("daily_weather")); n != 3 {
+                t.Errorf("inserted %d rows, want 3", n)
+            }
+            if tt.wantSummary {
+                if !strings.Contains(rec.Body.String(), "Successfully inserted 3 rows") || !strings.Contains(rec.Body.String(), "rows not returned") {
+                    t.Errorf("body %q, want the summary explaining the rows were not returned", rec.Body)
+                }
+                return
+            }
+            if got := rec.Header().Get("X-Inserted-Rows"); got != "3" {
+                t.Errorf("X-Inserted-Rows = %q, want 3", got)
+            }
+            if rec.Header().Get("X-Batch-Id") == "" {
+                t.Error("X-Batch-Id is not set")
+            }
+            var got int
+            if tt.query == "&format=influx" {
+                got = strings.Count(rec.Body.String(), "\n")
+            } else {
+                var rows []map[string]interface{}
+                if err := json.Unmarshal(rec.Body.Bytes(), &rows); err != nil {
+                    t.Fatalf("%v: %s", err, rec.Body)
+                }
+                got = len(rows)
+            }
+            if got != tt.wantRows {
+                t.Errorf("returned %d rows, want %d: %s", got, tt.wantRows, rec.Body)
+            }
+        })
+    }
+}
+
+func TestReturnRowsSingleLocation(t *testing.T) {
+    noBigQuery(t)
+    stubOpenMeteo(t, serveBody(threeDays))
+    rec := httptest.NewRecorder()
+    fetchWeatherData(rec, httptest.NewRequest(http.MethodGet, "/?min_lat=52&min_lon=13&max_lat=53&max_lon=14&step=1&start_date=2024-01-01&end_date=2024-01-03&return_rows=true", nil))
+    if rec.Code != http.StatusBadRequest || !strings.Contains(rec.Body.String(), "single location") {
+        t.Errorf("status = %d, body %q; want a 400 for a grid", rec.Code, rec.Body)
+    }
+}
+
+func TestPreviewRows(t *testing.T) {
+    tests := []struct {
+        name     string
+        rows     int
+        n        int
+        wantHead []string
+        wantTail []string
+    }{
+        {"long range", 10, 2, []string{"day-0", "day-1"}, []string{"day-8", "day-9"}},
+        {"exactly 2n rows", 4, 2, []string{"day-0", "day-1"}, []string{"day-2", "day-3"}},
+        {"overlapping ends", 3, 2, []string{"day-0", "day-1"}, []string{"day-2"}},
+        {"shorter than n", 1, 2, []string{"day-0"}, nil},
+        {"empty", 0, 2, nil, nil},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            rows := make([]*WeatherData, tt.rows)
+            for i := range rows {
+                rows[i] = &WeatherData{Date: fmt.Sprintf("day-%d", i)}
+            }
+            got := previewRows(rows, tt.n)
+            dates := func(rows []*WeatherData) []string {
+                var out []string
+                for _, row := range rows {
+                    out = append(out, row.Date)
+                }
+                return out
+            }
+            if got.TotalRows != tt.rows {
+                t.Errorf("TotalRows = %d, want %d", got.TotalRows, tt.rows)
+            }
+            if !reflect.DeepEqual(dates(got.Head), tt.wantHead) || !reflect.DeepEqual(dates(got.Tail), tt.wantTail) {
+                t.Errorf("head %v, tail %v; want %v, %v", dates(got.Head), dates(got.Tail), tt.wantHead, tt.wantTail)
+            }
+        })
+    }
+}
+
+func TestPreview(t *testing.T) {
+    noBigQuery(t)
+    fixClock(t, time.Date(2024, 6, 30, 12, 0, 0, 0, time.UTC))
+    stubOpenMeteo(t, serveDays(t, "", 0))
+    rec := httptest.NewRecorder()
+    fetchWeatherData(rec, httptest.NewRequest(http.MethodGet, "/?latitude=52.52&longitude=13.41&start_date=2024-01-01&end_date=2024-01-31&preview=2", nil))
+    if rec.Code != http.StatusOK {
+        t.Fatalf("status = %d: %s", rec.Code, rec.Body)
+    }
+    var body struct {
+        TotalRows int `json:"total_rows"`
+        Head      []struct {
+            Date string `json:"date"`
+        } `json:"head"`
+        Tail []struct {
+            Date string `json:"date"`
+        } `json:"tail"`
+    }
+    if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+        t.Fatalf("%v: %s", err, rec.Body)
+    }
+    if body.TotalRows != 31 || len(body.Head) != 2 || len(body.Tail) != 2 {
+        t.Fatalf("preview = %+v, want 31 rows with 2 at each end", body)
+    }
+    if body.Head[0].Date != "2024-01-01" || body.Head[1].Date != "2024-01-02" || body.Tail[0].Date != "2024-01-30" || body.Tail[1].Date != "2024-01-31" {
+        t.Errorf("preview = %+v, want the range edges", body)
+    }
+}
+
+func TestParsePreview(t *testing.T) {
+    tests := []struct {
+        query   string
+        want    int
+        wantErr bool
+    }{
+        {"", 0, false},
+        {"&preview=5", 5, false},
+        {"&preview=100", 100, false},
+        {"&preview=0", 0, true},
+        {"&preview=101", 0, true},
+        {"&preview=few", 0, true},
+        {"&preview=5&format=influx", 0, true},
+        {"&preview=5&return_rows=true", 0, true},
+    }
+    for _, tt := range tests {
+        t.Run(tt.query, func(t *testing.T) {
+            query := "latitude=52.52&longitude=13.41" + tt.query
+            if tt.wantErr {
+                if err := parseOptionsError(t, query); err == nil {
+                    t.Error("parseQueryOptions() succeeded, want an error")
+                }
+                return
+            }
+            opts := mustParseOptions(t, query)
+            if opts.Preview != tt.want {
+                t.Errorf("Preview = %d, want %d", opts.Preview, tt.want)
+            }
+            if tt.want > 0 && opts.Sink != "none" {
+                t.Errorf("Sink = %q, want none for a preview", opts.Sink)
+            }
+        })
+    }
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+    "context"
+    "fmt"
+    "time"
+
+    "cloud.google.com/go/bigquery"
+    "cloud.google.com/go/bigquery/storage/managedwriter"
+    "cloud.google.com/go/bigquery/storage/managedwriter/adapt"
+    "google.golang.org/protobuf/proto"
+    "google.golang.org/protobuf/reflect/protoreflect"
+    "google.golang.org/protobuf/types/descriptorpb"
+    "google.golang.org/protobuf/types/dynamicpb"
+)
+
+// storageWriteBatch is how many rows go into one AppendRows call, keeping each request well
+// under the 10 MB limit of the Storage Write API.
+const storageWriteBatch = 500
+
+// storageWriteRows appends rows to the table through the default stream of the BigQuery
+// Storage Write API, which is cheaper than streaming inserts for large backfills. The
+// rows are encoded against the table's current schema, so columns the table lacks are
+// dropped just as streaming inserts ignore unknown values.
+func storageWriteRows(ctx context.Context, client *bigquery.Client, tableID string, rows []*WeatherData) error {
+    meta, err := client.Dataset(cfg.DatasetID).Table(tableID).Metadata(ctx)
+    if err != nil {
+        return fmt.Errorf("failed to read table metadata: %w", err)
+    }
+    descriptor, data, err := encodeProtoRows(meta.Schema, rows)
+    if err != nil {
+        return err
+    }
+
+    writer, err := managedwriter.NewClient(ctx, cfg.ProjectID, clientOptions()...)
+    if err != nil {
+        return fmt.Errorf("failed to create storage write client: %w", err)
+    }
+    defer writer.Close()
+    stream, err := writer.NewManagedStream(ctx,
+        managedwriter.WithDestinationTable(managedwriter.TableParentFromParts(cfg.ProjectID, cfg.DatasetID, tableID)),
+        managedwriter.WithType(managedwriter.DefaultStream),
+        managedwriter.WithSchemaDescriptor(descriptor),
+        managedwriter.EnableWriteRetries(true),
+    )
+    if err != nil {
+        return fmt.Errorf("failed to open write stream: %w", err)
+    }
+    defer stream.Close()
+
+    var results []*managedwriter.AppendResult
+    for start := 0; start < len(data); start += storageWriteBatch {
+        result, err := stream.AppendRows(ctx, data[start:min(start+storageWriteBatch, len(data))])
+        if err != nil {
+            return fmt.Errorf("failed to append rows: %w", err)
+        }
+        results = append(results, result)
+    }
+    for _, result := range results {
+        if _, err := result.GetResult(ctx); err != nil {
+            return fmt.Errorf("failed to append rows: %w", err)
+        }
+    }
+    return nil
+}
+
+// encodeProtoRows builds the proto descriptor for the table schema and serializes each row
+// as a message of it.
+func encodeProtoRows(schema bigquery.Schema, rows []*WeatherData) (*descriptorpb.DescriptorProto, [][]byte, error) {
+    storageSchema, err := adapt.BQSchemaToStorageTableSchema(schema)
+    if err != nil {
+        return nil, nil, fmt.Errorf("failed to convert schema: %w", err)
+    }
+    d, err := adapt.StorageSchemaToProto2Descriptor(storageSchema, "root")
+    if err != nil {
+        return nil, nil, fmt.Errorf("failed to build proto descriptor: %w", err)
+    }
+    md, ok := d.(protoreflect.MessageDescriptor)
+    if !ok {
+        return nil, nil, fmt.Errorf("proto descriptor is not a message")
+    }
+    descriptor, err := adapt.NormalizeDescriptor(md)
+    if err != nil {
+        return nil, nil, fmt.Errorf("failed to normalize proto descriptor: %w", err)
+    }
+
+    rowSchema, err := bigquery.InferSchema(WeatherData{})
+    if err != nil {
+        return nil, nil, fmt.Errorf("failed to infer schema: %w", err)
+    }
+    data := make([][]byte, 0, len(rows))
+    for _, row := range rows {
+        values, _, err := (&bigquery.StructSaver{Struct: row, Schema: rowSchema}).Save()
+        if err != nil {
+            return nil, nil, fmt.Errorf("failed to read row: %w", err)
+        }
+        msg := dynamicpb.NewMessage(md)
+        for name, v := range values {
+            field := md.Fields().ByName(protoreflect.Name(name))
+            if field == nil {
+                continue
+            }
+            pv, ok, err := protoValue(v, field.Kind())
+            if err != nil {
+                return nil, nil, fmt.Errorf("column %s of %s: %w", name, row.Date, err)
+            }
+            if ok {
+                msg.Set(field, pv)
+            }
+        }
+        b, err := proto.Marshal(msg)
+        if err != nil {
+            return nil, nil, fmt.Errorf("failed to encode row: %w", err)
+        }
+        data = append(data, b)
+    }
+    return descriptor, data, nil
+}
+
+// protoValue converts a saved column value to the proto kind the Storage Write API uses for
+// its column type: DATE as days since the epoch and TIMESTAMP as epoch microseconds. It
+// reports false for NULL values, which are left unset.
+func protoValue(v bigquery.Value, kind protoreflect.Kind) (protoreflect.Value, bool, error) {
+    switch x := v.(type) {
+    case bigquery.NullFloat64:
+        if !x.Valid {
+            return protoreflect.Value{}, false, nil
+        }
+        v = x.Float64
+    case bigquery.NullInt64:
+        if !x.Valid {
+            return protoreflect.Value{}, false, nil
+        }
+        v = x.Int64
+    case bigquery.NullString:
+        if !x.Valid {
+            return protoreflect.Value{}, false, nil
+        }
+        v = x.StringVal
+    case bigquery.NullBool:
+        if !x.Valid {
+            return protoreflect.Value{}, false, nil
+        }
+        v = x.Bool
+    case bigquery.NullTimestamp:
+        if !x.Valid {
+            return protoreflect.Value{}, false, nil
+        }
+        v = x.Timestamp
+    }
+
+    switch x := v.(type) {
+    case float64:
+        return protoreflect.ValueOfFloat64(x), true, nil
+    case int64:
+        return protoreflect.ValueOfInt64(x), true, nil
+    case bool:
+        return protoreflect.ValueOfBool(x), true, nil
+    case time.Time:
+        return protoreflect.ValueOfInt64(x.UnixMicro()), true, nil
+    case string:
+        if kind != protoreflect.Int32Kind {
+            return protoreflect.ValueOfString(x), true, nil
+        }
+        date, err := time.Parse(dateLayout, x)
+        if err != nil {
+            return protoreflect.Value{}, false, err
+        }
+        return protoreflect.ValueOfInt32(int32(date.Unix() / 86400)), true, nil
+    }
+    return protoreflect.Value{}, false, fmt.Errorf("unsupported value type %T", v)
+}
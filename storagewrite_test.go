@@ -0,0 +1,160 @@
+package main
+
+import (
+    "testing"
+    "time"
+
+    "cloud.google.com/go/bigquery"
+    "google.golang.org/protobuf/proto"
+    "google.golang.org/protobuf/reflect/protodesc"
+    "google.golang.org/protobuf/reflect/protoreflect"
+    "google.golang.org/protobuf/types/descriptorpb"
+    "google.golang.org/protobuf/types/dynamicpb"
+)
+
+// decodeProtoRows parses rows encoded by encodeProtoRows back into messages of descriptor.
+func decodeProtoRows(t *testing.T, descriptor *descriptorpb.DescriptorProto, data [][]byte) []*dynamicpb.Message {
+    t.Helper()
+    file, err := protodesc.NewFile(&descriptorpb.FileDescriptorProto{
+        Name:        proto.String("rows.proto"),
+        Syntax:      proto.String("proto2"),
+        MessageType: []*descriptorpb.DescriptorProto{descriptor},
+    }, nil)
+    if err != nil {
+        t.Fatal(err)
+    }
+    md := file.Messages().Get(0)
+    var msgs []*dynamicpb.Message
+    for _, b := range data {
+        msg := dynamicpb.NewMessage(md)
+        if err := proto.Unmarshal(b, msg); err != nil {
+            t.Fatal(err)
+        }
+        msgs = append(msgs, msg)
+    }
+    return msgs
+}
+
+func TestEncodeProtoRows(t *testing.T) {
+    meta, err := newTableMetadata()
+    if err != nil {
+        t.Fatal(err)
+    }
+    narrow := bigquery.Schema{
+        {Name: "latitude", Type: bigquery.FloatFieldType},
+        {Name: "date", Type: bigquery.DateFieldType},
+        {Name: "rain_sum", Type: bigquery.FloatFieldType},
+    }
+    inserted := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+    row := &WeatherData{
+        Latitude:    52.5,
+        Longitude:   13.4,
+        Date:        "2024-01-02",
+        RainSum:     nf(0.4),
+        WeatherCode: bigquery.NullInt64{Int64: 63, Valid: true},
+        BatchID:     "batch",
+        InsertedAt:  inserted,
+    }
+    tests := []struct {
+        name      string
+        schema    bigquery.Schema
+        want      map[string]interface{}
+        wantUnset []string
+    }{
+        {
+            name:   "table schema",
+            schema: meta.Schema,
+            want: map[string]interface{}{
+                "latitude":     52.5,
+                "longitude":    13.4,
+                "date":         int32(19724),
+                "rain_sum":     0.4,
+                "weather_code": int64(63),
+                "batch_id":     "batch",
+                "inserted_at":  inserted.UnixMicro(),
+            },
+            wantUnset: []string{"snowfall_sum", "source_model", "date_ts"},
+        },
+        {
+            name:   "columns the table lacks are dropped",
+            schema: narrow,
+            want:   map[string]interface{}{"latitude": 52.5, "date": int32(19724), "rain_sum": 0.4},
+        },
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            descriptor, data, err := encodeProtoRows(tt.schema, []*WeatherData{row, row})
+            if err != nil {
+                t.Fatal(err)
+            }
+            msgs := decodeProtoRows(t, descriptor, data)
+            if len(msgs) != 2 {
+                t.Fatalf("encoded %d rows, want 2", len(msgs))
+            }
+            fields := msgs[0].Descriptor().Fields()
+            if fields.Len() != len(tt.schema) {
+                t.Errorf("descriptor has %d fields, want one per table column (%d)", fields.Len(), len(tt.schema))
+            }
+            for name, want := range tt.want {
+                field := fields.ByName(protoreflect.Name(name))
+                if field == nil {
+                    t.Errorf("descriptor lacks %s", name)
+                    continue
+                }
+                if !msgs[0].Has(field) {
+                    t.Errorf("%s is unset, want %v", name, want)
+                    continue
+                }
+                if got := msgs[0].Get(field).Interface(); got != want {
+                    t.Errorf("%s = %v (%T), want %v (%T)", name, got, got, want, want)
+                }
+            }
+            for _, name := range tt.wantUnset {
+                if field := fields.ByName(protoreflect.Name(name)); field == nil || msgs[0].Has(field) {
+                    t.Errorf("%s is set or missing from the descriptor, want a NULL column", name)
+                }
+            }
+        })
+    }
+}
+
+func TestProtoValue(t *testing.T) {
+    at := time.Date(2024, 1, 1, 0, 0, 1, 0, time.UTC)
+    tests := []struct {
+        name    string
+        v       bigquery.Value
+        kind    protoreflect.Kind
+        want    interface{}
+        wantSet bool
+        wantErr bool
+    }{
+        {"float", 1.5, protoreflect.DoubleKind, 1.5, true, false},
+        {"null float", bigquery.NullFloat64{}, protoreflect.DoubleKind, nil, false, false},
+        {"valid float", nf(2), protoreflect.DoubleKind, 2.0, true, false},
+        {"null int", bigquery.NullInt64{}, protoreflect.Int64Kind, nil, false, false},
+        {"valid int", bigquery.NullInt64{Int64: 3, Valid: true}, protoreflect.Int64Kind, int64(3), true, false},
+        {"null string", bigquery.NullString{}, protoreflect.StringKind, nil, false, false},
+        {"valid string", bigquery.NullString{StringVal: "x", Valid: true}, protoreflect.StringKind, "x", true, false},
+        {"valid bool", bigquery.NullBool{Bool: true, Valid: true}, protoreflect.BoolKind, true, true, false},
+        {"timestamp as micros", at, protoreflect.Int64Kind, at.UnixMicro(), true, false},
+        {"null timestamp", bigquery.NullTimestamp{}, protoreflect.Int64Kind, nil, false, false},
+        {"date as epoch days", "1970-01-02", protoreflect.Int32Kind, int32(1), true, false},
+        {"date before the epoch", "1969-12-31", protoreflect.Int32Kind, int32(-1), true, false},
+        {"invalid date", "01/02/1970", protoreflect.Int32Kind, nil, false, true},
+        {"unsupported type", []int{1}, protoreflect.Int64Kind, nil, false, true},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            got, set, err := protoValue(tt.v, tt.kind)
+            if (err != nil) != tt.wantErr {
+                t.Fatalf("err = %v, wantErr %v", err, tt.wantErr)
+            }
+            if set != tt.wantSet {
+                t.Fatalf("set = %v, want %v", set, tt.wantSet)
+            }
+            if set && got.Interface() != tt.want {
+                t.Errorf("protoValue() = %v (%T), want %v (%T)", got.Interface(), got.Interface(), tt.want, tt.want)
+            }
+        })
+    }
+}
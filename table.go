@@ -0,0 +1,227 @@
+package main
+
+import (
+    "bytes"
+    "context"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "net/http"
+    "strings"
+    "sync"
+    "time"
+
+    "cloud.google.com/go/bigquery"
+    "google.golang.org/api/googleapi"
+    "google.golang.org/api/option"
+)
+
+var (
+    tableMu sync.Mutex
+    // readyTables caches the tables known to exist, with the time this instance created
+    // them; the time is zero for tables that already existed.
+    readyTables = make(map[string]time.Time)
+)
+
+// newBigQueryClient creates a BigQuery client for the configured project. It is a variable
+// so tests can point the handlers at a fake.
+var newBigQueryClient = func(ctx context.Context) (*bigquery.Client, error) {
+    return bigquery.NewClient(ctx, cfg.ProjectID, clientOptions()...)
+}
+
+// clientOptions returns the options shared by the Google Cloud clients. Credentials from
+// GOOGLE_APPLICATION_CREDENTIALS_JSON are used when set; otherwise the clients fall back
+// to Application Default Credentials.
+func clientOptions() []option.ClientOption {
+    if cfg.CredentialsJSON == "" {
+        return nil
+    }
+    return []option.ClientOption{option.WithCredentialsJSON([]byte(cfg.CredentialsJSON))}
+}
+
+// ensureTable creates the table with the metadata from newMeta if it does not exist yet,
+// reporting whether it was created by this call.
+// Partitioning and clustering are only applied on creation; existing tables are left untouched.
+func ensureTable(ctx context.Context, client *bigquery.Client, tableID string, newMeta func() (*bigquery.TableMetadata, error)) (bool, error) {
+    tableMu.Lock()
+    defer tableMu.Unlock()
+    if _, ok := readyTables[tableID]; ok {
+        return false, nil
+    }
+
+    table := client.Dataset(cfg.DatasetID).Table(tableID)
+    if _, err := table.Metadata(ctx); err == nil {
+        readyTables[tableID] = time.Time{}
+        return false, nil
+    } else if !isHTTPStatus(err, http.StatusNotFound) {
+        return false, fmt.Errorf("failed to read table metadata: %w", err)
+    }
+
+    meta, err := newMeta()
+    if err != nil {
+        return false, err
+    }
+    if err := table.Create(ctx, meta); err != nil {
+        if isHTTPStatus(err, http.StatusConflict) {
+            readyTables[tableID] = time.Time{}
+            return false, nil
+        }
+        return false, fmt.Errorf("failed to create table %s: %w", tableID, err)
+    }
+    readyTables[tableID] = time.Now()
+    return true, nil
+}
+
+// createRetryRemaining returns how much of cfg.CreateRetryWindow is left since this instance
+// created the table, or 0 when it did not create it or the window has passed.
+func createRetryRemaining(tableID string) time.Duration {
+    tableMu.Lock()
+    defer tableMu.Unlock()
+    createdAt := readyTables[tableID]
+    if createdAt.IsZero() {
+        return 0
+    }
+    return max(cfg.CreateRetryWindow-time.Since(createdAt), 0)
+}
+
+// putRows streams rows into the table, retrying transient BigQuery errors. Right after a
+// table is created, streaming inserts can also report "not found" until the table
+// propagates, so within cfg.CreateRetryWindow of its creation those errors are retried
+// for every caller, not only the one that created it.
+func putRows(ctx context.Context, table *bigquery.Table, rows interface{}) error {
+    inserter := table.Inserter()
+    // Older tables may lack newer nullable columns; ignore those rather than failing the insert.
+    inserter.IgnoreUnknownValues = true
+
+    policy := retryPolicy{Attempts: cfg.MaxRetries + 1, Initial: time.Second, Max: 8 * time.Second}
+    remaining := createRetryRemaining(table.TableID)
+    if remaining > 0 {
+        policy.Attempts = 0
+        policy.Window = remaining
+    }
+    retryable := func(err error) bool {
+        if isHTTPStatus(err, http.StatusNotFound) {
+            return remaining > 0
+        }
+        return isTransientBigQueryError(err)
+    }
+    return retryWithBackoff(ctx, policy, retryable, func() error {
+        return inserter.Put(ctx, rows)
+    })
+}
+
+// loadPartition replaces the partition of the given day with rows using a load job on the
+// table$YYYYMMDD decorator with WriteTruncate, so reloading a day is idempotent instead of
+// appending duplicates the way streaming inserts do. The truncation covers the whole day,
+// not just the coordinates of rows, so the rows of every other location for the day are
+// removed; parseQueryOptions therefore only allows it for a single location.
+func loadPartition(ctx context.Context, client *bigquery.Client, tableID, date string, rows []*WeatherData) error {
+    buf, err := encodeNDJSON(rows)
+    if err != nil {
+        return err
+    }
+    source := bigquery.NewReaderSource(buf)
+    source.SourceFormat = bigquery.JSON
+    source.IgnoreUnknownValues = true
+
+    partition := client.Dataset(cfg.DatasetID).Table(tableID + "$" + strings.ReplaceAll(date, "-", ""))
+    loader := partition.LoaderFrom(source)
+    loader.WriteDisposition = bigquery.WriteTruncate
+    job, err := loader.Run(ctx)
+    if err != nil {
+        return fmt.Errorf("failed to start load job: %w", err)
+    }
+    status, err := job.Wait(ctx)
+    if err != nil {
+        return fmt.Errorf("failed to wait for load job: %w", err)
+    }
+    if err := status.Err(); err != nil {
+        return fmt.Errorf("load job %s failed: %w", job.ID(), err)
+    }
+    return nil
+}
+
+// encodeNDJSON encodes rows as newline-delimited JSON.
+func encodeNDJSON(rows []*WeatherData) (*bytes.Buffer, error) {
+    var buf bytes.Buffer
+    enc := json.NewEncoder(&buf)
+    for _, row := range rows {
+        if err := enc.Encode(row); err != nil {
+            return nil, fmt.Errorf("failed to encode row: %w", err)
+        }
+    }
+    return &buf, nil
+}
+
+// isTransientBigQueryError reports whether err is a BigQuery error worth retrying under the
+// bigquery retry rules, by default a 500, 502, or 503.
+func isTransientBigQueryError(err error) bool {
+    rule := cfg.RetryRules.BigQuery
+    if rule.matchesError(err) {
+        return true
+    }
+    var apiErr *googleapi.Error
+    return errors.As(err, &apiErr) && rule.matchesStatus(apiErr.Code)
+}
+
+// newTableMetadata builds the schema, partitioning, and clustering for a new table.
+func newTableMetadata() (*bigquery.TableMetadata, error) {
+    schema, err := bigquery.InferSchema(WeatherData{})
+    if err != nil {
+        return nil, fmt.Errorf("failed to infer schema: %w", err)
+    }
+    // Store the date as DATE so it can be used as the partitioning column.
+    meta := &bigquery.TableMetadata{Schema: dateSchema(schema)}
+    if cfg.PartitionField != "" {
+        meta.TimePartitioning = &bigquery.TimePartitioning{
+            Type:  bigquery.TimePartitioningType(cfg.PartitionType),
+            Field: cfg.PartitionField,
+        }
+    }
+    if len(cfg.ClusteringFields) > 0 {
+        meta.Clustering = &bigquery.Clustering{Fields: cfg.ClusteringFields}
+    }
+    return meta, nil
+}
+
+// dateSchema returns a copy of an inferred schema with its date column typed DATE. The
+// inferred schema is cached and shared with every row saver, so the date field is copied
+// rather than changed in place.
+func dateSchema(inferred bigquery.Schema) bigquery.Schema {
+    schema := make(bigquery.Schema, len(inferred))
+    for i, field := range inferred {
+        schema[i] = field
+        if field.Name == "date" {
+            f := *field
+            f.Type = bigquery.DateFieldType
+            schema[i] = &f
+        }
+    }
+    return schema
+}
+
+// isHTTPStatus reports whether err is a Google API error with the given status code.
+func isHTTPStatus(err error, code int) bool {
+    var apiErr *googleapi.Error
+    return errors.As(err, &apiErr) && apiErr.Code == code
+}
+
+// runDML runs a DML statement to completion and returns the number of affected rows.
+func runDML(ctx context.Context, query *bigquery.Query) (int64, error) {
+    job, err := query.Run(ctx)
+    if err != nil {
+        return 0, err
+    }
+    status, err := job.Wait(ctx)
+    if err != nil {
+        return 0, err
+    }
+    if err := status.Err(); err != nil {
+        return 0, err
+    }
+    stats, ok := status.Statistics.Details.(*bigquery.QueryStatistics)
+    if !ok {
+        return 0, nil
+    }
+    return stats.NumDMLAffectedRows, nil
+}
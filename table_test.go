@@ -0,0 +1,691 @@
+package main
+
+import (
+    "context"
+    "encoding/json"
+    "fmt"
+    "io"
+    "mime"
+    "mime/multipart"
+    "net/http"
+    "net/http/httptest"
+    "reflect"
+    "regexp"
+    "strconv"
+    "strings"
+    "sync"
+    "testing"
+    "time"
+
+    "cloud.google.com/go/bigquery"
+    bq "google.golang.org/api/bigquery/v2"
+    "google.golang.org/api/option"
+)
+
+// fakeBigQuery serves the parts of the BigQuery REST API the function uses, recording
+// what it is asked to do.
+type fakeBigQuery struct {
+    // answer returns the result of a query; nil answers with no rows.
+    answer func(q *fakeQuery) *fakeResult
+    // insertStatus returns an HTTP status to fail the nth (from 1) insertAll call into the
+    // table with, or 0 to accept it.
+    insertStatus func(table string, n int) int
+
+    mu      sync.Mutex
+    tables  map[string]*bq.Table
+    queries []*fakeQuery
+    inserts map[string][]map[string]bq.JsonValue
+    calls   map[string]int
+    loads   []*fakeLoad
+    jobs    map[string]*fakeJob
+}
+
+// fakeQuery is a query the fake received.
+type fakeQuery struct {
+    SQL    string
+    Params []*bq.QueryParameter
+    Labels map[string]string
+}
+
+// param returns the scalar value of the named parameter, or "" when it was not passed.
+func (q *fakeQuery) param(name string) string {
+    for _, p := range q.Params {
+        if p.Name == name && p.ParameterValue != nil {
+            return p.ParameterValue.Value
+        }
+    }
+    return ""
+}
+
+// paramType returns the type of the named parameter, or "" when it was not passed.
+func (q *fakeQuery) paramType(name string) string {
+    for _, p := range q.Params {
+        if p.Name == name && p.ParameterType != nil {
+            return p.ParameterType.Type
+        }
+    }
+    return ""
+}
+
+// fakeResult is the answer to a query: its columns and rows, or the rows a DML statement
+// affected, or an error.
+type fakeResult struct {
+    Fields   []*bq.TableFieldSchema
+    Rows     [][]interface{}
+    Affected int64
+    Err      string
+}
+
+// fakeLoad is a load job the fake received.
+type fakeLoad struct {
+    Config *bq.JobConfigurationLoad
+    Data   string
+}
+
+// fakeJob is a finished job and its result.
+type fakeJob struct {
+    job    *bq.Job
+    result *fakeResult
+}
+
+// newFakeBigQuery starts a fake and returns it with a client talking to it.
+func newFakeBigQuery(t *testing.T) (*fakeBigQuery, *bigquery.Client) {
+    t.Helper()
+    fake := &fakeBigQuery{
+        tables:  make(map[string]*bq.Table),
+        inserts: make(map[string][]map[string]bq.JsonValue),
+        calls:   make(map[string]int),
+        jobs:    make(map[string]*fakeJob),
+    }
+    srv := httptest.NewServer(fake)
+    t.Cleanup(srv.Close)
+    connect := func(ctx context.Context) (*bigquery.Client, error) {
+        return bigquery.NewClient(ctx, cfg.ProjectID, option.WithEndpoint(srv.URL+"/bigquery/v2/"), option.WithoutAuthentication())
+    }
+    client, err := connect(context.Background())
+    if err != nil {
+        t.Fatal(err)
+    }
+    t.Cleanup(func() { client.Close() })
+
+    // Handlers create their own clients, which connect to the fake too.
+    saved := newBigQueryClient
+    newBigQueryClient = connect
+    t.Cleanup(func() { newBigQueryClient = saved })
+    resetReadyTables(t)
+    return fake, client
+}
+
+// resetReadyTables clears the cache of prepared tables for the test.
+func resetReadyTables(t *testing.T) {
+    tableMu.Lock()
+    defer tableMu.Unlock()
+    readyTables = make(map[string]time.Time)
+    t.Cleanup(func() {
+        tableMu.Lock()
+        defer tableMu.Unlock()
+        readyTables = make(map[string]time.Time)
+    })
+}
+
+// addTable registers an existing table with the schema inferred from v.
+func (f *fakeBigQuery) addTable(t *testing.T, tableID string, v interface{}) {
+    t.Helper()
+    schema, err := bigquery.InferSchema(v)
+    if err != nil {
+        t.Fatal(err)
+    }
+    f.mu.Lock()
+    defer f.mu.Unlock()
+    f.tables[tableID] = &bq.Table{TableReference: &bq.TableReference{TableId: tableID}, Schema: toBQSchema(schema)}
+}
+
+// table returns the stored definition of a table, or nil.
+func (f *fakeBigQuery) table(tableID string) *bq.Table {
+    f.mu.Lock()
+    defer f.mu.Unlock()
+    return f.tables[tableID]
+}
+
+// rows returns the rows inserted into a table.
+func (f *fakeBigQuery) rows(tableID string) []map[string]bq.JsonValue {
+    f.mu.Lock()
+    defer f.mu.Unlock()
+    return f.inserts[tableID]
+}
+
+// received returns the queries received so far.
+func (f *fakeBigQuery) received() []*fakeQuery {
+    f.mu.Lock()
+    defer f.mu.Unlock()
+    return append([]*fakeQuery(nil), f.queries...)
+}
+
+var (
+    tablePath       = regexp.MustCompile(`/projects/[^/]+/datasets/[^/]+/tables/([^/]+)$`)
+    tablesPath      = regexp.MustCompile(`/projects/[^/]+/datasets/[^/]+/tables$`)
+    insertAllPath   = regexp.MustCompile(`/projects/[^/]+/datasets/[^/]+/tables/([^/]+)/insertAll$`)
+    queriesPath     = regexp.MustCompile(`/projects/[^/]+/queries$`)
+    queryResultPath = regexp.MustCompile(`/projects/[^/]+/queries/([^/]+)$`)
+    jobsPath        = regexp.MustCompile(`/projects/[^/]+/jobs$`)
+    jobPath         = regexp.MustCompile(`/projects/[^/]+/jobs/([^/]+)$`)
+)
+
+// ServeHTTP implements http.Handler.
+func (f *fakeBigQuery) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+    path := r.URL.Path
+    switch {
+    case r.Method == http.MethodPost && insertAllPath.MatchString(path):
+        f.insertAll(w, r, insertAllPath.FindStringSubmatch(path)[1])
+    case r.Method == http.MethodGet && tablePath.MatchString(path):
+        f.getTable(w, tablePath.FindStringSubmatch(path)[1])
+    case (r.Method == http.MethodPatch || r.Method == http.MethodPut) && tablePath.MatchString(path):
+        f.patchTable(w, r, tablePath.FindStringSubmatch(path)[1])
+    case r.Method == http.MethodPost && tablesPath.MatchString(path):
+        f.createTable(w, r)
+    case r.Method == http.MethodPost && queriesPath.MatchString(path):
+        f.query(w, r)
+    case r.Method == http.MethodGet && queryResultPath.MatchString(path):
+        f.queryResults(w, r, queryResultPath.FindStringSubmatch(path)[1])
+    case r.Method == http.MethodPost && jobsPath.MatchString(path):
+        f.insertJob(w, r)
+    case r.Method == http.MethodGet && jobPath.MatchString(path):
+        f.getJob(w, jobPath.FindStringSubmatch(path)[1])
+    default:
+        writeAPIError(w, http.StatusNotImplemented, "fake BigQuery does not serve "+r.Method+" "+path)
+    }
+}
+
+func (f *fakeBigQuery) getTable(w http.ResponseWriter, tableID string) {
+    table := f.table(tableID)
+    if table == nil {
+        writeAPIError(w, http.StatusNotFound, "Not found: Table "+tableID)
+        return
+    }
+    json.NewEncoder(w).Encode(table)
+}
+
+func (f *fakeBigQuery) createTable(w http.ResponseWriter, r *http.Request) {
+    var table bq.Table
+    if err := json.NewDecoder(r.Body).Decode(&table); err != nil {
+        writeAPIError(w, http.StatusBadRequest, err.Error())
+        return
+    }
+    f.mu.Lock()
+    defer f.mu.Unlock()
+    id := table.TableReference.TableId
+    if f.tables[id] != nil {
+        writeAPIError(w, http.StatusConflict, "Already Exists: Table "+id)
+        return
+    }
+    table.Etag = "1"
+    f.tables[id] = &table
+    json.NewEncoder(w).Encode(&table)
+}
+
+func (f *fakeBigQuery) patchTable(w http.ResponseWriter, r *http.Request, tableID string) {
+    var patch bq.Table
+    if err := json.NewDecoder(r.Body).Decode(&patch); err != nil {
+        writeAPIError(w, http.StatusBadRequest, err.Error())
+        return
+    }
+    f.mu.Lock()
+    defer f.mu.Unlock()
+    table := f.tables[tableID]
+    if table == nil {
+        writeAPIError(w, http.StatusNotFound, "Not found: Table "+tableID)
+        return
+    }
+    if patch.Schema != nil {
+        table.Schema = patch.Schema
+    }
+    json.NewEncoder(w).Encode(table)
+}
+
+func (f *fakeBigQuery) insertAll(w http.ResponseWriter, r *http.Request, tableID string) {
+    var req bq.TableDataInsertAllRequest
+    if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+        writeAPIError(w, http.StatusBadRequest, err.Error())
+        return
+    }
+    f.mu.Lock()
+    f.calls[tableID]++
+    n := f.calls[tableID]
+    f.mu.Unlock()
+    if f.insertStatus != nil {
+        if status := f.insertStatus(tableID, n); status != 0 {
+            writeAPIError(w, status, fmt.Sprintf("insert %d into %s failed", n, tableID))
+            return
+        }
+    }
+    f.mu.Lock()
+    for _, row := range req.Rows {
+        f.inserts[tableID] = append(f.inserts[tableID], row.Json)
+    }
+    f.mu.Unlock()
+    json.NewEncoder(w).Encode(&bq.TableDataInsertAllResponse{})
+}
+
+// run records a query and returns its result.
+func (f *fakeBigQuery) run(q *fakeQuery) *fakeResult {
+    f.mu.Lock()
+    f.queries = append(f.queries, q)
+    f.mu.Unlock()
+    var res *fakeResult
+    if f.answer != nil {
+        res = f.answer(q)
+    }
+    if res == nil {
+        res = &fakeResult{}
+    }
+    return res
+}
+
+// finish stores a completed job with its result.
+func (f *fakeBigQuery) finish(job *bq.Job, res *fakeResult) {
+    job.Status = &bq.JobStatus{State: "DONE"}
+    if res.Err != "" {
+        job.Status.ErrorResult = &bq.ErrorProto{Reason: "invalidQuery", Message: res.Err}
+    }
+    job.Statistics = &bq.JobStatistics{Query: &bq.JobStatistics2{NumDmlAffectedRows: res.Affected}}
+    f.mu.Lock()
+    defer f.mu.Unlock()
+    if job.JobReference.JobId == "" {
+        job.JobReference.JobId = fmt.Sprintf("job_%d", len(f.jobs)+1)
+    }
+    f.jobs[job.JobReference.JobId] = &fakeJob{job: job, result: res}
+}
+
+func (f *fakeBigQuery) query(w http.ResponseWriter, r *http.Request) {
+    var req bq.QueryRequest
+    if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+        writeAPIError(w, http.StatusBadRequest, err.Error())
+        return
+    }
+    res := f.run(&fakeQuery{SQL: req.Query, Params: req.QueryParameters, Labels: req.Labels})
+    job := &bq.Job{
+        JobReference:  &bq.JobReference{ProjectId: cfg.ProjectID},
+        Configuration: &bq.JobConfiguration{Labels: req.Labels, Query: &bq.JobConfigurationQuery{Query: req.Query}},
+    }
+    f.finish(job, res)
+    if res.Err != "" {
+        writeAPIError(w, http.StatusBadRequest, res.Err)
+        return
+    }
+    json.NewEncoder(w).Encode(&bq.QueryResponse{
+        JobReference:       job.JobReference,
+        JobComplete:        true,
+        Schema:             &bq.TableSchema{Fields: res.Fields},
+        Rows:               tableRows(res.Rows),
+        TotalRows:          uint64(len(res.Rows)),
+        NumDmlAffectedRows: res.Affected,
+    })
+}
+
+func (f *fakeBigQuery) insertJob(w http.ResponseWriter, r *http.Request) {
+    var job bq.Job
+    var data string
+    mediaType, params, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+    if strings.HasPrefix(mediaType, "multipart/") {
+        mr := multipart.NewReader(r.Body, params["boundary"])
+        part, err := mr.NextPart()
+        if err != nil {
+            writeAPIError(w, http.StatusBadRequest, err.Error())
+            return
+        }
+        if err := json.NewDecoder(part).Decode(&job); err != nil {
+            writeAPIError(w, http.StatusBadRequest, err.Error())
+            return
+        }
+        if part, err = mr.NextPart(); err == nil {
+            b, _ := io.ReadAll(part)
+            data = string(b)
+        }
+    } else if err := json.NewDecoder(r.Body).Decode(&job); err != nil {
+        writeAPIError(w, http.StatusBadRequest, err.Error())
+        return
+    }
+    if job.JobReference == nil {
+        job.JobReference = &bq.JobReference{ProjectId: cfg.ProjectID}
+    }
+
+    res := &fakeResult{}
+    switch {
+    case job.Configuration.Query != nil:
+        qc := job.Configuration.Query
+        res = f.run(&fakeQuery{SQL: qc.Query, Params: qc.QueryParameters, Labels: job.Configuration.Labels})
+    case job.Configuration.Load != nil:
+        f.mu.Lock()
+        f.loads = append(f.loads, &fakeLoad{Config: job.Configuration.Load, Data: data})
+        f.mu.Unlock()
+    }
+    f.finish(&job, res)
+    json.NewEncoder(w).Encode(&job)
+}
+
+func (f *fakeBigQuery) getJob(w http.ResponseWriter, jobID string) {
+    f.mu.Lock()
+    j := f.jobs[jobID]
+    f.mu.Unlock()
+    if j == nil {
+        writeAPIError(w, http.StatusNotFound, "Not found: Job "+jobID)
+        return
+    }
+    json.NewEncoder(w).Encode(j.job)
+}
+
+// queryResults serves a page of a finished query job, using the row offset as page token.
+func (f *fakeBigQuery) queryResults(w http.ResponseWriter, r *http.Request, jobID string) {
+    f.mu.Lock()
+    j := f.jobs[jobID]
+    f.mu.Unlock()
+    if j == nil {
+        writeAPIError(w, http.StatusNotFound, "Not found: Job "+jobID)
+        return
+    }
+    if j.result.Err != "" {
+        writeAPIError(w, http.StatusBadRequest, j.result.Err)
+        return
+    }
+    q := r.URL.Query()
+    start, _ := strconv.Atoi(q.Get("startIndex"))
+    if token := q.Get("pageToken"); token != "" {
+        start, _ = strconv.Atoi(token)
+    }
+    end := len(j.result.Rows)
+    if s := q.Get("maxResults"); s != "" {
+        n, _ := strconv.Atoi(s)
+        end = min(start+n, end)
+    }
+    start = min(start, end)
+    resp := &bq.GetQueryResultsResponse{
+        JobReference:       j.job.JobReference,
+        JobComplete:        true,
+        Schema:             &bq.TableSchema{Fields: j.result.Fields},
+        Rows:               tableRows(j.result.Rows[start:end]),
+        TotalRows:          uint64(len(j.result.Rows)),
+        NumDmlAffectedRows: j.result.Affected,
+    }
+    if end < len(j.result.Rows) {
+        resp.PageToken = strconv.Itoa(end)
+    }
+    json.NewEncoder(w).Encode(resp)
+}
+
+// tableRows converts result rows to the API representation, where every value is a string.
+func tableRows(rows [][]interface{}) []*bq.TableRow {
+    out := make([]*bq.TableRow, len(rows))
+    for i, row := range rows {
+        cells := make([]*bq.TableCell, len(row))
+        for j, v := range row {
+            if v != nil {
+                v = fmt.Sprint(v)
+            }
+            cells[j] = &bq.TableCell{V: v}
+        }
+        out[i] = &bq.TableRow{F: cells}
+    }
+    return out
+}
+
+// fields builds result columns from name/type pairs such as "n", "INTEGER".
+func fields(nameTypes ...string) []*bq.TableFieldSchema {
+    out := make([]*bq.TableFieldSchema, 0, len(nameTypes)/2)
+    for i := 0; i+1 < len(nameTypes); i += 2 {
+        out = append(out, &bq.TableFieldSchema{Name: nameTypes[i], Type: nameTypes[i+1]})
+    }
+    return out
+}
+
+// toBQSchema converts a schema to the API representation.
+func toBQSchema(schema bigquery.Schema) *bq.TableSchema {
+    out := &bq.TableSchema{}
+    for _, f := range schema {
+        field := &bq.TableFieldSchema{Name: f.Name, Type: string(f.Type)}
+        switch {
+        case f.Repeated:
+            field.Mode = "REPEATED"
+        case f.Required:
+            field.Mode = "REQUIRED"
+        }
+        if len(f.Schema) > 0 {
+            field.Fields = toBQSchema(f.Schema).Fields
+        }
+        out.Fields = append(out.Fields, field)
+    }
+    return out
+}
+
+// writeAPIError writes an error in the format of the Google APIs.
+func writeAPIError(w http.ResponseWriter, status int, message string) {
+    w.Header().Set("Content-Type", "application/json")
+    w.WriteHeader(status)
+    json.NewEncoder(w).Encode(map[string]interface{}{
+        "error": map[string]interface{}{"code": status, "message": message},
+    })
+}
+
+func TestNewTableMetadata(t *testing.T) {
+    tests := []struct {
+        name           string
+        field          string
+        partitionType  string
+        clustering     []string
+        wantPartition  *bigquery.TimePartitioning
+        wantClustering *bigquery.Clustering
+    }{
+        {
+            name: "default date partitioning and coordinate clustering", field: "date", partitionType: "DAY",
+            clustering:     []string{"latitude", "longitude"},
+            wantPartition:  &bigquery.TimePartitioning{Type: bigquery.DayPartitioningType, Field: "date"},
+            wantClustering: &bigquery.Clustering{Fields: []string{"latitude", "longitude"}},
+        },
+        {
+            name: "monthly partitions without clustering", field: "date", partitionType: "MONTH",
+            wantPartition: &bigquery.TimePartitioning{Type: bigquery.MonthPartitioningType, Field: "date"},
+        },
+        {name: "unpartitioned", partitionType: "DAY"},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            withConfig(t, func(c *Config) {
+                c.PartitionField, c.PartitionType, c.ClusteringFields = tt.field, tt.partitionType, tt.clustering
+            })
+            meta, err := newTableMetadata()
+            if err != nil {
+                t.Fatal(err)
+            }
+            if !reflect.DeepEqual(meta.TimePartitioning, tt.wantPartition) {
+                t.Errorf("TimePartitioning = %+v, want %+v", meta.TimePartitioning, tt.wantPartition)
+            }
+            if !reflect.DeepEqual(meta.Clustering, tt.wantClustering) {
+                t.Errorf("Clustering = %+v, want %+v", meta.Clustering, tt.wantClustering)
+            }
+            for _, f := range meta.Schema {
+                if f.Name == "date" && f.Type != bigquery.DateFieldType {
+                    t.Errorf("date column has type %s, want DATE", f.Type)
+                }
+            }
+        })
+    }
+}
+
+func TestTableMetadataLeavesInferredSchema(t *testing.T) {
+    tests := []struct {
+        name     string
+        metadata func() (*bigquery.TableMetadata, error)
+        row      interface{}
+    }{
+        {"daily", newTableMetadata, WeatherData{}},
+        {"long", longTableMetadata, LongWeather{}},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            meta, err := tt.metadata()
+            if err != nil {
+                t.Fatal(err)
+            }
+            for _, f := range meta.Schema {
+                if f.Name == "date" && f.Type != bigquery.DateFieldType {
+                    t.Errorf("table date column has type %s, want DATE", f.Type)
+                }
+            }
+            // The inferred schema is cached and shared with the row savers.
+            inferred, err := bigquery.InferSchema(tt.row)
+            if err != nil {
+                t.Fatal(err)
+            }
+            for _, f := range inferred {
+                if f.Name == "date" && f.Type != bigquery.StringFieldType {
+                    t.Errorf("inferred date column has type %s after building the table metadata, want STRING", f.Type)
+                }
+            }
+        })
+    }
+}
+
+func TestEnsureTable(t *testing.T) {
+    tests := []struct {
+        name        string
+        exists      bool
+        wantCreated bool
+    }{
+        {"creates a missing table", false, true},
+        {"leaves an existing table", true, false},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            fake, client := newFakeBigQuery(t)
+            if tt.exists {
+                fake.addTable(t, "daily_weather", IngestRun{})
+            }
+            created, err := ensureTable(context.Background(), client, "daily_weather", newTableMetadata)
+            if err != nil {
+                t.Fatal(err)
+            }
+            if created != tt.wantCreated {
+                t.Errorf("created = %v, want %v", created, tt.wantCreated)
+            }
+            table := fake.table("daily_weather")
+            if tt.wantCreated && (table.TimePartitioning == nil || table.TimePartitioning.Field != "date" || table.Clustering == nil) {
+                t.Errorf("created table lacks partitioning or clustering: %+v", table)
+            }
+            // A second call is answered from the cache.
+            if created, err := ensureTable(context.Background(), client, "daily_weather", newTableMetadata); err != nil || created {
+                t.Errorf("second ensureTable() = %v, %v; want false, nil", created, err)
+            }
+        })
+    }
+}
+
+func TestPutRowsRetriesNotFoundAfterCreation(t *testing.T) {
+    tests := []struct {
+        name      string
+        createdAt time.Duration // before now; negative means the table already existed
+        wantErr   bool
+        wantCalls int
+    }{
+        {"just created by this instance", 0, false, 2},
+        {"created by another request moments ago", 5 * time.Second, false, 2},
+        {"created outside the window", time.Minute, true, 1},
+        {"already existed", -1, true, 1},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            withConfig(t, func(c *Config) { c.CreateRetryWindow = 30 * time.Second })
+            fake, client := newFakeBigQuery(t)
+            fake.addTable(t, "daily_weather", WeatherData{})
+            fake.insertStatus = func(table string, n int) int {
+                if n == 1 {
+                    return http.StatusNotFound
+                }
+                return 0
+            }
+            tableMu.Lock()
+            if tt.createdAt >= 0 {
+                readyTables["daily_weather"] = time.Now().Add(-tt.createdAt)
+            } else {
+                readyTables["daily_weather"] = time.Time{}
+            }
+            tableMu.Unlock()
+
+            err := putRows(context.Background(), client.Dataset(cfg.DatasetID).Table("daily_weather"), &WeatherData{Date: "2024-01-01"})
+            if (err != nil) != tt.wantErr {
+                t.Fatalf("putRows() err = %v, wantErr %v", err, tt.wantErr)
+            }
+            fake.mu.Lock()
+            calls := fake.calls["daily_weather"]
+            fake.mu.Unlock()
+            if calls != tt.wantCalls {
+                t.Errorf("insertAll called %d times, want %d", calls, tt.wantCalls)
+            }
+        })
+    }
+}
+
+func TestEnsureTableSharesCreationTime(t *testing.T) {
+    withConfig(t, func(c *Config) { c.CreateRetryWindow = 30 * time.Second })
+    _, client := newFakeBigQuery(t)
+    if created, err := ensureTable(context.Background(), client, "daily_weather", newTableMetadata); err != nil || !created {
+        t.Fatalf("first ensureTable() = %v, %v; want true, nil", created, err)
+    }
+    // A later caller is answered from the cache but still sees the table as new.
+    if created, err := ensureTable(context.Background(), client, "daily_weather", newTableMetadata); err != nil || created {
+        t.Fatalf("second ensureTable() = %v, %v; want false, nil", created, err)
+    }
+    if remaining := createRetryRemaining("daily_weather"); remaining <= 0 || remaining > cfg.CreateRetryWindow {
+        t.Errorf("createRetryRemaining() = %v, want within (0, %v]", remaining, cfg.CreateRetryWindow)
+    }
+    if remaining := createRetryRemaining("unknown"); remaining != 0 {
+        t.Errorf("createRetryRemaining(unknown) = %v, want 0", remaining)
+    }
+}
+
+func TestClientOptions(t *testing.T) {
+    tests := []struct {
+        name  string
+        creds string
+        want  int
+    }{
+        {"application default credentials", "", 0},
+        {"inline credentials", `{"type":"service_account"}`, 1},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            withConfig(t, func(c *Config) { c.CredentialsJSON = tt.creds })
+            if got := len(clientOptions()); got != tt.want {
+                t.Errorf("clientOptions() returned %d options, want %d", got, tt.want)
+            }
+        })
+    }
+}
+
+func TestLoadPartition(t *testing.T) {
+    tests := []struct {
+        name      string
+        date      string
+        rows      []*WeatherData
+        wantTable string
+    }{
+        {"one row", "2024-01-01", []*WeatherData{{Latitude: 52.5, Longitude: 13.4, Date: "2024-01-01", RainSum: nf(1)}}, "daily_weather$20240101"},
+        {"two rows", "2024-02-29", []*WeatherData{{Latitude: 52.5, Longitude: 13.4, Date: "2024-02-29"}, {Latitude: 52.5, Longitude: 13.4, Date: "2024-02-29", SourceModel: bigquery.NullString{StringVal: "icon", Valid: true}}}, "daily_weather$20240229"},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            fake, client := newFakeBigQuery(t)
+            if err := loadPartition(context.Background(), client, "daily_weather", tt.date, tt.rows); err != nil {
+                t.Fatal(err)
+            }
+            if len(fake.loads) != 1 {
+                t.Fatalf("ran %d load jobs, want 1", len(fake.loads))
+            }
+            load := fake.loads[0]
+            if load.Config.DestinationTable.TableId != tt.wantTable || load.Config.WriteDisposition != "WRITE_TRUNCATE" {
+                t.Errorf("load into %s with %s, want %s with WRITE_TRUNCATE", load.Config.DestinationTable.TableId, load.Config.WriteDisposition, tt.wantTable)
+            }
+            if n := strings.Count(load.Data, "\n"); n != len(tt.rows) {
+                t.Errorf("loaded %d lines, want %d", n, len(tt.rows))
+            }
+        })
+    }
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+    "context"
+    "log/slog"
+    "time"
+)
+
+// archiveLagDays is how far behind today the archive API is expected to run; days within
+// it missing from a response are normal, not truncation.
+const archiveLagDays = 7
+
+// maxTailRefetches bounds how many times a truncated tail is requested again.
+const maxTailRefetches = 2
+
+// expectedLastDate returns the last date a response for the range should reach: the end
+// date, pulled back by the archive lag in archive mode.
+func expectedLastDate(opts *requestOptions) string {
+    last := opts.EndDate
+    if opts.Mode == "archive" {
+        if lagged := now().AddDate(0, 0, -archiveLagDays).Format(dateLayout); lagged < last {
+            last = lagged
+        }
+    }
+    return last
+}
+
+// fillTruncatedTail detects a response that stopped short of the expected last date and,
+// when refetch_tail is set, requests the missing days again and appends them. It returns
+// the rows with any recovered tail and the fraction of the expected days covered.
+func fillTruncatedTail(ctx context.Context, opts *requestOptions, startDate string, rows []*WeatherData, batchID string) ([]*WeatherData, float64) {
+    want := expectedLastDate(opts)
+    for attempt := 0; ; attempt++ {
+        last := rows[len(rows)-1].Date
+        if last >= want {
+            break
+        }
+        slog.Warn("Open-Meteo response ends before the requested range", "last_date", last, "expected", want, "attempt", attempt)
+        if !opts.RefetchTail || attempt >= maxTailRefetches {
+            break
+        }
+        next, err := time.Parse(dateLayout, last)
+        if err != nil {
+            break
+        }
+        meteoResp, err := fetchOpenMeteo(ctx, opts, next.AddDate(0, 0, 1).Format(dateLayout))
+        if err != nil {
+            slog.Warn("Failed to refetch truncated tail", "from", last, "error", err)
+            break
+        }
+        tail := convertDaily(meteoResp, opts, batchID)
+        if len(tail) == 0 {
+            break
+        }
+        rows = append(rows, tail...)
+    }
+
+    coverage := daysCovered(startDate, want, len(rows))
+    if coverage < 1 {
+        slog.Warn("Incomplete coverage of the requested range", "coverage", coverage, "rows", len(rows))
+    }
+    return rows, coverage
+}
+
+// daysCovered returns n as a fraction of the days from start to end inclusive, capped at 1.
+func daysCovered(start, end string, n int) float64 {
+    s, err1 := time.Parse(dateLayout, start)
+    e, err2 := time.Parse(dateLayout, end)
+    if err1 != nil || err2 != nil || e.Before(s) {
+        return 1
+    }
+    return min(float64(n)/float64(spanDays(s, e)), 1)
+}
+
+// spanDays returns the number of days from start to end inclusive.
+func spanDays(start, end time.Time) int {
+    return int(end.Sub(start).Hours()/24) + 1
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+    "context"
+    "fmt"
+    "net/http"
+    "strings"
+    "testing"
+    "time"
+)
+
+func TestExpectedLastDate(t *testing.T) {
+    fixClock(t, time.Date(2024, 6, 30, 12, 0, 0, 0, time.UTC))
+    tests := []struct {
+        mode string
+        end  string
+        want string
+    }{
+        {"archive", "2024-01-31", "2024-01-31"},
+        {"archive", "2024-06-30", "2024-06-23"},
+        {"archive", "2024-06-23", "2024-06-23"},
+        {"forecast", "2024-06-30", "2024-06-30"},
+    }
+    for _, tt := range tests {
+        t.Run(tt.mode+"/"+tt.end, func(t *testing.T) {
+            opts := &requestOptions{Mode: tt.mode, EndDate: tt.end}
+            if got := expectedLastDate(opts); got != tt.want {
+                t.Errorf("expectedLastDate() = %s, want %s", got, tt.want)
+            }
+        })
+    }
+}
+
+func TestDaysCovered(t *testing.T) {
+    tests := []struct {
+        start, end string
+        n          int
+        want       float64
+    }{
+        {"2024-01-01", "2024-01-10", 10, 1},
+        {"2024-01-01", "2024-01-10", 5, 0.5},
+        {"2024-01-01", "2024-01-01", 1, 1},
+        {"2024-01-01", "2024-01-10", 12, 1},
+        {"2024-01-10", "2024-01-01", 0, 1},
+        {"bad", "2024-01-01", 0, 1},
+    }
+    for _, tt := range tests {
+        t.Run(fmt.Sprintf("%s..%s/%d", tt.start, tt.end, tt.n), func(t *testing.T) {
+            if got := daysCovered(tt.start, tt.end, tt.n); got != tt.want {
+                t.Errorf("daysCovered() = %v, want %v", got, tt.want)
+            }
+        })
+    }
+}
+
+// dailyBody returns an Open-Meteo payload with the given dates.
+func dailyBody(dates ...string) string {
+    quoted := make([]string, len(dates))
+    for i, d := range dates {
+        quoted[i] = `"` + d + `"`
+    }
+    return `{"latitude":52.5,"longitude":13.4,"daily":{"time":[` + strings.Join(quoted, ",") + `]}}`
+}
+
+func TestFillTruncatedTail(t *testing.T) {
+    tests := []struct {
+        name         string
+        end          string
+        refetch      bool
+        tails        map[string]string
+        wantDates    int
+        wantCoverage float64
+        wantFetches  int
+    }{
+        {"complete", "2024-01-02", true, nil, 2, 1, 0},
+        {"not refetched", "2024-01-04", false, nil, 2, 0.5, 0},
+        {"tail recovered", "2024-01-04", true, map[string]string{"2024-01-03": dailyBody("2024-01-03", "2024-01-04")}, 4, 1, 1},
+        {"tail recovered in two steps", "2024-01-04", true, map[string]string{"2024-01-03": dailyBody("2024-01-03"), "2024-01-04": dailyBody("2024-01-04")}, 4, 1, 2},
+        {"refetches are bounded", "2024-01-05", true, map[string]string{"2024-01-03": dailyBody("2024-01-03"), "2024-01-04": dailyBody("2024-01-04")}, 4, 0.8, 2},
+        {"empty tail", "2024-01-04", true, map[string]string{"2024-01-03": dailyBody()}, 2, 0.5, 1},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            fixClock(t, time.Date(2024, 6, 30, 0, 0, 0, 0, time.UTC))
+            fetches := 0
+            stubOpenMeteo(t, func(w http.ResponseWriter, r *http.Request) {
+                fetches++
+                body, ok := tt.tails[r.URL.Query().Get("start_date")]
+                if !ok {
+                    t.Errorf("unexpected fetch from %s", r.URL.Query().Get("start_date"))
+                }
+                serveBody(body)(w, r)
+            })
+            query := "latitude=52.52&longitude=13.41&start_date=2024-01-01&end_date=" + tt.end
+            if tt.refetch {
+                query += "&refetch_tail=true"
+            }
+            opts := mustParseOptions(t, query)
+            first := convertDaily(mustDecode(t, dailyBody("2024-01-01", "2024-01-02")), opts, "batch")
+            rows, coverage := fillTruncatedTail(context.Background(), opts, opts.StartDate, first, "batch")
+            if len(rows) != tt.wantDates || coverage != tt.wantCoverage || fetches != tt.wantFetches {
+                t.Errorf("got %d rows, coverage %v after %d fetches; want %d, %v after %d", len(rows), coverage, fetches, tt.wantDates, tt.wantCoverage, tt.wantFetches)
+            }
+            for i := 1; i < len(rows); i++ {
+                if rows[i].Date <= rows[i-1].Date {
+                    t.Errorf("rows out of order at %d: %s after %s", i, rows[i].Date, rows[i-1].Date)
+                }
+            }
+        })
+    }
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+    "context"
+    "log/slog"
+    "net/http"
+    "strings"
+    "sync"
+    "time"
+)
+
+// withRequestTimeout bounds each request to d. When the budget runs out before the handler
+// has responded, the client gets a structured 408 right away; if the response had already
+// started, it is cut off where it was. Either way the handler keeps running until it
+// notices the cancelled context, with its writes captured and logged instead of sent, so
+// inserts that completed before the timeout are still accounted for. Its limitInFlight slot
+// stays taken until it returns. A d of 0 disables it.
+func withRequestTimeout(d time.Duration, next http.Handler) http.Handler {
+    if d <= 0 {
+        return next
+    }
+    return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        ctx, cancel := context.WithTimeout(r.Context(), d)
+        defer cancel()
+
+        tw := &timeoutWriter{w: w, header: make(http.Header)}
+        done := make(chan struct{})
+        // net/http only recovers panics on the goroutine serving the request, so a panic
+        // in the handler goroutine, such as http.ErrAbortHandler, is carried back to it.
+        var panicked interface{}
+        go func() {
+            defer close(done)
+            defer func() { panicked = recover() }()
+            next.ServeHTTP(tw, r.WithContext(ctx))
+        }()
+
+        select {
+        case <-done:
+            if panicked != nil {
+                panic(panicked)
+            }
+            tw.forwardTrailers()
+        case <-ctx.Done():
+            if tw.timeout() {
+                writeJSON(w, http.StatusRequestTimeout, map[string]interface{}{
+                    "error":   "request timeout",
+                    "message": "The request did not complete within " + d.String(),
+                })
+            } else {
+                slog.Warn("Request timed out after the response started", "path", r.URL.Path, "timeout", d)
+            }
+            release := keepInFlightSlot(r.Context())
+            go func() {
+                defer release()
+                <-done
+                if panicked != nil && panicked != http.ErrAbortHandler {
+                    slog.Error("Timed-out request panicked", "path", r.URL.Path, "panic", panicked)
+                }
+                status, body := tw.discarded()
+                if status != 0 {
+                    slog.Warn("Discarded response of timed-out request", "path", r.URL.Path, "status", status, "body", body)
+                }
+            }()
+        }
+    })
+}
+
+// timeoutWriter passes writes through until the request times out. After the timeout,
+// writes never reach the underlying writer, which the request may already have finished
+// with; they are captured for logging instead.
+type timeoutWriter struct {
+    w      http.ResponseWriter
+    header http.Header
+
+    mu          sync.Mutex
+    wroteHeader bool
+    timedOut    bool
+    // startedBeforeTimeout is set when the response had started when the request timed out.
+    startedBeforeTimeout bool
+    status               int
+    body                 []byte
+}
+
+func (tw *timeoutWriter) Header() http.Header {
+    return tw.header
+}
+
+func (tw *timeoutWriter) WriteHeader(status int) {
+    tw.mu.Lock()
+    defer tw.mu.Unlock()
+    tw.writeHeaderLocked(status)
+}
+
+func (tw *timeoutWriter) writeHeaderLocked(status int) {
+    if tw.wroteHeader {
+        return
+    }
+    tw.wroteHeader = true
+    tw.status = status
+    if tw.timedOut {
+        return
+    }
+    for k, v := range tw.header {
+        tw.w.Header()[k] = v
+    }
+    tw.w.WriteHeader(status)
+}
+
+func (tw *timeoutWriter) Write(b []byte) (int, error) {
+    tw.mu.Lock()
+    defer tw.mu.Unlock()
+    tw.writeHeaderLocked(http.StatusOK)
+    if tw.timedOut {
+        if len(tw.body) < maxDiscardedBody {
+            tw.body = append(tw.body, b[:min(len(b), maxDiscardedBody-len(tw.body))]...)
+        }
+        return len(b), nil
+    }
+    return tw.w.Write(b)
+}
+
+// Flush forwards to the underlying writer so streamed responses keep working.
+func (tw *timeoutWriter) Flush() {
+    tw.mu.Lock()
+    defer tw.mu.Unlock()
+    if f, ok := tw.w.(http.Flusher); ok && !tw.timedOut {
+        f.Flush()
+    }
+}
+
+// forwardTrailers copies the trailers the handler set after its response started, which
+// went to the private header map, to the underlying writer so they are still sent.
+func (tw *timeoutWriter) forwardTrailers() {
+    tw.mu.Lock()
+    defer tw.mu.Unlock()
+    if tw.timedOut || !tw.wroteHeader {
+        return
+    }
+    for _, declared := range tw.header.Values("Trailer") {
+        for _, k := range strings.Split(declared, ",") {
+            k = http.CanonicalHeaderKey(strings.TrimSpace(k))
+            if v, ok := tw.header[k]; ok {
+                tw.w.Header()[k] = v
+            }
+        }
+    }
+    for k, v := range tw.header {
+        if strings.HasPrefix(k, http.TrailerPrefix) {
+            tw.w.Header()[k] = v
+        }
+    }
+}
+
+// timeout marks the writer as timed out, reporting whether the response had not started
+// yet so that the caller can still send the 408.
+func (tw *timeoutWriter) timeout() bool {
+    tw.mu.Lock()
+    defer tw.mu.Unlock()
+    tw.timedOut = true
+    tw.startedBeforeTimeout = tw.wroteHeader
+    return !tw.wroteHeader
+}
+
+// discarded returns the status and body the handler wrote after the timeout. The status
+// is 0 when nothing was discarded.
+func (tw *timeoutWriter) discarded() (int, string) {
+    tw.mu.Lock()
+    defer tw.mu.Unlock()
+    if !tw.timedOut || !tw.wroteHeader || (tw.startedBeforeTimeout && len(tw.body) == 0) {
+        return 0, ""
+    }
+    return tw.status, string(tw.body)
+}
+
+// maxDiscardedBody caps how much of a discarded response is kept for logging.
+const maxDiscardedBody = 4096
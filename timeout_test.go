@@ -0,0 +1,126 @@
+package main
+
+import (
+    "log/slog"
+    "net/http"
+    "net/http/httptest"
+    "strings"
+    "testing"
+    "time"
+)
+
+// blockingHandler writes before, waits for release or the request's cancellation plus
+// release, then writes after. It closes finished when it returns.
+func blockingHandler(before, after string, release, finished chan struct{}) http.Handler {
+    return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        defer close(finished)
+        if before != "" {
+            w.Write([]byte(before))
+            w.(http.Flusher).Flush()
+        }
+        <-r.Context().Done()
+        <-release
+        if after != "" {
+            w.WriteHeader(http.StatusCreated)
+            w.Write([]byte(after))
+        }
+    })
+}
+
+func TestWithRequestTimeout(t *testing.T) {
+    tests := []struct {
+        name        string
+        before      string
+        after       string
+        wantStatus  int
+        wantBody    string
+        wantLogged  string
+        wantMissing string
+    }{
+        {"timeout before the response", "", `{"rows":3}`, http.StatusRequestTimeout, "request timeout", `"status":201`, `{"rows":3}`},
+        {"timeout after the response started", "[first", `,second]`, http.StatusOK, "[first", "Request timed out after the response started", "second"},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            logs := captureLogs(t, slog.LevelWarn)
+            release, finished := make(chan struct{}), make(chan struct{})
+            handler := withRequestTimeout(20*time.Millisecond, blockingHandler(tt.before, tt.after, release, finished))
+            rec := httptest.NewRecorder()
+            handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+            close(release)
+            <-finished
+
+            if rec.Code != tt.wantStatus || !strings.Contains(rec.Body.String(), tt.wantBody) {
+                t.Errorf("response = %d %q, want %d containing %q", rec.Code, rec.Body, tt.wantStatus, tt.wantBody)
+            }
+            if strings.Contains(rec.Body.String(), tt.wantMissing) {
+                t.Errorf("write after the timeout reached the client: %q", rec.Body)
+            }
+            if !logs.waitFor(tt.wantLogged) {
+                t.Errorf("logs lack %q: %s", tt.wantLogged, logs)
+            }
+        })
+    }
+}
+
+func TestWithRequestTimeoutInTime(t *testing.T) {
+    handler := withRequestTimeout(time.Second, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        w.Header().Set("X-Rows", "3")
+        w.WriteHeader(http.StatusCreated)
+        w.Write([]byte("stored"))
+    }))
+    rec := httptest.NewRecorder()
+    handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+    if rec.Code != http.StatusCreated || rec.Body.String() != "stored" || rec.Header().Get("X-Rows") != "3" {
+        t.Errorf("response = %d %q %v, want the handler's", rec.Code, rec.Body, rec.Header())
+    }
+}
+
+func TestWithRequestTimeoutHoldsInFlightSlot(t *testing.T) {
+    release, finished := make(chan struct{}), make(chan struct{})
+    slow := blockingHandler("", "late", release, finished)
+    fast := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { w.Write([]byte("ok")) })
+    handler := limitInFlight(1, withRequestTimeout(20*time.Millisecond, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        if r.URL.Path == "/slow" {
+            slow.ServeHTTP(w, r)
+            return
+        }
+        fast.ServeHTTP(w, r)
+    })))
+    serve := func(path string) int {
+        rec := httptest.NewRecorder()
+        handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+        return rec.Code
+    }
+
+    if got := serve("/slow"); got != http.StatusRequestTimeout {
+        t.Fatalf("slow request: status = %d, want 408", got)
+    }
+    if got := serve("/"); got != http.StatusServiceUnavailable {
+        t.Errorf("while the timed-out handler runs: status = %d, want 503", got)
+    }
+    close(release)
+    <-finished
+    deadline := time.Now().Add(time.Second)
+    for serve("/") != http.StatusOK {
+        if time.Now().After(deadline) {
+            t.Fatal("slot was not released after the handler returned")
+        }
+        time.Sleep(time.Millisecond)
+    }
+}
+
+func TestWithRequestTimeoutUnderGzip(t *testing.T) {
+    release, finished := make(chan struct{}), make(chan struct{})
+    handler := withGzip(1, withRequestTimeout(20*time.Millisecond, blockingHandler("[first", strings.Repeat(",more", 1000)+"]", release, finished)))
+    req := httptest.NewRequest(http.MethodGet, "/", nil)
+    req.Header.Set("Accept-Encoding", "gzip")
+    rec := httptest.NewRecorder()
+    handler.ServeHTTP(rec, req)
+    // The gzip writer is closed by now; the late writes must not reach it.
+    close(release)
+    <-finished
+    if rec.Header().Get("Content-Encoding") != "gzip" {
+        t.Errorf("Content-Encoding = %q, want gzip", rec.Header().Get("Content-Encoding"))
+    }
+}
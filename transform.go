@@ -0,0 +1,235 @@
+package main
+
+import (
+    "log/slog"
+    "math"
+    "sort"
+    "time"
+
+    "cloud.google.com/go/bigquery"
+)
+
+// finishRows puts converted rows into their final form: with snowfall in the requested
+// unit, limited to the requested months, checked against the plausibility bounds, with
+// suspect zero precipitation cleared if requested, without rows below min_fields, quality
+// scored, sorted, with growing degree days and rolling aggregates added if requested,
+// rounded, and with NULLs replaced by the nodata sentinel when one was requested.
+func finishRows(rows []*WeatherData, opts *requestOptions) []*WeatherData {
+    if opts.SnowfallUnit == "mm" {
+        snowfallToMillimetres(rows)
+    }
+    if len(opts.Months) > 0 {
+        rows = filterMonths(rows, opts.Months)
+    }
+    rows = applyPlausibility(rows, opts.Variables, opts.OutOfBounds)
+    if opts.ZeroPrecipAsNull {
+        nullUncorroboratedZeros(rows)
+    }
+    if opts.MinFields > 0 {
+        rows = dropSparseRows(rows, opts.Variables, opts.MinFields)
+    }
+    if opts.QualityScore {
+        scoreRows(rows, opts.Variables)
+    }
+    sortRows(rows)
+    if opts.GDDBase != nil {
+        addGDD(rows, *opts.GDDBase, opts.GDDCumulative)
+    }
+    if opts.Rolling > 0 {
+        addRolling(rows, opts.Rolling, opts.RollingPartial)
+    }
+    if opts.Round >= 0 {
+        roundRows(rows, opts.Round)
+    }
+    if opts.NodataSentinel != nil {
+        fillSentinel(rows, *opts.NodataSentinel, opts.Variables)
+    }
+    return rows
+}
+
+// snowfallToMillimetres converts snowfall from the centimetres Open-Meteo reports; 1 cm of
+// snow is 10 mm.
+func snowfallToMillimetres(rows []*WeatherData) {
+    for _, row := range rows {
+        if row.SnowfallSum.Valid {
+            row.SnowfallSum.Float64 *= 10
+        }
+    }
+}
+
+// filterMonths keeps the rows whose date falls in one of the months. Open-Meteo cannot skip
+// months, so the full range is still fetched; this only saves filtering downstream.
+func filterMonths(rows []*WeatherData, months map[time.Month]bool) []*WeatherData {
+    kept := rows[:0]
+    for _, row := range rows {
+        if date, err := time.Parse(dateLayout, row.Date); err == nil && months[date.Month()] {
+            kept = append(kept, row)
+        }
+    }
+    slog.Info("Filtered rows by month", "kept", len(kept), "fetched", len(rows))
+    return kept
+}
+
+// nullUncorroboratedZeros sets zero rain and snowfall to NULL on days where none of the
+// temperatures are present. Open-Meteo fills some gaps in older archive periods with 0
+// precipitation, but a real observation of "no rain" comes with the rest of the day's data,
+// so a zero is only cleared when nothing else corroborates that the day was observed.
+// Zeros on days with any temperature are kept, which leaves some filled gaps in place
+// rather than discarding real dry days.
+func nullUncorroboratedZeros(rows []*WeatherData) {
+    cleared := 0
+    for _, row := range rows {
+        if row.MeanTemperature.Valid || row.MinTemperature.Valid || row.MaxTemperature.Valid {
+            continue
+        }
+        for _, v := range []*bigquery.NullFloat64{&row.RainSum, &row.SnowfallSum} {
+            if v.Valid && v.Float64 == 0 {
+                *v = bigquery.NullFloat64{}
+                cleared++
+            }
+        }
+    }
+    if cleared > 0 {
+        slog.Debug("Cleared uncorroborated zero precipitation", "values", cleared)
+    }
+}
+
+// dropSparseRows removes rows with fewer than minFields non-NULL requested variables.
+func dropSparseRows(rows []*WeatherData, variables []string, minFields int) []*WeatherData {
+    kept := rows[:0]
+    for _, row := range rows {
+        n := 0
+        for _, name := range variables {
+            if row.hasValue(name) {
+                n++
+            }
+        }
+        if n >= minFields {
+            kept = append(kept, row)
+        }
+    }
+    if dropped := len(rows) - len(kept); dropped > 0 {
+        slog.Info("Dropped rows below min_fields", "dropped", dropped, "kept", len(kept), "min_fields", minFields)
+    }
+    return kept
+}
+
+// hasValue reports whether the row has a non-NULL value for the daily variable.
+func (r *WeatherData) hasValue(name string) bool {
+    if name == "weather_code" {
+        return r.WeatherCode.Valid
+    }
+    v := r.floatField(name)
+    return v != nil && v.Valid
+}
+
+// floatField returns the column of a float daily variable, or nil for weather_code and
+// unknown names.
+func (r *WeatherData) floatField(name string) *bigquery.NullFloat64 {
+    switch name {
+    case "temperature_2m_min":
+        return &r.MinTemperature
+    case "temperature_2m_max":
+        return &r.MaxTemperature
+    case "temperature_2m_mean":
+        return &r.MeanTemperature
+    case "rain_sum":
+        return &r.RainSum
+    case "snowfall_sum":
+        return &r.SnowfallSum
+    case "surface_pressure_mean":
+        return &r.SurfacePressureMean
+    case "cloud_cover_mean":
+        return &r.CloudCoverMean
+    case "et0_fao_evapotranspiration":
+        return &r.ET0
+    }
+    return nil
+}
+
+// fillSentinel replaces NULL values of the requested numeric variables with the sentinel.
+// Weather codes and descriptions stay NULL, as a sentinel would read as a valid code or label.
+func fillSentinel(rows []*WeatherData, sentinel float64, variables []string) {
+    for _, row := range rows {
+        values := []*bigquery.NullFloat64{&row.MeanTemperature, &row.MinTemperature, &row.MaxTemperature, &row.RainSum, &row.SnowfallSum}
+        if hasVariable(variables, "surface_pressure_mean") {
+            values = append(values, &row.SurfacePressureMean)
+        }
+        if hasVariable(variables, "cloud_cover_mean") {
+            values = append(values, &row.CloudCoverMean)
+        }
+        if hasVariable(variables, "et0_fao_evapotranspiration") {
+            values = append(values, &row.ET0)
+        }
+        for _, v := range values {
+            if !v.Valid {
+                *v = bigquery.NullFloat64{Float64: sentinel, Valid: true}
+            }
+        }
+    }
+}
+
+// roundRows rounds every numeric weather value to the given number of decimal places.
+func roundRows(rows []*WeatherData, places int) {
+    for _, row := range rows {
+        for _, variable := range supportedVariables {
+            if v := row.floatField(variable.Name); v != nil {
+                roundNull(v, places)
+            }
+        }
+        roundNull(&row.GDD, places)
+        roundNull(&row.GDDCumulative, places)
+        for name, v := range row.Rolling {
+            roundNull(&v, places)
+            row.Rolling[name] = v
+        }
+    }
+}
+
+// addGDD sets each row's growing degree days, max(0, (min+max)/2 - base), leaving it NULL
+// when either temperature is missing. With cumulative, the running total over the sorted
+// rows of each model is stored as well; a day without GDD carries the total forward.
+func addGDD(rows []*WeatherData, base float64, cumulative bool) {
+    totals := make(map[string]float64)
+    for _, row := range rows {
+        if row.MinTemperature.Valid && row.MaxTemperature.Valid {
+            gdd := max(0, (row.MinTemperature.Float64+row.MaxTemperature.Float64)/2-base)
+            row.GDD = bigquery.NullFloat64{Float64: gdd, Valid: true}
+            totals[row.SourceModel.StringVal] += gdd
+        }
+        if cumulative {
+            row.GDDCumulative = bigquery.NullFloat64{Float64: totals[row.SourceModel.StringVal], Valid: true}
+        }
+    }
+}
+
+// roundNull rounds a nullable value in place, leaving NULLs untouched.
+func roundNull(v *bigquery.NullFloat64, places int) {
+    if v.Valid {
+        v.Float64 = roundTo(v.Float64, places)
+    }
+}
+
+// roundTo rounds v half away from zero to the given number of decimal places.
+func roundTo(v float64, places int) float64 {
+    scale := math.Pow(10, float64(places))
+    return math.Round(v*scale) / scale
+}
+
+// sortRows orders rows by date, then by coordinate and model, so output is stable across runs
+// regardless of the order Open-Meteo returned them in.
+func sortRows(rows []*WeatherData) {
+    sort.Slice(rows, func(i, j int) bool {
+        a, b := rows[i], rows[j]
+        if a.Date != b.Date {
+            return a.Date < b.Date
+        }
+        if a.Latitude != b.Latitude {
+            return a.Latitude < b.Latitude
+        }
+        if a.Longitude != b.Longitude {
+            return a.Longitude < b.Longitude
+        }
+        return a.SourceModel.StringVal < b.SourceModel.StringVal
+    })
+}
@@ -0,0 +1,365 @@
+package main
+
+import (
+    "fmt"
+    "log/slog"
+    "reflect"
+    "strings"
+    "testing"
+    "time"
+
+    "cloud.google.com/go/bigquery"
+)
+
+// nf returns a valid nullable float.
+func nf(v float64) bigquery.NullFloat64 {
+    return bigquery.NullFloat64{Float64: v, Valid: true}
+}
+
+func TestRoundTo(t *testing.T) {
+    tests := []struct {
+        v      float64
+        places int
+        want   float64
+    }{
+        {1.23456, 2, 1.23},
+        {1.235, 2, 1.24},
+        {-1.5, 0, -2},
+        {2.5, 0, 3},
+        {12.3456, 1, 12.3},
+        {0.1 + 0.2, 10, 0.3},
+    }
+    for _, tt := range tests {
+        if got := roundTo(tt.v, tt.places); got != tt.want {
+            t.Errorf("roundTo(%v, %d) = %v, want %v", tt.v, tt.places, got, tt.want)
+        }
+    }
+}
+
+func TestRoundRows(t *testing.T) {
+    row := &WeatherData{
+        MinTemperature:      nf(-3.14159),
+        MaxTemperature:      nf(12.5551),
+        MeanTemperature:     nf(4.449),
+        RainSum:             nf(0.05),
+        SurfacePressureMean: nf(1013.2549),
+        CloudCoverMean:      nf(87.55),
+        ET0:                 nf(2.3456),
+        GDD:                 nf(1.2345),
+        Rolling:             map[string]bigquery.NullFloat64{"rain_sum_7d": nf(3.3333)},
+    }
+    roundRows([]*WeatherData{row}, 1)
+
+    tests := []struct {
+        name string
+        got  bigquery.NullFloat64
+        want bigquery.NullFloat64
+    }{
+        {"min", row.MinTemperature, nf(-3.1)},
+        {"max", row.MaxTemperature, nf(12.6)},
+        {"mean", row.MeanTemperature, nf(4.4)},
+        {"rain", row.RainSum, nf(0.1)},
+        {"null stays null", row.SnowfallSum, bigquery.NullFloat64{}},
+        {"surface pressure", row.SurfacePressureMean, nf(1013.3)},
+        {"cloud cover", row.CloudCoverMean, nf(87.6)},
+        {"et0", row.ET0, nf(2.3)},
+        {"gdd", row.GDD, nf(1.2)},
+        {"rolling", row.Rolling["rain_sum_7d"], nf(3.3)},
+    }
+    for _, tt := range tests {
+        if tt.got != tt.want {
+            t.Errorf("%s = %+v, want %+v", tt.name, tt.got, tt.want)
+        }
+    }
+}
+
+func TestParseRound(t *testing.T) {
+    tests := []struct {
+        param   string
+        def     int
+        want    int
+        wantErr bool
+    }{
+        {"", -1, -1, false},
+        {"", 2, 2, false},
+        {"0", -1, 0, false},
+        {"10", -1, 10, false},
+        {"11", -1, 0, true},
+        {"-1", -1, 0, true},
+        {"two", -1, 0, true},
+    }
+    for _, tt := range tests {
+        t.Run(tt.param, func(t *testing.T) {
+            withConfig(t, func(c *Config) { c.DefaultRound = tt.def })
+            got, err := parseRound(tt.param)
+            if (err != nil) != tt.wantErr {
+                t.Fatalf("err = %v, wantErr %v", err, tt.wantErr)
+            }
+            if got != tt.want {
+                t.Errorf("parseRound(%q) = %d, want %d", tt.param, got, tt.want)
+            }
+        })
+    }
+}
+
+func TestSortRows(t *testing.T) {
+    row := func(date string, lat, lon float64, model string) *WeatherData {
+        return &WeatherData{Date: date, Latitude: lat, Longitude: lon, SourceModel: bigquery.NullString{StringVal: model, Valid: model != ""}}
+    }
+    tests := []struct {
+        name string
+        rows []*WeatherData
+        want []string
+    }{
+        {"by date", []*WeatherData{row("2024-01-03", 0, 0, ""), row("2024-01-01", 0, 0, ""), row("2024-01-02", 0, 0, "")}, []string{"2024-01-01 0 0 ", "2024-01-02 0 0 ", "2024-01-03 0 0 "}},
+        {"then latitude", []*WeatherData{row("2024-01-01", 52, 13, ""), row("2024-01-01", 48, 2, "")}, []string{"2024-01-01 48 2 ", "2024-01-01 52 13 "}},
+        {"then longitude", []*WeatherData{row("2024-01-01", 52, 14, ""), row("2024-01-01", 52, 13, "")}, []string{"2024-01-01 52 13 ", "2024-01-01 52 14 "}},
+        {"then model", []*WeatherData{row("2024-01-01", 52, 13, "icon_seamless"), row("2024-01-01", 52, 13, "ecmwf_ifs04")}, []string{"2024-01-01 52 13 ecmwf_ifs04", "2024-01-01 52 13 icon_seamless"}},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            sortRows(tt.rows)
+            for i, r := range tt.rows {
+                if got := fmt.Sprintf("%s %v %v %s", r.Date, r.Latitude, r.Longitude, r.SourceModel.StringVal); got != tt.want[i] {
+                    t.Errorf("row %d = %q, want %q", i, got, tt.want[i])
+                }
+            }
+        })
+    }
+}
+
+func TestFillSentinel(t *testing.T) {
+    tests := []struct {
+        name      string
+        variables []string
+        row       WeatherData
+        check     func(*WeatherData) []bigquery.NullFloat64
+        want      []bigquery.NullFloat64
+    }{
+        {
+            name:      "core values",
+            variables: []string{"rain_sum"},
+            row:       WeatherData{RainSum: nf(0.4)},
+            check: func(r *WeatherData) []bigquery.NullFloat64 {
+                return []bigquery.NullFloat64{r.MeanTemperature, r.RainSum, r.SnowfallSum}
+            },
+            want: []bigquery.NullFloat64{nf(-999), nf(0.4), nf(-999)},
+        },
+        {
+            name:      "optional value only when requested",
+            variables: []string{"cloud_cover_mean"},
+            row:       WeatherData{},
+            check: func(r *WeatherData) []bigquery.NullFloat64 {
+                return []bigquery.NullFloat64{r.CloudCoverMean, r.SurfacePressureMean, r.ET0}
+            },
+            want: []bigquery.NullFloat64{nf(-999), {}, {}},
+        },
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            row := tt.row
+            fillSentinel([]*WeatherData{&row}, -999, tt.variables)
+            got := tt.check(&row)
+            for i := range got {
+                if got[i] != tt.want[i] {
+                    t.Errorf("value %d = %+v, want %+v", i, got[i], tt.want[i])
+                }
+            }
+            if row.WeatherCode.Valid || row.WeatherDescription.Valid {
+                t.Error("sentinel filled the weather code or description")
+            }
+        })
+    }
+}
+
+func TestDropSparseRows(t *testing.T) {
+    variables := []string{"temperature_2m_max", "rain_sum", "weather_code"}
+    rows := func() []*WeatherData {
+        return []*WeatherData{
+            {Date: "2024-01-01", MaxTemperature: nf(4), RainSum: nf(0), WeatherCode: bigquery.NullInt64{Int64: 3, Valid: true}},
+            {Date: "2024-01-02", MaxTemperature: nf(5), RainSum: nf(0.2)},
+            {Date: "2024-01-03", RainSum: nf(1)},
+            {Date: "2024-01-04"},
+        }
+    }
+    tests := []struct {
+        name      string
+        minFields int
+        wantDates []string
+        wantLog   bool
+    }{
+        {"one value", 1, []string{"2024-01-01", "2024-01-02", "2024-01-03"}, true},
+        {"two values", 2, []string{"2024-01-01", "2024-01-02"}, true},
+        {"all values with code", 3, []string{"2024-01-01"}, true},
+        {"nothing dropped", 0, []string{"2024-01-01", "2024-01-02", "2024-01-03", "2024-01-04"}, false},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            logs := captureLogs(t, slog.LevelInfo)
+            var dates []string
+            for _, row := range dropSparseRows(rows(), variables, tt.minFields) {
+                dates = append(dates, row.Date)
+            }
+            if !reflect.DeepEqual(dates, tt.wantDates) {
+                t.Errorf("kept %q, want %q", dates, tt.wantDates)
+            }
+            if got := strings.Contains(logs.String(), "Dropped rows below min_fields"); got != tt.wantLog {
+                t.Errorf("logged drop = %v, want %v", got, tt.wantLog)
+            }
+        })
+    }
+}
+
+func TestNullUncorroboratedZeros(t *testing.T) {
+    tests := []struct {
+        name         string
+        row          WeatherData
+        wantRain     bigquery.NullFloat64
+        wantSnowfall bigquery.NullFloat64
+    }{
+        {"zero with temperatures is a dry day", WeatherData{MeanTemperature: nf(3), RainSum: nf(0), SnowfallSum: nf(0)}, nf(0), nf(0)},
+        {"zero with only a minimum temperature is kept", WeatherData{MinTemperature: nf(-2), RainSum: nf(0)}, nf(0), bigquery.NullFloat64{}},
+        {"zero without temperatures is cleared", WeatherData{RainSum: nf(0), SnowfallSum: nf(0)}, bigquery.NullFloat64{}, bigquery.NullFloat64{}},
+        {"non-zero without temperatures is kept", WeatherData{RainSum: nf(1.2), SnowfallSum: nf(0)}, nf(1.2), bigquery.NullFloat64{}},
+        {"NULL stays NULL", WeatherData{}, bigquery.NullFloat64{}, bigquery.NullFloat64{}},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            row := tt.row
+            nullUncorroboratedZeros([]*WeatherData{&row})
+            if row.RainSum != tt.wantRain || row.SnowfallSum != tt.wantSnowfall {
+                t.Errorf("rain %+v, snowfall %+v; want %+v, %+v", row.RainSum, row.SnowfallSum, tt.wantRain, tt.wantSnowfall)
+            }
+        })
+    }
+}
+
+func TestFinishRowsZeroPrecip(t *testing.T) {
+    tests := []struct {
+        query    string
+        wantRain bigquery.NullFloat64
+    }{
+        {"latitude=52.52&longitude=13.41", nf(0)},
+        {"latitude=52.52&longitude=13.41&zero_precip_as_null=true", bigquery.NullFloat64{}},
+    }
+    for _, tt := range tests {
+        t.Run(tt.query, func(t *testing.T) {
+            rows := finishRows([]*WeatherData{{Date: "2024-01-01", RainSum: nf(0)}}, mustParseOptions(t, tt.query))
+            if rows[0].RainSum != tt.wantRain {
+                t.Errorf("rain = %+v, want %+v", rows[0].RainSum, tt.wantRain)
+            }
+        })
+    }
+}
+
+func TestFilterMonths(t *testing.T) {
+    rows := func() []*WeatherData {
+        var rows []*WeatherData
+        for _, date := range []string{"2003-06-30", "2003-07-01", "2003-07-31", "2004-07-15", "2004-08-01", "bad"} {
+            rows = append(rows, &WeatherData{Date: date})
+        }
+        return rows
+    }
+    tests := []struct {
+        name   string
+        months map[time.Month]bool
+        want   []string
+    }{
+        {"July across years", map[time.Month]bool{time.July: true}, []string{"2003-07-01", "2003-07-31", "2004-07-15"}},
+        {"summer", map[time.Month]bool{time.June: true, time.August: true}, []string{"2003-06-30", "2004-08-01"}},
+        {"no match", map[time.Month]bool{time.January: true}, nil},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            logs := captureLogs(t, slog.LevelInfo)
+            var dates []string
+            for _, row := range filterMonths(rows(), tt.months) {
+                dates = append(dates, row.Date)
+            }
+            if !reflect.DeepEqual(dates, tt.want) {
+                t.Errorf("kept %q, want %q", dates, tt.want)
+            }
+            if !strings.Contains(logs.String(), "Filtered rows by month") {
+                t.Errorf("kept count not logged: %s", logs)
+            }
+        })
+    }
+}
+
+func TestAddGDD(t *testing.T) {
+    var null bigquery.NullFloat64
+    // row builds a day of the given model with the given minimum and maximum.
+    row := func(model string, min, max bigquery.NullFloat64) *WeatherData {
+        return &WeatherData{MinTemperature: min, MaxTemperature: max, SourceModel: bigquery.NullString{StringVal: model, Valid: model != ""}}
+    }
+    tests := []struct {
+        name           string
+        base           float64
+        cumulative     bool
+        rows           []*WeatherData
+        wantGDD        []bigquery.NullFloat64
+        wantCumulative []bigquery.NullFloat64
+    }{
+        {
+            name:           "above base",
+            base:           10,
+            rows:           []*WeatherData{row("", nf(12), nf(24))},
+            wantGDD:        []bigquery.NullFloat64{nf(8)},
+            wantCumulative: []bigquery.NullFloat64{null},
+        },
+        {
+            name:           "below base is zero",
+            base:           10,
+            rows:           []*WeatherData{row("", nf(-2), nf(6))},
+            wantGDD:        []bigquery.NullFloat64{nf(0)},
+            wantCumulative: []bigquery.NullFloat64{null},
+        },
+        {
+            name:           "at base is zero",
+            base:           10,
+            rows:           []*WeatherData{row("", nf(5), nf(15))},
+            wantGDD:        []bigquery.NullFloat64{nf(0)},
+            wantCumulative: []bigquery.NullFloat64{null},
+        },
+        {
+            name:           "negative base",
+            base:           -5,
+            rows:           []*WeatherData{row("", nf(-4), nf(0))},
+            wantGDD:        []bigquery.NullFloat64{nf(3)},
+            wantCumulative: []bigquery.NullFloat64{null},
+        },
+        {
+            name:           "missing temperature",
+            base:           10,
+            rows:           []*WeatherData{row("", null, nf(24)), row("", nf(12), null)},
+            wantGDD:        []bigquery.NullFloat64{null, null},
+            wantCumulative: []bigquery.NullFloat64{null, null},
+        },
+        {
+            name:           "running total carries over gaps",
+            base:           10,
+            cumulative:     true,
+            rows:           []*WeatherData{row("", nf(12), nf(24)), row("", nf(0), nf(4)), row("", null, nf(30)), row("", nf(14), nf(20))},
+            wantGDD:        []bigquery.NullFloat64{nf(8), nf(0), null, nf(7)},
+            wantCumulative: []bigquery.NullFloat64{nf(8), nf(8), nf(8), nf(15)},
+        },
+        {
+            name:           "running total per model",
+            base:           10,
+            cumulative:     true,
+            rows:           []*WeatherData{row("a", nf(12), nf(24)), row("a", nf(14), nf(20)), row("b", nf(10), nf(14)), row("b", nf(10), nf(14))},
+            wantGDD:        []bigquery.NullFloat64{nf(8), nf(7), nf(2), nf(2)},
+            wantCumulative: []bigquery.NullFloat64{nf(8), nf(15), nf(2), nf(4)},
+        },
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            addGDD(tt.rows, tt.base, tt.cumulative)
+            for i, row := range tt.rows {
+                if row.GDD != tt.wantGDD[i] || row.GDDCumulative != tt.wantCumulative[i] {
+                    t.Errorf("row %d: gdd %+v, cumulative %+v; want %+v, %+v", i, row.GDD, row.GDDCumulative, tt.wantGDD[i], tt.wantCumulative[i])
+                }
+            }
+        })
+    }
+}
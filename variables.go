@@ -0,0 +1,169 @@
+package main
+
+import (
+    "fmt"
+    "net/http"
+    "strings"
+)
+
+// Variable describes a weather variable the function can request from Open-Meteo.
+type Variable struct {
+    Name        string   `json:"name"`
+    Unit        string   `json:"unit"`
+    Granularity string   `json:"granularity"`
+    Modes       []string `json:"modes"`
+    Core        bool     `json:"core"`
+    Description string   `json:"description"`
+}
+
+// supportedVariables is the single source for both the /variables endpoint and request validation.
+// Core variables are always requested; the others are only requested when asked for.
+var supportedVariables = []Variable{
+    {Name: "temperature_2m_min", Unit: "°C", Granularity: "daily", Modes: []string{"archive", "forecast"}, Core: true, Description: "Minimum air temperature at 2 m"},
+    {Name: "temperature_2m_max", Unit: "°C", Granularity: "daily", Modes: []string{"archive", "forecast"}, Core: true, Description: "Maximum air temperature at 2 m"},
+    {Name: "temperature_2m_mean", Unit: "°C", Granularity: "daily", Modes: []string{"archive", "forecast"}, Core: true, Description: "Mean air temperature at 2 m"},
+    {Name: "rain_sum", Unit: "mm", Granularity: "daily", Modes: []string{"archive", "forecast"}, Core: true, Description: "Total rain"},
+    {Name: "snowfall_sum", Unit: "cm", Granularity: "daily", Modes: []string{"archive", "forecast"}, Core: true, Description: "Total snowfall"},
+    {Name: "weather_code", Unit: "wmo code", Granularity: "daily", Modes: []string{"archive", "forecast"}, Description: "Most severe WMO weather code of the day"},
+    {Name: "surface_pressure_mean", Unit: "hPa", Granularity: "daily", Modes: []string{"archive", "forecast"}, Description: "Mean surface air pressure"},
+    {Name: "cloud_cover_mean", Unit: "%", Granularity: "daily", Modes: []string{"archive", "forecast"}, Description: "Mean total cloud cover"},
+    {Name: "et0_fao_evapotranspiration", Unit: "mm", Granularity: "daily", Modes: []string{"archive", "forecast"}, Description: "FAO-56 reference evapotranspiration"},
+}
+
+// lookupVariable returns the supported variable with the given name and granularity.
+func lookupVariable(name, granularity string) (Variable, bool) {
+    for _, v := range supportedVariables {
+        if v.Name == name && v.Granularity == granularity {
+            return v, true
+        }
+    }
+    return Variable{}, false
+}
+
+// parseDailyVariables validates the comma-separated daily parameter for the given mode
+// and returns the full list of variables to request, core variables first.
+func parseDailyVariables(param, mode string) ([]string, error) {
+    requested := make(map[string]bool)
+    for _, name := range splitList(param) {
+        v, ok := lookupVariable(name, "daily")
+        if !ok {
+            return nil, fmt.Errorf("unsupported daily variable %q", name)
+        }
+        if !v.supportsMode(mode) {
+            return nil, fmt.Errorf("daily variable %q is not available in %s mode", name, mode)
+        }
+        requested[name] = true
+    }
+
+    var names []string
+    for _, v := range supportedVariables {
+        if v.Granularity == "daily" && (v.Core || requested[v.Name]) {
+            names = append(names, v.Name)
+        }
+    }
+    return names, nil
+}
+
+// supportsMode reports whether the variable can be requested in the given mode.
+func (v Variable) supportsMode(mode string) bool {
+    for _, m := range v.Modes {
+        if m == mode {
+            return true
+        }
+    }
+    return false
+}
+
+// hasVariable reports whether name is among the requested variables.
+func hasVariable(names []string, name string) bool {
+    for _, n := range names {
+        if n == name {
+            return true
+        }
+    }
+    return false
+}
+
+// listVariables returns the supported variables as JSON.
+func listVariables(w http.ResponseWriter, r *http.Request) {
+    if r.Method != http.MethodGet {
+        http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+        return
+    }
+    writeJSON(w, http.StatusOK, map[string]interface{}{"variables": supportedVariables})
+}
+
+// variableList joins variable names for the Open-Meteo daily parameter.
+func variableList(names []string) string {
+    return strings.Join(names, ",")
+}
+
+// floatValues returns the daily array of a float variable, or nil for weather_code and
+// unknown names.
+func (d *DailyData) floatValues(name string) []*float64 {
+    switch name {
+    case "temperature_2m_min":
+        return d.Temperature2mMin
+    case "temperature_2m_max":
+        return d.Temperature2mMax
+    case "temperature_2m_mean":
+        return d.Temperature2mMean
+    case "rain_sum":
+        return d.RainSum
+    case "snowfall_sum":
+        return d.SnowfallSum
+    case "surface_pressure_mean":
+        return d.SurfacePressureMean
+    case "cloud_cover_mean":
+        return d.CloudCoverMean
+    case "et0_fao_evapotranspiration":
+        return d.ET0
+    }
+    return nil
+}
+
+// hasValues reports whether the response has at least one non-NULL value for the variable.
+func (d *DailyData) hasValues(name string) bool {
+    if name == "weather_code" {
+        for _, v := range d.WeatherCode {
+            if v != nil {
+                return true
+            }
+        }
+        return false
+    }
+    for _, v := range d.floatValues(name) {
+        if v != nil {
+            return true
+        }
+    }
+    return false
+}
+
+// emptyVariables returns the variables among names that have no non-NULL value in the response.
+func (d *DailyData) emptyVariables(names []string) []string {
+    var empty []string
+    for _, name := range names {
+        if !d.hasValues(name) {
+            empty = append(empty, name)
+        }
+    }
+    return empty
+}
+
+// checkComplete fails with a 422 when require_complete is set and a requested variable
+// came back entirely NULL, which usually means the coordinate lacks coverage.
+func checkComplete(d *DailyData, opts *requestOptions) error {
+    if !opts.RequireComplete {
+        return nil
+    }
+    empty := d.emptyVariables(opts.Variables)
+    if len(empty) == 0 {
+        return nil
+    }
+    msg := "No data for requested variables: " + strings.Join(empty, ", ")
+    if opts.Model != "" {
+        msg += " (model " + opts.Model + ")"
+    }
+    return &requestError{http.StatusUnprocessableEntity, msg, fmt.Errorf("incomplete response for %.4f,%.4f: %s", opts.Latitude, opts.Longitude, msg)}
+}
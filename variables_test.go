@@ -0,0 +1,113 @@
+package main
+
+import (
+    "context"
+    "encoding/json"
+    "errors"
+    "net/http"
+    "net/http/httptest"
+    "reflect"
+    "strings"
+    "testing"
+)
+
+func TestListVariables(t *testing.T) {
+    tests := []struct {
+        method string
+        want   int
+    }{
+        {http.MethodGet, http.StatusOK},
+        {http.MethodPost, http.StatusMethodNotAllowed},
+    }
+    for _, tt := range tests {
+        t.Run(tt.method, func(t *testing.T) {
+            rec := httptest.NewRecorder()
+            listVariables(rec, httptest.NewRequest(tt.method, "/variables", nil))
+            if rec.Code != tt.want {
+                t.Fatalf("status = %d, want %d", rec.Code, tt.want)
+            }
+            if tt.want != http.StatusOK {
+                return
+            }
+            var body struct {
+                Variables []Variable `json:"variables"`
+            }
+            if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+                t.Fatal(err)
+            }
+            if !reflect.DeepEqual(body.Variables, supportedVariables) {
+                t.Errorf("variables = %+v, want the supported list", body.Variables)
+            }
+        })
+    }
+}
+
+func TestParseDailyVariables(t *testing.T) {
+    core := []string{"temperature_2m_min", "temperature_2m_max", "temperature_2m_mean", "rain_sum", "snowfall_sum"}
+    tests := []struct {
+        name    string
+        param   string
+        mode    string
+        want    []string
+        wantErr bool
+    }{
+        {"core only", "", "archive", core, false},
+        {"optional variable is appended", "weather_code", "archive", append(append([]string{}, core...), "weather_code"), false},
+        {"core variable is not repeated", "rain_sum", "forecast", core, false},
+        {"unknown variable", "wind_speed_10m_max", "archive", nil, true},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            got, err := parseDailyVariables(tt.param, tt.mode)
+            if (err != nil) != tt.wantErr {
+                t.Fatalf("err = %v, wantErr %v", err, tt.wantErr)
+            }
+            if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+                t.Errorf("parseDailyVariables() = %q, want %q", got, tt.want)
+            }
+        })
+    }
+}
+
+func TestCheckComplete(t *testing.T) {
+    const body = `{"latitude":52.5,"longitude":13.4,"daily":{"time":["2024-01-01","2024-01-02"],` +
+        `"temperature_2m_min":[-1,0],"temperature_2m_max":[4,5],"temperature_2m_mean":[1.5,2.5],"rain_sum":[0,null],"snowfall_sum":[null,null]}}`
+    tests := []struct {
+        name       string
+        query      string
+        wantStatus int
+        wantEmpty  string
+    }{
+        {"permissive by default", "", 0, ""},
+        {"all-null snowfall", "&require_complete=true", http.StatusUnprocessableEntity, "snowfall_sum"},
+        {"missing optional variable", "&require_complete=true&daily=weather_code", http.StatusUnprocessableEntity, "weather_code"},
+        {"model is named", "&require_complete=true&models=icon_seamless", http.StatusUnprocessableEntity, "model icon_seamless"},
+        {"explicitly off", "&require_complete=false", 0, ""},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            stubOpenMeteo(t, serveBody(body))
+            opts := mustParseOptions(t, "latitude=52.52&longitude=13.41&start_date=2024-01-01&end_date=2024-01-02"+tt.query)
+            _, rows, err := fetchRows(context.Background(), nil, opts)
+            var reqErr *requestError
+            if tt.wantStatus == 0 {
+                if err != nil || len(rows) != 2 {
+                    t.Fatalf("fetchRows() = %d rows, %v; want 2 rows", len(rows), err)
+                }
+                return
+            }
+            if !errors.As(err, &reqErr) || reqErr.Status != tt.wantStatus {
+                t.Fatalf("err = %v, want a %d request error", err, tt.wantStatus)
+            }
+            if !strings.Contains(reqErr.Message, tt.wantEmpty) {
+                t.Errorf("message %q does not mention %q", reqErr.Message, tt.wantEmpty)
+            }
+            if strings.Contains(reqErr.Message, "rain_sum") {
+                t.Errorf("message %q lists the partly filled rain_sum", reqErr.Message)
+            }
+            if rows != nil {
+                t.Errorf("fetchRows() returned %d rows for an incomplete response", len(rows))
+            }
+        })
+    }
+}
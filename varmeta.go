@@ -0,0 +1,94 @@
+package main
+
+import (
+    "context"
+    "fmt"
+    "sync"
+    "time"
+
+    "cloud.google.com/go/bigquery"
+)
+
+// VariableMeta describes one stored column in the variables meta table, for consumers
+// to join against when labelling values.
+type VariableMeta struct {
+    Variable    string    `bigquery:"variable"`
+    Column      string    `bigquery:"column"`
+    Unit        string    `bigquery:"unit"`
+    Description string    `bigquery:"description"`
+    UpdatedAt   time.Time `bigquery:"updated_at"`
+}
+
+var (
+    metaMu sync.Mutex
+    // syncedMeta remembers the unit last merged per variable, so an instance only runs
+    // the MERGE when a variable is new to it or its unit changed.
+    syncedMeta = make(map[string]string)
+)
+
+// variableMetaRows lists the meta rows of the requested variables. The unit of
+// snowfall_sum follows snowfall_unit, so it describes the latest ingestion; each row
+// also records its own unit in the snowfall_unit column.
+func variableMetaRows(opts *requestOptions) []VariableMeta {
+    var rows []VariableMeta
+    for _, col := range modelColumns {
+        if !hasVariable(opts.Variables, col.Variable) {
+            continue
+        }
+        v, ok := lookupVariable(col.Variable, "daily")
+        if !ok {
+            continue
+        }
+        unit := v.Unit
+        if col.Variable == "snowfall_sum" && opts.SnowfallUnit != "" {
+            unit = opts.SnowfallUnit
+        }
+        rows = append(rows, VariableMeta{Variable: v.Name, Column: col.Column, Unit: unit, Description: v.Description})
+    }
+    return rows
+}
+
+// recordVariablesMeta merges the requested variables into the variables meta table,
+// creating it on first use. Rows are keyed by variable, so repeated runs update rather
+// than duplicate them.
+func recordVariablesMeta(ctx context.Context, client *bigquery.Client, opts *requestOptions) error {
+    metaMu.Lock()
+    defer metaMu.Unlock()
+    var pending []VariableMeta
+    for _, row := range variableMetaRows(opts) {
+        if syncedMeta[row.Variable] != row.Unit {
+            row.UpdatedAt = now()
+            pending = append(pending, row)
+        }
+    }
+    if len(pending) == 0 {
+        return nil
+    }
+
+    if _, err := ensureTable(ctx, client, cfg.VariablesMetaTable, variablesMetaMetadata); err != nil {
+        return err
+    }
+    query := client.Query(fmt.Sprintf(
+        "MERGE `%s.%s.%s` AS t USING UNNEST(@rows) AS s ON t.variable = s.variable "+
+            "WHEN MATCHED THEN UPDATE SET `column` = s.`column`, unit = s.unit, description = s.description, updated_at = s.updated_at "+
+            "WHEN NOT MATCHED THEN INSERT (variable, `column`, unit, description, updated_at) VALUES (s.variable, s.`column`, s.unit, s.description, s.updated_at)",
+        cfg.ProjectID, cfg.DatasetID, cfg.VariablesMetaTable,
+    ))
+    query.Parameters = []bigquery.QueryParameter{{Name: "rows", Value: pending}}
+    if _, err := runDML(ctx, query); err != nil {
+        return fmt.Errorf("failed to merge variables meta: %w", err)
+    }
+    for _, row := range pending {
+        syncedMeta[row.Variable] = row.Unit
+    }
+    return nil
+}
+
+// variablesMetaMetadata builds the schema for a new variables meta table.
+func variablesMetaMetadata() (*bigquery.TableMetadata, error) {
+    schema, err := bigquery.InferSchema(VariableMeta{})
+    if err != nil {
+        return nil, fmt.Errorf("failed to infer schema: %w", err)
+    }
+    return &bigquery.TableMetadata{Schema: schema}, nil
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+    "fmt"
+    "log/slog"
+    "net/http"
+    "net/http/httptest"
+    "strings"
+    "testing"
+)
+
+// resetSyncedMeta forgets which variables this instance merged, for the test's duration.
+func resetSyncedMeta(t *testing.T) {
+    t.Helper()
+    metaMu.Lock()
+    saved := syncedMeta
+    syncedMeta = make(map[string]string)
+    metaMu.Unlock()
+    t.Cleanup(func() {
+        metaMu.Lock()
+        syncedMeta = saved
+        metaMu.Unlock()
+    })
+}
+
+func TestVariableMetaRows(t *testing.T) {
+    tests := []struct {
+        name  string
+        query string
+        want  []string
+    }{
+        {"core variables", "", []string{
+            "temperature_2m_mean mean_temperature °C", "temperature_2m_min min_temperature °C", "temperature_2m_max max_temperature °C",
+            "rain_sum rain_sum mm", "snowfall_sum snowfall_sum cm",
+        }},
+        {"requested variables", "&daily=weather_code,et0_fao_evapotranspiration", []string{
+            "temperature_2m_mean mean_temperature °C", "temperature_2m_min min_temperature °C", "temperature_2m_max max_temperature °C",
+            "rain_sum rain_sum mm", "snowfall_sum snowfall_sum cm", "weather_code weather_code wmo code", "et0_fao_evapotranspiration et0_fao_evapotranspiration mm",
+        }},
+        {"snowfall in mm", "&snowfall_unit=mm", []string{
+            "temperature_2m_mean mean_temperature °C", "temperature_2m_min min_temperature °C", "temperature_2m_max max_temperature °C",
+            "rain_sum rain_sum mm", "snowfall_sum snowfall_sum mm",
+        }},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            var got []string
+            for _, row := range variableMetaRows(mustParseOptions(t, "latitude=52.52&longitude=13.41"+tt.query)) {
+                if row.Description == "" {
+                    t.Errorf("%s has no description", row.Variable)
+                }
+                got = append(got, row.Variable+" "+row.Column+" "+row.Unit)
+            }
+            if strings.Join(got, "\n") != strings.Join(tt.want, "\n") {
+                t.Errorf("rows = %q, want %q", got, tt.want)
+            }
+        })
+    }
+}
+
+func TestRecordVariablesMeta(t *testing.T) {
+    tests := []struct {
+        name       string
+        enabled    bool
+        queries    []string
+        mergeErr   bool
+        wantMerged []int
+    }{
+        {"disabled", false, []string{""}, false, nil},
+        {"first ingest", true, []string{""}, false, []int{5}},
+        {"unchanged variables are merged once", true, []string{"", ""}, false, []int{5}},
+        {"new variable and changed unit", true, []string{"", "&daily=weather_code&snowfall_unit=mm"}, false, []int{5, 2}},
+        {"merge failure does not fail the request", true, []string{""}, true, []int{5}},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            resetSyncedMeta(t)
+            withConfig(t, func(c *Config) { c.RecordVariablesMeta = tt.enabled })
+            captureLogs(t, slog.LevelError)
+            fake, _ := newFakeBigQuery(t)
+            fake.answer = func(q *fakeQuery) *fakeResult {
+                if tt.mergeErr && strings.Contains(q.SQL, cfg.VariablesMetaTable) {
+                    return &fakeResult{Err: "boom"}
+                }
+                return nil
+            }
+            stubOpenMeteo(t, serveBody(threeDays))
+            for _, query := range tt.queries {
+                rec := httptest.NewRecorder()
+                fetchWeatherData(rec, httptest.NewRequest(http.MethodGet, "/?latitude=52.52&longitude=13.41&start_date=2024-01-01&end_date=2024-01-03"+query, nil))
+                if rec.Code != http.StatusOK {
+                    t.Fatalf("status = %d: %s", rec.Code, rec.Body)
+                }
+            }
+
+            var merged []int
+            for _, q := range fake.received() {
+                if !strings.HasPrefix(q.SQL, "MERGE `"+cfg.ProjectID+"."+cfg.DatasetID+"."+cfg.VariablesMetaTable+"` AS t") {
+                    continue
+                }
+                if !strings.Contains(q.SQL, "ON t.variable = s.variable") {
+                    t.Errorf("merge is not keyed by variable: %s", q.SQL)
+                }
+                for _, p := range q.Params {
+                    if p.Name == "rows" {
+                        merged = append(merged, len(p.ParameterValue.ArrayValues))
+                    }
+                }
+            }
+            if fmt.Sprint(merged) != fmt.Sprint(tt.wantMerged) {
+                t.Errorf("merged %v rows, want %v", merged, tt.wantMerged)
+            }
+            table := fake.table(cfg.VariablesMetaTable)
+            if tt.enabled != (table != nil) {
+                t.Fatalf("meta table created: %v, want %v", table != nil, tt.enabled)
+            }
+            if table != nil {
+                var columns []string
+                for _, f := range table.Schema.Fields {
+                    columns = append(columns, f.Name)
+                }
+                if got := strings.Join(columns, ","); got != "variable,column,unit,description,updated_at" {
+                    t.Errorf("meta table columns = %s", got)
+                }
+            }
+        })
+    }
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+    "context"
+    "errors"
+    "fmt"
+    "time"
+
+    "cloud.google.com/go/bigquery"
+    "cloud.google.com/go/civil"
+    "google.golang.org/api/iterator"
+)
+
+// errNotYetVisible is returned while fewer inserted rows are visible than expected.
+var errNotYetVisible = errors.New("inserted rows not yet visible")
+
+// verifyInsert polls the table until the rows of the batch are visible to queries, backing
+// off between polls for up to cfg.VerifyWindow, and returns how many were visible at the
+// end. Rows are counted by batch ID rather than coordinate and date range, so rows left by
+// earlier ingestions of the same range cannot make a short insert look complete; the date
+// range only limits the scan to the batch's partitions, and is left zero for tables without
+// a date column. Streamed rows usually become queryable within seconds, but can lag while
+// the streaming buffer catches up, which is what the window allows for.
+func verifyInsert(ctx context.Context, client *bigquery.Client, tableID, batchID string, start, end civil.Date, expected int) (int, error) {
+    policy := retryPolicy{Window: cfg.VerifyWindow, Initial: time.Second, Max: 8 * time.Second}
+    visible := 0
+    err := retryWithBackoff(ctx, policy, func(err error) bool { return errors.Is(err, errNotYetVisible) }, func() error {
+        n, err := countBatchRows(ctx, client, tableID, batchID, start, end)
+        if err != nil {
+            return err
+        }
+        visible = n
+        if visible < expected {
+            return errNotYetVisible
+        }
+        return nil
+    })
+    if errors.Is(err, errNotYetVisible) || errors.Is(err, errRetryDeadline) {
+        return visible, nil
+    }
+    return visible, err
+}
+
+// countBatchRows counts the rows of a batch in the table, within the date range when it
+// is set.
+func countBatchRows(ctx context.Context, client *bigquery.Client, tableID, batchID string, start, end civil.Date) (int, error) {
+    sql := fmt.Sprintf("SELECT COUNT(*) AS n FROM `%s.%s.%s` WHERE batch_id = @batch_id", cfg.ProjectID, cfg.DatasetID, tableID)
+    params := []bigquery.QueryParameter{{Name: "batch_id", Value: batchID}}
+    if !start.IsZero() && !end.IsZero() {
+        sql += " AND date BETWEEN @start_date AND @end_date"
+        params = append(params, bigquery.QueryParameter{Name: "start_date", Value: start}, bigquery.QueryParameter{Name: "end_date", Value: end})
+    }
+    query := client.Query(sql)
+    query.Parameters = params
+    it, err := query.Read(ctx)
+    if err != nil {
+        return 0, err
+    }
+
+    var row struct {
+        N int64 `bigquery:"n"`
+    }
+    if err := it.Next(&row); err != nil && err != iterator.Done {
+        return 0, err
+    }
+    return int(row.N), nil
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+    "context"
+    "strings"
+    "sync/atomic"
+    "testing"
+    "time"
+
+    "cloud.google.com/go/civil"
+)
+
+func TestVerifyInsert(t *testing.T) {
+    start, end := civil.Date{Year: 2024, Month: 1, Day: 1}, civil.Date{Year: 2024, Month: 1, Day: 3}
+    tests := []struct {
+        name        string
+        counts      []int64
+        start, end  civil.Date
+        window      time.Duration
+        wantVisible int
+        wantPolls   int
+        wantRange   bool
+    }{
+        {"visible at once", []int64{3}, start, end, time.Second, 3, 1, true},
+        {"visible on the second poll", []int64{1, 3}, start, end, 1500 * time.Millisecond, 3, 2, true},
+        {"shortfall after the window", []int64{2}, start, end, 500 * time.Millisecond, 2, 1, true},
+        {"table without dates", []int64{3}, civil.Date{}, civil.Date{}, time.Second, 3, 1, false},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            withConfig(t, func(c *Config) { c.VerifyWindow = tt.window })
+            fake, client := newFakeBigQuery(t)
+            var polls atomic.Int32
+            fake.answer = func(q *fakeQuery) *fakeResult {
+                n := int(polls.Add(1)) - 1
+                return &fakeResult{Fields: fields("n", "INTEGER"), Rows: [][]interface{}{{tt.counts[min(n, len(tt.counts)-1)]}}}
+            }
+            visible, err := verifyInsert(context.Background(), client, "daily_weather", "batch-1", tt.start, tt.end, 3)
+            if err != nil {
+                t.Fatal(err)
+            }
+            if visible != tt.wantVisible || int(polls.Load()) != tt.wantPolls {
+                t.Errorf("verifyInsert() = %d after %d polls, want %d after %d", visible, polls.Load(), tt.wantVisible, tt.wantPolls)
+            }
+            q := fake.received()[0]
+            if q.param("batch_id") != "batch-1" {
+                t.Errorf("batch_id parameter = %q", q.param("batch_id"))
+            }
+            if got := strings.Contains(q.SQL, "date BETWEEN @start_date AND @end_date"); got != tt.wantRange {
+                t.Errorf("SQL %q filters on the date range: %v, want %v", q.SQL, got, tt.wantRange)
+            }
+            if tt.wantRange && (q.paramType("start_date") != "DATE" || q.param("start_date") != "2024-01-01" || q.param("end_date") != "2024-01-03") {
+                t.Errorf("date parameters = %+v, want DATE 2024-01-01 to 2024-01-03", q.Params)
+            }
+        })
+    }
+}
+
+func TestIngestVerifyDateRange(t *testing.T) {
+    tests := []struct {
+        name      string
+        query     string
+        wantRange bool
+    }{
+        {"daily table", "", true},
+        {"monthly table has no date column", "&aggregate=monthly", false},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            fake, client := newFakeBigQuery(t)
+            fake.answer = func(q *fakeQuery) *fakeResult {
+                return &fakeResult{Fields: fields("n", "INTEGER"), Rows: [][]interface{}{{100}}}
+            }
+            stubOpenMeteo(t, serveBody(threeDays))
+            opts := mustParseOptions(t, "latitude=52.52&longitude=13.41&start_date=2024-01-01&end_date=2024-01-03&verify=true"+tt.query)
+            result, err := ingest(context.Background(), client, opts, time.Now())
+            if err != nil {
+                t.Fatal(err)
+            }
+            if !result.Verified {
+                t.Errorf("result = %+v, want verified", result)
+            }
+            var count *fakeQuery
+            for _, q := range fake.received() {
+                if strings.Contains(q.SQL, "batch_id = @batch_id") {
+                    count = q
+                }
+            }
+            if count == nil {
+                t.Fatal("no verification query ran")
+            }
+            if got := count.param("start_date") == "2024-01-01" && count.param("end_date") == "2024-01-03"; got != tt.wantRange {
+                t.Errorf("verification query %q with %+v limited to the range: %v, want %v", count.SQL, count.Params, got, tt.wantRange)
+            }
+        })
+    }
+}
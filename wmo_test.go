@@ -0,0 +1,23 @@
+package main
+
+import "testing"
+
+func TestDescribeWeatherCode(t *testing.T) {
+    tests := []struct {
+        code   int64
+        want   string
+        wantOK bool
+    }{
+        {0, "Clear sky", true},
+        {63, "Moderate rain", true},
+        {99, "Thunderstorm with heavy hail", true},
+        {4, "", false},
+        {-1, "", false},
+    }
+    for _, tt := range tests {
+        got, ok := describeWeatherCode(tt.code)
+        if got != tt.want || ok != tt.wantOK {
+            t.Errorf("describeWeatherCode(%d) = %q, %v; want %q, %v", tt.code, got, ok, tt.want, tt.wantOK)
+        }
+    }
+}